@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ooni/2023-05-richer-input/pkg/ooniprobe/runner"
+	"github.com/spf13/cobra"
+)
+
+func newCapabilitiesSubcommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "capabilities",
+		Short: "Internal command that prints the probe capabilities as JSON.",
+		Run:   capabilitiesMain,
+		Args:  cobra.NoArgs,
+	}
+}
+
+// capabilitiesMain is the main of the capabilities subcommand.
+func capabilitiesMain(cmd *cobra.Command, args []string) {
+	data, err := json.MarshalIndent(runner.NewCapabilities(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: json.MarshalIndent: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", string(data))
+}
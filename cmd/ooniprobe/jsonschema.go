@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ooni/2023-05-richer-input/pkg/ooniprobe/runner"
+	"github.com/spf13/cobra"
+)
+
+// jsonschemaGenerators maps each document kind to the function generating its schema.
+var jsonschemaGenerators = map[string]func() map[string]any{
+	"ast":    runner.ASTJSONSchema,
+	"script": runner.ScriptJSONSchema,
+}
+
+func newJSONSchemaSubcommand() *cobra.Command {
+	return &cobra.Command{
+		Use:       "jsonschema {ast|script}",
+		Short:     "Internal command that prints the JSON schema of ASTs or scripts.",
+		Run:       jsonschemaMain,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"ast", "script"},
+	}
+}
+
+// jsonschemaMain is the main of the jsonschema subcommand.
+func jsonschemaMain(cmd *cobra.Command, args []string) {
+	data, err := json.MarshalIndent(jsonschemaGenerators[args[0]](), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: json.MarshalIndent: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", string(data))
+}
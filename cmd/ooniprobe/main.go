@@ -25,9 +25,21 @@ func main() {
 		"enable verbose logging",
 	)
 
+	// create the capabilities command
+	root.AddCommand(newCapabilitiesSubcommand())
+
+	// create the jsonschema command
+	root.AddCommand(newJSONSchemaSubcommand())
+
+	// create the keygenx command
+	root.AddCommand(newKeygenxSubcommand())
+
 	// create the runx command
 	root.AddCommand(newRunxSubcommand())
 
+	// create the signx command
+	root.AddCommand(newSignxSubcommand())
+
 	// execute the selected subcommand
 	runtimex.Try0(root.Execute())
 }
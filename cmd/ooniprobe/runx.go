@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/ooni/2023-05-richer-input/pkg/ooniprobe/prommetrics"
 	"github.com/ooni/2023-05-richer-input/pkg/ooniprobe/runner"
 	"github.com/ooni/probe-engine/pkg/model"
 	"github.com/ooni/probe-engine/pkg/optional"
@@ -21,13 +25,25 @@ import (
 func newRunxSubcommand() *cobra.Command {
 	// create the subcommand state
 	state := &runxSubcommand{
-		enabledNettests: []string{},
-		enabledSuites:   []string{},
-		location:        "",
-		logfile:         "",
-		maxRuntime:      0,
-		output:          "",
-		script:          "",
+		allowUnsigned:     false,
+		destinationPolicy: "",
+		devTrustedKeys:    []string{},
+		enabledNettests:   []string{},
+		enabledSuites:     []string{},
+		location:          "",
+		logfile:           "",
+		maxInFlight:       0,
+		maxOpenConns:      0,
+		maxOpsPerSecond:   0,
+		maxParallelism:    0,
+		maxRuntime:        0,
+		otlpEndpoint:      "",
+		otlpSpansFile:     "",
+		output:            "",
+		prometheus:        false,
+		prometheusAddress: "",
+		repeatEvery:       0,
+		script:            "",
 	}
 
 	// initialize the cobra subcommand
@@ -38,6 +54,30 @@ func newRunxSubcommand() *cobra.Command {
 		Args:  cobra.NoArgs,
 	}
 
+	// register the --dev-allow-unsigned-script flag
+	cmd.Flags().BoolVar(
+		&state.allowUnsigned,
+		"dev-allow-unsigned-script",
+		false,
+		"DEVELOPERS ONLY: run unsigned or expired scripts",
+	)
+
+	// register the --dev-trust-script-key-file flag
+	cmd.Flags().StringSliceVar(
+		&state.devTrustedKeys,
+		"dev-trust-script-key-file",
+		[]string{},
+		"DEVELOPERS ONLY: also trust the public key in the given keygenx key file (can be provided multiple times)",
+	)
+
+	// register the --destination-policy-file flag
+	cmd.Flags().StringVar(
+		&state.destinationPolicy,
+		"destination-policy-file",
+		"",
+		"path of the file containing the destination policy for DSL-based nettests",
+	)
+
 	// register the required --location-file flag
 	cmd.Flags().StringVar(
 		&state.location,
@@ -55,6 +95,38 @@ func newRunxSubcommand() *cobra.Command {
 		"path of the output log file",
 	)
 
+	// register the --max-in-flight-operations flag
+	cmd.Flags().IntVar(
+		&state.maxInFlight,
+		"max-in-flight-operations",
+		0,
+		"maximum number of concurrent dials, handshakes, and lookups for DSL-based nettests (does not limit open connections)",
+	)
+
+	// register the --max-open-connections flag
+	cmd.Flags().IntVar(
+		&state.maxOpenConns,
+		"max-open-connections",
+		0,
+		"maximum number of TCP and QUIC connections DSL-based nettests may keep open at the same time",
+	)
+
+	// register the --max-operations-per-second flag
+	cmd.Flags().Float64Var(
+		&state.maxOpsPerSecond,
+		"max-operations-per-second",
+		0,
+		"maximum number of network operations per second for DSL-based nettests",
+	)
+
+	// register the --max-parallelism flag
+	cmd.Flags().IntVar(
+		&state.maxParallelism,
+		"max-parallelism",
+		0,
+		"maximum number of goroutines used by each parallel stage of DSL-based nettests (0 means using the default)",
+	)
+
 	// register the --max-runtime flag
 	cmd.Flags().DurationVar(
 		&state.maxRuntime,
@@ -79,6 +151,46 @@ func newRunxSubcommand() *cobra.Command {
 		"only run the given suite (can be provided multiple times)",
 	)
 
+	// register the --otlp-endpoint flag
+	cmd.Flags().StringVar(
+		&state.otlpEndpoint,
+		"otlp-endpoint",
+		"",
+		"OTLP/HTTP traces URL to export DSL-based nettests spans to (e.g., http://127.0.0.1:4318/v1/traces)",
+	)
+
+	// register the --otlp-spans-file flag
+	cmd.Flags().StringVar(
+		&state.otlpSpansFile,
+		"otlp-spans-file",
+		"",
+		"path of the file where to append DSL-based nettests spans in OTLP JSON format",
+	)
+
+	// register the --prometheus flag
+	cmd.Flags().BoolVar(
+		&state.prometheus,
+		"prometheus",
+		false,
+		"serve Prometheus metrics at the /metrics endpoint of --prometheus-address",
+	)
+
+	// register the --prometheus-address flag
+	cmd.Flags().StringVar(
+		&state.prometheusAddress,
+		"prometheus-address",
+		prommetrics.DefaultAddress,
+		"address where to serve Prometheus metrics when using --prometheus",
+	)
+
+	// register the --repeat-every flag
+	cmd.Flags().DurationVar(
+		&state.repeatEvery,
+		"repeat-every",
+		0,
+		"keep running and reload and run the script again after the given interval",
+	)
+
 	// register the -o,--results-file flag
 	cmd.Flags().StringVarP(
 		&state.output,
@@ -93,7 +205,7 @@ func newRunxSubcommand() *cobra.Command {
 		&state.script,
 		"script-file",
 		"",
-		"path of the script file to interpret",
+		"path of the script file to interpret (either JSON or CBOR)",
 	)
 	cmd.MarkFlagRequired("script-file")
 
@@ -102,6 +214,15 @@ func newRunxSubcommand() *cobra.Command {
 
 // runxSubcommand contains the state bound to the runx subcommand.
 type runxSubcommand struct {
+	// allowUnsigned allows running unsigned or expired scripts.
+	allowUnsigned bool
+
+	// destinationPolicy is the OPTIONAL name of the file containing the destination policy.
+	destinationPolicy string
+
+	// devTrustedKeys contains the names of the key files containing extra trusted keys.
+	devTrustedKeys []string
+
 	// enabledNettests contains the enabled nettests.
 	enabledNettests []string
 
@@ -114,20 +235,51 @@ type runxSubcommand struct {
 	// logfile is the output logfile
 	logfile string
 
+	// maxInFlight is zero or the maximum number of dials, handshakes, and lookups in flight.
+	maxInFlight int
+
+	// maxOpenConns is zero or the maximum number of open TCP and QUIC connections.
+	maxOpenConns int
+
+	// maxOpsPerSecond is zero or the maximum number of network operations per second.
+	maxOpsPerSecond float64
+
+	// maxParallelism is zero or the maximum parallelism of parallel DSL stages.
+	maxParallelism int
+
 	// maxRuntime is zero or the maximum runtime for nettests measuring lists of targets.
 	maxRuntime time.Duration
 
+	// otlpEndpoint is the OPTIONAL OTLP/HTTP traces URL.
+	otlpEndpoint string
+
+	// otlpSpansFile is the OPTIONAL name of the OTLP JSON spans file.
+	otlpSpansFile string
+
 	// output is the name of the output file
 	output string
 
+	// prometheus indicates whether to serve Prometheus metrics.
+	prometheus bool
+
+	// prometheusAddress is the address where to serve Prometheus metrics.
+	prometheusAddress string
+
+	// repeatEvery is zero or the interval after which we run the script again.
+	repeatEvery time.Duration
+
 	// script is the name of the file containing the script to run.
 	script string
 }
 
 // Main is the main of the [runxSubcommand]
 func (sc *runxSubcommand) Main(cmd *cobra.Command, args []string) {
+	// create the exporter collecting metrics
+	exporter := prommetrics.NewExporter()
+
 	// load script from disk
 	script, err := sc.loadScript()
+	exporter.ScriptFetched(err)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: loadRunnerPlan: %s\n", err.Error())
 		os.Exit(1)
@@ -166,29 +318,77 @@ func (sc *runxSubcommand) Main(cmd *cobra.Command, args []string) {
 	log.SetOutput(view.StdlibLoggerWriter())
 
 	// create the interpreter
-	ix := runner.NewInterpreter(
+	ix, err := runner.NewInterpreter(
 		location,
 		view,
+		exporter,
 		mw,
 		&runxSettings{
-			enabledNettests: sc.enabledNettests,
-			enabledSuites:   sc.enabledSuites,
-			maxRuntime:      sc.maxRuntime,
+			destinationPolicy: sc.destinationPolicy,
+			enabledNettests:   sc.enabledNettests,
+			enabledSuites:     sc.enabledSuites,
+			maxInFlight:       sc.maxInFlight,
+			maxOpenConns:      sc.maxOpenConns,
+			maxOpsPerSecond:   sc.maxOpsPerSecond,
+			maxParallelism:    sc.maxParallelism,
+			maxRuntime:        sc.maxRuntime,
+			otlpEndpoint:      sc.otlpEndpoint,
+			otlpSpansFile:     sc.otlpSpansFile,
 		},
 		"miniooni",
 		"0.1.0-dev",
 		view,
 	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: runner.NewInterpreter: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// serve metrics if requested
+	if sc.prometheus {
+		srv, err := sc.servePrometheusMetrics(exporter, ix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: servePrometheusMetrics: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer srv.Close()
+	}
 
-	// create context
-	ctx := context.Background()
+	// create a context that SIGINT and SIGTERM cancel, such that we stop
+	// measuring and still flush the output file and stop the metrics server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// perform all the measurements
-	if err := ix.Run(ctx, script); err != nil {
+	if err := ix.Run(ctx, script); err != nil && ctx.Err() == nil {
 		fmt.Fprintf(os.Stderr, "ERROR: rs.Run: %s\n", err.Error())
 		os.Exit(1)
 	}
 
+	// when requested, keep reloading and running the script, and only
+	// warn on failure, since the next attempt may succeed
+	for sc.repeatEvery > 0 {
+		timer := time.NewTimer(sc.repeatEvery)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			log.Printf("interrupted, shutting down")
+			break
+		}
+		script, err := sc.loadScript()
+		exporter.ScriptFetched(err)
+		if err != nil {
+			log.Printf("WARNING: loadRunnerPlan: %s", err.Error())
+			continue
+		}
+		if err := ix.Run(ctx, script); err != nil {
+			log.Printf("WARNING: rs.Run: %s", err.Error())
+		}
+	}
+
 	// make sure we flushed the output file
 	if err := mw.Close(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: mw.Close: %s\n", err.Error())
@@ -196,7 +396,22 @@ func (sc *runxSubcommand) Main(cmd *cobra.Command, args []string) {
 	}
 }
 
-// loadScript loads the script from file.
+// servePrometheusMetrics starts serving the metrics collected by the given exporter,
+// including the DSL metrics of the given interpreter, in a background goroutine.
+func (sc *runxSubcommand) servePrometheusMetrics(
+	exporter *prommetrics.Exporter, ix *runner.Interpreter) (*prommetrics.Server, error) {
+	if err := exporter.RegisterDSLMetrics(ix.DSLMetrics()); err != nil {
+		return nil, err
+	}
+	srv, err := exporter.Listen(sc.prometheusAddress)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("serving Prometheus metrics at http://%s/metrics", srv.Addr().String())
+	return srv, nil
+}
+
+// loadScript loads the script from file and verifies its signatures and expiry.
 func (sc *runxSubcommand) loadScript() (*modelx.InterpreterScript, error) {
 	// read raw script
 	data, err := os.ReadFile(sc.script)
@@ -204,18 +419,78 @@ func (sc *runxSubcommand) loadScript() (*modelx.InterpreterScript, error) {
 		return nil, err
 	}
 
+	// transcode CBOR scripts to JSON
+	data, err = runner.ScriptJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
 	// make sure we remove comments
 	data, err = hujson.Standardize(data)
 	if err != nil {
 		return nil, err
 	}
 
-	// parse the script from JSON
-	var script modelx.InterpreterScript
-	if err := json.Unmarshal(data, &script); err != nil {
+	// parse the signed script from JSON
+	var signed modelx.SignedInterpreterScript
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+
+	// handle the case of unsigned scripts
+	if len(signed.Payload) <= 0 && len(signed.Signatures) <= 0 {
+		if !sc.allowUnsigned {
+			return nil, runner.ErrScriptUnsigned
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: running an unsigned script\n")
+		var script modelx.InterpreterScript
+		if err := json.Unmarshal(data, &script); err != nil {
+			return nil, err
+		}
+		return &script, nil
+	}
+
+	// verify the signatures using the pinned keys and the extra keys
+	extraKeys, err := sc.loadDevTrustedKeys()
+	if err != nil {
 		return nil, err
 	}
-	return &script, nil
+	verifier := runner.NewPinnedScriptVerifier(extraKeys...)
+	payload, err := verifier.VerifySignatures(&signed)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the script has not expired
+	if err := verifier.CheckExpiry(payload); err != nil {
+		if !sc.allowUnsigned {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: running an expired script: %s\n", err.Error())
+	}
+	return &payload.Script, nil
+}
+
+// loadDevTrustedKeys loads the extra keys we trust for verifying scripts from the key files
+// generated by keygenx. Only the key_id and public_key fields of a key file are required,
+// therefore you can share a key file without the private key.
+func (sc *runxSubcommand) loadDevTrustedKeys() ([]runner.ScriptSigningKey, error) {
+	keys := []runner.ScriptSigningKey{}
+	for _, keyfile := range sc.devTrustedKeys {
+		var key signxKeyFile
+		if err := signxLoadJSONFile(keyfile, &key); err != nil {
+			return nil, err
+		}
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key in %s", keyfile)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: trusting script signing key: %s\n", key.KeyID)
+		keys = append(keys, runner.ScriptSigningKey{
+			KeyID:     key.KeyID,
+			PublicKey: key.PublicKey,
+		})
+	}
+	return keys, nil
 }
 
 // runxLocation is the location definition used by this subcommand.
@@ -303,18 +578,44 @@ func (mw *runxMeasurementWriter) SaveMeasurement(ctx context.Context, meas *mode
 
 // runxSettings implements [modelx.Settings]
 type runxSettings struct {
+	// destinationPolicy is the OPTIONAL name of the file containing the destination policy.
+	destinationPolicy string
+
 	// enabledNettests contains the list of enabled nettests
 	enabledNettests []string
 
 	// enabledSuites contains the list of enabled suites
 	enabledSuites []string
 
+	// maxInFlight is zero or the maximum number of dials, handshakes, and lookups in flight.
+	maxInFlight int
+
+	// maxOpenConns is zero or the maximum number of open TCP and QUIC connections.
+	maxOpenConns int
+
+	// maxOpsPerSecond is zero or the maximum number of network operations per second.
+	maxOpsPerSecond float64
+
+	// maxParallelism is zero or the maximum parallelism of parallel DSL stages.
+	maxParallelism int
+
 	// maxRuntime is zero or the maximum runtime for nettests measuring lists of targets.
 	maxRuntime time.Duration
+
+	// otlpEndpoint is the OPTIONAL OTLP/HTTP traces URL.
+	otlpEndpoint string
+
+	// otlpSpansFile is the OPTIONAL name of the OTLP JSON spans file.
+	otlpSpansFile string
 }
 
 var _ modelx.InterpreterSettings = &runxSettings{}
 
+// DestinationPolicyFile implements model.Settings
+func (rs *runxSettings) DestinationPolicyFile() string {
+	return rs.destinationPolicy
+}
+
 // IsNettestEnabled implements model.Settings
 func (rs *runxSettings) IsNettestEnabled(name string) bool {
 	if len(rs.enabledNettests) <= 0 {
@@ -341,7 +642,37 @@ func (rs *runxSettings) IsSuiteEnabled(name string) bool {
 	return false
 }
 
+// MaxInFlightOperations implements model.Settings
+func (rs *runxSettings) MaxInFlightOperations() int {
+	return rs.maxInFlight
+}
+
+// MaxOpenConnections implements model.Settings
+func (rs *runxSettings) MaxOpenConnections() int {
+	return rs.maxOpenConns
+}
+
+// MaxOperationsPerSecond implements model.Settings
+func (rs *runxSettings) MaxOperationsPerSecond() float64 {
+	return rs.maxOpsPerSecond
+}
+
+// MaxParallelism implements model.Settings
+func (rs *runxSettings) MaxParallelism() int {
+	return rs.maxParallelism
+}
+
 // MaxRuntime implements model.Settings
 func (rs *runxSettings) MaxRuntime() time.Duration {
 	return rs.maxRuntime
 }
+
+// OTLPEndpoint implements model.Settings
+func (rs *runxSettings) OTLPEndpoint() string {
+	return rs.otlpEndpoint
+}
+
+// OTLPSpansFile implements model.Settings
+func (rs *runxSettings) OTLPSpansFile() string {
+	return rs.otlpSpansFile
+}
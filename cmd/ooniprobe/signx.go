@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/ooni/2023-05-richer-input/pkg/ooniprobe/runner"
+	"github.com/spf13/cobra"
+	"github.com/tailscale/hujson"
+)
+
+// signxKeyFile is the content of a script signing key file.
+type signxKeyFile struct {
+	// KeyID identifies the key.
+	KeyID string `json:"key_id"`
+
+	// PrivateKey is the ed25519 private key.
+	PrivateKey []byte `json:"private_key"`
+
+	// PublicKey is the ed25519 public key.
+	PublicKey []byte `json:"public_key"`
+}
+
+func newKeygenxSubcommand() *cobra.Command {
+	// create the subcommand state
+	state := &keygenxSubcommand{
+		keyfile: "",
+		keyID:   "",
+	}
+
+	// initialize the cobra subcommand
+	cmd := &cobra.Command{
+		Use:   "keygenx --key-id ID --key-file FILE",
+		Short: "Internal command that generates a script signing key.",
+		Run:   state.Main,
+		Args:  cobra.NoArgs,
+	}
+
+	// register the required --key-file flag
+	cmd.Flags().StringVar(
+		&state.keyfile,
+		"key-file",
+		"",
+		"path of the key file to create",
+	)
+	cmd.MarkFlagRequired("key-file")
+
+	// register the required --key-id flag
+	cmd.Flags().StringVar(
+		&state.keyID,
+		"key-id",
+		"",
+		"identifier of the key to create",
+	)
+	cmd.MarkFlagRequired("key-id")
+
+	return cmd
+}
+
+// keygenxSubcommand contains the state bound to the keygenx subcommand.
+type keygenxSubcommand struct {
+	// keyfile is the name of the key file to create.
+	keyfile string
+
+	// keyID is the key identifier.
+	keyID string
+}
+
+// Main is the main of the [keygenxSubcommand].
+func (sc *keygenxSubcommand) Main(cmd *cobra.Command, args []string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: ed25519.GenerateKey: %s\n", err.Error())
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(&signxKeyFile{
+		KeyID:      sc.keyID,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+	}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: json.MarshalIndent: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := os.WriteFile(sc.keyfile, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: os.WriteFile: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// print the public key such that we can pin it
+	fmt.Printf("%s %s\n", sc.keyID, base64.StdEncoding.EncodeToString(publicKey))
+}
+
+func newSignxSubcommand() *cobra.Command {
+	// create the subcommand state
+	state := &signxSubcommand{
+		cbor:         false,
+		expiresAfter: 0,
+		keyfiles:     []string{},
+		output:       "",
+		script:       "",
+	}
+
+	// initialize the cobra subcommand
+	cmd := &cobra.Command{
+		Use:   "signx --key-file FILE --script-file FILE",
+		Short: "Internal command that signs a script.",
+		Run:   state.Main,
+		Args:  cobra.NoArgs,
+	}
+
+	// register the --cbor flag
+	cmd.Flags().BoolVar(
+		&state.cbor,
+		"cbor",
+		false,
+		"sign and write the script using CBOR rather than JSON",
+	)
+
+	// register the --expires-after flag
+	cmd.Flags().DurationVar(
+		&state.expiresAfter,
+		"expires-after",
+		24*time.Hour,
+		"time after which the signed script expires",
+	)
+
+	// register the required --key-file flag
+	cmd.Flags().StringSliceVar(
+		&state.keyfiles,
+		"key-file",
+		[]string{},
+		"path of the signing key file (can be provided multiple times)",
+	)
+	cmd.MarkFlagRequired("key-file")
+
+	// register the -o,--output flag
+	cmd.Flags().StringVarP(
+		&state.output,
+		"output",
+		"o",
+		"signed.json",
+		"path of the output signed script file",
+	)
+
+	// register the required --script-file flag
+	cmd.Flags().StringVar(
+		&state.script,
+		"script-file",
+		"",
+		"path of the script file to sign",
+	)
+	cmd.MarkFlagRequired("script-file")
+
+	return cmd
+}
+
+// signxSubcommand contains the state bound to the signx subcommand.
+type signxSubcommand struct {
+	// cbor indicates whether to sign and write the script using CBOR.
+	cbor bool
+
+	// expiresAfter is the time after which the signed script expires.
+	expiresAfter time.Duration
+
+	// keyfiles contains the names of the signing key files.
+	keyfiles []string
+
+	// output is the name of the output file.
+	output string
+
+	// script is the name of the file containing the script to sign.
+	script string
+}
+
+// Main is the main of the [signxSubcommand].
+func (sc *signxSubcommand) Main(cmd *cobra.Command, args []string) {
+	// load the script to sign
+	var script modelx.InterpreterScript
+	if err := signxLoadJSONFile(sc.script, &script); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: signxLoadJSONFile: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// load the signing keys
+	signers := []runner.ScriptSigner{}
+	for _, keyfile := range sc.keyfiles {
+		var key signxKeyFile
+		if err := signxLoadJSONFile(keyfile, &key); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: signxLoadJSONFile: %s\n", err.Error())
+			os.Exit(1)
+		}
+		signers = append(signers, runner.ScriptSigner{
+			KeyID:      key.KeyID,
+			PrivateKey: key.PrivateKey,
+		})
+	}
+
+	// sign and write the script
+	expires := time.Now().Add(sc.expiresAfter)
+	var data []byte
+	if sc.cbor {
+		signed, err := runner.SignInterpreterScriptCBOR(&script, expires, signers...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: runner.SignInterpreterScriptCBOR: %s\n", err.Error())
+			os.Exit(1)
+		}
+		data, err = runner.MarshalSignedInterpreterScriptCBOR(signed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: runner.MarshalSignedInterpreterScriptCBOR: %s\n", err.Error())
+			os.Exit(1)
+		}
+	} else {
+		signed, err := runner.SignInterpreterScript(&script, expires, signers...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: runner.SignInterpreterScript: %s\n", err.Error())
+			os.Exit(1)
+		}
+		data, err = json.MarshalIndent(signed, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: json.MarshalIndent: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if err := os.WriteFile(sc.output, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: os.WriteFile: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// signxLoadJSONFile loads a JSON file possibly containing comments.
+func signxLoadJSONFile(filepath string, value any) error {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	data, err = hujson.Standardize(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
@@ -5,18 +5,20 @@ go 1.19
 require (
 	github.com/apex/log v1.9.0
 	github.com/fatih/color v1.15.0
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/google/go-cmp v0.5.9
 	github.com/ooni/probe-engine v0.25.1-0.20230908090215-28aeb3307924
+	github.com/prometheus/client_golang v1.16.0
 	github.com/quic-go/quic-go v0.33.0
 )
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
-	github.com/prometheus/client_golang v1.16.0 // indirect
 	github.com/prometheus/client_model v0.4.0 // indirect
 	github.com/prometheus/common v0.44.0 // indirect
 	github.com/prometheus/procfs v0.10.1 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 )
 
 require (
@@ -42,7 +44,7 @@ require (
 	github.com/refraction-networking/conjure v0.4.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
-	golang.org/x/time v0.3.0 // indirect
+	golang.org/x/time v0.3.0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gvisor.dev/gvisor v0.0.0-20230603040744-5c9219dedd33 // indirect
 )
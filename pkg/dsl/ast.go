@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 )
 
 // SerializableASTNode is the serializable representation of a [Stage].
@@ -19,6 +20,24 @@ type SerializableASTNode struct {
 	Children []*SerializableASTNode `json:"children"`
 }
 
+// newSerializableASTNode converts a [LoadableASTNode] to a [SerializableASTNode] without
+// parsing the arguments, such that serializing the result produces the original arguments.
+func newSerializableASTNode(node *LoadableASTNode) *SerializableASTNode {
+	var arguments any
+	if len(node.Arguments) > 0 {
+		arguments = node.Arguments
+	}
+	children := []*SerializableASTNode{}
+	for _, child := range node.Children {
+		children = append(children, newSerializableASTNode(child))
+	}
+	return &SerializableASTNode{
+		StageName: node.StageName,
+		Arguments: arguments,
+		Children:  children,
+	}
+}
+
 // LoadableASTNode is the loadable representation of a [SerializableASTNode].
 type LoadableASTNode struct {
 	// StageName is the name of the DSL stage to execute.
@@ -38,7 +57,8 @@ type RunnableASTNode interface {
 	Run(ctx context.Context, rtx Runtime, input Maybe[any]) Maybe[any]
 }
 
-// ASTLoaderRule is a rule to load a [*LoadableASTNode] and convert it into a [RunnableASTNode].
+// ASTLoaderRule is a rule to load a [*LoadableASTNode] and convert it into a [RunnableASTNode]. A
+// rule declares the input and output types of the stage by returning a [TypedRunnableASTNode].
 type ASTLoaderRule interface {
 	Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error)
 	StageName() string
@@ -47,16 +67,28 @@ type ASTLoaderRule interface {
 // ASTLoader loads a [LoadableASTNode] and transforms it into a [RunnableASTNode]. The zero value
 // of this struct is not ready to use; please, use the [NewASTLoader] factory.
 type ASTLoader struct {
-	m map[string]ASTLoaderRule
+	limits ASTLoaderLimits
+	m      map[string]ASTLoaderRule
 }
 
 // NewASTLoader constructs a new [ASTLoader] and calls [ASTLoader.RegisterCustomLoadRule] for
 // all the built-in [ASTLoaderRule]. There's a built-in [ASTLoaderRule] for each [Stage] defined
-// by this package.
-func NewASTLoader() *ASTLoader {
+// by this package. By default, the [ASTLoader] enforces the [DefaultASTLoaderLimits] and you
+// can use the options to change such limits.
+func NewASTLoader(options ...ASTLoaderOption) *ASTLoader {
 	al := &ASTLoader{
-		m: map[string]ASTLoaderRule{},
+		limits: DefaultASTLoaderLimits(),
+		m:      map[string]ASTLoaderRule{},
 	}
+	for _, option := range options {
+		option(&al.limits)
+	}
+
+	// compare.go
+	al.RegisterCustomLoaderRule(&compareLoader[*DNSLookupResult, *DNSLookupResult, *AddressSetComparison]{
+		compareAddressSets, compareAddressSetsStageName})
+	al.RegisterCustomLoaderRule(&compareLoader[*HTTPResponse, *HTTPResponse, *HTTPResponseComparison]{
+		compareHTTPResponses, compareHTTPResponsesStageName})
 
 	// compose.go
 	al.RegisterCustomLoaderRule(&composeLoader{})
@@ -67,6 +99,9 @@ func NewASTLoader() *ASTLoader {
 	// dnsdomain.go
 	al.RegisterCustomLoaderRule(&domainNameLoader{})
 
+	// dnsdomains.go
+	al.RegisterCustomLoaderRule(&domainNamesLoader{})
+
 	// dnsgetaddrinfo.go
 	al.RegisterCustomLoaderRule(&dnsLookupGetaddrinfoLoader{})
 
@@ -79,6 +114,11 @@ func NewASTLoader() *ASTLoader {
 	// dnsudp.go
 	al.RegisterCustomLoaderRule(&dnsLookupUDPLoader{})
 
+	// endpointcollect.go
+	al.RegisterCustomLoaderRule(&aggregateEndpointResultsLoader{allFailedWithSameErrorStageName})
+	al.RegisterCustomLoaderRule(&aggregateEndpointResultsLoader{atLeastOneSucceededStageName})
+	al.RegisterCustomLoaderRule(&collectEndpointResultsLoader{})
+
 	// endpointmake.go
 	al.RegisterCustomLoaderRule(&makeEndpointForPortLoader{})
 
@@ -88,12 +128,32 @@ func NewASTLoader() *ASTLoader {
 	// endpointpipeline.go
 	al.RegisterCustomLoaderRule(&newEndpointPipelineLoader{})
 
+	// endpointports.go
+	al.RegisterCustomLoaderRule(&makeEndpointsForPortsLoader{})
+
+	// endpointrace.go
+	al.RegisterCustomLoaderRule(&raceEndpointsLoader{})
+
+	// endpointtransform.go
+	al.RegisterCustomLoaderRule(&filterEndpointsByCIDRLoader{})
+	al.RegisterCustomLoaderRule(&filterEndpointsByFamilyLoader{})
+	al.RegisterCustomLoaderRule(&filterEndpointsByNetworkLoader{})
+	al.RegisterCustomLoaderRule(&sampleEndpointsLoader{})
+	al.RegisterCustomLoaderRule(&takeEndpointsLoader{})
+	al.RegisterCustomLoaderRule(&uniqueEndpointsLoader{})
+
 	// endpointnew.go
 	al.RegisterCustomLoaderRule(&newEndpointLoader{})
 
+	// fallback.go
+	al.RegisterCustomLoaderRule(&withFallbacksLoader{})
+
 	// filter.go
 	al.RegisterCustomLoaderRule(&ifFilterExistsLoader{})
 
+	// foreach.go
+	al.RegisterCustomLoaderRule(&forEachLoader{})
+
 	// httpcore.go
 	al.RegisterCustomLoaderRule(&httpTransactionLoader{})
 
@@ -118,31 +178,85 @@ func NewASTLoader() *ASTLoader {
 	// quichandshake.go
 	al.RegisterCustomLoaderRule(&quicHandshakeLoader{})
 
+	// savemetrics.go
+	al.RegisterCustomLoaderRule(&saveMetricsLoader{})
+
+	// snidifferential.go
+	al.RegisterCustomLoaderRule(&sniDifferentialLoader{})
+
 	// tcpconnect.go
 	al.RegisterCustomLoaderRule(&tcpConnectLoader{})
 
+	// template.go
+	al.RegisterCustomLoaderRule(&bindLoader{})
+
+	// timeout.go
+	al.RegisterCustomLoaderRule(&withTimeoutLoader{})
+
 	// tlshandshake.go
 	al.RegisterCustomLoaderRule(&tlsHandshakeLoader{})
 
+	// zip.go
+	al.RegisterCustomLoaderRule(&zipLoader{})
+
 	return al
 }
 
 // RegisterCustomLoaderRule registers a custom [ASTLoaderRule]. Note that the [NewASTLoader]
-// factory already registers all the built-in loader rules defined by this package.
+// factory already registers all the built-in loader rules defined by this package. The rule
+// StageName method must return the stage name without any version suffix and a rule should
+// implement [ASTLoaderRuleWithVersion] to load a version more recent than the first one.
 func (al *ASTLoader) RegisterCustomLoaderRule(rule ASTLoaderRule) {
 	al.m[rule.StageName()] = rule
 }
 
+// Limits returns the [ASTLoaderLimits] enforced by this [ASTLoader]. A custom [ASTLoaderRule]
+// should use these limits to bound the resources used by the stage it loads.
+func (al *ASTLoader) Limits() ASTLoaderLimits {
+	return al.limits
+}
+
 // ErrNoSuchStage is returned when there's no such stage with the given name.
 var ErrNoSuchStage = errors.New("dsl: no such stage")
 
-// Load loads a [*LoadableASTNode] producing the correspoinding [*RunnableASTNode].
+// Load loads a [*LoadableASTNode] producing the correspoinding [*RunnableASTNode]. The stage name
+// may include a version suffix (see [VersionedStageName]) and we return an error wrapping
+// [ErrNoSuchStage] when we do not support such a version of the stage. While loading,
+// we check whether the types of the nodes are compatible, therefore we reject ill-typed ASTs before
+// running them. In such a case, the error is an [*ErrASTTypeCheck] containing the path to the node.
+// We also return an [*ErrASTLimitExceeded] error if the AST exceeds the [ASTLoaderLimits] and
+// an [*ErrUnboundASTVariable] error if the AST is a template (see [ASTLoader.LoadTemplate]).
 func (al *ASTLoader) Load(node *LoadableASTNode) (RunnableASTNode, error) {
-	rule, good := al.m[node.StageName]
+	if err := al.checkLimits(node); err != nil {
+		return nil, err
+	}
+	if err := checkUnboundASTVariables(node); err != nil {
+		return nil, err
+	}
+	name, version, err := parseVersionedStageName(node.StageName)
+	if err != nil {
+		return nil, err
+	}
+	if name == referenceStageName {
+		// Note: we do not want WithFallbacks to skip a reference as an unknown stage
+		return nil, fmt.Errorf("%w: %s: references require an AST document", ErrNoSuchASTDefinition, node.StageName)
+	}
+	rule, good := al.m[name]
 	if !good {
 		return nil, fmt.Errorf("%w: %s", ErrNoSuchStage, node.StageName)
 	}
-	return rule.Load(al, node)
+	if supported := astLoaderRuleVersion(rule); version > supported {
+		return nil, fmt.Errorf("%w: %s: we only support version %d", ErrNoSuchStage, node.StageName, supported)
+	}
+	runnable, err := rule.Load(al, node)
+	if err != nil {
+		var typeErr *ErrASTTypeCheck
+		if errors.As(err, &typeErr) {
+			typeErr.prependPath(node.StageName)
+		}
+		return nil, err
+	}
+	return runnable, nil
 }
 
 // LoadEmptyArguments is a convenience function for loading empty arguments when implementing
@@ -168,9 +282,13 @@ func (al *ASTLoader) RequireExactlyNumChildren(node *LoadableASTNode, num int) e
 // LoadChildren is a convenience function to load all the node's children
 // when implementing an [ASTLoaderRule].
 func (al *ASTLoader) LoadChildren(node *LoadableASTNode) (out []RunnableASTNode, err error) {
-	for _, node := range node.Children {
+	for idx, node := range node.Children {
 		runnable, err := al.Load(node)
 		if err != nil {
+			var typeErr *ErrASTTypeCheck
+			if errors.As(err, &typeErr) {
+				typeErr.prependPath(astChildPathElem(idx))
+			}
 			return nil, err
 		}
 		out = append(out, runnable)
@@ -181,6 +299,9 @@ func (al *ASTLoader) LoadChildren(node *LoadableASTNode) (out []RunnableASTNode,
 // StageRunnableASTNode adapts a [Stage] to become a [RunnableASTNode].
 type StageRunnableASTNode[A, B any] struct {
 	S Stage[A, B]
+
+	// node caches the node we pass to the StageInterceptor.
+	node interceptedStageNode
 }
 
 // ASTNode implements RunnableASTNode.
@@ -188,6 +309,16 @@ func (n *StageRunnableASTNode[A, B]) ASTNode() *SerializableASTNode {
 	return n.S.ASTNode()
 }
 
+// InputType implements TypedRunnableASTNode.
+func (n *StageRunnableASTNode[A, B]) InputType() reflect.Type {
+	return typeOf[A]()
+}
+
+// OutputType implements TypedRunnableASTNode.
+func (n *StageRunnableASTNode[A, B]) OutputType() reflect.Type {
+	return typeOf[B]()
+}
+
 // Run implements RunnableASTNode.
 func (n *StageRunnableASTNode[A, B]) Run(ctx context.Context, rtx Runtime, input Maybe[any]) Maybe[any] {
 	// convert generic to specific input
@@ -196,8 +327,8 @@ func (n *StageRunnableASTNode[A, B]) Run(ctx context.Context, rtx Runtime, input
 		return NewError[B](except).AsGeneric()
 	}
 
-	// call the underlying stage
-	output := n.S.Run(ctx, rtx, xinput)
+	// call the underlying stage using the interceptor
+	output := interceptStage(ctx, rtx, n.S, &n.node, xinput, n.S.Run)
 
 	// return a generic maybe to the caller
 	return output.AsGeneric()
@@ -0,0 +1,154 @@
+package dsl
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Budget is shared by all the network operations using a [Runtime] and limits the number of
+// network operations in flight, the number of open connections, and the rate at which we start
+// new network operations, such that an AST cannot flood the user's network or generate traffic
+// resembling a scan.
+//
+// The [TCPConnect], [QUICHandshake], [DNSLookupGetaddrinfo], and [DNSLookupUDP] stages
+// acquire the operation budget before starting and release it when the dial, handshake, or
+// lookup returns. Additionally, [TCPConnect] and [QUICHandshake] acquire the connection
+// budget before dialing and, on success, release it when the [Runtime] closes the connection.
+type Budget interface {
+	// Acquire blocks until we can start a new network operation and returns the function
+	// to call when the operation is done. This method returns an error if the context is
+	// done before we can start the operation and, in such a case, there's nothing to release.
+	Acquire(ctx context.Context) (release func(), err error)
+
+	// AcquireConnection blocks until we can open a new connection and returns the function
+	// to call when we close the connection. Like Acquire, this method returns an error if the
+	// context is done before we can open the connection and there's nothing to release.
+	AcquireConnection(ctx context.Context) (release func(), err error)
+}
+
+// NullBudget is a [Budget] that does not impose any limit. The zero
+// value of this struct is ready to use.
+type NullBudget struct{}
+
+var _ Budget = &NullBudget{}
+
+// Acquire implements Budget.
+func (*NullBudget) Acquire(ctx context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+// AcquireConnection implements Budget.
+func (*NullBudget) AcquireConnection(ctx context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+// defaultNullBudget is the default [*NullBudget] instance.
+var defaultNullBudget = &NullBudget{}
+
+// LimitedBudget is a [Budget] limiting the number of network operations in flight, the number
+// of open connections, and the rate at which we start network operations. The zero value of
+// this struct is not ready to use; construct using the [NewLimitedBudget] factory function.
+type LimitedBudget struct {
+	// conns is the OPTIONAL channel containing the open-connection slots.
+	conns chan struct{}
+
+	// limiter is the OPTIONAL rate limiter.
+	limiter *rate.Limiter
+
+	// slots is the OPTIONAL channel containing the in-flight slots.
+	slots chan struct{}
+}
+
+var _ Budget = &LimitedBudget{}
+
+// NewLimitedBudget creates a new [*LimitedBudget] instance.
+//
+// Arguments:
+//
+// - maxInFlight is the maximum number of dials, handshakes, and lookups in flight, which
+// does not include the connections that are already established (zero or negative means
+// that we do not limit the number of operations in flight);
+//
+// - maxOpenConnections is the maximum number of TCP and QUIC connections that are either
+// being established or open (zero or negative means that we do not limit them);
+//
+// - maxOperationsPerSecond is the maximum number of network operations we start each
+// second (zero or negative means that we do not limit the operations rate).
+func NewLimitedBudget(maxInFlight, maxOpenConnections int, maxOperationsPerSecond float64) *LimitedBudget {
+	budget := &LimitedBudget{
+		conns:   nil,
+		limiter: nil,
+		slots:   nil,
+	}
+	if maxInFlight > 0 {
+		budget.slots = make(chan struct{}, maxInFlight)
+	}
+	if maxOpenConnections > 0 {
+		budget.conns = make(chan struct{}, maxOpenConnections)
+	}
+	if maxOperationsPerSecond > 0 {
+		// Note: we use a burst of one so that we evenly space operations
+		budget.limiter = rate.NewLimiter(rate.Limit(maxOperationsPerSecond), 1)
+	}
+	return budget
+}
+
+// Acquire implements Budget.
+func (b *LimitedBudget) Acquire(ctx context.Context) (func(), error) {
+	// wait for an in-flight slot to become available
+	release := func() {}
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+			release = func() { <-b.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// wait for the rate limiter to allow us to start
+	if b.limiter != nil {
+		if err := b.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// AcquireConnection implements Budget.
+func (b *LimitedBudget) AcquireConnection(ctx context.Context) (func(), error) {
+	if b.conns == nil {
+		return func() {}, nil
+	}
+	select {
+	case b.conns <- struct{}{}:
+		return func() { <-b.conns }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireConnectionBudget acquires the connection budget waiting at most for the given timeout.
+// Because the runtime only closes connections when the measurement is done, an AST opening more
+// connections than allowed would otherwise block until the measurement context is done.
+func acquireConnectionBudget(ctx context.Context, rtx Runtime, timeout time.Duration) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return rtx.Budget().AcquireConnection(ctx)
+}
+
+// budgetReleaseCloser is an [io.Closer] releasing the connection budget, which we
+// register using [Runtime.TrackCloser] along with the corresponding connection.
+type budgetReleaseCloser struct {
+	release func()
+}
+
+// Close implements io.Closer.
+func (c *budgetReleaseCloser) Close() error {
+	c.release()
+	return nil
+}
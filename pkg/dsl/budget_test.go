@@ -0,0 +1,186 @@
+package dsl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLimitedBudget(t *testing.T) {
+	t.Run("we limit the number of operations in flight", func(t *testing.T) {
+		budget := NewLimitedBudget(1, 0, 0)
+		release, err := budget.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a second acquire should block until the context expires
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := budget.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("unexpected error", err)
+		}
+
+		// after releasing, we should be able to acquire again
+		release()
+		release, err = budget.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	})
+
+	t.Run("we limit the rate at which we start operations", func(t *testing.T) {
+		budget := NewLimitedBudget(0, 0, 50)
+		t0 := time.Now()
+		for idx := 0; idx < 4; idx++ {
+			release, err := budget.Acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+		}
+		// the first operation starts immediately and the others are 20 ms apart
+		if elapsed := time.Since(t0); elapsed < 50*time.Millisecond {
+			t.Fatal("operations were not rate limited", elapsed)
+		}
+	})
+
+	t.Run("TCPConnect fails without connecting when the context is done", func(t *testing.T) {
+		budget := NewLimitedBudget(1, 0, 0)
+		release, err := budget.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionBudget(budget))
+		endpoint := NewValue(&Endpoint{
+			Address: "10.0.0.1:80",
+			Domain:  "www.example.com",
+		})
+		results := TCPConnect().Run(ctx, rtx, endpoint)
+		if !IsErrTCPConnect(results.Error) || !errors.Is(results.Error, context.Canceled) {
+			t.Fatal("unexpected error", results.Error)
+		}
+	})
+
+	t.Run("budget failures reach the error counters", func(t *testing.T) {
+		budget := NewLimitedBudget(1, 0, 0)
+		release, err := budget.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		metrics := NewAccountingMetrics()
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionBudget(budget), RuntimeOptionSharedMetrics(metrics))
+		endpoint := NewValue(&Endpoint{
+			Address: "8.8.8.8:443",
+			Domain:  "dns.google",
+		})
+		_ = TCPConnect().Run(ctx, rtx, endpoint)
+		_ = QUICHandshake().Run(ctx, rtx, endpoint)
+		_ = DNSLookupUDP("8.8.8.8:53").Run(ctx, rtx, NewValue("dns.google"))
+		_ = DNSLookupGetaddrinfo().Run(ctx, rtx, NewValue("dns.google"))
+
+		expect := map[string]int64{
+			"dns_lookup_getaddrinfo_error_count": 1,
+			"dns_lookup_udp_error_count":         1,
+			"quic_handshake_error_count":         1,
+			"tcp_connect_error_count":            1,
+		}
+		if diff := cmp.Diff(expect, metrics.Snapshot()); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("open connections do not count as operations in flight", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionBudget(NewLimitedBudget(1, 0, 0)))
+		defer rtx.Close()
+		endpoint := NewValue(&Endpoint{
+			Address: listener.Addr().String(),
+			Domain:  "www.example.com",
+		})
+
+		// the connections stay open until we close the runtime but we release
+		// the budget after connecting, so the second connect does not block
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for idx := 0; idx < 2; idx++ {
+			if results := TCPConnect().Run(ctx, rtx, endpoint); results.Error != nil {
+				t.Fatal(results.Error)
+			}
+		}
+	})
+
+	t.Run("we limit the number of open connections", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		endpoint := NewValue(&Endpoint{
+			Address: listener.Addr().String(),
+			Domain:  "www.example.com",
+		})
+		budget := NewLimitedBudget(0, 1, 0)
+		connect := TCPConnect(TCPConnectOptionTimeout(50 * time.Millisecond))
+
+		// the first connection holds the only slot until we close the runtime
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionBudget(budget))
+		if results := connect.Run(context.Background(), rtx, endpoint); results.Error != nil {
+			t.Fatal(results.Error)
+		}
+
+		// the second connect waits at most for its timeout and then fails
+		other := NewMinimalRuntime(log.Log, RuntimeOptionBudget(budget))
+		defer other.Close()
+		results := connect.Run(context.Background(), other, endpoint)
+		if !IsErrTCPConnect(results.Error) || !errors.Is(results.Error, context.DeadlineExceeded) {
+			t.Fatal("unexpected error", results.Error)
+		}
+
+		// closing the runtime closes the connection and releases the slot
+		rtx.Close()
+		if results := connect.Run(context.Background(), other, endpoint); results.Error != nil {
+			t.Fatal(results.Error)
+		}
+	})
+
+	t.Run("failed connects release the connection slot", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		address := listener.Addr().String()
+		listener.Close() // make sure connecting fails
+
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionBudget(NewLimitedBudget(0, 1, 0)))
+		defer rtx.Close()
+		endpoint := NewValue(&Endpoint{Address: address, Domain: "www.example.com"})
+		for idx := 0; idx < 2; idx++ {
+			results := TCPConnect().Run(context.Background(), rtx, endpoint)
+			if !IsErrTCPConnect(results.Error) || errors.Is(results.Error, context.DeadlineExceeded) {
+				t.Fatal("unexpected error", results.Error)
+			}
+		}
+	})
+}
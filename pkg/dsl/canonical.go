@@ -0,0 +1,244 @@
+package dsl
+
+//
+// Canonical AST serialization and content hashing
+//
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// ASTContentHashAnnotation is the measurement annotation containing the [ASTDocumentContentHash]
+// of the AST that an experiment received and executed.
+const ASTContentHashAnnotation = "dsl_ast_content_hash"
+
+// ErrInvalidCanonicalAST indicates that we cannot canonicalize a JSON-serialized AST.
+var ErrInvalidCanonicalAST = errors.New("dsl: cannot canonicalize AST")
+
+// CanonicalASTJSON returns the canonical JSON serialization of a [SerializableASTNode]. See
+// [CanonicalizeASTJSON] for a description of the canonical form.
+func CanonicalASTJSON(node *SerializableASTNode) ([]byte, error) {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return CanonicalizeASTJSON(data)
+}
+
+// CanonicalizeASTJSON converts the JSON serialization of an AST node (either produced by
+// marshaling a [SerializableASTNode] or a [LoadableASTNode]) to its canonical form, where:
+//
+// 1. objects keys are sorted and there is no insignificant whitespace;
+//
+// 2. nodes only contain the "arguments", "children", and "stage_name" keys;
+//
+// 3. null or missing arguments become an empty object and null or missing children
+// become an empty array;
+//
+// 4. we omit arguments with default values (i.e., null, false, zero, the empty string,
+// the empty array, and the empty object), because the zero value of each argument is its
+// default value, which is what the loader uses when the argument is missing;
+//
+// 5. integral numbers do not contain a fractional part or an exponent.
+//
+// Two ASTs that the loader would load in the same way have the same canonical form.
+func CanonicalizeASTJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	canonical, err := canonicalASTNode(value)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(canonical); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// ASTContentHash returns a stable content hash of the given AST node and of its children,
+// computed as the SHA256 of the [CanonicalASTJSON] and formatted as "sha256:<hex digest>".
+func ASTContentHash(node *SerializableASTNode) (string, error) {
+	data, err := CanonicalASTJSON(node)
+	if err != nil {
+		return "", err
+	}
+	return astContentHash(data), nil
+}
+
+// LoadableASTContentHash is like [ASTContentHash] but takes in input a [LoadableASTNode]. Use
+// this function to hash the AST as received, before loading it, because the ASTNode method of
+// a loaded AST may differ from the received AST (e.g., [ASTLoader.LoadDocument] expands the
+// references and a [WithFallbacks] node becomes the fallback that the probe can load).
+func LoadableASTContentHash(node *LoadableASTNode) (string, error) {
+	data, err := canonicalLoadableAST(node)
+	if err != nil {
+		return "", err
+	}
+	return astContentHash(data), nil
+}
+
+// ASTDocumentContentHash returns the content hash of a [LoadableASTDocument] as received. The
+// hash of a document without definitions is the [LoadableASTContentHash] of its root. Otherwise,
+// we hash the JSON object containing the canonical form of the definitions and of the root.
+func ASTDocumentContentHash(doc *LoadableASTDocument) (string, error) {
+	if len(doc.Definitions) <= 0 {
+		return LoadableASTContentHash(doc.Root)
+	}
+	definitions := map[string]json.RawMessage{}
+	for name, node := range doc.Definitions {
+		data, err := canonicalLoadableAST(node)
+		if err != nil {
+			return "", err
+		}
+		definitions[name] = data
+	}
+	root, err := canonicalLoadableAST(doc.Root)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	value := map[string]any{"definitions": definitions, "root": json.RawMessage(root)}
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return astContentHash(bytes.TrimSuffix(out.Bytes(), []byte("\n"))), nil
+}
+
+// canonicalLoadableAST returns the canonical JSON serialization of a [LoadableASTNode].
+func canonicalLoadableAST(node *LoadableASTNode) ([]byte, error) {
+	if node == nil {
+		return nil, ErrNilASTNode
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return CanonicalizeASTJSON(data)
+}
+
+// astContentHash returns the content hash of the given canonical JSON serialization.
+func astContentHash(data []byte) string {
+	digest := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
+
+// canonicalASTNode returns the canonical form of a generic AST node.
+func canonicalASTNode(value any) (map[string]any, error) {
+	node, good := value.(map[string]any)
+	if !good {
+		return nil, ErrInvalidCanonicalAST
+	}
+	stageName, good := node["stage_name"].(string)
+	if !good {
+		return nil, ErrInvalidCanonicalAST
+	}
+
+	// Note: ASTLoader.LoadEmptyArguments accepts both null and {}
+	arguments := canonicalJSONValue(node["arguments"])
+	if arguments == nil {
+		arguments = map[string]any{}
+	}
+
+	children := []any{}
+	switch rawChildren := node["children"].(type) {
+	case nil:
+		// nothing
+	case []any:
+		for _, rawChild := range rawChildren {
+			child, err := canonicalASTNode(rawChild)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+	default:
+		return nil, ErrInvalidCanonicalAST
+	}
+
+	out := map[string]any{
+		"arguments":  arguments,
+		"children":   children,
+		"stage_name": stageName,
+	}
+	return out, nil
+}
+
+// canonicalJSONValue returns the canonical form of a generic JSON value.
+func canonicalJSONValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, entry := range value {
+			entry = canonicalJSONValue(entry)
+			if isDefaultJSONValue(entry) {
+				continue
+			}
+			out[key] = entry
+		}
+		return out
+
+	case []any:
+		// Note: the position of array entries is significant, so we keep default entries
+		out := []any{}
+		for _, entry := range value {
+			out = append(out, canonicalJSONValue(entry))
+		}
+		return out
+
+	case json.Number:
+		return canonicalJSONNumber(value)
+
+	default:
+		return value
+	}
+}
+
+// canonicalJSONNumber returns the canonical form of a JSON number.
+func canonicalJSONNumber(number json.Number) json.Number {
+	if integer, err := number.Int64(); err == nil {
+		return json.Number(strconv.FormatInt(integer, 10))
+	}
+	float, err := number.Float64()
+	if err != nil {
+		return number
+	}
+	if float == math.Trunc(float) && math.Abs(float) < (1<<63) {
+		return json.Number(strconv.FormatInt(int64(float), 10))
+	}
+	return json.Number(strconv.FormatFloat(float, 'g', -1, 64))
+}
+
+// isDefaultJSONValue returns whether a canonical JSON value is the default value.
+func isDefaultJSONValue(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !value
+	case string:
+		return value == ""
+	case json.Number:
+		return value == "0"
+	case []any:
+		return len(value) <= 0
+	case map[string]any:
+		return len(value) <= 0
+	default:
+		return false
+	}
+}
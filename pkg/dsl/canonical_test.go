@@ -0,0 +1,199 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalizeASTJSON(t *testing.T) {
+	t.Run("we normalize keys, nulls, defaults, and numbers", func(t *testing.T) {
+		input := `{
+			"stage_name": "compose",
+			"extra": "ignored",
+			"arguments": null,
+			"children": [{
+				"children": null,
+				"stage_name": "tcp_connect",
+				"arguments": {"timeout": 1e9, "tags": [], "extra": {"x": false}}
+			}, {
+				"stage_name": "make_endpoints_for_port",
+				"arguments": {"port": 443.0, "name": "<&>", "values": [0, "", null]}
+			}]
+		}`
+		expected := `{"arguments":{},"children":[` +
+			`{"arguments":{"timeout":1000000000},"children":[],"stage_name":"tcp_connect"},` +
+			`{"arguments":{"name":"<&>","port":443,"values":[0,"",null]},"children":[],"stage_name":"make_endpoints_for_port"}` +
+			`],"stage_name":"compose"}`
+		data, err := CanonicalizeASTJSON([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatal("unexpected canonical form", string(data))
+		}
+	})
+
+	t.Run("we reject invalid nodes", func(t *testing.T) {
+		inputs := []string{
+			`[]`,
+			`{"arguments":{}}`,
+			`{"stage_name":"compose","children":{}}`,
+			`{"stage_name":"compose","children":[17]}`,
+		}
+		for _, input := range inputs {
+			if _, err := CanonicalizeASTJSON([]byte(input)); !errors.Is(err, ErrInvalidCanonicalAST) {
+				t.Fatal(input, err)
+			}
+		}
+	})
+
+	t.Run("the canonical form is loadable and a fixed point", func(t *testing.T) {
+		stage := Compose(
+			DomainName("www.example.com"),
+			DNSLookupGetaddrinfo(),
+		)
+		data, err := CanonicalASTJSON(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(data, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&loadable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := CanonicalASTJSON(runnable.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(again) {
+			t.Fatal("expected", string(data), "got", string(again))
+		}
+	})
+}
+
+func TestASTContentHash(t *testing.T) {
+	t.Run("equivalent ASTs have the same hash", func(t *testing.T) {
+		first, err := ASTContentHash(TCPConnect().ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := ASTContentHash(&SerializableASTNode{
+			StageName: "tcp_connect",
+			Arguments: map[string]any{"timeout": 0},
+			Children:  nil,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Fatal("expected", first, "got", second)
+		}
+		if !strings.HasPrefix(first, "sha256:") || len(first) != len("sha256:")+64 {
+			t.Fatal("unexpected hash format", first)
+		}
+	})
+
+	t.Run("different ASTs have different hashes", func(t *testing.T) {
+		first, err := ASTContentHash(TCPConnect().ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := ASTContentHash(TCPConnect(TCPConnectOptionTimeout(time.Second)).ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == second {
+			t.Fatal("expected different hashes")
+		}
+	})
+}
+
+func TestASTDocumentContentHash(t *testing.T) {
+	// unmarshal returns the document received by a probe
+	unmarshal := func(t *testing.T, value any) *LoadableASTDocument {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := UnmarshalASTDocument(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+
+	// hash returns the hash of the given document
+	hash := func(t *testing.T, doc *LoadableASTDocument) string {
+		value, err := ASTDocumentContentHash(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	t.Run("we hash the received AST rather than the loaded AST", func(t *testing.T) {
+		root := WithFallbacks(
+			TLSHandshake(TLSHandshakeOptionTimeout(time.Second)),
+			TLSHandshake(),
+		).ASTNode()
+		doc := unmarshal(t, root)
+		runnable, err := NewASTLoader().LoadDocument(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ASTContentHash(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := ASTContentHash(runnable.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hash(t, doc); got != expected || got == loaded {
+			t.Fatal("unexpected hash", got)
+		}
+	})
+
+	t.Run("we hash the definitions rather than the expanded AST", func(t *testing.T) {
+		branch := Compose(TCPConnect(), TLSHandshake()).ASTNode()
+		root := &SerializableASTNode{
+			StageName: runStagesInParallelStageName,
+			Arguments: nil,
+			Children:  []*SerializableASTNode{branch, branch},
+		}
+		serializable, err := NewSerializableASTDocument(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(serializable.Definitions) <= 0 {
+			t.Fatal("expected definitions")
+		}
+		doc := unmarshal(t, serializable)
+		expanded, err := ASTContentHash(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := hash(t, doc)
+		if got == expanded {
+			t.Fatal("expected the hash to differ from the expanded AST hash")
+		}
+
+		// an equivalent document must have the same hash
+		doc.Definitions["def0"].Arguments = []byte(`{ }`)
+		if other := hash(t, doc); other != got {
+			t.Fatal("expected", got, "got", other)
+		}
+	})
+
+	t.Run("we reject documents without a root", func(t *testing.T) {
+		if _, err := ASTDocumentContentHash(&LoadableASTDocument{}); !errors.Is(err, ErrNilASTNode) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package dsl
+
+//
+// Stage versions and capabilities
+//
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ASTLoaderRuleWithVersion is an OPTIONAL interface that an [ASTLoaderRule] implements when
+// it loads a version of a stage more recent than the first version. We bump the version of a
+// stage when we add arguments or behaviors that older probes would not honor. A rule loading
+// version N of a stage also loads all the previous versions. We assume that a rule that does
+// not implement this interface loads the first version of the stage.
+type ASTLoaderRuleWithVersion interface {
+	ASTLoaderRule
+
+	// StageVersion returns the stage version, which is a positive integer.
+	StageVersion() int
+}
+
+// ASTLoaderRuleWithArguments is an OPTIONAL interface that an [ASTLoaderRule] implements to
+// describe the arguments of the stage it loads, such that we can report the arguments schema
+// to the backend using [ASTLoader.Capabilities]. We assume that a rule that does not implement
+// this interface accepts any arguments.
+type ASTLoaderRuleWithArguments interface {
+	ASTLoaderRule
+
+	// StageArguments returns a pointer to the zero value of the structure into
+	// which we unmarshal the arguments or nil if the stage takes no arguments.
+	StageArguments() any
+}
+
+// VersionedStageName returns the stage name to use inside a [SerializableASTNode] for the
+// given version of a stage. For example, the name of the second version of the "tls_handshake"
+// stage is "tls_handshake@2". We omit the version when the version is the first one, therefore
+// older probes, which do not know about versions, can still load the first version.
+func VersionedStageName(name string, version int) string {
+	if version <= 1 {
+		return name
+	}
+	return fmt.Sprintf("%s@%d", name, version)
+}
+
+// parseVersionedStageName is the inverse of [VersionedStageName].
+func parseVersionedStageName(versioned string) (name string, version int, err error) {
+	name, rawVersion, found := strings.Cut(versioned, "@")
+	if !found {
+		return name, 1, nil
+	}
+	version, err = strconv.Atoi(rawVersion)
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("%w: %s: invalid version", ErrNoSuchStage, versioned)
+	}
+	return name, version, nil
+}
+
+// astLoaderRuleVersion returns the version of the stage loaded by the given rule.
+func astLoaderRuleVersion(rule ASTLoaderRule) int {
+	if versioned, good := rule.(ASTLoaderRuleWithVersion); good {
+		return versioned.StageVersion()
+	}
+	return 1
+}
+
+// StageCapability describes a stage that an [ASTLoader] can load.
+type StageCapability struct {
+	// Name is the stage name.
+	Name string `json:"name"`
+
+	// Version is the most recent stage version we can load.
+	Version int `json:"version"`
+
+	// Arguments is the JSON schema of the stage arguments.
+	Arguments map[string]any `json:"arguments"`
+}
+
+// Capabilities returns the stages that this [ASTLoader] can load sorted by name. A probe
+// reports its capabilities to the backend, such that the backend can serve the richest AST
+// the probe understands, possibly using [WithFallbacks] to provide alternatives.
+func (al *ASTLoader) Capabilities() []StageCapability {
+	out := []StageCapability{}
+	for name, rule := range al.m {
+		capability := StageCapability{
+			Name:      name,
+			Version:   astLoaderRuleVersion(rule),
+			Arguments: map[string]any{},
+		}
+		if described, good := rule.(ASTLoaderRuleWithArguments); good {
+			capability.Arguments = jsonSchemaForValue(described.StageArguments())
+		}
+		out = append(out, capability)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,455 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// capabilitiesTestStage is the second version of a stage returning its argument.
+type capabilitiesTestStage struct {
+	Value string `json:"value"`
+}
+
+const capabilitiesTestStageName = "capabilities_test"
+
+// ASTNode implements Stage.
+func (sx *capabilitiesTestStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: VersionedStageName(capabilitiesTestStageName, 2),
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// Run implements Stage.
+func (sx *capabilitiesTestStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[string] {
+	return NewValue(sx.Value)
+}
+
+type capabilitiesTestLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*capabilitiesTestLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage capabilitiesTestStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[*Void, string]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*capabilitiesTestLoader) StageName() string {
+	return capabilitiesTestStageName
+}
+
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*capabilitiesTestLoader) StageVersion() int {
+	return 2
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*capabilitiesTestLoader) StageArguments() any {
+	return &capabilitiesTestStage{}
+}
+
+func TestVersionedStageNames(t *testing.T) {
+	loader := NewASTLoader()
+	loader.RegisterCustomLoaderRule(&capabilitiesTestLoader{})
+
+	// load loads a node with the given stage name
+	load := func(stageName string) error {
+		node := &LoadableASTNode{
+			StageName: stageName,
+			Arguments: []byte(`{}`),
+			Children:  []*LoadableASTNode{},
+		}
+		_, err := loader.Load(node)
+		return err
+	}
+
+	t.Run("we load the supported versions", func(t *testing.T) {
+		for _, name := range []string{"capabilities_test", "capabilities_test@1", "capabilities_test@2", "tcp_connect@1", "tcp_connect@2"} {
+			if err := load(name); err != nil {
+				t.Fatal(name, err)
+			}
+		}
+	})
+
+	t.Run("we reject unsupported and invalid versions", func(t *testing.T) {
+		for _, name := range []string{"capabilities_test@3", "tcp_connect@3", "tcp_connect@0", "tcp_connect@x"} {
+			if err := load(name); !errors.Is(err, ErrNoSuchStage) {
+				t.Fatal(name, err)
+			}
+		}
+	})
+
+	t.Run("we omit the first version when serializing", func(t *testing.T) {
+		if name := VersionedStageName("tls_handshake", 1); name != "tls_handshake" {
+			t.Fatal("unexpected name", name)
+		}
+		if name := VersionedStageName("tls_handshake", 2); name != "tls_handshake@2" {
+			t.Fatal("unexpected name", name)
+		}
+	})
+}
+
+// capabilitiesTestTLSHandshakeV1Loader loads the tls_handshake stage like a
+// probe only supporting the first version of the stage would do.
+type capabilitiesTestTLSHandshakeV1Loader struct{}
+
+// Load implements ASTLoaderRule.
+func (*capabilitiesTestTLSHandshakeV1Loader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	return (&tlsHandshakeLoader{}).Load(loader, node)
+}
+
+// StageName implements ASTLoaderRule.
+func (*capabilitiesTestTLSHandshakeV1Loader) StageName() string {
+	return tlsHandshakeStageName
+}
+
+func TestVersionedTLSHandshake(t *testing.T) {
+	// load serializes and loads the given stage using the given loader
+	load := func(t *testing.T, loader *ASTLoader, stage Stage[*TCPConnection, *TLSConnection]) (RunnableASTNode, error) {
+		data, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(data, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		return loader.Load(&loadable)
+	}
+
+	// newOldLoader returns a loader behaving like a probe only supporting the first version
+	newOldLoader := func() *ASTLoader {
+		loader := NewASTLoader()
+		loader.RegisterCustomLoaderRule(&capabilitiesTestTLSHandshakeV1Loader{})
+		return loader
+	}
+
+	t.Run("we only use the second version when configuring the timeout", func(t *testing.T) {
+		if name := TLSHandshake().ASTNode().StageName; name != "tls_handshake" {
+			t.Fatal("unexpected name", name)
+		}
+		if name := TLSHandshake(TLSHandshakeOptionTimeout(time.Second)).ASTNode().StageName; name != "tls_handshake@2" {
+			t.Fatal("unexpected name", name)
+		}
+	})
+
+	t.Run("we advertise the second version", func(t *testing.T) {
+		for _, capability := range NewASTLoader().Capabilities() {
+			if capability.Name == tlsHandshakeStageName && capability.Version != 2 {
+				t.Fatal("unexpected version", capability.Version)
+			}
+		}
+	})
+
+	t.Run("a recent probe loads the second version", func(t *testing.T) {
+		runnable, err := load(t, NewASTLoader(), TLSHandshake(TLSHandshakeOptionTimeout(time.Second)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := runnable.ASTNode().StageName; name != "tls_handshake@2" {
+			t.Fatal("unexpected name", name)
+		}
+	})
+
+	t.Run("an old probe rejects the second version", func(t *testing.T) {
+		_, err := load(t, newOldLoader(), TLSHandshake(TLSHandshakeOptionTimeout(time.Second)))
+		if !errors.Is(err, ErrNoSuchStage) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("an old probe loads the fallback", func(t *testing.T) {
+		stage := WithFallbacks(
+			TLSHandshake(TLSHandshakeOptionTimeout(time.Second)),
+			TLSHandshake(),
+		)
+		for _, entry := range []struct {
+			loader   *ASTLoader
+			expected string
+		}{{
+			loader:   NewASTLoader(),
+			expected: "tls_handshake@2",
+		}, {
+			loader:   newOldLoader(),
+			expected: "tls_handshake",
+		}} {
+			runnable, err := load(t, entry.loader, stage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := runnable.ASTNode().StageName; name != entry.expected {
+				t.Fatal("expected", entry.expected, "got", name)
+			}
+		}
+	})
+}
+
+// capabilitiesTestV1Loader loads a stage like a probe only supporting
+// the first version of the stage would do.
+type capabilitiesTestV1Loader struct {
+	rule ASTLoaderRule
+}
+
+// Load implements ASTLoaderRule.
+func (l *capabilitiesTestV1Loader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	return l.rule.Load(loader, node)
+}
+
+// StageName implements ASTLoaderRule.
+func (l *capabilitiesTestV1Loader) StageName() string {
+	return l.rule.StageName()
+}
+
+func TestVersionedTimeoutStages(t *testing.T) {
+	type testcase struct {
+		// name is the stage name.
+		name string
+
+		// rule is the loader rule of the stage.
+		rule ASTLoaderRule
+
+		// withoutTimeout is the AST of the stage without a timeout.
+		withoutTimeout *SerializableASTNode
+
+		// withTimeout is the AST of the stage with a timeout.
+		withTimeout *SerializableASTNode
+	}
+
+	testcases := []testcase{{
+		name:           "dns_lookup_getaddrinfo",
+		rule:           &dnsLookupGetaddrinfoLoader{},
+		withoutTimeout: DNSLookupGetaddrinfo().ASTNode(),
+		withTimeout:    DNSLookupGetaddrinfo(DNSLookupGetaddrinfoOptionTimeout(time.Second)).ASTNode(),
+	}, {
+		name:           "dns_lookup_udp",
+		rule:           &dnsLookupUDPLoader{},
+		withoutTimeout: DNSLookupUDP("8.8.8.8:53").ASTNode(),
+		withTimeout:    DNSLookupUDP("8.8.8.8:53", DNSLookupUDPOptionTimeout(time.Second)).ASTNode(),
+	}, {
+		name:           "http_transaction",
+		rule:           &httpTransactionLoader{},
+		withoutTimeout: HTTPTransaction().ASTNode(),
+		withTimeout:    HTTPTransaction(HTTPTransactionOptionTimeout(time.Second)).ASTNode(),
+	}, {
+		name:           "quic_handshake",
+		rule:           &quicHandshakeLoader{},
+		withoutTimeout: QUICHandshake().ASTNode(),
+		withTimeout:    QUICHandshake(QUICHandshakeOptionTimeout(time.Second)).ASTNode(),
+	}, {
+		name:           "tcp_connect",
+		rule:           &tcpConnectLoader{},
+		withoutTimeout: TCPConnect().ASTNode(),
+		withTimeout:    TCPConnect(TCPConnectOptionTimeout(time.Second)).ASTNode(),
+	}}
+
+	// load serializes and loads the given node using the given loader
+	load := func(t *testing.T, loader *ASTLoader, node *SerializableASTNode) (RunnableASTNode, error) {
+		data, err := json.Marshal(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(data, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		return loader.Load(&loadable)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			versioned := tc.name + "@2"
+
+			// newOldLoader returns a loader behaving like a probe only supporting the first version
+			newOldLoader := func() *ASTLoader {
+				loader := NewASTLoader()
+				loader.RegisterCustomLoaderRule(&capabilitiesTestV1Loader{tc.rule})
+				return loader
+			}
+
+			if name := tc.withoutTimeout.StageName; name != tc.name {
+				t.Fatal("unexpected name", name)
+			}
+			if name := tc.withTimeout.StageName; name != versioned {
+				t.Fatal("unexpected name", name)
+			}
+
+			for _, capability := range NewASTLoader().Capabilities() {
+				if capability.Name == tc.name && capability.Version != 2 {
+					t.Fatal("unexpected version", capability.Version)
+				}
+			}
+
+			runnable, err := load(t, NewASTLoader(), tc.withTimeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := runnable.ASTNode().StageName; name != versioned {
+				t.Fatal("unexpected name", name)
+			}
+
+			if _, err := load(t, newOldLoader(), tc.withTimeout); !errors.Is(err, ErrNoSuchStage) {
+				t.Fatal("unexpected error", err)
+			}
+			if _, err := load(t, newOldLoader(), tc.withoutTimeout); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWithFallbacks(t *testing.T) {
+	// newPipeline creates a pipeline preferring the second version of the test stage
+	newPipeline := func() Stage[*Void, string] {
+		return WithFallbacks[*Void, string](
+			&capabilitiesTestStage{"preferred"},
+			Compose[*Void, string, string](DomainName("fallback"), &Identity[string]{}),
+		)
+	}
+
+	// load serializes and loads the pipeline using the given loader
+	load := func(t *testing.T, loader *ASTLoader, stage Stage[*Void, string]) (RunnableASTNode, error) {
+		data, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(data, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		return loader.Load(&loadable)
+	}
+
+	// run runs the given runnable and returns its result
+	run := func(t *testing.T, runnable RunnableASTNode) string {
+		rtx := NewMinimalRuntime(log.Log)
+		results := runnable.Run(context.Background(), rtx, NewValue[any](&Void{}))
+		if results.Error != nil {
+			t.Fatal(results.Error)
+		}
+		return results.Value.(string)
+	}
+
+	t.Run("we load the preferred alternative when we support it", func(t *testing.T) {
+		loader := NewASTLoader()
+		loader.RegisterCustomLoaderRule(&capabilitiesTestLoader{})
+		runnable, err := load(t, loader, newPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t, runnable); got != "preferred" {
+			t.Fatal("unexpected result", got)
+		}
+	})
+
+	t.Run("we load the fallback otherwise", func(t *testing.T) {
+		runnable, err := load(t, NewASTLoader(), newPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t, runnable); got != "fallback" {
+			t.Fatal("unexpected result", got)
+		}
+	})
+
+	t.Run("we fail when we do not support any alternative", func(t *testing.T) {
+		stage := WithFallbacks[*Void, string](&capabilitiesTestStage{"preferred"})
+		if _, err := load(t, NewASTLoader(), stage); !errors.Is(err, ErrNoSuchStage) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we reject fallbacks whose types differ from the selected alternative", func(t *testing.T) {
+		loadable := &LoadableASTNode{
+			StageName: withFallbacksStageName,
+			Arguments: []byte(`null`),
+			Children: []*LoadableASTNode{{
+				StageName: "tcp_connect",
+				Arguments: []byte(`{}`),
+				Children:  []*LoadableASTNode{},
+			}, {
+				StageName: "tls_handshake",
+				Arguments: []byte(`{}`),
+				Children:  []*LoadableASTNode{},
+			}},
+		}
+		_, err := NewASTLoader().Load(loadable)
+		var typeErr *ErrASTTypeCheck
+		if !errors.As(err, &typeErr) {
+			t.Fatal("unexpected error", err)
+		}
+		expectedPath := []string{"with_fallbacks", "children[1]", "tls_handshake"}
+		if diff := cmp.Diff(expectedPath, typeErr.Path); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("when running the Go code we run the preferred stage", func(t *testing.T) {
+		rtx := NewMinimalRuntime(log.Log)
+		results := newPipeline().Run(context.Background(), rtx, NewValue(&Void{}))
+		if results.Value != "preferred" {
+			t.Fatal("unexpected result", results.Value)
+		}
+	})
+}
+
+func TestASTLoaderCapabilities(t *testing.T) {
+	loader := NewASTLoader()
+	loader.RegisterCustomLoaderRule(&capabilitiesTestLoader{})
+	capabilities := loader.Capabilities()
+
+	// find returns the capability with the given name
+	find := func(name string) *StageCapability {
+		for _, capability := range capabilities {
+			if capability.Name == name {
+				return &capability
+			}
+		}
+		t.Fatal("cannot find", name)
+		return nil
+	}
+
+	t.Run("we include the version and the arguments schema", func(t *testing.T) {
+		expected := &StageCapability{
+			Name:    capabilitiesTestStageName,
+			Version: 2,
+			Arguments: map[string]any{
+				"type": []string{"object", "null"},
+				"properties": map[string]any{
+					"value": map[string]any{"type": "string"},
+				},
+			},
+		}
+		if diff := cmp.Diff(expected, find(capabilitiesTestStageName)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we describe the built-in stages", func(t *testing.T) {
+		capability := find("tcp_connect")
+		if capability.Version != 2 {
+			t.Fatal("unexpected version", capability.Version)
+		}
+		properties := capability.Arguments["properties"].(map[string]any)
+		if _, found := properties["timeout"]; !found {
+			t.Fatal("missing timeout property")
+		}
+	})
+
+	t.Run("we sort the stages by name", func(t *testing.T) {
+		for idx := 1; idx < len(capabilities); idx++ {
+			if capabilities[idx-1].Name >= capabilities[idx].Name {
+				t.Fatal("not sorted", capabilities[idx-1].Name, capabilities[idx].Name)
+			}
+		}
+	})
+}
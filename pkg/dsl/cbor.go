@@ -0,0 +1,173 @@
+package dsl
+
+//
+// CBOR encoding
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// The JSON encoding is the source of truth for ASTs and scripts, and we use CBOR (RFC 8949)
+// only as a more compact representation for delivering them. To this end, we transcode
+// between the JSON and the CBOR data models, such that CBOR documents always represent
+// valid JSON documents and decoding CBOR always produces the same tree as decoding JSON.
+
+// cborSelfDescribePrefix is the self-described CBOR tag (see RFC 8949 Sect. 3.4.6) that
+// we prepend to CBOR documents, which allows us to distinguish CBOR from JSON.
+var cborSelfDescribePrefix = []byte{0xd9, 0xd9, 0xf7}
+
+// cborMaxNestedLevels is the maximum nesting we allow when decoding CBOR, which must be large
+// enough for the default maximum AST depth, given that each AST node uses two levels.
+const cborMaxNestedLevels = 1024
+
+// ErrInvalidCBOR indicates that a CBOR document does not represent a valid JSON document.
+var ErrInvalidCBOR = errors.New("dsl: CBOR document does not represent a JSON document")
+
+var (
+	// cborEncMode is the CBOR encoding mode.
+	cborEncMode cbor.EncMode
+
+	// cborDecMode is the CBOR decoding mode.
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	encOptions := cbor.CoreDetEncOptions()
+	encOptions.ShortestFloat = cbor.ShortestFloat16
+	cborEncMode = runtimex.Try1(encOptions.EncMode())
+
+	decOptions := cbor.DecOptions{
+		DupMapKey:       cbor.DupMapKeyEnforcedAPF,
+		MaxNestedLevels: cborMaxNestedLevels,
+		DefaultMapType:  reflect.TypeOf(map[string]any{}),
+	}
+	cborDecMode = runtimex.Try1(decOptions.DecMode())
+}
+
+// IsCBOR returns whether the given data looks like a CBOR document rather than JSON, i.e.,
+// whether it starts with the self-described CBOR tag or with a CBOR map.
+func IsCBOR(data []byte) bool {
+	if bytes.HasPrefix(data, cborSelfDescribePrefix) {
+		return true
+	}
+	// Note: a CBOR map has major type 5, i.e., 0xa0 ... 0xbf, and these bytes
+	// cannot appear at the beginning of valid UTF-8 (hence JSON) text
+	return len(data) > 0 && data[0] >= 0xa0 && data[0] <= 0xbf
+}
+
+// JSONToCBOR transcodes a JSON document to CBOR.
+func JSONToCBOR(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	encoded, err := cborEncMode.Marshal(cborValueFromJSON(value))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, cborSelfDescribePrefix...), encoded...), nil
+}
+
+// cborValueFromJSON converts a JSON value decoded using json.Decoder.UseNumber to the
+// value we should encode to CBOR, by converting numbers to integers or floats.
+func cborValueFromJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, entry := range value {
+			value[key] = cborValueFromJSON(entry)
+		}
+		return value
+
+	case []any:
+		for idx, entry := range value {
+			value[idx] = cborValueFromJSON(entry)
+		}
+		return value
+
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		if unsigned, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			return unsigned
+		}
+		// Note: the JSON decoder has already validated the number
+		float, _ := value.Float64()
+		return float
+
+	default:
+		return value
+	}
+}
+
+// CBORToJSON transcodes a CBOR document produced by [JSONToCBOR] to JSON.
+func CBORToJSON(data []byte) ([]byte, error) {
+	var value any
+	if err := cborDecMode.Unmarshal(bytes.TrimPrefix(data, cborSelfDescribePrefix), &value); err != nil {
+		return nil, err
+	}
+	if !cborIsJSONValue(value) {
+		return nil, ErrInvalidCBOR
+	}
+	return json.Marshal(value)
+}
+
+// cborIsJSONValue returns whether a decoded CBOR value belongs to the JSON data model.
+func cborIsJSONValue(value any) bool {
+	switch value := value.(type) {
+	case nil, bool, string, int64, uint64, float64:
+		return true
+
+	case map[string]any:
+		for _, entry := range value {
+			if !cborIsJSONValue(entry) {
+				return false
+			}
+		}
+		return true
+
+	case []any:
+		for _, entry := range value {
+			if !cborIsJSONValue(entry) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		// e.g., byte strings and tags, which do not exist in JSON
+		return false
+	}
+}
+
+// MarshalASTCBOR returns the CBOR encoding of the given [SerializableASTNode].
+func MarshalASTCBOR(node *SerializableASTNode) ([]byte, error) {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return JSONToCBOR(data)
+}
+
+// UnmarshalASTCBOR decodes the CBOR encoding of an AST produced by [MarshalASTCBOR].
+func UnmarshalASTCBOR(data []byte) (*LoadableASTNode, error) {
+	data, err := CBORToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var node LoadableASTNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
@@ -0,0 +1,181 @@
+package dsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/tailscale/hujson"
+)
+
+func TestASTCBOR(t *testing.T) {
+	stage := Compose3(
+		DomainName("www.example.com"),
+		DNSLookupGetaddrinfo(DNSLookupGetaddrinfoOptionTags("dns")),
+		MeasureMultipleEndpoints(
+			Compose(
+				MakeEndpointsForPort(443),
+				NewEndpointPipeline(
+					Compose4(
+						TCPConnect(TCPConnectOptionTimeout(10*time.Second)),
+						TLSHandshake(),
+						HTTPConnectionTLS(),
+						Discard[*HTTPConnection](),
+					),
+				),
+			),
+		),
+	)
+
+	// load loads the given node and returns the canonical form of the loaded tree
+	load := func(t *testing.T, node *LoadableASTNode) string {
+		runnable, err := NewASTLoader().Load(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return textTestCanonicalSerializable(t, runnable.ASTNode())
+	}
+
+	jsonData, err := json.Marshal(stage.ASTNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonNode LoadableASTNode
+	if err := json.Unmarshal(jsonData, &jsonNode); err != nil {
+		t.Fatal(err)
+	}
+
+	cborData, err := MarshalASTCBOR(stage.ASTNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cborNode, err := UnmarshalASTCBOR(cborData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("we load the same tree", func(t *testing.T) {
+		if diff := cmp.Diff(load(t, &jsonNode), load(t, cborNode)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we detect the format", func(t *testing.T) {
+		if IsCBOR(jsonData) || !IsCBOR(cborData) {
+			t.Fatal("unexpected format detection")
+		}
+		if !IsCBOR(bytes.TrimPrefix(cborData, cborSelfDescribePrefix)) {
+			t.Fatal("expected to detect CBOR without the self-described tag")
+		}
+	})
+
+	t.Run("CBOR is more compact than JSON", func(t *testing.T) {
+		t.Logf("AST size: JSON %d bytes, CBOR %d bytes", len(jsonData), len(cborData))
+		if len(cborData) >= len(jsonData) {
+			t.Fatal("expected CBOR to be smaller")
+		}
+	})
+}
+
+func TestScriptCBOR(t *testing.T) {
+	for _, filename := range []string{"full.jsonc", "throttling.jsonc", "fbmessenger.jsonc"} {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile("../../testdata/" + filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = hujson.Minimize(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected modelx.InterpreterScript
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			cborData, err := JSONToCBOR(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("script size: JSON %d bytes, CBOR %d bytes (%.1f%%)",
+				len(data), len(cborData), 100*float64(len(cborData))/float64(len(data)))
+			if len(cborData) >= len(data) {
+				t.Fatal("expected CBOR to be smaller")
+			}
+
+			jsonData, err := CBORToJSON(cborData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got modelx.InterpreterScript
+			if err := json.Unmarshal(jsonData, &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(scriptCBORTestNormalize(t, &expected), scriptCBORTestNormalize(t, &got)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// scriptCBORTestNormalize returns a generic representation of the script where the
+// commands arguments are decoded values rather than raw JSON bytes.
+func scriptCBORTestNormalize(t *testing.T, script *modelx.InterpreterScript) any {
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestCBORToJSON(t *testing.T) {
+	t.Run("we preserve numbers", func(t *testing.T) {
+		input := `[0,-3,1.5,18446744073709551615,-9223372036854775808,1e+300]`
+		cborData, err := JSONToCBOR([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := CBORToJSON(cborData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != input {
+			t.Fatal("expected", input, "got", string(output))
+		}
+	})
+
+	t.Run("we reject values that do not exist in JSON", func(t *testing.T) {
+		for _, value := range []any{
+			map[string]any{"x": []byte{1, 2, 3}},
+			cbor.Tag{Number: 1, Content: 17},
+			map[int]any{1: "x"},
+		} {
+			data, err := cbor.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CBORToJSON(data); err == nil {
+				t.Fatal("expected an error for", value)
+			}
+		}
+	})
+
+	t.Run("we reject duplicate keys", func(t *testing.T) {
+		// {"a": 1, "a": 2}
+		data := []byte{0xa2, 0x61, 'a', 0x01, 0x61, 'a', 0x02}
+		var dupErr *cbor.DupMapKeyError
+		if _, err := CBORToJSON(data); !errors.As(err, &dupErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
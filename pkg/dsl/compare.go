@@ -0,0 +1,254 @@
+package dsl
+
+//
+// Stages comparing the results joined by Zip
+//
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/ooni/probe-engine/pkg/measurexlite"
+)
+
+// AddressSetComparison is the result of [CompareAddressSets].
+type AddressSetComparison struct {
+	// FirstFailure is the failure of the first lookup or nil.
+	FirstFailure *string `json:"first_failure"`
+
+	// SecondFailure is the failure of the second lookup or nil.
+	SecondFailure *string `json:"second_failure"`
+
+	// Common contains the addresses returned by both lookups.
+	Common []string `json:"common"`
+
+	// OnlyFirst contains the addresses returned only by the first lookup.
+	OnlyFirst []string `json:"only_first"`
+
+	// OnlySecond contains the addresses returned only by the second lookup.
+	OnlySecond []string `json:"only_second"`
+
+	// Overlap is true when the lookups returned at least one common address.
+	Overlap bool `json:"overlap"`
+}
+
+// CompareAddressSets returns a stage comparing the addresses returned by two DNS lookups
+// joined using [Zip] (e.g., getaddrinfo and a UDP resolver). The stage saves the result of
+// the comparison as an [Annotation] with the given name. An empty name means using the
+// stage name, i.e., "compare_address_sets". We treat a failed lookup as an empty set.
+func CompareAddressSets(annotation string) Stage[*Pair[*DNSLookupResult, *DNSLookupResult], *AddressSetComparison] {
+	return &compareStage[*DNSLookupResult, *DNSLookupResult, *AddressSetComparison]{
+		args:      compareArguments{annotation},
+		compare:   compareAddressSets,
+		stageName: compareAddressSetsStageName,
+	}
+}
+
+const compareAddressSetsStageName = "compare_address_sets"
+
+// compareAddressSets implements [CompareAddressSets].
+func compareAddressSets(first, second Maybe[*DNSLookupResult]) *AddressSetComparison {
+	output := &AddressSetComparison{
+		FirstFailure:  measurexlite.NewFailure(first.Error),
+		SecondFailure: measurexlite.NewFailure(second.Error),
+		Common:        []string{},
+		OnlyFirst:     []string{},
+		OnlySecond:    []string{},
+	}
+	firstSet, secondSet := compareAddressSetOf(first), compareAddressSetOf(second)
+	for address := range firstSet {
+		if secondSet[address] {
+			output.Common = append(output.Common, address)
+			continue
+		}
+		output.OnlyFirst = append(output.OnlyFirst, address)
+	}
+	for address := range secondSet {
+		if !firstSet[address] {
+			output.OnlySecond = append(output.OnlySecond, address)
+		}
+	}
+	sort.Strings(output.Common)
+	sort.Strings(output.OnlyFirst)
+	sort.Strings(output.OnlySecond)
+	output.Overlap = len(output.Common) > 0
+	return output
+}
+
+// compareAddressSetOf returns the set of addresses of a DNS lookup result.
+func compareAddressSetOf(result Maybe[*DNSLookupResult]) map[string]bool {
+	set := map[string]bool{}
+	if result.Error != nil || result.Value == nil {
+		return set
+	}
+	for _, address := range result.Value.Addresses {
+		set[address] = true
+	}
+	return set
+}
+
+// HTTPResponseComparison is the result of [CompareHTTPResponses].
+type HTTPResponseComparison struct {
+	// FirstFailure is the failure of the first transaction or nil.
+	FirstFailure *string `json:"first_failure"`
+
+	// SecondFailure is the failure of the second transaction or nil.
+	SecondFailure *string `json:"second_failure"`
+
+	// FirstStatusCode is the status code of the first response or zero.
+	FirstStatusCode int64 `json:"first_status_code"`
+
+	// SecondStatusCode is the status code of the second response or zero.
+	SecondStatusCode int64 `json:"second_status_code"`
+
+	// FirstBodySHA256 is the SHA256 of the first response body snapshot or empty.
+	FirstBodySHA256 string `json:"first_body_sha256"`
+
+	// SecondBodySHA256 is the SHA256 of the second response body snapshot or empty.
+	SecondBodySHA256 string `json:"second_body_sha256"`
+
+	// StatusCodeEqual is true when both transactions succeeded with the same status code.
+	StatusCodeEqual bool `json:"status_code_equal"`
+
+	// BodyHashEqual is true when both transactions succeeded with the same body snapshot.
+	BodyHashEqual bool `json:"body_hash_equal"`
+}
+
+// CompareHTTPResponses returns a stage comparing the responses returned by two HTTP
+// transactions joined using [Zip] (e.g., using HTTP/2 and HTTP/3). The stage saves the result
+// of the comparison as an [Annotation] with the given name. An empty name means using the
+// stage name, i.e., "compare_http_responses". Note that we hash the body snapshots, which
+// are truncated (see [HTTPTransactionOptionResponseBodySnapshotSize]).
+func CompareHTTPResponses(annotation string) Stage[*Pair[*HTTPResponse, *HTTPResponse], *HTTPResponseComparison] {
+	return &compareStage[*HTTPResponse, *HTTPResponse, *HTTPResponseComparison]{
+		args:      compareArguments{annotation},
+		compare:   compareHTTPResponses,
+		stageName: compareHTTPResponsesStageName,
+	}
+}
+
+const compareHTTPResponsesStageName = "compare_http_responses"
+
+// compareHTTPResponses implements [CompareHTTPResponses].
+func compareHTTPResponses(first, second Maybe[*HTTPResponse]) *HTTPResponseComparison {
+	output := &HTTPResponseComparison{
+		FirstFailure:  measurexlite.NewFailure(first.Error),
+		SecondFailure: measurexlite.NewFailure(second.Error),
+	}
+	output.FirstStatusCode, output.FirstBodySHA256 = compareHTTPResponseSummary(first)
+	output.SecondStatusCode, output.SecondBodySHA256 = compareHTTPResponseSummary(second)
+	succeeded := first.Error == nil && second.Error == nil
+	output.StatusCodeEqual = succeeded && output.FirstStatusCode == output.SecondStatusCode
+	output.BodyHashEqual = succeeded && output.FirstBodySHA256 == output.SecondBodySHA256
+	return output
+}
+
+// compareHTTPResponseSummary returns the status code and the body hash of a response.
+func compareHTTPResponseSummary(result Maybe[*HTTPResponse]) (int64, string) {
+	if result.Error != nil || result.Value == nil || result.Value.Response == nil {
+		return 0, ""
+	}
+	digest := sha256.Sum256(result.Value.ResponseBodySnapshot)
+	return int64(result.Value.Response.StatusCode), hex.EncodeToString(digest[:])
+}
+
+// compareArguments contains the arguments of the comparison stages.
+type compareArguments struct {
+	Annotation string `json:"annotation,omitempty"`
+}
+
+// compareStage is a stage comparing the results contained by a [Pair].
+type compareStage[A, B, C any] struct {
+	args      compareArguments
+	compare   func(first Maybe[A], second Maybe[B]) C
+	stageName string
+}
+
+// ASTNode implements Stage.
+func (sx *compareStage[A, B, C]) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: sx.stageName,
+		Arguments: &sx.args,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// compareLoader loads a comparison stage. Because [Zip] has type erasure when we load
+// it from an AST, the loaded stage receives a *Pair[any, any] in input, and we declare
+// the expected types of the pair elements, such that we can type check the AST.
+type compareLoader[A, B, C any] struct {
+	compare   func(first Maybe[A], second Maybe[B]) C
+	stageName string
+}
+
+// Load implements ASTLoaderRule.
+func (cl *compareLoader[A, B, C]) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var args compareArguments
+	if err := json.Unmarshal(node.Arguments, &args); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	stage := &compareStage[A, B, C]{
+		args:      args,
+		compare:   cl.compare,
+		stageName: cl.stageName,
+	}
+	return &typedRunnableASTNode{
+		RunnableASTNode: &StageRunnableASTNode[*Pair[any, any], C]{S: &erasedCompareStage[A, B, C]{stage}},
+		input:           typeOf[*Pair[any, any]](),
+		output:          typeOf[C](),
+		inputPair:       &astPairTypes{typeOf[A](), typeOf[B]()},
+		outputPair:      nil,
+	}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (cl *compareLoader[A, B, C]) StageName() string {
+	return cl.stageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (cl *compareLoader[A, B, C]) StageArguments() any {
+	return &compareArguments{}
+}
+
+// Run implements Stage.
+func (sx *compareStage[A, B, C]) Run(ctx context.Context, rtx Runtime, input Maybe[*Pair[A, B]]) Maybe[C] {
+	if input.Error != nil {
+		return NewError[C](input.Error)
+	}
+	output := sx.compare(input.Value.First, input.Value.Second)
+	annotation := sx.args.Annotation
+	if annotation == "" {
+		annotation = sx.stageName
+	}
+	SaveAnnotation(rtx, annotation, output)
+	return NewValue(output)
+}
+
+// erasedCompareStage adapts a comparison stage to receive a *Pair[any, any] in input.
+type erasedCompareStage[A, B, C any] struct {
+	sx *compareStage[A, B, C]
+}
+
+// ASTNode implements Stage.
+func (sx *erasedCompareStage[A, B, C]) ASTNode() *SerializableASTNode {
+	return sx.sx.ASTNode()
+}
+
+// Run implements Stage.
+func (sx *erasedCompareStage[A, B, C]) Run(ctx context.Context, rtx Runtime, input Maybe[*Pair[any, any]]) Maybe[C] {
+	if input.Error != nil {
+		return NewError[C](input.Error)
+	}
+	pair, except := asSpecificPair[A, B](input.Value)
+	if except != nil {
+		return NewError[C](except)
+	}
+	return sx.sx.Run(ctx, rtx, NewValue(pair))
+}
@@ -0,0 +1,209 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// compareTestRun runs the given AST after a JSON round trip, such that we also test
+// the loader, and returns the output and the observations.
+func compareTestRun(t *testing.T, root *SerializableASTNode) (Maybe[any], *Observations) {
+	data, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node LoadableASTNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		t.Fatal(err)
+	}
+	runnable, err := NewASTLoader().Load(&node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtx := NewMinimalRuntime(log.Log)
+	output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{}))
+	return output, ReduceObservations(rtx.ExtractObservations()...)
+}
+
+func TestZip(t *testing.T) {
+	first := DNSLookupStatic("8.8.8.8", "1.1.1.1")
+	second := DNSLookupStatic("8.8.8.8", "9.9.9.9")
+
+	t.Run("we return the result of each stage", func(t *testing.T) {
+		output := Zip(first, second).Run(context.Background(), NewMinimalRuntime(log.Log), NewValue("dns.google"))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		if diff := cmp.Diff([]string{"8.8.8.8", "1.1.1.1"}, output.Value.First.Value.Addresses); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{"8.8.8.8", "9.9.9.9"}, output.Value.Second.Value.Addresses); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we can load and compare address sets", func(t *testing.T) {
+		stage := Compose3(DomainName("dns.google"), Zip(first, second), CompareAddressSets("dns_consistency"))
+		output, observations := compareTestRun(t, stage.ASTNode())
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		expected := &AddressSetComparison{
+			Common:     []string{"8.8.8.8"},
+			OnlyFirst:  []string{"1.1.1.1"},
+			OnlySecond: []string{"9.9.9.9"},
+			Overlap:    true,
+		}
+		if diff := cmp.Diff(expected, output.Value); diff != "" {
+			t.Fatal(diff)
+		}
+		if len(observations.Annotations) != 1 || observations.Annotations[0].Name != "dns_consistency" {
+			t.Fatal("expected to find the annotation")
+		}
+	})
+
+	t.Run("we type check the elements of the pair", func(t *testing.T) {
+		// compareTestLoad loads the given AST after a JSON round trip
+		compareTestLoad := func(t *testing.T, root *SerializableASTNode) error {
+			data, err := json.Marshal(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var node LoadableASTNode
+			if err := json.Unmarshal(data, &node); err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewASTLoader().Load(&node)
+			return err
+		}
+
+		httpTransaction := Compose3(TCPConnect(), HTTPConnectionTCP(), HTTPTransaction())
+		httpResponses := Compose(
+			NewEndpoint("93.184.216.34:80", NewEndpointOptionDomain("www.example.com")),
+			Zip(httpTransaction, httpTransaction),
+		)
+
+		testcases := []struct {
+			name       string
+			root       *SerializableASTNode
+			expectPath []string
+		}{{
+			name: "when comparing DNS results as HTTP responses",
+			root: &SerializableASTNode{
+				StageName: composeStageName,
+				Children: []*SerializableASTNode{
+					Compose(DomainName("dns.google"), Zip(first, second)).ASTNode(),
+					CompareHTTPResponses("").ASTNode(),
+				},
+			},
+			expectPath: []string{"compose", "children[1]", "compare_http_responses"},
+		}, {
+			name: "when comparing the results of HTTP stages as address sets",
+			root: &SerializableASTNode{
+				StageName: composeStageName,
+				Children:  []*SerializableASTNode{httpResponses.ASTNode(), CompareAddressSets("").ASTNode()},
+			},
+			expectPath: []string{"compose", "children[1]", "compare_address_sets"},
+		}, {
+			name: "when the pair crosses a timeout",
+			root: &SerializableASTNode{
+				StageName: composeStageName,
+				Children: []*SerializableASTNode{
+					WithTimeout(Compose(DomainName("dns.google"), Zip(first, second)), time.Second).ASTNode(),
+					CompareHTTPResponses("").ASTNode(),
+				},
+			},
+			expectPath: []string{"compose", "children[1]", "compare_http_responses"},
+		}}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				var typeErr *ErrASTTypeCheck
+				if err := compareTestLoad(t, tc.root); !errors.As(err, &typeErr) {
+					t.Fatal("unexpected error", err)
+				}
+				if diff := cmp.Diff(tc.expectPath, typeErr.Path); diff != "" {
+					t.Fatal(diff)
+				}
+			})
+		}
+
+		t.Run("when the elements types are correct", func(t *testing.T) {
+			stage := Compose3(DomainName("dns.google"), WithTimeout(Zip(first, second), time.Second), CompareAddressSets(""))
+			if err := compareTestLoad(t, stage.ASTNode()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+
+	t.Run("we type check the children inputs", func(t *testing.T) {
+		root := &SerializableASTNode{
+			StageName: zipStageName,
+			Children:  []*SerializableASTNode{first.ASTNode(), TCPConnect().ASTNode()},
+		}
+		data, err := json.Marshal(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		var typeErr *ErrASTTypeCheck
+		if _, err := NewASTLoader().Load(&node); !errors.As(err, &typeErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
+
+func TestCompareHTTPResponses(t *testing.T) {
+	// newResponse creates a successful HTTP response
+	newResponse := func(statusCode int, body string) Maybe[*HTTPResponse] {
+		return NewValue(&HTTPResponse{
+			Response:             &http.Response{StatusCode: statusCode},
+			ResponseBodySnapshot: []byte(body),
+		})
+	}
+
+	t.Run("with equal responses", func(t *testing.T) {
+		output := compareHTTPResponses(newResponse(200, "hello"), newResponse(200, "hello"))
+		if !output.StatusCodeEqual || !output.BodyHashEqual {
+			t.Fatalf("unexpected comparison %+v", output)
+		}
+	})
+
+	t.Run("with different bodies", func(t *testing.T) {
+		output := compareHTTPResponses(newResponse(200, "hello"), newResponse(200, "blocked"))
+		if !output.StatusCodeEqual || output.BodyHashEqual {
+			t.Fatalf("unexpected comparison %+v", output)
+		}
+	})
+
+	t.Run("with a failed transaction", func(t *testing.T) {
+		failed := NewError[*HTTPResponse](errors.New("connection_reset"))
+		output := compareHTTPResponses(newResponse(200, ""), failed)
+		if output.StatusCodeEqual || output.BodyHashEqual {
+			t.Fatalf("unexpected comparison %+v", output)
+		}
+		if output.FirstFailure != nil || output.SecondFailure == nil {
+			t.Fatalf("unexpected failure %+v", output.SecondFailure)
+		}
+	})
+
+	t.Run("we save the annotation using the stage name by default", func(t *testing.T) {
+		rtx := NewMinimalRuntime(log.Log)
+		pair := &Pair[*HTTPResponse, *HTTPResponse]{newResponse(200, ""), newResponse(200, "")}
+		CompareHTTPResponses("").Run(context.Background(), rtx, NewValue(pair))
+		observations := ReduceObservations(rtx.ExtractObservations()...)
+		if len(observations.Annotations) != 1 || observations.Annotations[0].Name != "compare_http_responses" {
+			t.Fatal("expected to find the annotation")
+		}
+	})
+}
@@ -51,8 +51,12 @@ func (*composeLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableAS
 	// Note: we Compose using `any` but we're not creating any Maybe[any] in the [composeStage.Run]
 	// method and inner stages should create correctly-typed Maybes.
 	runtimex.Assert(len(runnables) == 2, "expected exactly two children nodes")
-	stage := Compose[any, any, any](runnables[0], runnables[1])
-	return stage, nil
+	typed, err := inferComposeTypes(node, runnables[0], runnables[1])
+	if err != nil {
+		return nil, err
+	}
+	typed.RunnableASTNode = Compose[any, any, any](runnables[0], runnables[1])
+	return typed, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -60,6 +64,11 @@ func (*composeLoader) StageName() string {
 	return composeStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*composeLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (sx *composeStage[A, B, C]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[C] {
 	// Note: we cannot create any Maybe here because we may be a composeStage[any, any, any]
@@ -0,0 +1,277 @@
+package dsl
+
+//
+// AST documents with named subtree definitions
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SerializableASTDocument is the serializable representation of an AST document, which
+// contains a root node and a table of named subtree definitions. Nodes created using
+// [ASTReference] refer to the definitions, so we only serialize repeated subtrees once.
+type SerializableASTDocument struct {
+	// Definitions maps a definition name to the corresponding subtree.
+	Definitions map[string]*SerializableASTNode `json:"definitions"`
+
+	// Root is the root node of the AST.
+	Root *SerializableASTNode `json:"root"`
+}
+
+// LoadableASTDocument is the loadable representation of a [SerializableASTDocument].
+type LoadableASTDocument struct {
+	// Definitions maps a definition name to the corresponding subtree.
+	Definitions map[string]*LoadableASTNode `json:"definitions"`
+
+	// Root is the root node of the AST.
+	Root *LoadableASTNode `json:"root"`
+}
+
+// referenceStageName is the name of the nodes referring to a definition.
+const referenceStageName = "ref"
+
+// referenceArguments contains the arguments of a node referring to a definition.
+type referenceArguments struct {
+	Name string `json:"name"`
+}
+
+// ASTReference returns a node referring to the definition with the given name in the
+// [SerializableASTDocument] containing the node.
+func ASTReference(name string) *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: referenceStageName,
+		Arguments: &referenceArguments{name},
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// NewSerializableASTDocument creates a [SerializableASTDocument] from the given root node by
+// moving each subtree that occurs more than once into the definitions table and by replacing
+// its occurrences with an [ASTReference]. We only move subtrees containing children, since
+// a reference is not smaller than a leaf node. We compare subtrees using [CanonicalASTJSON].
+func NewSerializableASTDocument(root *SerializableASTNode) (*SerializableASTDocument, error) {
+	if root == nil {
+		return nil, ErrNilASTNode
+	}
+	dd := &astDeduplicator{
+		counts:      map[string]int{},
+		definitions: map[string]*SerializableASTNode{},
+		keys:        map[*SerializableASTNode]string{},
+		names:       map[string]string{},
+		uses:        map[string]int{},
+	}
+	if err := dd.count(root); err != nil {
+		return nil, err
+	}
+	dd.use(root)
+	doc := &SerializableASTDocument{
+		Definitions: dd.definitions,
+		Root:        dd.rewrite(root),
+	}
+	return doc, nil
+}
+
+// astDeduplicator moves repeated subtrees into the definitions table.
+//
+// We proceed in three steps. First, we count the occurrences of each subtree. Then, we count
+// the uses of each subtree assuming we replace all the repeated subtrees with references,
+// such that we only visit the body of a definition once. Finally, we only replace the subtrees
+// used more than once, which excludes the subtrees that only repeat inside another definition.
+type astDeduplicator struct {
+	// counts maps the canonical form of a subtree to its number of occurrences.
+	counts map[string]int
+
+	// definitions is the definitions table we are building.
+	definitions map[string]*SerializableASTNode
+
+	// keys maps each node to its canonical form.
+	keys map[*SerializableASTNode]string
+
+	// names maps the canonical form of a repeated subtree to the definition name.
+	names map[string]string
+
+	// uses maps the canonical form of a subtree to its number of uses.
+	uses map[string]int
+}
+
+// count computes the canonical form of each subtree and counts the occurrences.
+func (dd *astDeduplicator) count(node *SerializableASTNode) error {
+	if node == nil {
+		return ErrNilASTNode
+	}
+	canonical, err := CanonicalASTJSON(node)
+	if err != nil {
+		return err
+	}
+	key := string(canonical)
+	dd.keys[node] = key
+	dd.counts[key]++
+	for _, child := range node.Children {
+		if err := dd.count(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// use counts the uses of each subtree.
+func (dd *astDeduplicator) use(node *SerializableASTNode) {
+	key := dd.keys[node]
+	dd.uses[key]++
+	if len(node.Children) > 0 && dd.counts[key] > 1 && dd.uses[key] > 1 {
+		return // we have already visited the body of this definition
+	}
+	for _, child := range node.Children {
+		dd.use(child)
+	}
+}
+
+// rewrite returns a copy of the given node where repeated subtrees are references.
+func (dd *astDeduplicator) rewrite(node *SerializableASTNode) *SerializableASTNode {
+	key := dd.keys[node]
+	if len(node.Children) <= 0 || dd.uses[key] <= 1 {
+		return dd.rewriteChildren(node)
+	}
+	name, found := dd.names[key]
+	if !found {
+		name = "def" + strconv.Itoa(len(dd.names))
+		dd.names[key] = name
+		dd.definitions[name] = dd.rewriteChildren(node)
+	}
+	return ASTReference(name)
+}
+
+// rewriteChildren returns a copy of the given node where we rewrite the children.
+func (dd *astDeduplicator) rewriteChildren(node *SerializableASTNode) *SerializableASTNode {
+	children := []*SerializableASTNode{}
+	for _, child := range node.Children {
+		children = append(children, dd.rewrite(child))
+	}
+	return &SerializableASTNode{
+		StageName: node.StageName,
+		Arguments: node.Arguments,
+		Children:  children,
+	}
+}
+
+// UnmarshalASTDocument parses the JSON serialization of either a [SerializableASTDocument]
+// or a [SerializableASTNode], which we treat as a document without definitions.
+func UnmarshalASTDocument(data []byte) (*LoadableASTDocument, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	if _, found := probe["root"]; !found {
+		var root LoadableASTNode
+		if err := json.Unmarshal(data, &root); err != nil {
+			return nil, err
+		}
+		return &LoadableASTDocument{Root: &root}, nil
+	}
+	var doc LoadableASTDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// ErrNoSuchASTDefinition indicates that an AST refers to a definition that does not exist.
+var ErrNoSuchASTDefinition = errors.New("dsl: no such AST definition")
+
+// ErrASTDefinitionCycle indicates that the definitions of an AST refer to each other cyclically.
+var ErrASTDefinitionCycle = errors.New("dsl: cycle in AST definitions")
+
+// ExpandASTDocument returns the AST obtained by replacing each reference in the document
+// with the corresponding definition. We return an error wrapping [ErrNoSuchASTDefinition]
+// when a definition is missing and an error wrapping [ErrASTDefinitionCycle] when a
+// definition directly or indirectly refers to itself.
+//
+// We expand each definition once and the resulting AST shares the expanded definition
+// among all the nodes referring to it, so the expansion takes linear time. Note that the
+// [ASTLoader] visits a shared subtree once for each reference, hence the [ASTLoaderLimits]
+// apply to the fully expanded AST and bound the work the loader performs.
+func ExpandASTDocument(doc *LoadableASTDocument) (*LoadableASTNode, error) {
+	ex := &astExpander{
+		doc:      doc,
+		expanded: map[string]*LoadableASTNode{},
+		visiting: map[string]bool{},
+	}
+	return ex.expand(doc.Root, nil)
+}
+
+// astExpander expands the references of a [LoadableASTDocument].
+type astExpander struct {
+	doc      *LoadableASTDocument
+	expanded map[string]*LoadableASTNode
+	visiting map[string]bool
+}
+
+// expand returns a copy of the given node where we have replaced the references. The
+// stack argument contains the definitions we're expanding, which we use to report cycles.
+func (ex *astExpander) expand(node *LoadableASTNode, stack []string) (*LoadableASTNode, error) {
+	if node == nil {
+		return nil, ErrNilASTNode
+	}
+	if node.StageName == referenceStageName {
+		return ex.resolve(node, stack)
+	}
+	children := []*LoadableASTNode{}
+	for _, child := range node.Children {
+		expanded, err := ex.expand(child, stack)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, expanded)
+	}
+	output := &LoadableASTNode{
+		StageName: node.StageName,
+		Arguments: node.Arguments,
+		Children:  children,
+	}
+	return output, nil
+}
+
+// resolve returns the expanded definition referred to by the given node.
+func (ex *astExpander) resolve(node *LoadableASTNode, stack []string) (*LoadableASTNode, error) {
+	var config referenceArguments
+	if err := json.Unmarshal(node.Arguments, &config); err != nil {
+		return nil, err
+	}
+	if len(node.Children) != 0 {
+		return nil, ErrInvalidNumberOfChildren
+	}
+	if expanded, found := ex.expanded[config.Name]; found {
+		return expanded, nil
+	}
+	stack = append(stack, config.Name)
+	if ex.visiting[config.Name] {
+		return nil, fmt.Errorf("%w: %s", ErrASTDefinitionCycle, strings.Join(stack, " -> "))
+	}
+	definition, found := ex.doc.Definitions[config.Name]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchASTDefinition, config.Name)
+	}
+	ex.visiting[config.Name] = true
+	expanded, err := ex.expand(definition, stack)
+	delete(ex.visiting, config.Name)
+	if err != nil {
+		return nil, err
+	}
+	ex.expanded[config.Name] = expanded
+	return expanded, nil
+}
+
+// LoadDocument expands the given [LoadableASTDocument] using [ExpandASTDocument] and
+// then loads the resulting AST using [ASTLoader.Load].
+func (al *ASTLoader) LoadDocument(doc *LoadableASTDocument) (RunnableASTNode, error) {
+	root, err := ExpandASTDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	return al.Load(root)
+}
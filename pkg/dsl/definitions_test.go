@@ -0,0 +1,206 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// definitionsTestPipeline returns a pipeline containing repeated subtrees.
+func definitionsTestPipeline() Stage[*Void, *Void] {
+	endpointPipeline := func() Stage[*Endpoint, *Void] {
+		return Compose4(
+			TCPConnect(TCPConnectOptionTimeout(10*time.Second)),
+			TLSHandshake(),
+			HTTPConnectionTLS(),
+			Discard[*HTTPConnection](),
+		)
+	}
+	domainPipeline := func(domain string) Stage[*Void, *Void] {
+		return Compose3(
+			DomainName(domain),
+			DNSLookupGetaddrinfo(),
+			MeasureMultipleEndpoints(
+				Compose(
+					MakeEndpointsForPort(443),
+					NewEndpointPipeline(endpointPipeline()),
+				),
+			),
+		)
+	}
+	return RunStagesInParallel(
+		domainPipeline("www.example.com"),
+		domainPipeline("www.example.org"),
+		domainPipeline("www.example.com"),
+	)
+}
+
+// definitionsTestDocument parses the given JSON as a document.
+func definitionsTestDocument(t *testing.T, data string) *LoadableASTDocument {
+	doc, err := UnmarshalASTDocument([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestNewSerializableASTDocument(t *testing.T) {
+	root := definitionsTestPipeline().ASTNode()
+	doc, err := NewSerializableASTDocument(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("we define the repeated subtrees", func(t *testing.T) {
+		// Note: we expect one definition for the repeated domain pipeline and one for the
+		// DNS lookup and endpoints pipeline, which is also part of the other domain pipeline
+		if len(doc.Definitions) != 2 {
+			t.Fatal("unexpected number of definitions", len(doc.Definitions))
+		}
+		docData, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootData, err := json.Marshal(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("AST size: %d bytes; document size: %d bytes", len(rootData), len(docData))
+		if len(docData) >= len(rootData) {
+			t.Fatal("expected the document to be smaller")
+		}
+	})
+
+	t.Run("we preserve the serialization", func(t *testing.T) {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadable := definitionsTestDocument(t, string(data))
+		roundTrip, err := json.Marshal(loadable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(data), string(roundTrip)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we load the same AST", func(t *testing.T) {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().LoadDocument(definitionsTestDocument(t, string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := textTestCanonicalSerializable(t, root)
+		if diff := cmp.Diff(expected, textTestCanonicalSerializable(t, runnable.ASTNode())); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestExpandASTDocument(t *testing.T) {
+	t.Run("we accept a node without definitions", func(t *testing.T) {
+		doc := definitionsTestDocument(t, `{"stage_name":"identity","arguments":{},"children":[]}`)
+		root, err := ExpandASTDocument(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.StageName != "identity" {
+			t.Fatal("unexpected stage name", root.StageName)
+		}
+	})
+
+	t.Run("we share the definitions", func(t *testing.T) {
+		doc := definitionsTestDocument(t, `{
+			"definitions": {"x": {"stage_name": "identity"}},
+			"root": {"stage_name": "compose", "children": [
+				{"stage_name": "ref", "arguments": {"name": "x"}},
+				{"stage_name": "ref", "arguments": {"name": "x"}}
+			]}
+		}`)
+		root, err := ExpandASTDocument(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Children[0] != root.Children[1] || root.Children[0].StageName != "identity" {
+			t.Fatal("expected to share the expanded definition")
+		}
+	})
+
+	t.Run("we reject missing definitions", func(t *testing.T) {
+		doc := definitionsTestDocument(t, `{"root": {"stage_name": "ref", "arguments": {"name": "x"}}}`)
+		if _, err := ExpandASTDocument(doc); !errors.Is(err, ErrNoSuchASTDefinition) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we reject cycles", func(t *testing.T) {
+		for _, definitions := range []string{
+			`{"x": {"stage_name": "ref", "arguments": {"name": "x"}}}`,
+			`{"x": {"stage_name": "compose", "children": [
+				{"stage_name": "identity"},
+				{"stage_name": "ref", "arguments": {"name": "y"}}
+			]}, "y": {"stage_name": "ref", "arguments": {"name": "x"}}}`,
+		} {
+			doc := definitionsTestDocument(t, fmt.Sprintf(
+				`{"definitions": %s, "root": {"stage_name": "ref", "arguments": {"name": "x"}}}`, definitions))
+			if _, err := ExpandASTDocument(doc); !errors.Is(err, ErrASTDefinitionCycle) {
+				t.Fatal("unexpected error", err)
+			}
+		}
+	})
+
+	t.Run("the limits apply to the expanded AST", func(t *testing.T) {
+		// Note: each definition doubles the size of the AST
+		definitions := map[string]any{"d0": (&Identity[*Void]{}).ASTNode()}
+		for idx := 1; idx < 32; idx++ {
+			previous := ASTReference(fmt.Sprintf("d%d", idx-1))
+			definitions[fmt.Sprintf("d%d", idx)] = &SerializableASTNode{
+				StageName: composeStageName,
+				Children:  []*SerializableASTNode{previous, previous},
+			}
+		}
+		data, err := json.Marshal(map[string]any{
+			"definitions": definitions,
+			"root":        ASTReference("d31"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var limitErr *ErrASTLimitExceeded
+		if _, err := NewASTLoader().LoadDocument(definitionsTestDocument(t, string(data))); !errors.As(err, &limitErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
+
+func TestASTLoaderLoadReference(t *testing.T) {
+	t.Run("we cannot load a reference outside of a document", func(t *testing.T) {
+		node := &LoadableASTNode{StageName: "ref", Arguments: []byte(`{"name":"x"}`)}
+		if _, err := NewASTLoader().Load(node); !errors.Is(err, ErrNoSuchASTDefinition) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("with_fallbacks does not skip a reference", func(t *testing.T) {
+		node := &LoadableASTNode{
+			StageName: withFallbacksStageName,
+			Arguments: []byte(`{}`),
+			Children: []*LoadableASTNode{
+				{StageName: "ref", Arguments: []byte(`{"name":"x"}`)},
+				{StageName: "identity"},
+			},
+		}
+		if _, err := NewASTLoader().Load(node); !errors.Is(err, ErrNoSuchASTDefinition) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
@@ -37,7 +37,7 @@ func (*discardLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableAS
 	// Because there is type erasure, we create a Discard[any], which is fine because the
 	// type parameter is for the input and not for the output
 	stage := Discard[any]()
-	return &StageRunnableASTNode[any, *Void]{stage}, nil
+	return &StageRunnableASTNode[any, *Void]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -45,6 +45,11 @@ func (*discardLoader) StageName() string {
 	return discardStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*discardLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (sx *discardStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[T]) Maybe[*Void] {
 	if input.Error != nil {
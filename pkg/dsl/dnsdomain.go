@@ -39,7 +39,7 @@ func (*domainNameLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runnabl
 	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
 		return nil, err
 	}
-	return &StageRunnableASTNode[*Void, string]{&stage}, nil
+	return &StageRunnableASTNode[*Void, string]{S: &stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -47,6 +47,11 @@ func (*domainNameLoader) StageName() string {
 	return domainNameStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*domainNameLoader) StageArguments() any {
+	return &domainNameStage{}
+}
+
 // Run implements Stage.
 func (sx *domainNameStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[string] {
 	if input.Error != nil {
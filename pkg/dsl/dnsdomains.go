@@ -0,0 +1,67 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// DomainNames returns a stage that returns the given list of domain names. Use [ForEach]
+// to measure each domain name using the same measurement pipeline.
+func DomainNames(values ...string) Stage[*Void, []string] {
+	return &domainNamesStage{values}
+}
+
+type domainNamesStage struct {
+	Domains []string `json:"domains"`
+}
+
+const domainNamesStageName = "domain_names"
+
+// ASTNode implements Stage.
+func (sx *domainNamesStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: domainNamesStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type domainNamesLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*domainNamesLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage domainNamesStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[*Void, []string]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*domainNamesLoader) StageName() string {
+	return domainNamesStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*domainNamesLoader) StageArguments() any {
+	return &domainNamesStage{}
+}
+
+// Run implements Stage.
+func (sx *domainNamesStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[[]string] {
+	if input.Error != nil {
+		return NewError[[]string](input.Error)
+	}
+	if len(sx.Domains) <= 0 {
+		return NewError[[]string](&ErrException{&ErrInvalidDomain{""}})
+	}
+	for _, domain := range sx.Domains {
+		if !ValidDomainNames(domain) {
+			return NewError[[]string](&ErrException{&ErrInvalidDomain{domain}})
+		}
+	}
+	return NewValue(sx.Domains)
+}
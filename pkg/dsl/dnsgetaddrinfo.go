@@ -19,6 +19,14 @@ func DNSLookupGetaddrinfoOptionTags(tags ...string) DNSLookupGetaddrinfoOption {
 	}
 }
 
+// DNSLookupGetaddrinfoOptionTimeout allows configuring the DNS lookup timeout. A zero or
+// negative value means that we should use the default timeout.
+func DNSLookupGetaddrinfoOptionTimeout(timeout time.Duration) DNSLookupGetaddrinfoOption {
+	return func(operation *dnsLookupGetaddrinfoOperation) {
+		operation.Timeout = Duration(timeout)
+	}
+}
+
 // DNSLookupGetaddrinfo returns a stage that performs DNS lookups using getaddrinfo.
 //
 // This function returns an [ErrDNSLookup] if the error is a DNS lookup error. Remember to
@@ -34,18 +42,33 @@ func DNSLookupGetaddrinfo(options ...DNSLookupGetaddrinfoOption) Stage[string, *
 }
 
 type dnsLookupGetaddrinfoOperation struct {
-	Tags []string `json:"tags,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
 }
 
+// dnsLookupGetaddrinfoDefaultTimeout is the default DNS lookup timeout.
+const dnsLookupGetaddrinfoDefaultTimeout = 4 * time.Second
+
 const dnsLookupGetaddrinfoStageName = "dns_lookup_getaddrinfo"
 
+// dnsLookupGetaddrinfoStageVersion is the most recent version of the dns_lookup_getaddrinfo stage. The
+// second version adds the timeout argument, which probes only supporting the first version would ignore.
+const dnsLookupGetaddrinfoStageVersion = 2
+
 // ASTNode implements operation.
 func (op *dnsLookupGetaddrinfoOperation) ASTNode() *SerializableASTNode {
 	// Note: we serialize the structure because this gives us forward compatibility (i.e., we
 	// may add a field to a future version without breaking the AST structure and old probes will
 	// be fine as long as the zero value of the new field is the default)
+	//
+	// Also, we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if op.Timeout > 0 {
+		version = dnsLookupGetaddrinfoStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: dnsLookupGetaddrinfoStageName,
+		StageName: VersionedStageName(dnsLookupGetaddrinfoStageName, version),
 		Arguments: op,
 		Children:  []*SerializableASTNode{},
 	}
@@ -63,7 +86,7 @@ func (*dnsLookupGetaddrinfoLoader) Load(loader *ASTLoader, node *LoadableASTNode
 		return nil, err
 	}
 	stage := wrapOperation[string, *DNSLookupResult](&op)
-	return &StageRunnableASTNode[string, *DNSLookupResult]{stage}, nil
+	return &StageRunnableASTNode[string, *DNSLookupResult]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -71,8 +94,33 @@ func (*dnsLookupGetaddrinfoLoader) StageName() string {
 	return dnsLookupGetaddrinfoStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*dnsLookupGetaddrinfoLoader) StageVersion() int {
+	return dnsLookupGetaddrinfoStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*dnsLookupGetaddrinfoLoader) StageArguments() any {
+	return &dnsLookupGetaddrinfoOperation{}
+}
+
 // Run implements operation.
 func (op *dnsLookupGetaddrinfoOperation) Run(ctx context.Context, rtx Runtime, domain string) (*DNSLookupResult, error) {
+	// make sure the policy allows the lookup
+	if except := checkDestinationDomain(rtx, domain); except != nil {
+		observeOperation(rtx, dnsLookupGetaddrinfoStageName, "", op.Tags, time.Now(), except)
+		return nil, except
+	}
+
+	// wait for the budget to allow us to start
+	tb := time.Now()
+	release, err := rtx.Budget().Acquire(ctx)
+	if err != nil {
+		observeOperation(rtx, dnsLookupGetaddrinfoStageName, "", op.Tags, tb, err)
+		return nil, &ErrDNSLookup{err}
+	}
+	defer release()
+
 	// create trace
 	trace := rtx.NewTrace(op.Tags...)
 
@@ -85,13 +133,15 @@ func (op *dnsLookupGetaddrinfoOperation) Run(ctx context.Context, rtx Runtime, d
 	)
 
 	// setup
-	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
+	timeout := durationOrDefault(op.Timeout, dnsLookupGetaddrinfoDefaultTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// instantiate a resolver
 	resolver := trace.NewStdlibResolver()
 
 	// do the lookup
+	t0 := time.Now()
 	addrs, err := resolver.LookupHost(ctx, domain)
 
 	// stop the operation logger
@@ -102,11 +152,11 @@ func (op *dnsLookupGetaddrinfoOperation) Run(ctx context.Context, rtx Runtime, d
 
 	// handle the error case
 	if err != nil {
-		rtx.Metrics().Error(dnsLookupGetaddrinfoStageName)
+		observeOperation(rtx, dnsLookupGetaddrinfoStageName, "", trace.Tags(), t0, err)
 		return nil, &ErrDNSLookup{err}
 	}
 
 	// handle the successful case
-	rtx.Metrics().Success(dnsLookupGetaddrinfoStageName)
+	observeOperation(rtx, dnsLookupGetaddrinfoStageName, "", trace.Tags(), t0, nil)
 	return &DNSLookupResult{Domain: domain, Addresses: addrs}, nil
 }
@@ -8,15 +8,27 @@ import (
 // pool of background goroutines. Note that this stage disregards the result of substages and
 // returns an empty list of addresses when all the substages have failed.
 func DNSLookupParallel(stages ...Stage[string, *DNSLookupResult]) Stage[string, *DNSLookupResult] {
-	return &dnsLookupParallelStage{stages}
+	return DNSLookupParallelWithParallelism(0, stages...)
+}
+
+// DNSLookupParallelWithParallelism is like [DNSLookupParallel] but allows to configure
+// the number of background goroutines. A zero or negative value means using the default.
+func DNSLookupParallelWithParallelism(
+	parallelism int, stages ...Stage[string, *DNSLookupResult]) Stage[string, *DNSLookupResult] {
+	return &dnsLookupParallelStage{parallelism, stages}
 }
 
 type dnsLookupParallelStage struct {
-	stages []Stage[string, *DNSLookupResult]
+	parallelism int
+	stages      []Stage[string, *DNSLookupResult]
 }
 
 const dnsLookupParallelStageName = "dns_lookup_parallel"
 
+// dnsLookupParallelDefaultParallelism is the default parallelism for [DNSLookupParallel], which
+// is larger than [defaultParallelism] because DNS lookups are relatively cheap.
+const dnsLookupParallelDefaultParallelism = 5
+
 // ASTNode implements Stage.
 func (sx *dnsLookupParallelStage) ASTNode() *SerializableASTNode {
 	var nodes []*SerializableASTNode
@@ -25,7 +37,7 @@ func (sx *dnsLookupParallelStage) ASTNode() *SerializableASTNode {
 	}
 	return &SerializableASTNode{
 		StageName: dnsLookupParallelStageName,
-		Arguments: nil,
+		Arguments: newParallelStageArguments(sx.parallelism),
 		Children:  nodes,
 	}
 }
@@ -34,16 +46,20 @@ type dnsLookupParallelLoader struct{}
 
 // Load implements ASTLoaderRule.
 func (*dnsLookupParallelLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
-	if err := loader.LoadEmptyArguments(node); err != nil {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
 		return nil, err
 	}
 	runnables, err := loader.LoadChildren(node)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckChildrenTypes[string, *DNSLookupResult](node, runnables...); err != nil {
+		return nil, err
+	}
 	children := RunnableASTNodeListToStageList[string, *DNSLookupResult](runnables...)
-	stage := DNSLookupParallel(children...)
-	return &StageRunnableASTNode[string, *DNSLookupResult]{stage}, nil
+	stage := DNSLookupParallelWithParallelism(config.Parallelism, children...)
+	return &StageRunnableASTNode[string, *DNSLookupResult]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -51,6 +67,11 @@ func (*dnsLookupParallelLoader) StageName() string {
 	return dnsLookupParallelStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*dnsLookupParallelLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
 // Run implements Stage.
 func (sx *dnsLookupParallelStage) Run(ctx context.Context, rtx Runtime, input Maybe[string]) Maybe[*DNSLookupResult] {
 	// handle the case where the previous stage failed
@@ -65,7 +86,7 @@ func (sx *dnsLookupParallelStage) Run(ctx context.Context, rtx Runtime, input Ma
 	}
 
 	// run workers
-	const parallelism = 5
+	parallelism := effectiveParallelism(rtx, sx.parallelism, dnsLookupParallelDefaultParallelism)
 	results := ParallelRun(ctx, parallelism, workers...)
 
 	// route exceptions
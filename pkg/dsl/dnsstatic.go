@@ -40,7 +40,7 @@ func (*dnsLookupStaticLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Ru
 		return nil, err
 	}
 	stage := wrapOperation[string, *DNSLookupResult](&op)
-	return &StageRunnableASTNode[string, *DNSLookupResult]{stage}, nil
+	return &StageRunnableASTNode[string, *DNSLookupResult]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -48,6 +48,11 @@ func (*dnsLookupStaticLoader) StageName() string {
 	return dnsLookupStaticStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*dnsLookupStaticLoader) StageArguments() any {
+	return &dnsLookupStaticOperation{}
+}
+
 // Run implements operation.
 func (sx *dnsLookupStaticOperation) Run(ctx context.Context, rtx Runtime, domain string) (*DNSLookupResult, error) {
 	if !ValidIPAddrs(sx.Addresses...) {
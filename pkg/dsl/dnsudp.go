@@ -19,6 +19,14 @@ func DNSLookupUDPOptionTags(tags ...string) DNSLookupUDPOption {
 	}
 }
 
+// DNSLookupUDPOptionTimeout allows configuring the DNS lookup timeout. A zero or
+// negative value means that we should use the default timeout.
+func DNSLookupUDPOptionTimeout(timeout time.Duration) DNSLookupUDPOption {
+	return func(operation *dnsLookupUDPOperation) {
+		operation.Timeout = Duration(timeout)
+	}
+}
+
 // DNSLookupUDP returns a stage that performs a DNS lookup using the given UDP resolver
 // endpoint; use "ADDRESS:PORT" for IPv4 and "[ADDRESS]:PORT" for IPv6 endpoints.
 //
@@ -38,17 +46,32 @@ func DNSLookupUDP(endpoint string, options ...DNSLookupUDPOption) Stage[string,
 type dnsLookupUDPOperation struct {
 	Endpoint string   `json:"endpoint"`
 	Tags     []string `json:"tags,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
 }
 
+// dnsLookupUDPDefaultTimeout is the default DNS lookup timeout.
+const dnsLookupUDPDefaultTimeout = 4 * time.Second
+
 const dnsLookupUDPStageName = "dns_lookup_udp"
 
+// dnsLookupUDPStageVersion is the most recent version of the dns_lookup_udp stage. The second
+// version adds the timeout argument, which probes only supporting the first version would ignore.
+const dnsLookupUDPStageVersion = 2
+
 // ASTNode implements operation.
 func (sx *dnsLookupUDPOperation) ASTNode() *SerializableASTNode {
 	// Note: we serialize the structure because this gives us forward compatibility (i.e., we
 	// may add a field to a future version without breaking the AST structure and old probes will
 	// be fine as long as the zero value of the new field is the default)
+	//
+	// Also, we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if sx.Timeout > 0 {
+		version = dnsLookupUDPStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: dnsLookupUDPStageName,
+		StageName: VersionedStageName(dnsLookupUDPStageName, version),
 		Arguments: sx,
 		Children:  []*SerializableASTNode{},
 	}
@@ -66,7 +89,7 @@ func (*dnsLookupUDPLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runna
 		return nil, err
 	}
 	stage := wrapOperation[string, *DNSLookupResult](&op)
-	return &StageRunnableASTNode[string, *DNSLookupResult]{stage}, nil
+	return &StageRunnableASTNode[string, *DNSLookupResult]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -74,12 +97,36 @@ func (*dnsLookupUDPLoader) StageName() string {
 	return dnsLookupUDPStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*dnsLookupUDPLoader) StageVersion() int {
+	return dnsLookupUDPStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*dnsLookupUDPLoader) StageArguments() any {
+	return &dnsLookupUDPOperation{}
+}
+
 // Run implements operation.
 func (sx *dnsLookupUDPOperation) Run(ctx context.Context, rtx Runtime, domain string) (*DNSLookupResult, error) {
-	// make sure the target endpoint is valid
-	if !ValidEndpoints(sx.Endpoint) {
-		return nil, &ErrException{&ErrInvalidEndpoint{sx.Endpoint}}
+	// make sure the target endpoint is valid and the policy allows the lookup
+	if except := checkDestinationEndpoint(rtx, sx.Endpoint); except != nil {
+		observeOperation(rtx, dnsLookupUDPStageName, sx.Endpoint, sx.Tags, time.Now(), except)
+		return nil, except
+	}
+	if except := checkDestinationDomain(rtx, domain); except != nil {
+		observeOperation(rtx, dnsLookupUDPStageName, sx.Endpoint, sx.Tags, time.Now(), except)
+		return nil, except
+	}
+
+	// wait for the budget to allow us to start
+	tb := time.Now()
+	release, err := rtx.Budget().Acquire(ctx)
+	if err != nil {
+		observeOperation(rtx, dnsLookupUDPStageName, sx.Endpoint, sx.Tags, tb, err)
+		return nil, &ErrDNSLookup{err}
 	}
+	defer release()
 
 	// create trace
 	trace := rtx.NewTrace(sx.Tags...)
@@ -94,13 +141,15 @@ func (sx *dnsLookupUDPOperation) Run(ctx context.Context, rtx Runtime, domain st
 	)
 
 	// setup
-	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
+	timeout := durationOrDefault(sx.Timeout, dnsLookupUDPDefaultTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// instantiate resolver
 	resolver := trace.NewParallelUDPResolver(sx.Endpoint)
 
 	// do the lookup
+	t0 := time.Now()
 	addrs, err := resolver.LookupHost(ctx, domain)
 
 	// stop the operation logger
@@ -111,11 +160,11 @@ func (sx *dnsLookupUDPOperation) Run(ctx context.Context, rtx Runtime, domain st
 
 	// handle the error case
 	if err != nil {
-		rtx.Metrics().Error(dnsLookupUDPStageName)
+		observeOperation(rtx, dnsLookupUDPStageName, sx.Endpoint, trace.Tags(), t0, err)
 		return nil, &ErrDNSLookup{err}
 	}
 
 	// handle the successful case
-	rtx.Metrics().Success(dnsLookupUDPStageName)
+	observeOperation(rtx, dnsLookupUDPStageName, sx.Endpoint, trace.Tags(), t0, nil)
 	return &DNSLookupResult{Domain: domain, Addresses: addrs}, nil
 }
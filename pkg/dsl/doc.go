@@ -18,7 +18,9 @@
 // to obtain a [LoadableASTNode]. In turn, you can transform a [LoadableASTNode] to
 // a [RunnableASTNode] by using the [ASTLoader.Load] method. The [RunnableASTNode] is a
 // wrapper for a [Stage] that has a generic-typing interface (e.g., uses any) and performs
-// type checking at runtime. By calling a [RunnableASTNode] Run method, you run the
+// type checking at runtime. Additionally, the [ASTLoader.Load] method uses the input and
+// output types declared by each [TypedRunnableASTNode] to reject ill-typed ASTs before
+// running them. By calling a [RunnableASTNode] Run method, you run the
 // generic pipeline and obtain the same results you would have obtained had you called
 // the original composed pipeline [Stage] Run method.
 //
@@ -0,0 +1,50 @@
+package dsl
+
+//
+// Duration arguments
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Duration is the type of the duration arguments of stages (e.g., the timeout of
+// [TCPConnect] and of [WithTimeout]). We serialize a Duration to JSON as a string
+// using the Go duration syntax (e.g., "4s" or "250ms"), and we also accept a JSON
+// integer, which is a number of milliseconds (e.g., 4000), when parsing.
+type Duration time.Duration
+
+var (
+	_ json.Marshaler   = Duration(0)
+	_ json.Unmarshaler = new(Duration)
+)
+
+// ErrInvalidDuration indicates that we cannot parse a JSON [Duration].
+var ErrInvalidDuration = errors.New("dsl: invalid duration")
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var millis int64
+	if err := json.Unmarshal(data, &millis); err == nil {
+		*d = Duration(time.Duration(millis) * time.Millisecond)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("%w: expected a string or an integer number of milliseconds", ErrInvalidDuration)
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDuration, err.Error())
+	}
+	*d = Duration(value)
+	return nil
+}
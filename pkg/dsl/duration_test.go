@@ -0,0 +1,65 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	t.Run("we marshal a duration as a Go duration string", func(t *testing.T) {
+		data, err := json.Marshal(&tcpConnectOperation{Timeout: Duration(4 * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != `{"timeout":"4s"}` {
+			t.Fatal("unexpected JSON", string(data))
+		}
+	})
+
+	t.Run("we unmarshal a duration", func(t *testing.T) {
+		testcases := []struct {
+			input     string
+			expect    Duration
+			expectErr error
+		}{{
+			input:     `"250ms"`,
+			expect:    Duration(250 * time.Millisecond),
+			expectErr: nil,
+		}, {
+			input:     `"1m30s"`,
+			expect:    Duration(90 * time.Second),
+			expectErr: nil,
+		}, {
+			input:     `4000`,
+			expect:    Duration(4 * time.Second),
+			expectErr: nil,
+		}, {
+			input:     `"4"`,
+			expect:    0,
+			expectErr: ErrInvalidDuration,
+		}, {
+			input:     `1.5`,
+			expect:    0,
+			expectErr: ErrInvalidDuration,
+		}, {
+			input:     `true`,
+			expect:    0,
+			expectErr: ErrInvalidDuration,
+		}}
+
+		for _, tc := range testcases {
+			t.Run(tc.input, func(t *testing.T) {
+				var value Duration
+				err := json.Unmarshal([]byte(tc.input), &value)
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatal("expected", tc.expectErr, "got", err)
+				}
+				if value != tc.expect {
+					t.Fatal("expected", tc.expect, "got", value)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// CollectEndpointResults is like [NewEndpointPipeline] but the stage may return any type and
+// we return the result of measuring each endpoint, in the same order of the input endpoints,
+// such that the following stages (e.g., [AtLeastOneSucceeded]) can reason about the outcome of
+// measuring several endpoints. We do not modify the errors returned by the stage, so the
+// errors preserve their classification (e.g., `connection_refused`).
+//
+// When we load this stage from an AST, there is type erasure and the stage returns []Maybe[any],
+// where each value has the type returned by the child stage.
+func CollectEndpointResults[T any](stage Stage[*Endpoint, T]) Stage[[]*Endpoint, []Maybe[T]] {
+	return CollectEndpointResultsWithParallelism(0, stage)
+}
+
+// CollectEndpointResultsWithParallelism is like [CollectEndpointResults] but allows to configure
+// the number of background goroutines. A zero or negative value means using the default.
+func CollectEndpointResultsWithParallelism[T any](parallelism int, stage Stage[*Endpoint, T]) Stage[[]*Endpoint, []Maybe[T]] {
+	return &collectEndpointResultsStage[T]{parallelism, stage}
+}
+
+type collectEndpointResultsStage[T any] struct {
+	parallelism int
+	sx          Stage[*Endpoint, T]
+}
+
+const collectEndpointResultsStageName = "collect_endpoint_results"
+
+// ASTNode implements Stage.
+func (sx *collectEndpointResultsStage[T]) ASTNode() *SerializableASTNode {
+	// There is type erasure when we AST-serialize
+	return &SerializableASTNode{
+		StageName: collectEndpointResultsStageName,
+		Arguments: newParallelStageArguments(sx.parallelism),
+		Children:  []*SerializableASTNode{sx.sx.ASTNode()},
+	}
+}
+
+type collectEndpointResultsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*collectEndpointResultsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
+		return nil, err
+	}
+	runnables, err := loader.LoadChildren(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckChildrenTypes[*Endpoint, any](node, runnables...); err != nil {
+		return nil, err
+	}
+	runtimex.Assert(len(runnables) == 1, "unexpected number of children")
+	stage := CollectEndpointResultsWithParallelism[any](
+		config.Parallelism,
+		&erasedRunnableASTNodeStage[*Endpoint]{runnables[0]},
+	)
+	return &StageRunnableASTNode[[]*Endpoint, []Maybe[any]]{S: stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*collectEndpointResultsLoader) StageName() string {
+	return collectEndpointResultsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*collectEndpointResultsLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
+// Run implements Stage.
+func (sx *collectEndpointResultsStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]Maybe[T]] {
+	if input.Error != nil {
+		return NewError[[]Maybe[T]](input.Error)
+	}
+
+	// create list of workers, where each worker writes into its own slot, since
+	// ParallelRun returns the results in the order in which they are available
+	results := make([]Maybe[T], len(input.Value))
+	var workers []Worker[Maybe[T]]
+	for idx, endpoint := range input.Value {
+		workers = append(workers, &collectEndpointResultsWorker[T]{
+			input:  endpoint,
+			output: &results[idx],
+			rtx:    rtx,
+			sx:     sx.sx,
+		})
+	}
+
+	// perform the measurement in parallel
+	parallelism := effectiveParallelism(rtx, sx.parallelism, defaultParallelism)
+	_ = ParallelRun(ctx, parallelism, workers...)
+
+	// route exceptions
+	if err := catch(results...); err != nil {
+		return NewError[[]Maybe[T]](err)
+	}
+
+	return NewValue(results)
+}
+
+// collectEndpointResultsWorker is the [Worker] used by [collectEndpointResultsStage].
+type collectEndpointResultsWorker[T any] struct {
+	input  *Endpoint
+	output *Maybe[T]
+	rtx    Runtime
+	sx     Stage[*Endpoint, T]
+}
+
+func (w *collectEndpointResultsWorker[T]) Produce(ctx context.Context) Maybe[T] {
+	*w.output = w.sx.Run(ctx, w.rtx, NewValue(w.input))
+	return *w.output
+}
+
+// EndpointResultsSummary is the summary of the results of measuring several endpoints
+// produced by aggregator stages such as [AtLeastOneSucceeded].
+type EndpointResultsSummary struct {
+	// Total is the number of results.
+	Total int `json:"total"`
+
+	// Successes is the number of successful results.
+	Successes int `json:"successes"`
+
+	// Failure is the failure shared by all the results, if all the results failed
+	// with the same error, and nil otherwise.
+	Failure *string `json:"failure"`
+
+	// Result is the result computed by the aggregator stage.
+	Result bool `json:"result"`
+}
+
+// AtLeastOneSucceeded returns a stage that summarizes the given results and whose
+// [EndpointResultsSummary.Result] is true when at least one result is successful. When the
+// annotation argument is not empty, we also save the summary as an [Annotation] with
+// the given name, which allows to compute test keys using the observations.
+func AtLeastOneSucceeded[T any](annotation string) Stage[[]Maybe[T], *EndpointResultsSummary] {
+	return &aggregateEndpointResultsStage[T]{
+		Annotation: annotation,
+		stageName:  atLeastOneSucceededStageName,
+	}
+}
+
+// AllFailedWithSameError is like [AtLeastOneSucceeded] but the [EndpointResultsSummary.Result]
+// is true when there is at least one result and all the results failed with the same error.
+func AllFailedWithSameError[T any](annotation string) Stage[[]Maybe[T], *EndpointResultsSummary] {
+	return &aggregateEndpointResultsStage[T]{
+		Annotation: annotation,
+		stageName:  allFailedWithSameErrorStageName,
+	}
+}
+
+const (
+	atLeastOneSucceededStageName    = "at_least_one_succeeded"
+	allFailedWithSameErrorStageName = "all_failed_with_same_error"
+)
+
+type aggregateEndpointResultsStage[T any] struct {
+	Annotation string `json:"annotation,omitempty"`
+	stageName  string
+}
+
+// ASTNode implements Stage.
+func (sx *aggregateEndpointResultsStage[T]) ASTNode() *SerializableASTNode {
+	// There is type erasure when we AST-serialize
+	return &SerializableASTNode{
+		StageName: sx.stageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// aggregateEndpointResultsLoader loads the aggregator stage with the given name.
+type aggregateEndpointResultsLoader struct {
+	stageName string
+}
+
+// Load implements ASTLoaderRule.
+func (al *aggregateEndpointResultsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	stage := &aggregateEndpointResultsStage[any]{stageName: al.stageName}
+	if err := json.Unmarshal(node.Arguments, stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]Maybe[any], *EndpointResultsSummary]{S: stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (al *aggregateEndpointResultsLoader) StageName() string {
+	return al.stageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (al *aggregateEndpointResultsLoader) StageArguments() any {
+	return &aggregateEndpointResultsStage[any]{}
+}
+
+// Run implements Stage.
+func (sx *aggregateEndpointResultsStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[[]Maybe[T]]) Maybe[*EndpointResultsSummary] {
+	if input.Error != nil {
+		return NewError[*EndpointResultsSummary](input.Error)
+	}
+	summary := summarizeEndpointResults(input.Value...)
+	switch sx.stageName {
+	case atLeastOneSucceededStageName:
+		summary.Result = summary.Successes > 0
+	default:
+		summary.Result = summary.Failure != nil
+	}
+	if sx.Annotation != "" {
+		SaveAnnotation(rtx, sx.Annotation, summary)
+	}
+	return NewValue(summary)
+}
+
+// summarizeEndpointResults creates an [EndpointResultsSummary] for the given results
+// without setting the [EndpointResultsSummary.Result] field.
+func summarizeEndpointResults[T any](results ...Maybe[T]) *EndpointResultsSummary {
+	summary := &EndpointResultsSummary{Total: len(results)}
+	var failures []string
+	for _, result := range results {
+		if result.Error == nil {
+			summary.Successes++
+			continue
+		}
+		failures = append(failures, result.Error.Error())
+	}
+	if len(failures) <= 0 || summary.Successes > 0 {
+		return summary
+	}
+	for _, failure := range failures[1:] {
+		if failure != failures[0] {
+			return summary
+		}
+	}
+	summary.Failure = &failures[0]
+	return summary
+}
@@ -0,0 +1,154 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// collectTestStage is a stage returning the result associated with the endpoint address.
+type collectTestStage struct {
+	results map[string]Maybe[string]
+}
+
+// ASTNode implements Stage.
+func (sx *collectTestStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{StageName: "collect_test"}
+}
+
+// Run implements Stage.
+func (sx *collectTestStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Endpoint]) Maybe[string] {
+	return sx.results[input.Value.Address]
+}
+
+func TestCollectEndpointResults(t *testing.T) {
+	errRefused := errors.New("connection_refused")
+	stage := &collectTestStage{results: map[string]Maybe[string]{
+		"10.0.0.1:443": NewError[string](errRefused),
+		"10.0.0.2:443": NewValue("second"),
+		"10.0.0.3:443": NewError[string](errRefused),
+	}}
+	endpoints := []*Endpoint{{Address: "10.0.0.1:443"}, {Address: "10.0.0.2:443"}, {Address: "10.0.0.3:443"}}
+
+	t.Run("we return the results in the order of the endpoints", func(t *testing.T) {
+		output := CollectEndpointResults[string](stage).Run(
+			context.Background(), NewMinimalRuntime(log.Log), NewValue(endpoints))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		if len(output.Value) != 3 {
+			t.Fatal("unexpected number of results", len(output.Value))
+		}
+		if output.Value[0].Error != errRefused || output.Value[1].Value != "second" || output.Value[2].Error != errRefused {
+			t.Fatal("unexpected results", output.Value)
+		}
+	})
+
+	t.Run("we route exceptions", func(t *testing.T) {
+		stage := &collectTestStage{results: map[string]Maybe[string]{
+			"10.0.0.1:443": NewError[string](NewErrException("mocked exception")),
+		}}
+		output := CollectEndpointResults[string](stage).Run(
+			context.Background(), NewMinimalRuntime(log.Log), NewValue(endpoints[:1]))
+		if !IsErrException(output.Error) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+}
+
+func TestEndpointResultsAggregators(t *testing.T) {
+	failure := "connection_refused"
+	refused := NewError[string](errors.New(failure))
+
+	type testcase struct {
+		name     string
+		stage    Stage[[]Maybe[string], *EndpointResultsSummary]
+		input    []Maybe[string]
+		expected *EndpointResultsSummary
+	}
+
+	for _, tc := range []testcase{{
+		name:     "AtLeastOneSucceeded with one success",
+		stage:    AtLeastOneSucceeded[string](""),
+		input:    []Maybe[string]{refused, NewValue("")},
+		expected: &EndpointResultsSummary{Total: 2, Successes: 1, Result: true},
+	}, {
+		name:     "AtLeastOneSucceeded without successes",
+		stage:    AtLeastOneSucceeded[string](""),
+		input:    []Maybe[string]{refused, refused},
+		expected: &EndpointResultsSummary{Total: 2, Failure: &failure},
+	}, {
+		name:     "AtLeastOneSucceeded without results",
+		stage:    AtLeastOneSucceeded[string](""),
+		input:    []Maybe[string]{},
+		expected: &EndpointResultsSummary{},
+	}, {
+		name:     "AllFailedWithSameError with the same error",
+		stage:    AllFailedWithSameError[string](""),
+		input:    []Maybe[string]{refused, refused},
+		expected: &EndpointResultsSummary{Total: 2, Failure: &failure, Result: true},
+	}, {
+		name:     "AllFailedWithSameError with different errors",
+		stage:    AllFailedWithSameError[string](""),
+		input:    []Maybe[string]{refused, NewError[string](errors.New("generic_timeout_error"))},
+		expected: &EndpointResultsSummary{Total: 2},
+	}, {
+		name:     "AllFailedWithSameError with one success",
+		stage:    AllFailedWithSameError[string](""),
+		input:    []Maybe[string]{refused, NewValue("")},
+		expected: &EndpointResultsSummary{Total: 2, Successes: 1},
+	}, {
+		name:     "AllFailedWithSameError without results",
+		stage:    AllFailedWithSameError[string](""),
+		input:    []Maybe[string]{},
+		expected: &EndpointResultsSummary{},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.stage.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(tc.input))
+			if output.Error != nil {
+				t.Fatal(output.Error)
+			}
+			if diff := cmp.Diff(tc.expected, output.Value); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestEndpointResultsAggregatorsLoader(t *testing.T) {
+	// Note: connecting to the echo and discard ports on localhost should fail immediately
+	stage := Compose3(
+		MakeEndpointsForPorts("tcp", "7", "9"),
+		CollectEndpointResults(TCPConnect()),
+		AllFailedWithSameError[*TCPConnection]("tcp_blocking"),
+	)
+	data, err := json.Marshal(Compose3(DomainName("localhost"), DNSLookupStatic("127.0.0.1"), stage).ASTNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node LoadableASTNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		t.Fatal(err)
+	}
+	runnable, err := NewASTLoader().Load(&node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtx := NewMinimalRuntime(log.Log)
+	output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{}))
+	if output.Error != nil {
+		t.Fatal(output.Error)
+	}
+	summary := output.Value.(*EndpointResultsSummary)
+	if !summary.Result || summary.Total != 2 || summary.Failure == nil || *summary.Failure != "connection_refused" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	observations := ReduceObservations(rtx.ExtractObservations()...)
+	if len(observations.Annotations) != 1 || observations.Annotations[0].Name != "tcp_blocking" {
+		t.Fatal("expected to find the annotation")
+	}
+}
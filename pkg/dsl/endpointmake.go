@@ -10,11 +10,14 @@ import (
 // MakeEndpointsForPort returns a stage that converts the results of a DNS lookup to a list
 // of transport layer endpoints ready to be measured using a dedicated pipeline.
 func MakeEndpointsForPort(port uint16) Stage[*DNSLookupResult, []*Endpoint] {
-	return &makeEndpointsForPortStage{port}
+	return &makeEndpointsForPortStage{port, 0}
 }
 
 type makeEndpointsForPortStage struct {
 	Port uint16 `json:"port"`
+
+	// maxEndpoints is the OPTIONAL maximum number of endpoints set by the loader.
+	maxEndpoints int
 }
 
 const makeEndpointsForPortStageName = "make_endpoints_for_port"
@@ -42,7 +45,8 @@ func (*makeEndpointForPortLoader) Load(loader *ASTLoader, node *LoadableASTNode)
 	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
 		return nil, err
 	}
-	return &StageRunnableASTNode[*DNSLookupResult, []*Endpoint]{&stage}, nil
+	stage.maxEndpoints = loader.Limits().MaxEndpoints
+	return &StageRunnableASTNode[*DNSLookupResult, []*Endpoint]{S: &stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -50,6 +54,11 @@ func (*makeEndpointForPortLoader) StageName() string {
 	return makeEndpointsForPortStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*makeEndpointForPortLoader) StageArguments() any {
+	return &makeEndpointsForPortStage{}
+}
+
 // Run implements Stage.
 func (sx *makeEndpointsForPortStage) Run(ctx context.Context, rtx Runtime, input Maybe[*DNSLookupResult]) Maybe[[]*Endpoint] {
 	if input.Error != nil {
@@ -62,6 +71,12 @@ func (sx *makeEndpointsForPortStage) Run(ctx context.Context, rtx Runtime, input
 		uniq[addr] = true
 	}
 
+	// make sure a loaded AST does not produce too many endpoints
+	if exceedsLimit(len(uniq), sx.maxEndpoints) {
+		err := &ErrASTLimitExceeded{"MaxEndpoints", len(uniq), sx.maxEndpoints}
+		return NewError[[]*Endpoint](&ErrException{err})
+	}
+
 	var output []*Endpoint
 	for addr := range uniq {
 		output = append(output, &Endpoint{
@@ -15,4 +15,8 @@ type Endpoint struct {
 
 	// Domain is the domain associated with the endpoint.
 	Domain string
+
+	// Network is the OPTIONAL network of the endpoint (i.e., "tcp" or "udp"), which
+	// is empty when the stage creating the endpoint does not know the network.
+	Network string
 }
@@ -7,11 +7,19 @@ import (
 // MeasureMultipleEndpoints returns a stage that runs several endpoint measurement
 // pipelines in parallel using a pool of background goroutines.
 func MeasureMultipleEndpoints(stages ...Stage[*DNSLookupResult, *Void]) Stage[*DNSLookupResult, *Void] {
-	return &measureMultipleEndpointsStage{stages}
+	return MeasureMultipleEndpointsWithParallelism(0, stages...)
+}
+
+// MeasureMultipleEndpointsWithParallelism is like [MeasureMultipleEndpoints] but allows to configure
+// the number of background goroutines. A zero or negative value means using the default.
+func MeasureMultipleEndpointsWithParallelism(
+	parallelism int, stages ...Stage[*DNSLookupResult, *Void]) Stage[*DNSLookupResult, *Void] {
+	return &measureMultipleEndpointsStage{parallelism, stages}
 }
 
 type measureMultipleEndpointsStage struct {
-	stages []Stage[*DNSLookupResult, *Void]
+	parallelism int
+	stages      []Stage[*DNSLookupResult, *Void]
 }
 
 const measureMultipleEndpointsStageName = "measure_multiple_endpoints"
@@ -24,7 +32,7 @@ func (sx *measureMultipleEndpointsStage) ASTNode() *SerializableASTNode {
 	}
 	return &SerializableASTNode{
 		StageName: measureMultipleEndpointsStageName,
-		Arguments: nil,
+		Arguments: newParallelStageArguments(sx.parallelism),
 		Children:  nodes,
 	}
 }
@@ -33,16 +41,20 @@ type measureMultipleEndpointsLoader struct{}
 
 // Load implements ASTLoaderRule.
 func (*measureMultipleEndpointsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
-	if err := loader.LoadEmptyArguments(node); err != nil {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
 		return nil, err
 	}
 	runnables, err := loader.LoadChildren(node)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckChildrenTypes[*DNSLookupResult, *Void](node, runnables...); err != nil {
+		return nil, err
+	}
 	children := RunnableASTNodeListToStageList[*DNSLookupResult, *Void](runnables...)
-	stage := MeasureMultipleEndpoints(children...)
-	return &StageRunnableASTNode[*DNSLookupResult, *Void]{stage}, nil
+	stage := MeasureMultipleEndpointsWithParallelism(config.Parallelism, children...)
+	return &StageRunnableASTNode[*DNSLookupResult, *Void]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -50,6 +62,11 @@ func (*measureMultipleEndpointsLoader) StageName() string {
 	return measureMultipleEndpointsStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*measureMultipleEndpointsLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
 // Run implements stage.
 func (sx *measureMultipleEndpointsStage) Run(ctx context.Context, rtx Runtime, input Maybe[*DNSLookupResult]) Maybe[*Void] {
 	if input.Error != nil {
@@ -63,7 +80,7 @@ func (sx *measureMultipleEndpointsStage) Run(ctx context.Context, rtx Runtime, i
 	}
 
 	// parallel run
-	const parallelism = 2
+	parallelism := effectiveParallelism(rtx, sx.parallelism, defaultParallelism)
 	results := ParallelRun(ctx, parallelism, workers...)
 
 	// route exceptions
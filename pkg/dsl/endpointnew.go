@@ -58,7 +58,7 @@ func (*newEndpointLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runnab
 		return nil, err
 	}
 	stage := wrapOperation[*Void, *Endpoint](&op)
-	return &StageRunnableASTNode[*Void, *Endpoint]{stage}, nil
+	return &StageRunnableASTNode[*Void, *Endpoint]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -66,10 +66,15 @@ func (*newEndpointLoader) StageName() string {
 	return newEndpointStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*newEndpointLoader) StageArguments() any {
+	return &newEndpointOperation{}
+}
+
 // Run implements operation.
 func (sx *newEndpointOperation) Run(ctx context.Context, rtx Runtime, input *Void) (*Endpoint, error) {
-	if !ValidEndpoints(sx.Endpoint) {
-		return nil, &ErrException{&ErrInvalidEndpoint{sx.Endpoint}}
+	if except := checkDestinationEndpoint(rtx, sx.Endpoint); except != nil {
+		return nil, except
 	}
 	output := &Endpoint{
 		Address: sx.Endpoint,
@@ -9,11 +9,18 @@ import (
 // NewEndpointPipeline returns a stage that measures each endpoint given in input in
 // parallel using a pool of background goroutines.
 func NewEndpointPipeline(stage Stage[*Endpoint, *Void]) Stage[[]*Endpoint, *Void] {
-	return &newEndpointPipelineStage{stage}
+	return NewEndpointPipelineWithParallelism(0, stage)
+}
+
+// NewEndpointPipelineWithParallelism is like [NewEndpointPipeline] but allows to configure
+// the number of background goroutines. A zero or negative value means using the default.
+func NewEndpointPipelineWithParallelism(parallelism int, stage Stage[*Endpoint, *Void]) Stage[[]*Endpoint, *Void] {
+	return &newEndpointPipelineStage{parallelism, stage}
 }
 
 type newEndpointPipelineStage struct {
-	sx Stage[*Endpoint, *Void]
+	parallelism int
+	sx          Stage[*Endpoint, *Void]
 }
 
 const newEndpointPipelineStageName = "new_endpoint_pipeline"
@@ -22,7 +29,7 @@ func (sx *newEndpointPipelineStage) ASTNode() *SerializableASTNode {
 	node := sx.sx.ASTNode()
 	return &SerializableASTNode{
 		StageName: newEndpointPipelineStageName,
-		Arguments: nil,
+		Arguments: newParallelStageArguments(sx.parallelism),
 		Children:  []*SerializableASTNode{node},
 	}
 }
@@ -31,7 +38,8 @@ type newEndpointPipelineLoader struct{}
 
 // Load implements ASTLoaderRule.
 func (*newEndpointPipelineLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
-	if err := loader.LoadEmptyArguments(node); err != nil {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
 		return nil, err
 	}
 	runnables, err := loader.LoadChildren(node)
@@ -41,10 +49,13 @@ func (*newEndpointPipelineLoader) Load(loader *ASTLoader, node *LoadableASTNode)
 	if len(runnables) != 1 {
 		return nil, ErrInvalidNumberOfChildren
 	}
+	if err := CheckChildrenTypes[*Endpoint, *Void](node, runnables...); err != nil {
+		return nil, err
+	}
 	children := RunnableASTNodeListToStageList[*Endpoint, *Void](runnables[0])
 	runtimex.Assert(len(children) == 1, "unexpected number of children")
-	stage := NewEndpointPipeline(children[0])
-	return &StageRunnableASTNode[[]*Endpoint, *Void]{stage}, nil
+	stage := NewEndpointPipelineWithParallelism(config.Parallelism, children[0])
+	return &StageRunnableASTNode[[]*Endpoint, *Void]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -52,6 +63,11 @@ func (*newEndpointPipelineLoader) StageName() string {
 	return newEndpointPipelineStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*newEndpointPipelineLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
 func (sx *newEndpointPipelineStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[*Void] {
 	if input.Error != nil {
 		return NewError[*Void](input.Error)
@@ -64,7 +80,7 @@ func (sx *newEndpointPipelineStage) Run(ctx context.Context, rtx Runtime, input
 	}
 
 	// perform the measurement in parallel
-	const parallelism = 2
+	parallelism := effectiveParallelism(rtx, sx.parallelism, defaultParallelism)
 	results := ParallelRun(ctx, parallelism, workers...)
 
 	// route exceptions
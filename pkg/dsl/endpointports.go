@@ -0,0 +1,176 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPortSpec indicates that a port specification is invalid.
+type ErrInvalidPortSpec struct {
+	Spec string
+}
+
+// Error implements error.
+func (err *ErrInvalidPortSpec) Error() string {
+	return fmt.Sprintf("dsl: invalid port specification: %s", err.Spec)
+}
+
+// MakeEndpointsForPorts is like [MakeEndpointsForPort] but creates endpoints for several
+// ports. Each port specification is either a port (e.g., "443") or an inclusive port range
+// (e.g., "8000-8010") optionally followed by "/tcp" or "/udp" to select the network. When
+// a specification does not select the network, we use the given network, which must be
+// either "tcp" or "udp". For example
+//
+//	MakeEndpointsForPorts("tcp", "443", "443/udp", "5222", "8000-8010")
+//
+// creates endpoints for 443/tcp, 443/udp, 5222/tcp and for the 8000-8010/tcp range. Each
+// [*Endpoint] contains the network, so you can use [FilterEndpointsByNetwork] to select the
+// endpoints to measure using TCP and the endpoints to measure using QUIC.
+func MakeEndpointsForPorts(network string, ports ...string) Stage[*DNSLookupResult, []*Endpoint] {
+	return &makeEndpointsForPortsStage{network, ports, 0}
+}
+
+type makeEndpointsForPortsStage struct {
+	Network string   `json:"network"`
+	Ports   []string `json:"ports"`
+
+	// maxEndpoints is the OPTIONAL maximum number of endpoints set by the loader.
+	maxEndpoints int
+}
+
+const makeEndpointsForPortsStageName = "make_endpoints_for_ports"
+
+// ASTNode implements Stage.
+func (sx *makeEndpointsForPortsStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: makeEndpointsForPortsStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type makeEndpointsForPortsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*makeEndpointsForPortsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage makeEndpointsForPortsStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	// Note: we reject invalid port specifications before running the AST
+	if _, err := stage.parsePorts(); err != nil {
+		return nil, err
+	}
+	stage.maxEndpoints = loader.Limits().MaxEndpoints
+	return &StageRunnableASTNode[*DNSLookupResult, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*makeEndpointsForPortsLoader) StageName() string {
+	return makeEndpointsForPortsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*makeEndpointsForPortsLoader) StageArguments() any {
+	return &makeEndpointsForPortsStage{}
+}
+
+// portRange is an inclusive range of ports using a given network.
+type portRange struct {
+	first   int
+	last    int
+	network string
+}
+
+// parsePorts parses the port specifications.
+func (sx *makeEndpointsForPortsStage) parsePorts() (out []portRange, err error) {
+	if !validEndpointNetwork(sx.Network) {
+		return nil, &ErrInvalidPortSpec{sx.Network}
+	}
+	for _, spec := range sx.Ports {
+		entry := portRange{network: sx.Network}
+		ports, network, found := strings.Cut(spec, "/")
+		if found {
+			if !validEndpointNetwork(network) {
+				return nil, &ErrInvalidPortSpec{spec}
+			}
+			entry.network = network
+		}
+		first, last, found := strings.Cut(ports, "-")
+		if !found {
+			last = first
+		}
+		if entry.first, err = strconv.Atoi(first); err != nil || !ValidPorts(first) {
+			return nil, &ErrInvalidPortSpec{spec}
+		}
+		if entry.last, err = strconv.Atoi(last); err != nil || !ValidPorts(last) {
+			return nil, &ErrInvalidPortSpec{spec}
+		}
+		if entry.first > entry.last {
+			return nil, &ErrInvalidPortSpec{spec}
+		}
+		out = append(out, entry)
+	}
+	if len(out) <= 0 {
+		return nil, &ErrInvalidPortSpec{""}
+	}
+	return out, nil
+}
+
+// validEndpointNetwork returns whether the given network is a valid endpoint network.
+func validEndpointNetwork(network string) bool {
+	return network == "tcp" || network == "udp"
+}
+
+// Run implements Stage.
+func (sx *makeEndpointsForPortsStage) Run(ctx context.Context, rtx Runtime, input Maybe[*DNSLookupResult]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+
+	ports, err := sx.parsePorts()
+	if err != nil {
+		return NewError[[]*Endpoint](&ErrException{err})
+	}
+
+	// make sure we remove duplicates while preserving the order
+	uniq := make(map[string]bool)
+	var addrs []string
+	for _, addr := range input.Value.Addresses {
+		if !uniq[addr] {
+			uniq[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	// make sure a loaded AST does not produce too many endpoints
+	var count int
+	for _, entry := range ports {
+		count += (entry.last - entry.first + 1) * len(addrs)
+	}
+	if exceedsLimit(count, sx.maxEndpoints) {
+		err := &ErrASTLimitExceeded{"MaxEndpoints", count, sx.maxEndpoints}
+		return NewError[[]*Endpoint](&ErrException{err})
+	}
+
+	output := []*Endpoint{}
+	for _, entry := range ports {
+		for port := entry.first; port <= entry.last; port++ {
+			for _, addr := range addrs {
+				output = append(output, &Endpoint{
+					Address: net.JoinHostPort(addr, strconv.Itoa(port)),
+					Domain:  input.Value.Domain,
+					Network: entry.network,
+				})
+			}
+		}
+	}
+	return NewValue(output)
+}
@@ -0,0 +1,93 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMakeEndpointsForPorts(t *testing.T) {
+	lookup := &DNSLookupResult{
+		Domain:    "www.example.com",
+		Addresses: []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946", "93.184.216.34"},
+	}
+
+	// load serializes and loads the given stage
+	load := func(loader *ASTLoader, stage Stage[*DNSLookupResult, []*Endpoint]) (Stage[*DNSLookupResult, []*Endpoint], error) {
+		data, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			return nil, err
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		runnable, err := loader.Load(&node)
+		if err != nil {
+			return nil, err
+		}
+		return &RunnableASTNodeStage[*DNSLookupResult, []*Endpoint]{runnable}, nil
+	}
+
+	t.Run("we generate endpoints for ports and ranges", func(t *testing.T) {
+		stage, err := load(NewASTLoader(), MakeEndpointsForPorts("tcp", "443", "443/udp", "8000-8001"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := stage.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(lookup))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		expected := []*Endpoint{
+			{Address: "93.184.216.34:443", Domain: "www.example.com", Network: "tcp"},
+			{Address: "[2606:2800:220:1:248:1893:25c8:1946]:443", Domain: "www.example.com", Network: "tcp"},
+			{Address: "93.184.216.34:443", Domain: "www.example.com", Network: "udp"},
+			{Address: "[2606:2800:220:1:248:1893:25c8:1946]:443", Domain: "www.example.com", Network: "udp"},
+			{Address: "93.184.216.34:8000", Domain: "www.example.com", Network: "tcp"},
+			{Address: "[2606:2800:220:1:248:1893:25c8:1946]:8000", Domain: "www.example.com", Network: "tcp"},
+			{Address: "93.184.216.34:8001", Domain: "www.example.com", Network: "tcp"},
+			{Address: "[2606:2800:220:1:248:1893:25c8:1946]:8001", Domain: "www.example.com", Network: "tcp"},
+		}
+		if diff := cmp.Diff(expected, output.Value); diff != "" {
+			t.Fatal(diff)
+		}
+
+		udp := FilterEndpointsByNetwork("udp").Run(context.Background(), NewMinimalRuntime(log.Log), output)
+		if diff := cmp.Diff(expected[2:4], udp.Value); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we reject invalid port specifications when loading", func(t *testing.T) {
+		for _, stage := range []Stage[*DNSLookupResult, []*Endpoint]{
+			MakeEndpointsForPorts("tcp"),
+			MakeEndpointsForPorts("sctp", "443"),
+			MakeEndpointsForPorts("tcp", "443/sctp"),
+			MakeEndpointsForPorts("tcp", "70000"),
+			MakeEndpointsForPorts("tcp", "8010-8000"),
+			MakeEndpointsForPorts("tcp", "http"),
+		} {
+			var specErr *ErrInvalidPortSpec
+			if _, err := load(NewASTLoader(), stage); !errors.As(err, &specErr) {
+				t.Fatal("unexpected error", err)
+			}
+		}
+	})
+
+	t.Run("we enforce the maximum number of endpoints", func(t *testing.T) {
+		loader := NewASTLoader(ASTLoaderOptionMaxEndpoints(100))
+		stage, err := load(loader, MakeEndpointsForPorts("tcp", "1-65535"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := stage.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(lookup))
+		var limitErr *ErrASTLimitExceeded
+		if !errors.As(output.Error, &limitErr) || limitErr.Value != 2*65535 {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+}
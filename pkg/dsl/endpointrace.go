@@ -0,0 +1,271 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// RaceEndpointsOption is an option for [RaceEndpoints].
+type RaceEndpointsOption func(config *raceEndpointsArguments)
+
+// RaceEndpointsOptionDelay configures the delay between starting two consecutive attempts
+// while the previous attempts are still running. A zero or negative value means that we
+// should use the default delay, which is 250 milliseconds, as recommended by RFC 8305.
+func RaceEndpointsOptionDelay(delay time.Duration) RaceEndpointsOption {
+	return func(config *raceEndpointsArguments) {
+		config.Delay = Duration(delay)
+	}
+}
+
+// RaceEndpoints returns a stage that runs the given stage for the endpoints it receives in
+// input using the "happy eyeballs" algorithm (see RFC 8305) and returns the first successful
+// result. This stage models what real clients experience, while [NewEndpointPipeline] measures
+// the reachability of each endpoint.
+//
+// We interleave the IPv6 and IPv4 endpoints, starting with the family of the first endpoint,
+// and we start a new attempt after a delay (see [RaceEndpointsOptionDelay]) or as soon as the
+// previous attempt fails. Once an attempt succeeds, we cancel the other attempts and we wait
+// for them to terminate, so they can save their partial observations. When all the attempts
+// fail, we return the error of the last attempt that failed. We return [ErrNoEndpoints]
+// when the list of endpoints is empty.
+//
+// Note that the results of the losing attempts are not closed by this stage, so you should
+// rely on [Runtime.Close] to close, e.g., the connections of the losing attempts.
+func RaceEndpoints[T any](stage Stage[*Endpoint, T], options ...RaceEndpointsOption) Stage[[]*Endpoint, T] {
+	config := raceEndpointsArguments{}
+	for _, option := range options {
+		option(&config)
+	}
+	return &raceEndpointsStage[T]{config, stage}
+}
+
+// ErrNoEndpoints indicates that a stage did not receive any endpoint.
+var ErrNoEndpoints = errors.New("dsl: no endpoints")
+
+// raceEndpointsDefaultDelay is the default delay between attempts.
+const raceEndpointsDefaultDelay = 250 * time.Millisecond
+
+type raceEndpointsArguments struct {
+	Delay Duration `json:"delay,omitempty"`
+}
+
+type raceEndpointsStage[T any] struct {
+	config raceEndpointsArguments
+	sx     Stage[*Endpoint, T]
+}
+
+const raceEndpointsStageName = "race_endpoints"
+
+// ASTNode implements Stage.
+func (sx *raceEndpointsStage[T]) ASTNode() *SerializableASTNode {
+	// There is type erasure when we AST-serialize
+	return &SerializableASTNode{
+		StageName: raceEndpointsStageName,
+		Arguments: &sx.config,
+		Children:  []*SerializableASTNode{sx.sx.ASTNode()},
+	}
+}
+
+type raceEndpointsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*raceEndpointsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var config raceEndpointsArguments
+	if err := json.Unmarshal(node.Arguments, &config); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
+		return nil, err
+	}
+	runnables, err := loader.LoadChildren(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckChildrenTypes[*Endpoint, any](node, runnables...); err != nil {
+		return nil, err
+	}
+	runtimex.Assert(len(runnables) == 1, "unexpected number of children")
+
+	// Because there is type erasure, we create a RaceEndpoints[any] and we declare the
+	// output type using the output type of the child, such that we can type check the AST.
+	_, output := runnableASTNodeTypes(runnables[0])
+	if output == nil {
+		output = typeOf[*Endpoint]() // the child is a filter
+	}
+	stage := &raceEndpointsStage[any]{config, &erasedRunnableASTNodeStage[*Endpoint]{runnables[0]}}
+	return &raceEndpointsRunnableASTNode{stage: stage, output: output}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*raceEndpointsLoader) StageName() string {
+	return raceEndpointsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*raceEndpointsLoader) StageArguments() any {
+	return &raceEndpointsArguments{}
+}
+
+// erasedRunnableASTNodeStage adapts a [RunnableASTNode] to be a Stage[A, any]. Unlike
+// [RunnableASTNodeStage], we do not convert the output, which the node already creates
+// using the correct type, so we can pass it along without changing its type.
+type erasedRunnableASTNodeStage[A any] struct {
+	N RunnableASTNode
+}
+
+// ASTNode implements Stage.
+func (sx *erasedRunnableASTNodeStage[A]) ASTNode() *SerializableASTNode {
+	return sx.N.ASTNode()
+}
+
+// Run implements Stage.
+func (sx *erasedRunnableASTNodeStage[A]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[any] {
+	return sx.N.Run(ctx, rtx, input.AsGeneric())
+}
+
+// raceEndpointsRunnableASTNode is the [RunnableASTNode] of a loaded race_endpoints stage.
+type raceEndpointsRunnableASTNode struct {
+	node   interceptedStageNode
+	stage  Stage[[]*Endpoint, any]
+	output reflect.Type
+}
+
+var _ TypedRunnableASTNode = &raceEndpointsRunnableASTNode{}
+
+// ASTNode implements RunnableASTNode.
+func (n *raceEndpointsRunnableASTNode) ASTNode() *SerializableASTNode {
+	return n.stage.ASTNode()
+}
+
+// InputType implements TypedRunnableASTNode.
+func (n *raceEndpointsRunnableASTNode) InputType() reflect.Type {
+	return typeOf[[]*Endpoint]()
+}
+
+// OutputType implements TypedRunnableASTNode.
+func (n *raceEndpointsRunnableASTNode) OutputType() reflect.Type {
+	return n.output
+}
+
+// Run implements RunnableASTNode.
+func (n *raceEndpointsRunnableASTNode) Run(ctx context.Context, rtx Runtime, input Maybe[any]) Maybe[any] {
+	xinput, except := AsSpecificMaybe[[]*Endpoint](input)
+	if except != nil {
+		return NewError[any](except)
+	}
+
+	// call the underlying stage using the interceptor
+	output := interceptStage(ctx, rtx, n.stage, &n.node, xinput, n.stage.Run)
+
+	// make sure the errors we create have the correct type (e.g., when there are no endpoints)
+	if output.Value == nil && n.output != anyType {
+		output.Value = reflect.Zero(n.output).Interface()
+	}
+	return output
+}
+
+// Run implements Stage.
+func (sx *raceEndpointsStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[T] {
+	if input.Error != nil {
+		return NewError[T](input.Error)
+	}
+	endpoints := interleaveEndpointFamilies(input.Value)
+	if len(endpoints) <= 0 {
+		return NewError[T](ErrNoEndpoints)
+	}
+
+	// make sure we cancel the attempts still running when we have a winner
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := durationOrDefault(sx.config.Delay, raceEndpointsDefaultDelay)
+	results := make(chan Maybe[T], len(endpoints))
+	var next, running int
+
+	// start starts the next attempt
+	start := func() {
+		endpoint := endpoints[next]
+		next++
+		running++
+		go func() {
+			results <- sx.sx.Run(ctx, rtx, NewValue(endpoint))
+		}()
+	}
+
+	// canStart returns whether we can start a new attempt
+	canStart := func() bool {
+		limit := rtx.MaxParallelism()
+		return next < len(endpoints) && (limit <= 0 || running < limit)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	start()
+
+	var last Maybe[T]
+	for running > 0 {
+		select {
+		case <-timer.C:
+			if canStart() {
+				start()
+			}
+			timer.Reset(delay)
+
+		case last = <-results:
+			running--
+
+			// stop the race when we have a winner or when something went very wrong
+			if last.Error == nil || IsErrException(last.Error) {
+				cancel()
+				for ; running > 0; running-- {
+					<-results // wait for the losers to save their observations
+				}
+				return last
+			}
+
+			// start the next attempt immediately when an attempt fails
+			if canStart() {
+				start()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(delay)
+			}
+		}
+	}
+	return last
+}
+
+// interleaveEndpointFamilies returns a copy of the given endpoints where we interleave the
+// IPv6 and IPv4 endpoints starting with the family of the first endpoint.
+func interleaveEndpointFamilies(endpoints []*Endpoint) (output []*Endpoint) {
+	var families [2][]*Endpoint
+	var first int
+	for idx, endpoint := range endpoints {
+		family := 0
+		if ip, err := endpointIP(endpoint); err == nil && ip.To4() == nil {
+			family = 1
+		}
+		if idx == 0 {
+			first = family
+		}
+		families[family] = append(families[family], endpoint)
+	}
+	preferred, other := families[first], families[1-first]
+	for len(preferred) > 0 || len(other) > 0 {
+		if len(preferred) > 0 {
+			output = append(output, preferred[0])
+			preferred = preferred[1:]
+		}
+		if len(other) > 0 {
+			output = append(output, other[0])
+			other = other[1:]
+		}
+	}
+	return output
+}
@@ -0,0 +1,180 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// raceTestStage is a stage whose behavior depends on the endpoint address.
+type raceTestStage struct {
+	// behavior maps an address to a function implementing the attempt.
+	behavior map[string]func(ctx context.Context) Maybe[string]
+
+	// events records what happened.
+	events []string
+
+	// mu protects events.
+	mu sync.Mutex
+}
+
+// ASTNode implements Stage.
+func (sx *raceTestStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{StageName: "race_test"}
+}
+
+// Run implements Stage.
+func (sx *raceTestStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Endpoint]) Maybe[string] {
+	output := sx.behavior[input.Value.Address](ctx)
+	sx.mu.Lock()
+	sx.events = append(sx.events, input.Value.Address)
+	sx.mu.Unlock()
+	return output
+}
+
+// raceTestSucceed returns an attempt succeeding after the given delay.
+func raceTestSucceed(delay time.Duration, value string) func(ctx context.Context) Maybe[string] {
+	return func(ctx context.Context) Maybe[string] {
+		select {
+		case <-time.After(delay):
+			return NewValue(value)
+		case <-ctx.Done():
+			return NewError[string](ctx.Err())
+		}
+	}
+}
+
+// raceTestFail returns an attempt failing immediately.
+func raceTestFail(err error) func(ctx context.Context) Maybe[string] {
+	return func(ctx context.Context) Maybe[string] {
+		return NewError[string](err)
+	}
+}
+
+func TestRaceEndpoints(t *testing.T) {
+	endpoints := []*Endpoint{{Address: "10.0.0.1:443"}, {Address: "10.0.0.2:443"}}
+	errFailed := errors.New("mocked error")
+
+	// run runs the race and returns the output and the events
+	run := func(stage *raceTestStage, delay time.Duration, input ...*Endpoint) Maybe[string] {
+		race := RaceEndpoints[string](stage, RaceEndpointsOptionDelay(delay))
+		return race.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(input))
+	}
+
+	t.Run("the fastest attempt wins and we wait for the losers", func(t *testing.T) {
+		stage := &raceTestStage{behavior: map[string]func(ctx context.Context) Maybe[string]{
+			"10.0.0.1:443": raceTestSucceed(time.Hour, "first"),
+			"10.0.0.2:443": raceTestSucceed(10*time.Millisecond, "second"),
+		}}
+		output := run(stage, 10*time.Millisecond, endpoints...)
+		if output.Error != nil || output.Value != "second" {
+			t.Fatal("unexpected output", output)
+		}
+		if diff := cmp.Diff([]string{"10.0.0.2:443", "10.0.0.1:443"}, stage.events); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we start the next attempt as soon as an attempt fails", func(t *testing.T) {
+		stage := &raceTestStage{behavior: map[string]func(ctx context.Context) Maybe[string]{
+			"10.0.0.1:443": raceTestFail(errFailed),
+			"10.0.0.2:443": raceTestSucceed(0, "second"),
+		}}
+		done := make(chan Maybe[string])
+		go func() {
+			done <- run(stage, time.Hour, endpoints...)
+		}()
+		select {
+		case output := <-done:
+			if output.Error != nil || output.Value != "second" {
+				t.Fatal("unexpected output", output)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("we did not start the next attempt")
+		}
+	})
+
+	t.Run("we return the last error when all the attempts fail", func(t *testing.T) {
+		stage := &raceTestStage{behavior: map[string]func(ctx context.Context) Maybe[string]{
+			"10.0.0.1:443": raceTestFail(errors.New("first error")),
+			"10.0.0.2:443": raceTestFail(errFailed),
+		}}
+		if output := run(stage, time.Millisecond, endpoints...); !errors.Is(output.Error, errFailed) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+
+	t.Run("we return an error when there are no endpoints", func(t *testing.T) {
+		if output := run(&raceTestStage{}, 0); !errors.Is(output.Error, ErrNoEndpoints) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+}
+
+func TestInterleaveEndpointFamilies(t *testing.T) {
+	input := []*Endpoint{
+		{Address: "[2001:db8::1]:443"},
+		{Address: "[2001:db8::2]:443"},
+		{Address: "[2001:db8::3]:443"},
+		{Address: "10.0.0.1:443"},
+	}
+	expected := []string{"[2001:db8::1]:443", "10.0.0.1:443", "[2001:db8::2]:443", "[2001:db8::3]:443"}
+	if diff := cmp.Diff(expected, endpointTransformTestAddresses(interleaveEndpointFamilies(input))); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestRaceEndpointsLoader(t *testing.T) {
+	// load serializes and loads the given AST
+	load := func(root *SerializableASTNode) (RunnableASTNode, error) {
+		data, err := json.Marshal(root)
+		if err != nil {
+			return nil, err
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		return NewASTLoader().Load(&node)
+	}
+
+	// compose returns the AST composing the two given ASTs
+	compose := func(n1, n2 *SerializableASTNode) *SerializableASTNode {
+		return &SerializableASTNode{
+			StageName: composeStageName,
+			Children:  []*SerializableASTNode{n1, n2},
+		}
+	}
+
+	race := RaceEndpoints(TCPConnect(), RaceEndpointsOptionDelay(100*time.Millisecond))
+
+	t.Run("we declare the output type of the child", func(t *testing.T) {
+		if _, err := load(compose(race.ASTNode(), TLSHandshake().ASTNode())); err != nil {
+			t.Fatal(err)
+		}
+		var typeErr *ErrASTTypeCheck
+		if _, err := load(compose(race.ASTNode(), HTTPTransaction().ASTNode())); !errors.As(err, &typeErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("errors have the type of the child output", func(t *testing.T) {
+		runnable, err := load(race.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := runnable.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue[any]([]*Endpoint{}))
+		if !errors.Is(output.Error, ErrNoEndpoints) {
+			t.Fatal("unexpected error", output.Error)
+		}
+		if _, good := output.Value.(*TCPConnection); !good {
+			t.Fatalf("unexpected value type %T", output.Value)
+		}
+	})
+}
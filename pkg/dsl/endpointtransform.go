@@ -0,0 +1,451 @@
+package dsl
+
+//
+// Endpoint list transformations
+//
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand"
+	"net"
+
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// EndpointFamilyIPv4 is the [FilterEndpointsByFamily] family selecting IPv4 endpoints.
+const EndpointFamilyIPv4 = "ipv4"
+
+// EndpointFamilyIPv6 is the [FilterEndpointsByFamily] family selecting IPv6 endpoints.
+const EndpointFamilyIPv6 = "ipv6"
+
+// ErrInvalidEndpointFamily indicates that an endpoint family is invalid.
+type ErrInvalidEndpointFamily struct {
+	Family string
+}
+
+// Error implements error.
+func (err *ErrInvalidEndpointFamily) Error() string {
+	return fmt.Sprintf("dsl: invalid endpoint family: %s", err.Family)
+}
+
+// endpointIP returns the IP address of the given endpoint.
+func endpointIP(endpoint *Endpoint) (net.IP, error) {
+	addr, _, err := net.SplitHostPort(endpoint.Address)
+	if err != nil {
+		return nil, &ErrInvalidEndpoint{endpoint.Address}
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &ErrInvalidEndpoint{endpoint.Address}
+	}
+	return ip, nil
+}
+
+// FilterEndpointsByFamily returns a stage that only keeps the endpoints using the given
+// family, which is either [EndpointFamilyIPv4] or [EndpointFamilyIPv6].
+func FilterEndpointsByFamily(family string) Stage[[]*Endpoint, []*Endpoint] {
+	return &filterEndpointsByFamilyStage{family}
+}
+
+type filterEndpointsByFamilyStage struct {
+	Family string `json:"family"`
+}
+
+const filterEndpointsByFamilyStageName = "filter_endpoints_by_family"
+
+// ASTNode implements Stage.
+func (sx *filterEndpointsByFamilyStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: filterEndpointsByFamilyStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type filterEndpointsByFamilyLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*filterEndpointsByFamilyLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage filterEndpointsByFamilyStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*filterEndpointsByFamilyLoader) StageName() string {
+	return filterEndpointsByFamilyStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*filterEndpointsByFamilyLoader) StageArguments() any {
+	return &filterEndpointsByFamilyStage{}
+}
+
+// Run implements Stage.
+func (sx *filterEndpointsByFamilyStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	if sx.Family != EndpointFamilyIPv4 && sx.Family != EndpointFamilyIPv6 {
+		return NewError[[]*Endpoint](&ErrException{&ErrInvalidEndpointFamily{sx.Family}})
+	}
+	output := []*Endpoint{}
+	for _, endpoint := range input.Value {
+		ip, err := endpointIP(endpoint)
+		if err != nil {
+			return NewError[[]*Endpoint](&ErrException{err})
+		}
+		if isIPv4 := ip.To4() != nil; isIPv4 == (sx.Family == EndpointFamilyIPv4) {
+			output = append(output, endpoint)
+		}
+	}
+	return NewValue(output)
+}
+
+// FilterEndpointsByCIDR returns a stage that only keeps the endpoints whose IP address
+// belongs to at least one of the given networks in CIDR notation (e.g., "10.0.0.0/8").
+func FilterEndpointsByCIDR(networks ...string) Stage[[]*Endpoint, []*Endpoint] {
+	return &filterEndpointsByCIDRStage{networks}
+}
+
+type filterEndpointsByCIDRStage struct {
+	Networks []string `json:"networks"`
+}
+
+const filterEndpointsByCIDRStageName = "filter_endpoints_by_cidr"
+
+// ASTNode implements Stage.
+func (sx *filterEndpointsByCIDRStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: filterEndpointsByCIDRStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type filterEndpointsByCIDRLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*filterEndpointsByCIDRLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage filterEndpointsByCIDRStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*filterEndpointsByCIDRLoader) StageName() string {
+	return filterEndpointsByCIDRStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*filterEndpointsByCIDRLoader) StageArguments() any {
+	return &filterEndpointsByCIDRStage{}
+}
+
+// Run implements Stage.
+func (sx *filterEndpointsByCIDRStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	var networks []*net.IPNet
+	for _, entry := range sx.Networks {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return NewError[[]*Endpoint](&ErrException{err})
+		}
+		networks = append(networks, network)
+	}
+	output := []*Endpoint{}
+	for _, endpoint := range input.Value {
+		ip, err := endpointIP(endpoint)
+		if err != nil {
+			return NewError[[]*Endpoint](&ErrException{err})
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				output = append(output, endpoint)
+				break
+			}
+		}
+	}
+	return NewValue(output)
+}
+
+// FilterEndpointsByNetwork returns a stage that only keeps the endpoints using the given
+// network (i.e., "tcp" or "udp"). See [MakeEndpointsForPorts] for more information.
+func FilterEndpointsByNetwork(network string) Stage[[]*Endpoint, []*Endpoint] {
+	return &filterEndpointsByNetworkStage{network}
+}
+
+type filterEndpointsByNetworkStage struct {
+	Network string `json:"network"`
+}
+
+const filterEndpointsByNetworkStageName = "filter_endpoints_by_network"
+
+// ASTNode implements Stage.
+func (sx *filterEndpointsByNetworkStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: filterEndpointsByNetworkStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type filterEndpointsByNetworkLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*filterEndpointsByNetworkLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage filterEndpointsByNetworkStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*filterEndpointsByNetworkLoader) StageName() string {
+	return filterEndpointsByNetworkStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*filterEndpointsByNetworkLoader) StageArguments() any {
+	return &filterEndpointsByNetworkStage{}
+}
+
+// Run implements Stage.
+func (sx *filterEndpointsByNetworkStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	output := []*Endpoint{}
+	for _, endpoint := range input.Value {
+		if endpoint.Network == sx.Network {
+			output = append(output, endpoint)
+		}
+	}
+	return NewValue(output)
+}
+
+// UniqueEndpoints returns a stage that removes duplicate endpoints (i.e., endpoints with
+// the same address, domain, and network) preserving the order of the first occurrences.
+func UniqueEndpoints() Stage[[]*Endpoint, []*Endpoint] {
+	return &uniqueEndpointsStage{}
+}
+
+type uniqueEndpointsStage struct{}
+
+const uniqueEndpointsStageName = "unique_endpoints"
+
+// ASTNode implements Stage.
+func (sx *uniqueEndpointsStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: uniqueEndpointsStageName,
+		Arguments: nil,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type uniqueEndpointsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*uniqueEndpointsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	if err := loader.LoadEmptyArguments(node); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &uniqueEndpointsStage{}}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*uniqueEndpointsLoader) StageName() string {
+	return uniqueEndpointsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*uniqueEndpointsLoader) StageArguments() any {
+	return nil
+}
+
+// Run implements Stage.
+func (sx *uniqueEndpointsStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	uniq := make(map[Endpoint]bool)
+	output := []*Endpoint{}
+	for _, endpoint := range input.Value {
+		if uniq[*endpoint] {
+			continue
+		}
+		uniq[*endpoint] = true
+		output = append(output, endpoint)
+	}
+	return NewValue(output)
+}
+
+// TakeEndpoints returns a stage that keeps at most the first count endpoints.
+func TakeEndpoints(count int) Stage[[]*Endpoint, []*Endpoint] {
+	return &takeEndpointsStage{count}
+}
+
+type takeEndpointsStage struct {
+	Count int `json:"count"`
+}
+
+const takeEndpointsStageName = "take_endpoints"
+
+// ASTNode implements Stage.
+func (sx *takeEndpointsStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: takeEndpointsStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type takeEndpointsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*takeEndpointsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage takeEndpointsStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*takeEndpointsLoader) StageName() string {
+	return takeEndpointsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*takeEndpointsLoader) StageArguments() any {
+	return &takeEndpointsStage{}
+}
+
+// Run implements Stage.
+func (sx *takeEndpointsStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	count := sx.Count
+	if count < 0 {
+		count = 0
+	}
+	if count > len(input.Value) {
+		count = len(input.Value)
+	}
+	return NewValue(append([]*Endpoint{}, input.Value[:count]...))
+}
+
+// SampleEndpointsSeedAnnotation is the name of the [Annotation] containing the
+// random seed used by the [SampleEndpoints] stage.
+const SampleEndpointsSeedAnnotation = "sample_endpoints_seed"
+
+// SampleEndpoints returns a stage that randomly selects count endpoints and returns them
+// in random order. A zero or negative count means selecting all the endpoints, which is
+// useful to shuffle the endpoints. A zero seed means using a random seed. In any case, the
+// stage saves the seed it uses as an [Annotation] named [SampleEndpointsSeedAnnotation], such
+// that it is possible to reproduce the selection when analyzing the measurement.
+func SampleEndpoints(count int, seed int64) Stage[[]*Endpoint, []*Endpoint] {
+	return &sampleEndpointsStage{count, seed}
+}
+
+type sampleEndpointsStage struct {
+	Count int   `json:"count"`
+	Seed  int64 `json:"seed"`
+}
+
+const sampleEndpointsStageName = "sample_endpoints"
+
+// ASTNode implements Stage.
+func (sx *sampleEndpointsStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: sampleEndpointsStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type sampleEndpointsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*sampleEndpointsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage sampleEndpointsStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[[]*Endpoint, []*Endpoint]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*sampleEndpointsLoader) StageName() string {
+	return sampleEndpointsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*sampleEndpointsLoader) StageArguments() any {
+	return &sampleEndpointsStage{}
+}
+
+// Run implements Stage.
+func (sx *sampleEndpointsStage) Run(ctx context.Context, rtx Runtime, input Maybe[[]*Endpoint]) Maybe[[]*Endpoint] {
+	if input.Error != nil {
+		return NewError[[]*Endpoint](input.Error)
+	}
+	seed := sx.Seed
+	if seed == 0 {
+		seed = newSampleEndpointsSeed()
+	}
+	SaveAnnotation(rtx, SampleEndpointsSeedAnnotation, seed)
+
+	output := append([]*Endpoint{}, input.Value...)
+	prng := mrand.New(mrand.NewSource(seed))
+	prng.Shuffle(len(output), func(i, j int) {
+		output[i], output[j] = output[j], output[i]
+	})
+	if sx.Count > 0 && sx.Count < len(output) {
+		output = output[:sx.Count]
+	}
+	return NewValue(output)
+}
+
+// newSampleEndpointsSeed returns a new random nonzero seed.
+func newSampleEndpointsSeed() int64 {
+	var buffer [8]byte
+	for {
+		_, err := rand.Read(buffer[:])
+		runtimex.PanicOnError(err, "rand.Read failed")
+		// Note: we use a positive seed to make the annotation more readable
+		if seed := int64(binary.BigEndian.Uint64(buffer[:]) >> 1); seed != 0 {
+			return seed
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// endpointTransformTestAddresses returns the addresses of the given endpoints.
+func endpointTransformTestAddresses(endpoints []*Endpoint) (out []string) {
+	out = []string{}
+	for _, endpoint := range endpoints {
+		out = append(out, endpoint.Address)
+	}
+	return
+}
+
+// endpointTransformTestRun runs the given stage after a JSON round trip of its AST,
+// such that we also test the loader, and returns the output and the observations.
+func endpointTransformTestRun(t *testing.T, stage Stage[[]*Endpoint, []*Endpoint], input ...*Endpoint) (Maybe[[]*Endpoint], *Observations) {
+	data, err := json.Marshal(stage.ASTNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node LoadableASTNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		t.Fatal(err)
+	}
+	runnable, err := NewASTLoader().Load(&node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtx := NewMinimalRuntime(log.Log)
+	output := (&RunnableASTNodeStage[[]*Endpoint, []*Endpoint]{runnable}).Run(context.Background(), rtx, NewValue(input))
+	return output, ReduceObservations(rtx.ExtractObservations()...)
+}
+
+func TestEndpointTransformations(t *testing.T) {
+	endpoints := []*Endpoint{
+		{Address: "8.8.8.8:443", Domain: "dns.google"},
+		{Address: "[2001:4860:4860::8888]:443", Domain: "dns.google"},
+		{Address: "8.8.4.4:443", Domain: "dns.google"},
+		{Address: "8.8.8.8:443", Domain: "dns.google"},
+		{Address: "10.0.0.1:443", Domain: "dns.google"},
+	}
+
+	type testcase struct {
+		name     string
+		stage    Stage[[]*Endpoint, []*Endpoint]
+		expected []string
+	}
+
+	for _, tc := range []testcase{{
+		name:     "FilterEndpointsByFamily with IPv4",
+		stage:    FilterEndpointsByFamily(EndpointFamilyIPv4),
+		expected: []string{"8.8.8.8:443", "8.8.4.4:443", "8.8.8.8:443", "10.0.0.1:443"},
+	}, {
+		name:     "FilterEndpointsByFamily with IPv6",
+		stage:    FilterEndpointsByFamily(EndpointFamilyIPv6),
+		expected: []string{"[2001:4860:4860::8888]:443"},
+	}, {
+		name:     "FilterEndpointsByCIDR",
+		stage:    FilterEndpointsByCIDR("8.8.8.0/24", "2001:4860::/32"),
+		expected: []string{"8.8.8.8:443", "[2001:4860:4860::8888]:443", "8.8.8.8:443"},
+	}, {
+		name:     "UniqueEndpoints",
+		stage:    UniqueEndpoints(),
+		expected: []string{"8.8.8.8:443", "[2001:4860:4860::8888]:443", "8.8.4.4:443", "10.0.0.1:443"},
+	}, {
+		name:     "TakeEndpoints",
+		stage:    TakeEndpoints(2),
+		expected: []string{"8.8.8.8:443", "[2001:4860:4860::8888]:443"},
+	}, {
+		name:     "TakeEndpoints with more endpoints than available",
+		stage:    TakeEndpoints(10),
+		expected: endpointTransformTestAddresses(endpoints),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, _ := endpointTransformTestRun(t, tc.stage, endpoints...)
+			if output.Error != nil {
+				t.Fatal(output.Error)
+			}
+			if diff := cmp.Diff(tc.expected, endpointTransformTestAddresses(output.Value)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	t.Run("we throw on invalid arguments", func(t *testing.T) {
+		for _, stage := range []Stage[[]*Endpoint, []*Endpoint]{
+			FilterEndpointsByFamily("ipv5"),
+			FilterEndpointsByCIDR("8.8.8.8"),
+		} {
+			output, _ := endpointTransformTestRun(t, stage, endpoints...)
+			if !IsErrException(output.Error) {
+				t.Fatal("unexpected error", output.Error)
+			}
+		}
+	})
+
+	t.Run("SampleEndpoints is reproducible and records the seed", func(t *testing.T) {
+		first, observations := endpointTransformTestRun(t, SampleEndpoints(3, 0), endpoints...)
+		if first.Error != nil {
+			t.Fatal(first.Error)
+		}
+		if len(first.Value) != 3 {
+			t.Fatal("unexpected number of endpoints", len(first.Value))
+		}
+		if len(observations.Annotations) != 1 || observations.Annotations[0].Name != SampleEndpointsSeedAnnotation {
+			t.Fatal("expected to find the seed annotation")
+		}
+		seed := observations.Annotations[0].Value.(int64)
+		second, _ := endpointTransformTestRun(t, SampleEndpoints(3, seed), endpoints...)
+		if second.Error != nil {
+			t.Fatal(second.Error)
+		}
+		if diff := cmp.Diff(endpointTransformTestAddresses(first.Value), endpointTransformTestAddresses(second.Value)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("SampleEndpoints with zero count shuffles all the endpoints", func(t *testing.T) {
+		output, _ := endpointTransformTestRun(t, SampleEndpoints(0, 17), endpoints...)
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		if len(output.Value) != len(endpoints) {
+			t.Fatal("unexpected number of endpoints", len(output.Value))
+		}
+	})
+}
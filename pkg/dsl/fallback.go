@@ -0,0 +1,124 @@
+package dsl
+
+import (
+	"context"
+	"errors"
+)
+
+// WithFallbacks returns a stage that runs the preferred stage. When serialized, this stage
+// also contains the fallbacks, and probes interpreting the AST load the first alternative among
+// the preferred stage and the fallbacks (in this order) that they are able to load. When loading,
+// we also make sure that all the alternatives we can load have the same types. Combined
+// with [VersionedStageName] and [ASTLoader.Capabilities], this functionality allows the backend
+// to serve ASTs using recent stage versions while still supporting older probes.
+func WithFallbacks[A, B any](preferred Stage[A, B], fallbacks ...Stage[A, B]) Stage[A, B] {
+	return &withFallbacksStage[A, B]{append([]Stage[A, B]{preferred}, fallbacks...)}
+}
+
+type withFallbacksStage[A, B any] struct {
+	alternatives []Stage[A, B]
+}
+
+const withFallbacksStageName = "with_fallbacks"
+
+// ASTNode implements Stage.
+func (sx *withFallbacksStage[A, B]) ASTNode() *SerializableASTNode {
+	var nodes []*SerializableASTNode
+	for _, stage := range sx.alternatives {
+		nodes = append(nodes, stage.ASTNode())
+	}
+	return &SerializableASTNode{
+		StageName: withFallbacksStageName,
+		Arguments: nil,
+		Children:  nodes,
+	}
+}
+
+type withFallbacksLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*withFallbacksLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	if err := loader.LoadEmptyArguments(node); err != nil {
+		return nil, err
+	}
+	if len(node.Children) <= 0 {
+		return nil, ErrInvalidNumberOfChildren
+	}
+	var (
+		err      error
+		selected RunnableASTNode
+	)
+	for idx, child := range node.Children {
+		var runnable RunnableASTNode
+		runnable, err = loader.Load(child)
+
+		// skip the alternatives using stages we do not support
+		if errors.Is(err, ErrNoSuchStage) {
+			continue
+		}
+
+		// any other error means that the alternative is broken
+		if err != nil {
+			var typeErr *ErrASTTypeCheck
+			if errors.As(err, &typeErr) {
+				typeErr.prependPath(astChildPathElem(idx))
+			}
+			return nil, err
+		}
+
+		// we select the first alternative we can load and we make sure
+		// the other alternatives we can load have the same types
+		if selected == nil {
+			selected = runnable
+			continue
+		}
+		if err := checkFallbackTypes(node, idx, selected, runnable); err != nil {
+			return nil, err
+		}
+	}
+	if selected == nil {
+		// we return the error of the last alternative, which wraps ErrNoSuchStage
+		return nil, err
+	}
+	// Note: like IfFilterExists, we replace this node with the loaded alternative
+	return selected, nil
+}
+
+// checkFallbackTypes returns an [*ErrASTTypeCheck] when the alternative with the given index
+// does not have the same input and output types of the selected alternative.
+func checkFallbackTypes(node *LoadableASTNode, index int, selected, alternative RunnableASTNode) error {
+	input, output := runnableASTNodeTypes(selected)
+	altInput, altOutput := runnableASTNodeTypes(alternative)
+	if !typesAreCompatible(input, altInput) || !typesAreCompatible(altInput, input) {
+		return newErrASTTypeCheck(node, index, input, altInput)
+	}
+
+	// a filter returns the same type it receives in input
+	if output == nil {
+		output = input
+	}
+	if altOutput == nil {
+		altOutput = altInput
+	}
+	if !typesAreCompatible(output, altOutput) || !typesAreCompatible(altOutput, output) {
+		return newErrASTTypeCheck(node, index, output, altOutput)
+	}
+	return nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*withFallbacksLoader) StageName() string {
+	return withFallbacksStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*withFallbacksLoader) StageArguments() any {
+	return nil
+}
+
+// Run implements Stage.
+func (sx *withFallbacksStage[A, B]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[B] {
+	// When we created the withFallbacksStage directly the Go code has compiled so
+	// the preferred stage exists and we can run it directly.
+	return sx.alternatives[0].Run(ctx, rtx, input)
+}
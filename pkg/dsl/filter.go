@@ -2,6 +2,7 @@ package dsl
 
 import (
 	"context"
+	"errors"
 )
 
 // IfFilterExists wraps a filter such that probes interpreting the AST can compile the filter
@@ -38,13 +39,23 @@ func (*ifFilterExistsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Run
 	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
 		return nil, err
 	}
-	runnable, err := loader.Load(node.Children[0])
+	runnables, err := loader.LoadChildren(node)
 	if err != nil {
+		// An ill-typed filter exists, so we must not silently replace it
+		var typeErr *ErrASTTypeCheck
+		if errors.As(err, &typeErr) {
+			return nil, err
+		}
 		// If we cannot load the given filter, replace it with the identity
 		return &Identity[any]{}, nil
 	}
+	// Make sure that the underlying node is actually a filter
+	input, output := runnableASTNodeTypes(runnables[0])
+	if output != nil && !typesAreCompatible(output, input) {
+		return nil, newErrASTTypeCheck(node, 0, input, output)
+	}
 	// Otherwise, we replace the ifFilterExists node with the existing underlying node
-	return runnable, nil
+	return runnables[0], nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -52,6 +63,11 @@ func (*ifFilterExistsLoader) StageName() string {
 	return ifFilterExistsStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*ifFilterExistsLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (fx *ifFilterExistsStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[T]) Maybe[T] {
 	// When we created the ifFilterExistsStage directly the Go code has compiled so
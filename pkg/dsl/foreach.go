@@ -0,0 +1,161 @@
+package dsl
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// ForEach returns a stage that runs the given stage for each element of the list it
+// receives in input in parallel using a pool of background goroutines. This stage is the
+// generic counterpart of [NewEndpointPipeline], which allows, for example, to measure
+// a list of domain names returned by [DomainNames] using a single pipeline.
+func ForEach[T any](stage Stage[T, *Void]) Stage[[]T, *Void] {
+	return ForEachWithParallelism(0, stage)
+}
+
+// ForEachWithParallelism is like [ForEach] but allows to configure the number
+// of background goroutines. A zero or negative value means using the default.
+func ForEachWithParallelism[T any](parallelism int, stage Stage[T, *Void]) Stage[[]T, *Void] {
+	return &forEachStage[T]{parallelism, stage}
+}
+
+type forEachStage[T any] struct {
+	parallelism int
+	sx          Stage[T, *Void]
+}
+
+const forEachStageName = "for_each"
+
+// ASTNode implements Stage.
+func (sx *forEachStage[T]) ASTNode() *SerializableASTNode {
+	// There is type erasure when we AST-serialize
+	node := sx.sx.ASTNode()
+	return &SerializableASTNode{
+		StageName: forEachStageName,
+		Arguments: newParallelStageArguments(sx.parallelism),
+		Children:  []*SerializableASTNode{node},
+	}
+}
+
+type forEachLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*forEachLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
+		return nil, err
+	}
+	runnables, err := loader.LoadChildren(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckChildrenTypes[any, *Void](node, runnables...); err != nil {
+		return nil, err
+	}
+
+	// Because there is type erasure, we create a ForEach[any] and we declare the input
+	// type using the input type of the child, such that we can still type check the AST.
+	children := RunnableASTNodeListToStageList[any, *Void](runnables...)
+	runtimex.Assert(len(children) == 1, "unexpected number of children")
+	input, _ := runnableASTNodeTypes(runnables[0])
+	if input != anyType {
+		input = reflect.SliceOf(input)
+	}
+	stage := ForEachWithParallelism(config.Parallelism, children[0])
+	return &forEachRunnableASTNode{stage: stage, input: input}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*forEachLoader) StageName() string {
+	return forEachStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*forEachLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
+// forEachRunnableASTNode is the [RunnableASTNode] of a loaded for_each stage.
+type forEachRunnableASTNode struct {
+	node  interceptedStageNode
+	stage Stage[[]any, *Void]
+	input reflect.Type
+}
+
+var _ TypedRunnableASTNode = &forEachRunnableASTNode{}
+
+// ASTNode implements RunnableASTNode.
+func (n *forEachRunnableASTNode) ASTNode() *SerializableASTNode {
+	return n.stage.ASTNode()
+}
+
+// InputType implements TypedRunnableASTNode.
+func (n *forEachRunnableASTNode) InputType() reflect.Type {
+	return n.input
+}
+
+// OutputType implements TypedRunnableASTNode.
+func (n *forEachRunnableASTNode) OutputType() reflect.Type {
+	return typeOf[*Void]()
+}
+
+// Run implements RunnableASTNode.
+func (n *forEachRunnableASTNode) Run(ctx context.Context, rtx Runtime, input Maybe[any]) Maybe[any] {
+	if input.Error != nil {
+		return NewError[*Void](input.Error).AsGeneric()
+	}
+
+	// convert the specific list (e.g., []string) to a generic list
+	value := reflect.ValueOf(input.Value)
+	if value.Kind() != reflect.Slice {
+		return NewError[*Void](NewErrException("type error: expected a list; got %T", input.Value)).AsGeneric()
+	}
+	elements := make([]any, value.Len())
+	for idx := 0; idx < value.Len(); idx++ {
+		elements[idx] = value.Index(idx).Interface()
+	}
+
+	// call the underlying stage using the interceptor
+	return interceptStage(ctx, rtx, n.stage, &n.node, NewValue(elements), n.stage.Run).AsGeneric()
+}
+
+// Run implements Stage.
+func (sx *forEachStage[T]) Run(ctx context.Context, rtx Runtime, input Maybe[[]T]) Maybe[*Void] {
+	if input.Error != nil {
+		return NewError[*Void](input.Error)
+	}
+
+	// create list of workers
+	var workers []Worker[Maybe[*Void]]
+	for _, element := range input.Value {
+		workers = append(workers, &forEachWorker[T]{rtx: rtx, sx: sx.sx, input: element})
+	}
+
+	// run the stage in parallel
+	parallelism := effectiveParallelism(rtx, sx.parallelism, defaultParallelism)
+	results := ParallelRun(ctx, parallelism, workers...)
+
+	// route exceptions
+	if err := catch(results...); err != nil {
+		return NewError[*Void](err)
+	}
+
+	return NewValue(&Void{})
+}
+
+// forEachWorker is the [Worker] used by [forEachStage].
+type forEachWorker[T any] struct {
+	input T
+	rtx   Runtime
+	sx    Stage[T, *Void]
+}
+
+// Produce implements Worker.
+func (w *forEachWorker[T]) Produce(ctx context.Context) Maybe[*Void] {
+	return w.sx.Run(ctx, w.rtx, NewValue(w.input))
+}
@@ -0,0 +1,123 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// forEachTestRecordingStage is a stage that records the domain names it receives.
+type forEachTestRecordingStage struct {
+	domains []string
+	mu      sync.Mutex
+}
+
+// ASTNode implements Stage.
+func (sx *forEachTestRecordingStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{StageName: "recording"}
+}
+
+// Run implements Stage.
+func (sx *forEachTestRecordingStage) Run(ctx context.Context, rtx Runtime, input Maybe[string]) Maybe[*Void] {
+	sx.mu.Lock()
+	sx.domains = append(sx.domains, input.Value)
+	sx.mu.Unlock()
+	return NewValue(&Void{})
+}
+
+func TestForEach(t *testing.T) {
+	domains := []string{"www.example.com", "www.example.org", "www.example.net"}
+
+	t.Run("we run the stage for each element", func(t *testing.T) {
+		recorder := &forEachTestRecordingStage{}
+		pipeline := Compose(DomainNames(domains...), ForEachWithParallelism(2, Stage[string, *Void](recorder)))
+		output := pipeline.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		expected := append([]string{}, domains...)
+		sort.Strings(expected)
+		sort.Strings(recorder.domains)
+		if diff := cmp.Diff(expected, recorder.domains); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we reject an empty list of domains", func(t *testing.T) {
+		output := DomainNames().Run(context.Background(), NewMinimalRuntime(log.Log), NewValue(&Void{}))
+		if !IsErrException(output.Error) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+
+	// load serializes and loads the given AST
+	load := func(root *SerializableASTNode) (RunnableASTNode, error) {
+		data, err := json.Marshal(root)
+		if err != nil {
+			return nil, err
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		return NewASTLoader().Load(&node)
+	}
+
+	// compose returns the AST composing the two given ASTs
+	compose := func(n1, n2 *SerializableASTNode) *SerializableASTNode {
+		return &SerializableASTNode{
+			StageName: composeStageName,
+			Children:  []*SerializableASTNode{n1, n2},
+		}
+	}
+
+	t.Run("we can load and run the AST", func(t *testing.T) {
+		stage := Compose(
+			DomainNames(domains...),
+			ForEachWithParallelism(
+				3,
+				Compose(DNSLookupStatic("130.192.91.211"), Discard[*DNSLookupResult]()),
+			),
+		)
+		runnable, err := load(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(textTestCanonicalSerializable(t, stage.ASTNode()), textTestCanonicalSerializable(t, runnable.ASTNode())); diff != "" {
+			t.Fatal(diff)
+		}
+		output := runnable.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue[any](&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+	})
+
+	t.Run("we type check the elements", func(t *testing.T) {
+		root := compose(
+			DomainNames(domains...).ASTNode(),
+			ForEach(Compose(TCPConnect(), Discard[*TCPConnection]())).ASTNode(),
+		)
+		var typeErr *ErrASTTypeCheck
+		if _, err := load(root); !errors.As(err, &typeErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we reject inputs that are not lists", func(t *testing.T) {
+		root := compose(DomainName("www.example.com").ASTNode(), ForEach(Discard[string]()).ASTNode())
+		runnable, err := load(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := runnable.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue[any](&Void{}))
+		if !IsErrException(output.Error) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+}
@@ -27,14 +27,30 @@ type httpTransactionOperation struct {
 
 const httpTransactionStageName = "http_transaction"
 
+// httpTransactionStageVersion is the most recent version of the http_transaction stage. The second
+// version adds the timeout argument, which probes only supporting the first version would ignore.
+const httpTransactionStageVersion = 2
+
+// httpTransactionDefaultTimeout is the default HTTP transaction timeout.
+const httpTransactionDefaultTimeout = 10 * time.Second
+
+// httpTransactionDefaultResponseBodySnapshotSize is the default response body snapshot size.
+const httpTransactionDefaultResponseBodySnapshotSize = 1 << 19
+
 // ASTNode implements operation.
 func (op *httpTransactionOperation) ASTNode() *SerializableASTNode {
 	var config httpTransactionConfig
 	for _, option := range op.options {
 		option(&config)
 	}
+	// Note: we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if config.Timeout > 0 {
+		version = httpTransactionStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: httpTransactionStageName,
+		StageName: VersionedStageName(httpTransactionStageName, version),
 		Arguments: &config,
 		Children:  []*SerializableASTNode{},
 	}
@@ -52,8 +68,19 @@ func (*httpTransactionLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Ru
 		return nil, err
 	}
 	options := config.options()
+
+	// make sure a loaded AST does not read too large response bodies
+	if limit := loader.Limits().MaxResponseBodySnapshotSize; limit > 0 {
+		if exceedsLimit(config.ResponseBodySnapshotSize, limit) {
+			return nil, &ErrASTLimitExceeded{"MaxResponseBodySnapshotSize", config.ResponseBodySnapshotSize, limit}
+		}
+		if config.ResponseBodySnapshotSize <= 0 && exceedsLimit(httpTransactionDefaultResponseBodySnapshotSize, limit) {
+			options = append(options, HTTPTransactionOptionResponseBodySnapshotSize(limit))
+		}
+	}
+
 	stage := HTTPTransaction(options...)
-	return &StageRunnableASTNode[*HTTPConnection, *HTTPResponse]{stage}, nil
+	return &StageRunnableASTNode[*HTTPConnection, *HTTPResponse]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -61,13 +88,18 @@ func (*httpTransactionLoader) StageName() string {
 	return httpTransactionStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*httpTransactionLoader) StageVersion() int {
+	return httpTransactionStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*httpTransactionLoader) StageArguments() any {
+	return &httpTransactionConfig{}
+}
+
 // Run implements operation.
 func (op *httpTransactionOperation) Run(ctx context.Context, rtx Runtime, conn *HTTPConnection) (*HTTPResponse, error) {
-	// setup
-	const timeout = 10 * time.Second
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
 	// create configuration
 	config := &httpTransactionConfig{
 		AcceptHeader:                model.HTTPHeaderAccept,
@@ -76,7 +108,7 @@ func (op *httpTransactionOperation) Run(ctx context.Context, rtx Runtime, conn *
 		IncludeResponseBodySnapshot: false,
 		RefererHeader:               "",
 		RequestMethod:               "GET",
-		ResponseBodySnapshotSize:    1 << 19,
+		ResponseBodySnapshotSize:    httpTransactionDefaultResponseBodySnapshotSize,
 		URLHost:                     conn.Domain,
 		URLPath:                     "/",
 		URLScheme:                   conn.Scheme,
@@ -86,6 +118,11 @@ func (op *httpTransactionOperation) Run(ctx context.Context, rtx Runtime, conn *
 		option(config)
 	}
 
+	// setup
+	timeout := durationOrDefault(config.Timeout, httpTransactionDefaultTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// create HTTP request
 	req, err := op.newHTTPRequest(ctx, config)
 	if err != nil {
@@ -107,6 +144,7 @@ func (op *httpTransactionOperation) Run(ctx context.Context, rtx Runtime, conn *
 
 	// mediate the transaction execution via the trace, which gets a chance
 	// to generate HTTP observations for this transaction
+	t0 := time.Now()
 	resp, body, err := conn.Trace.HTTPTransaction(
 		conn,
 		config.IncludeResponseBodySnapshot,
@@ -122,12 +160,12 @@ func (op *httpTransactionOperation) Run(ctx context.Context, rtx Runtime, conn *
 
 	// handle the case where we failed
 	if err != nil {
-		rtx.Metrics().Error(httpTransactionStageName)
+		observeOperation(rtx, httpTransactionStageName, conn.Address, conn.Trace.Tags(), t0, err)
 		return nil, &ErrHTTPTransaction{err}
 	}
 
 	// prepare the value to return
-	rtx.Metrics().Success(httpTransactionStageName)
+	observeOperation(rtx, httpTransactionStageName, conn.Address, conn.Trace.Tags(), t0, nil)
 	runtimex.Assert(resp != nil, "expected response to be non-nil here")
 	output := &HTTPResponse{
 		Address:              conn.Address,
@@ -3,6 +3,7 @@ package dsl
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/ooni/probe-engine/pkg/model"
 )
@@ -112,6 +113,14 @@ func HTTPTransactionOptionUserAgent(value string) HTTPTransactionOption {
 	}
 }
 
+// HTTPTransactionOptionTimeout sets the timeout for the whole HTTP transaction. A
+// zero or negative value means that we should use the default timeout.
+func HTTPTransactionOptionTimeout(value time.Duration) HTTPTransactionOption {
+	return func(c *httpTransactionConfig) {
+		c.Timeout = Duration(value)
+	}
+}
+
 // TODO(bassosimone): we should probably autogenerate the config, the functional optional
 // setters, and the conversion from config to list of options.
 
@@ -138,6 +147,9 @@ type httpTransactionConfig struct {
 	// ResponseBodySnapshotSize is the size of the response body snapshot to read.
 	ResponseBodySnapshotSize int `json:"response_body_snapshot_size,omitempty"`
 
+	// Timeout is the timeout for the whole HTTP transaction.
+	Timeout Duration `json:"timeout,omitempty"`
+
 	// URLHost is the host for the URL
 	URLHost string `json:"url_host,omitempty"`
 
@@ -173,6 +185,9 @@ func (c *httpTransactionConfig) options() (options []HTTPTransactionOption) {
 	if value := c.ResponseBodySnapshotSize; value > 0 {
 		options = append(options, HTTPTransactionOptionResponseBodySnapshotSize(value))
 	}
+	if value := c.Timeout; value > 0 {
+		options = append(options, HTTPTransactionOptionTimeout(time.Duration(value)))
+	}
 	if value := c.URLHost; value != "" {
 		options = append(options, HTTPTransactionOptionURLHost(value))
 	}
@@ -35,7 +35,7 @@ func (*httpConnectionQUICLoader) Load(loader *ASTLoader, node *LoadableASTNode)
 		return nil, err
 	}
 	stage := HTTPConnectionQUIC()
-	return &StageRunnableASTNode[*QUICConnection, *HTTPConnection]{stage}, nil
+	return &StageRunnableASTNode[*QUICConnection, *HTTPConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -43,6 +43,11 @@ func (*httpConnectionQUICLoader) StageName() string {
 	return httpConnectionQUICStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*httpConnectionQUICLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (sx *httpConnectionQUICStage) Run(ctx context.Context, rtx Runtime, input Maybe[*QUICConnection]) Maybe[*HTTPConnection] {
 	if input.Error != nil {
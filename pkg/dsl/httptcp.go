@@ -35,7 +35,7 @@ func (*httpConnectionTCPLoader) Load(loader *ASTLoader, node *LoadableASTNode) (
 		return nil, err
 	}
 	stage := HTTPConnectionTCP()
-	return &StageRunnableASTNode[*TCPConnection, *HTTPConnection]{stage}, nil
+	return &StageRunnableASTNode[*TCPConnection, *HTTPConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -43,6 +43,11 @@ func (*httpConnectionTCPLoader) StageName() string {
 	return httpConnectionTCPStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*httpConnectionTCPLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (sx *httpConnectionTCPStage) Run(ctx context.Context, rtx Runtime, input Maybe[*TCPConnection]) Maybe[*HTTPConnection] {
 	if input.Error != nil {
@@ -35,7 +35,7 @@ func (*httpConnectionTLSLoader) Load(loader *ASTLoader, node *LoadableASTNode) (
 		return nil, err
 	}
 	stage := HTTPConnectionTLS()
-	return &StageRunnableASTNode[*TLSConnection, *HTTPConnection]{stage}, nil
+	return &StageRunnableASTNode[*TLSConnection, *HTTPConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -43,6 +43,11 @@ func (*httpConnectionTLSLoader) StageName() string {
 	return httpConnectionTLSStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*httpConnectionTLSLoader) StageArguments() any {
+	return nil
+}
+
 // Run implements Stage.
 func (sx *httpConnectionTLSStage) Run(ctx context.Context, rtx Runtime, input Maybe[*TLSConnection]) Maybe[*HTTPConnection] {
 	if input.Error != nil {
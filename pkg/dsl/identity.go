@@ -2,6 +2,7 @@ package dsl
 
 import (
 	"context"
+	"reflect"
 )
 
 // Identity returns a filter that copies its input to its output. We define as filter a
@@ -29,8 +30,9 @@ func (*identityLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableA
 	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
 		return nil, err
 	}
+	// Note: an Identity[any] is already a RunnableASTNode declaring it's a filter
 	stage := &Identity[any]{}
-	return &StageRunnableASTNode[any, any]{stage}, nil
+	return stage, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -38,6 +40,22 @@ func (*identityLoader) StageName() string {
 	return identityStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*identityLoader) StageArguments() any {
+	return nil
+}
+
+// InputType implements TypedRunnableASTNode.
+func (*Identity[T]) InputType() reflect.Type {
+	return typeOf[T]()
+}
+
+// OutputType implements TypedRunnableASTNode.
+func (*Identity[T]) OutputType() reflect.Type {
+	// Note: a nil output type means that this stage is a filter
+	return nil
+}
+
 // Run implements Stage.
 func (*Identity[T]) Run(ctx context.Context, rtx Runtime, input Maybe[T]) Maybe[T] {
 	return input
@@ -0,0 +1,115 @@
+package dsl
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// StageInterceptor is invoked around the execution of stages and allows to implement custom
+// logging, tracing, fault injection, and policy checks. Both [StageRunnableASTNode], which wraps
+// every stage loaded from an AST, and the stages implementing network operations such as
+// [TCPConnect] invoke the [StageInterceptor] returned by [Runtime.StageInterceptor].
+//
+// An interceptor may be invoked concurrently by several goroutines, so it must be
+// goroutine safe. Note that we compute the node passed to the interceptor by calling the
+// stage ASTNode method, which serializes the whole subtree, the first time we intercept the
+// stage, and we pass the same node to later invocations, so the interceptor must not modify it.
+type StageInterceptor interface {
+	// BeforeStage is called before running the stage described by the given node with the
+	// given input and returns the context to use for running the stage, which allows to pass
+	// information to the interceptor invocations of the inner stages (e.g., the parent span).
+	// Returning a non-nil error prevents running the stage, which returns the error instead
+	// (e.g., an [*ErrException] to reject a stage based on a policy).
+	BeforeStage(ctx context.Context, node *SerializableASTNode, input Maybe[any]) (context.Context, error)
+
+	// AfterStage is called after running the stage described by the given node with the context
+	// returned by BeforeStage, the input and output, and the time it took to run the stage. Returning
+	// a non-nil error replaces the stage output with the error (e.g., to inject faults); return nil
+	// to keep the output.
+	AfterStage(ctx context.Context, node *SerializableASTNode, input, output Maybe[any], elapsed time.Duration) error
+}
+
+// NullStageInterceptor is a [StageInterceptor] that does nothing. The zero value
+// of this struct is ready to use.
+type NullStageInterceptor struct{}
+
+var _ StageInterceptor = &NullStageInterceptor{}
+
+// BeforeStage implements StageInterceptor.
+func (*NullStageInterceptor) BeforeStage(
+	ctx context.Context, node *SerializableASTNode, input Maybe[any]) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterStage implements StageInterceptor.
+func (*NullStageInterceptor) AfterStage(
+	ctx context.Context, node *SerializableASTNode, input, output Maybe[any], elapsed time.Duration) error {
+	return nil
+}
+
+// defaultNullStageInterceptor is the default [*NullStageInterceptor] instance.
+var defaultNullStageInterceptor = &NullStageInterceptor{}
+
+// RuntimeOptionStageInterceptor configures the [StageInterceptor]. By default, we use
+// a [NullStageInterceptor], which does nothing.
+func RuntimeOptionStageInterceptor(value StageInterceptor) RuntimeOption {
+	return func(config *runtimeConfig) {
+		config.interceptor = value
+	}
+}
+
+// interceptedStageKey is the context key for the stage we're intercepting.
+type interceptedStageKey struct{}
+
+// interceptedStageNode lazily computes and caches the node of an intercepted stage. The
+// zero value is ready to use. Embed it into the struct running the stage, such that we do
+// not serialize the stage each time we intercept it.
+type interceptedStageNode struct {
+	node *SerializableASTNode
+	once sync.Once
+}
+
+// get returns the node of the given stage, which we compute the first time we're called.
+func (n *interceptedStageNode) get(stage interface{ ASTNode() *SerializableASTNode }) *SerializableASTNode {
+	n.once.Do(func() {
+		n.node = stage.ASTNode()
+	})
+	return n.node
+}
+
+// isInterceptedStage returns whether the context says we're already intercepting the given
+// stage. Because a stage may have a non-comparable dynamic type (e.g., a struct containing a
+// slice), we only compare stages whose type is comparable, and we otherwise return false.
+func isInterceptedStage(ctx context.Context, stage any) bool {
+	if !reflect.TypeOf(stage).Comparable() {
+		return false
+	}
+	current := ctx.Value(interceptedStageKey{})
+	return current != nil && reflect.TypeOf(current) == reflect.TypeOf(stage) && current == stage
+}
+
+// interceptStage runs the given stage using the [StageInterceptor] of the given runtime and
+// obtains the node to pass to the interceptor using the given cache. We save the stage into the
+// context, such that we only invoke the interceptor once when a stage wraps another stage, i.e.,
+// when [StageRunnableASTNode] wraps a network operation.
+func interceptStage[A, B any](ctx context.Context, rtx Runtime, stage Stage[A, B], cache *interceptedStageNode,
+	input Maybe[A], run func(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[B]) Maybe[B] {
+	interceptor := rtx.StageInterceptor()
+	if _, null := interceptor.(*NullStageInterceptor); null || isInterceptedStage(ctx, stage) {
+		return run(ctx, rtx, input)
+	}
+	ctx = context.WithValue(ctx, interceptedStageKey{}, any(stage))
+	node := cache.get(stage)
+	ctx, err := interceptor.BeforeStage(ctx, node, input.AsGeneric())
+	if err != nil {
+		return NewError[B](err)
+	}
+	t0 := time.Now()
+	output := run(ctx, rtx, input)
+	if err := interceptor.AfterStage(ctx, node, input.AsGeneric(), output.AsGeneric(), time.Since(t0)); err != nil {
+		return NewError[B](err)
+	}
+	return output
+}
@@ -0,0 +1,236 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// interceptorTestRecorder is a [StageInterceptor] recording the stages it sees.
+type interceptorTestRecorder struct {
+	// after optionally overrides AfterStage.
+	after func(node *SerializableASTNode, output Maybe[any]) error
+
+	// before optionally overrides BeforeStage.
+	before func(node *SerializableASTNode) error
+
+	// events contains the recorded events.
+	events []string
+
+	// mu protects events.
+	mu sync.Mutex
+}
+
+// BeforeStage implements StageInterceptor.
+func (sir *interceptorTestRecorder) BeforeStage(
+	ctx context.Context, node *SerializableASTNode, input Maybe[any]) (context.Context, error) {
+	sir.mu.Lock()
+	sir.events = append(sir.events, "before "+node.StageName)
+	sir.mu.Unlock()
+	if sir.before != nil {
+		return ctx, sir.before(node)
+	}
+	return ctx, nil
+}
+
+// AfterStage implements StageInterceptor.
+func (sir *interceptorTestRecorder) AfterStage(
+	ctx context.Context, node *SerializableASTNode, input, output Maybe[any], elapsed time.Duration) error {
+	sir.mu.Lock()
+	sir.events = append(sir.events, "after "+node.StageName)
+	sir.mu.Unlock()
+	if sir.after != nil {
+		return sir.after(node, output)
+	}
+	return nil
+}
+
+// interceptorTestStage is a non-comparable [Stage] counting the ASTNode calls.
+type interceptorTestStage struct {
+	// calls counts the ASTNode calls.
+	calls *int
+
+	// inner is the optional stage to run.
+	inner RunnableASTNode
+
+	// tags makes the stage non-comparable.
+	tags []string
+}
+
+// ASTNode implements Stage.
+func (sx interceptorTestStage) ASTNode() *SerializableASTNode {
+	*sx.calls++
+	return &SerializableASTNode{
+		StageName: "interceptor_test",
+		Arguments: sx.tags,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// Run implements Stage.
+func (sx interceptorTestStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
+	if sx.inner != nil {
+		sx.inner.Run(ctx, rtx, input.AsGeneric())
+	}
+	return input
+}
+
+func TestStageInterceptor(t *testing.T) {
+	pipeline := Compose3(
+		DomainName("www.example.com"),
+		DNSLookupStatic("130.192.91.211"),
+		MakeEndpointsForPort(443),
+	)
+
+	// load serializes and loads the given AST
+	load := func(t *testing.T, root *SerializableASTNode) RunnableASTNode {
+		data, err := json.Marshal(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runnable
+	}
+
+	t.Run("we intercept each loaded stage once", func(t *testing.T) {
+		recorder := &interceptorTestRecorder{}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		output := load(t, pipeline.ASTNode()).Run(context.Background(), rtx, NewValue[any](&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		expected := []string{
+			"before domain_name",
+			"after domain_name",
+			"before dns_lookup_static",
+			"after dns_lookup_static",
+			"before make_endpoints_for_port",
+			"after make_endpoints_for_port",
+		}
+		if diff := cmp.Diff(expected, recorder.events); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we intercept loaded stages with a custom runnable node", func(t *testing.T) {
+		testcases := []struct {
+			name     string
+			stage    Stage[*Void, *Void]
+			expected []string
+		}{{
+			name:  "race_endpoints",
+			stage: Compose(pipeline, RaceEndpoints(Discard[*Endpoint]())),
+			expected: []string{
+				"before race_endpoints",
+				"before discard",
+				"after discard",
+				"after race_endpoints",
+			},
+		}, {
+			name:  "for_each",
+			stage: Compose(pipeline, ForEach(Discard[*Endpoint]())),
+			expected: []string{
+				"before for_each",
+				"before discard",
+				"after discard",
+				"after for_each",
+			},
+		}}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				recorder := &interceptorTestRecorder{}
+				rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+				output := load(t, tc.stage.ASTNode()).Run(context.Background(), rtx, NewValue[any](&Void{}))
+				if output.Error != nil {
+					t.Fatal(output.Error)
+				}
+				// skip the events of the stages generating the endpoints
+				if diff := cmp.Diff(tc.expected, recorder.events[6:]); diff != "" {
+					t.Fatal(diff)
+				}
+			})
+		}
+	})
+
+	t.Run("we intercept network operations when not using the loader", func(t *testing.T) {
+		recorder := &interceptorTestRecorder{}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		output := pipeline.Run(context.Background(), rtx, NewValue(&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		expected := []string{"before dns_lookup_static", "after dns_lookup_static"}
+		if diff := cmp.Diff(expected, recorder.events); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we intercept non-comparable stages and compute their node once", func(t *testing.T) {
+		recorder := &interceptorTestRecorder{}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		var calls int
+		inner := &StageRunnableASTNode[*Void, *Void]{S: interceptorTestStage{&calls, nil, []string{"y"}}}
+		runnable := &StageRunnableASTNode[*Void, *Void]{S: interceptorTestStage{&calls, inner, []string{"x"}}}
+		for idx := 0; idx < 3; idx++ {
+			if output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{})); output.Error != nil {
+				t.Fatal(output.Error)
+			}
+		}
+		if calls != 2 {
+			t.Fatal("expected one ASTNode call per stage, got", calls)
+		}
+		if len(recorder.events) != 12 {
+			t.Fatal("unexpected events", recorder.events)
+		}
+	})
+
+	t.Run("BeforeStage can prevent running a stage", func(t *testing.T) {
+		recorder := &interceptorTestRecorder{
+			before: func(node *SerializableASTNode) error {
+				if node.StageName == dnsLookupStaticStageName {
+					return NewErrException("forbidden by policy")
+				}
+				return nil
+			},
+		}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		output := load(t, pipeline.ASTNode()).Run(context.Background(), rtx, NewValue[any](&Void{}))
+		if !IsErrException(output.Error) {
+			t.Fatal("unexpected error", output.Error)
+		}
+		if _, good := output.Value.([]*Endpoint); !good {
+			t.Fatalf("unexpected value type %T", output.Value)
+		}
+	})
+
+	t.Run("AfterStage can inject faults", func(t *testing.T) {
+		errInjected := errors.New("injected fault")
+		recorder := &interceptorTestRecorder{
+			after: func(node *SerializableASTNode, output Maybe[any]) error {
+				if _, good := output.Value.(*DNSLookupResult); good {
+					return &ErrDNSLookup{errInjected}
+				}
+				return nil
+			},
+		}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		output := pipeline.Run(context.Background(), rtx, NewValue(&Void{}))
+		if !errors.Is(output.Error, errInjected) || !IsErrDNSLookup(output.Error) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package dsl
+
+//
+// JSON schema generation
+//
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONSchemaDialect is the JSON schema dialect of the schemas we generate.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchemaGenerator generates JSON schemas from Go types. The generator collects the
+// definitions of the schemas that we reference using "$ref" (e.g., the definitions of
+// recursive types), and [JSONSchemaGenerator.Document] includes them into "$defs".
+// The zero value is invalid; please, use [NewJSONSchemaGenerator] to construct.
+type JSONSchemaGenerator struct {
+	// defs contains the definitions.
+	defs map[string]any
+
+	// recursive contains the struct types we have referenced recursively.
+	recursive map[reflect.Type]bool
+
+	// visiting contains the struct types we are currently visiting.
+	visiting map[reflect.Type]bool
+}
+
+// NewJSONSchemaGenerator creates a new [*JSONSchemaGenerator].
+func NewJSONSchemaGenerator() *JSONSchemaGenerator {
+	return &JSONSchemaGenerator{
+		defs:      map[string]any{},
+		recursive: map[reflect.Type]bool{},
+		visiting:  map[reflect.Type]bool{},
+	}
+}
+
+// Define adds the given schema to the definitions using the given name and returns
+// the schema referencing the definition.
+func (g *JSONSchemaGenerator) Define(name string, schema map[string]any) map[string]any {
+	g.defs[name] = schema
+	return g.Ref(name)
+}
+
+// Ref returns the schema referencing the definition with the given name.
+func (g *JSONSchemaGenerator) Ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// Document returns a JSON schema document using the given root schema and all
+// the definitions we have collected so far.
+func (g *JSONSchemaGenerator) Document(root map[string]any) map[string]any {
+	document := map[string]any{"$schema": JSONSchemaDialect}
+	for key, value := range root {
+		document[key] = value
+	}
+	if len(g.defs) > 0 {
+		document["$defs"] = g.defs
+	}
+	return document
+}
+
+// ForValue returns the JSON schema describing the JSON serialization of the given
+// value. A nil value means that there are no arguments, which we represent as an empty
+// object or null, because that is what [ASTLoader.LoadEmptyArguments] accepts.
+func (g *JSONSchemaGenerator) ForValue(value any) map[string]any {
+	if value == nil {
+		return map[string]any{
+			"type": []string{"object", "null"},
+		}
+	}
+	schema := g.ForType(reflect.TypeOf(value))
+	if schema["type"] == "object" {
+		// Note: unmarshaling JSON null into a struct is a no-op, so null is valid
+		schema["type"] = []string{"object", "null"}
+	}
+	return schema
+}
+
+// jsonSchemaForValue returns the self-contained JSON schema of the given value.
+func jsonSchemaForValue(value any) map[string]any {
+	g := NewJSONSchemaGenerator()
+	schema := g.ForValue(value)
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+	return schema
+}
+
+var (
+	jsonSchemaDSLDurationType = typeOf[Duration]()
+	jsonSchemaDurationType    = typeOf[time.Duration]()
+	jsonSchemaRawMessageType  = typeOf[json.RawMessage]()
+	jsonSchemaTimeType        = typeOf[time.Time]()
+)
+
+// ForType returns the JSON schema of the given [reflect.Type].
+func (g *JSONSchemaGenerator) ForType(t reflect.Type) map[string]any {
+	switch t {
+	case jsonSchemaDSLDurationType:
+		return map[string]any{
+			"type":        []string{"string", "integer"},
+			"description": "Go duration string (e.g., \"4s\") or integer number of milliseconds",
+		}
+	case jsonSchemaDurationType:
+		return map[string]any{
+			"type":        "integer",
+			"description": "duration in nanoseconds",
+		}
+	case jsonSchemaRawMessageType:
+		return map[string]any{}
+	case jsonSchemaTimeType:
+		return map[string]any{
+			"type":   "string",
+			"format": "date-time",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.ForType(t.Elem())
+
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+
+	case reflect.String:
+		return map[string]any{"type": "string"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := map[string]any{"type": "integer", "minimum": 0}
+		if bits := t.Bits(); bits < 64 {
+			schema["maximum"] = uint64(math.MaxUint64) >> (64 - bits)
+		}
+		return schema
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Note: encoding/json serializes []byte as a base64 string
+			return map[string]any{
+				"type":            []string{"string", "null"},
+				"contentEncoding": "base64",
+			}
+		}
+		return map[string]any{
+			"type":  []string{"array", "null"},
+			"items": g.ForType(t.Elem()),
+		}
+
+	case reflect.Map:
+		return map[string]any{
+			"type":                 []string{"object", "null"},
+			"additionalProperties": g.ForType(t.Elem()),
+		}
+
+	case reflect.Struct:
+		return g.forStruct(t)
+
+	default:
+		// we cannot say anything about this type, so we accept any value
+		return map[string]any{}
+	}
+}
+
+// forStruct returns the JSON schema of a struct type. When a struct type contains itself,
+// we add its schema to the definitions and we use references to break the recursion.
+func (g *JSONSchemaGenerator) forStruct(t reflect.Type) map[string]any {
+	name := jsonSchemaDefinitionName(t)
+	if g.visiting[t] {
+		g.recursive[t] = true
+		return g.Ref(name)
+	}
+	g.visiting[t] = true
+	defer delete(g.visiting, t)
+
+	// Note: we do not mark any field as required because [encoding/json.Unmarshal]
+	// leaves missing fields untouched, so the loader accepts missing fields
+	properties := map[string]any{}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if !field.IsExported() {
+			continue
+		}
+		fieldName, skip := jsonSchemaFieldName(field)
+		if skip {
+			continue
+		}
+		properties[fieldName] = g.ForType(field.Type)
+	}
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if g.recursive[t] {
+		return g.Define(name, schema)
+	}
+	return schema
+}
+
+// jsonSchemaDefinitionName returns the name of the definition of the given type.
+func jsonSchemaDefinitionName(t reflect.Type) string {
+	name := t.PkgPath() + "." + t.Name()
+	return strings.NewReplacer("/", "_", "[", "_", "]", "_", "*", "_").Replace(name)
+}
+
+// jsonSchemaFieldName returns the JSON name of a struct field and whether we should skip the field.
+func jsonSchemaFieldName(field reflect.StructField) (name string, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name, _, _ = strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+// JSONSchema returns the JSON schema document describing the ASTs that this [ASTLoader]
+// can load. Because we generate the schema from the registered [ASTLoaderRule], the schema
+// is always in sync with the stages we support. We describe the arguments of a stage
+// only when its rule implements [ASTLoaderRuleWithArguments].
+func (al *ASTLoader) JSONSchema() map[string]any {
+	g := NewJSONSchemaGenerator()
+	return g.Document(al.DefineJSONSchema(g))
+}
+
+// astNodeJSONSchemaDefinition is the name of the definition of an AST node.
+const astNodeJSONSchemaDefinition = "ast_node"
+
+// DefineJSONSchema is like [ASTLoader.JSONSchema] but adds the AST node definition to the
+// given generator and returns the schema referencing it, which allows to embed the schema
+// of ASTs into other schemas.
+func (al *ASTLoader) DefineJSONSchema(g *JSONSchemaGenerator) map[string]any {
+	names := []string{}
+	for name := range al.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Note: we validate the children once and we use if-then conditions on the stage name
+	// to validate the arguments, because using oneOf would require validators to validate
+	// the children once for each stage, which takes exponential time in the AST depth
+	conditions := []any{}
+	for _, name := range names {
+		rule := al.m[name]
+
+		// a rule loading version N of a stage also loads the previous versions
+		stageNames := []string{name}
+		for version := 1; version <= astLoaderRuleVersion(rule); version++ {
+			stageNames = append(stageNames, name+"@"+strconv.Itoa(version))
+		}
+
+		arguments := map[string]any{}
+		if described, good := rule.(ASTLoaderRuleWithArguments); good {
+			arguments = g.ForValue(described.StageArguments())
+		}
+
+		conditions = append(conditions, map[string]any{
+			"if": map[string]any{
+				"properties": map[string]any{
+					"stage_name": map[string]any{"enum": stageNames},
+				},
+			},
+			"then": map[string]any{
+				"properties": map[string]any{
+					"arguments": arguments,
+				},
+			},
+		})
+	}
+
+	// Note: we accept unknown stage names, which are valid, e.g., when using WithFallbacks or
+	// when a nettest registers custom rules, and we cannot validate their arguments
+	return g.Define(astNodeJSONSchemaDefinition, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"stage_name": map[string]any{"type": "string"},
+			"arguments":  map[string]any{},
+			"children": map[string]any{
+				"type":  []string{"array", "null"},
+				"items": g.Ref(astNodeJSONSchemaDefinition),
+			},
+		},
+		"required": []string{"stage_name"},
+		"allOf":    conditions,
+	})
+}
@@ -0,0 +1,102 @@
+package dsl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// jsonSchemaTestTree is a recursive type.
+type jsonSchemaTestTree struct {
+	Value    []byte                `json:"value"`
+	Children []*jsonSchemaTestTree `json:"children"`
+}
+
+func TestJSONSchemaGenerator(t *testing.T) {
+	t.Run("we use definitions for recursive types", func(t *testing.T) {
+		g := NewJSONSchemaGenerator()
+		schema := g.Document(g.ForValue(&jsonSchemaTestTree{}))
+		name := jsonSchemaDefinitionName(typeOf[jsonSchemaTestTree]())
+		ref := map[string]any{"$ref": "#/$defs/" + name}
+		expected := map[string]any{
+			"$schema": JSONSchemaDialect,
+			"$ref":    "#/$defs/" + name,
+			"$defs": map[string]any{
+				name: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"value": map[string]any{
+							"type":            []string{"string", "null"},
+							"contentEncoding": "base64",
+						},
+						"children": map[string]any{
+							"type":  []string{"array", "null"},
+							"items": ref,
+						},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(expected, schema); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestASTLoaderJSONSchema(t *testing.T) {
+	loader := NewASTLoader()
+	loader.RegisterCustomLoaderRule(&capabilitiesTestLoader{})
+	schema := loader.JSONSchema()
+
+	// Note: we serialize and parse the schema to walk it using generic types
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	node := generic["$defs"].(map[string]any)[astNodeJSONSchemaDefinition].(map[string]any)
+
+	// stageArguments maps each stage name in the conditions to the arguments schema
+	stageArguments := map[string]any{}
+	for _, entry := range node["allOf"].([]any) {
+		condition := entry.(map[string]any)
+		names := condition["if"].(map[string]any)["properties"].(map[string]any)["stage_name"].(map[string]any)["enum"]
+		arguments := condition["then"].(map[string]any)["properties"].(map[string]any)["arguments"]
+		for _, name := range names.([]any) {
+			stageArguments[name.(string)] = arguments
+		}
+	}
+
+	t.Run("we describe all the registered stages and versions", func(t *testing.T) {
+		for name := range loader.m {
+			if _, found := stageArguments[name]; !found {
+				t.Fatal("missing stage", name)
+			}
+		}
+		for _, name := range []string{"capabilities_test@1", "capabilities_test@2"} {
+			if _, found := stageArguments[name]; !found {
+				t.Fatal("missing stage", name)
+			}
+		}
+	})
+
+	t.Run("all the built-in rules describe their arguments", func(t *testing.T) {
+		// Note: this check ensures that new stages keep the schema in sync
+		for name, rule := range NewASTLoader().m {
+			if _, good := rule.(ASTLoaderRuleWithArguments); !good {
+				t.Fatal("rule does not implement ASTLoaderRuleWithArguments", name)
+			}
+		}
+	})
+
+	t.Run("children reference the node definition", func(t *testing.T) {
+		children := node["properties"].(map[string]any)["children"].(map[string]any)
+		if diff := cmp.Diff(generic["$ref"], children["items"].(map[string]any)["$ref"]); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package dsl
+
+//
+// AST resource limits
+//
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ASTLoaderLimits contains the resource limits enforced by the [ASTLoader]. Because the
+// backend serves us ASTs, we must treat them as untrusted input and make sure that a malformed
+// or malicious AST cannot exhaust the resources of low-end devices. For each field, a zero or
+// negative value means that the [ASTLoader] should not enforce such a limit.
+type ASTLoaderLimits struct {
+	// MaxDepth is the maximum number of nodes in a path from the root to a leaf.
+	MaxDepth int
+
+	// MaxNodes is the maximum total number of nodes.
+	MaxNodes int
+
+	// MaxChildren is the maximum number of children of each node.
+	MaxChildren int
+
+	// MaxArgumentsSize is the maximum size in bytes of the arguments of each node. Because
+	// the arguments of a [Bind] node contain all the bindings, for such a node we instead
+	// use this limit as the maximum average size of each binding.
+	MaxArgumentsSize int
+
+	// MaxBindings is the maximum number of bindings of a [Bind] node.
+	MaxBindings int
+
+	// MaxBindNodes is the maximum number of nodes produced by expanding a [Bind] node
+	// (i.e., the number of template nodes times the number of bindings, recursively
+	// expanding nested [Bind] nodes). Note that the other limits apply to the template
+	// and to each instance rather than to the expanded AST, therefore the number of
+	// targets of a [Bind] node is at most the minimum between MaxBindings and MaxBindNodes
+	// divided by the number of template nodes.
+	MaxBindNodes int
+
+	// MaxEndpoints is the maximum number of endpoints that a loaded
+	// stage may produce from the results of a single DNS lookup.
+	MaxEndpoints int
+
+	// MaxParallelism is the maximum parallelism that the arguments of a parallel
+	// stage (e.g., [RunStagesInParallel]) may request.
+	MaxParallelism int
+
+	// MaxResponseBodySnapshotSize is the maximum response body snapshot
+	// size that a loaded HTTP transaction may read.
+	MaxResponseBodySnapshotSize int
+}
+
+// DefaultASTLoaderLimits returns the default [ASTLoaderLimits] used by [NewASTLoader].
+func DefaultASTLoaderLimits() ASTLoaderLimits {
+	return ASTLoaderLimits{
+		MaxDepth:                    64,
+		MaxNodes:                    4096,
+		MaxChildren:                 256,
+		MaxArgumentsSize:            1 << 16,
+		MaxBindings:                 4096,
+		MaxBindNodes:                1 << 16,
+		MaxEndpoints:                128,
+		MaxParallelism:              64,
+		MaxResponseBodySnapshotSize: 1 << 20,
+	}
+}
+
+// ASTLoaderOption is an option for configuring an [ASTLoader].
+type ASTLoaderOption func(limits *ASTLoaderLimits)
+
+// ASTLoaderOptionLimits replaces all the [ASTLoaderLimits] with the given value.
+func ASTLoaderOptionLimits(value ASTLoaderLimits) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		*limits = value
+	}
+}
+
+// ASTLoaderOptionMaxDepth configures the maximum depth of the AST.
+func ASTLoaderOptionMaxDepth(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxDepth = value
+	}
+}
+
+// ASTLoaderOptionMaxNodes configures the maximum number of nodes of the AST.
+func ASTLoaderOptionMaxNodes(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxNodes = value
+	}
+}
+
+// ASTLoaderOptionMaxChildren configures the maximum number of children of each node.
+func ASTLoaderOptionMaxChildren(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxChildren = value
+	}
+}
+
+// ASTLoaderOptionMaxArgumentsSize configures the maximum size of the arguments of each node.
+func ASTLoaderOptionMaxArgumentsSize(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxArgumentsSize = value
+	}
+}
+
+// ASTLoaderOptionMaxBindings configures the maximum number of bindings of a [Bind] node.
+func ASTLoaderOptionMaxBindings(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxBindings = value
+	}
+}
+
+// ASTLoaderOptionMaxBindNodes configures the maximum number of nodes produced by expanding a [Bind] node.
+func ASTLoaderOptionMaxBindNodes(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxBindNodes = value
+	}
+}
+
+// ASTLoaderOptionMaxEndpoints configures the maximum number of endpoints produced
+// by a loaded stage from the results of a single DNS lookup.
+func ASTLoaderOptionMaxEndpoints(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxEndpoints = value
+	}
+}
+
+// ASTLoaderOptionMaxParallelism configures the maximum parallelism
+// that the arguments of a parallel stage may request.
+func ASTLoaderOptionMaxParallelism(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxParallelism = value
+	}
+}
+
+// ASTLoaderOptionMaxResponseBodySnapshotSize configures the maximum response
+// body snapshot size that a loaded HTTP transaction may read.
+func ASTLoaderOptionMaxResponseBodySnapshotSize(value int) ASTLoaderOption {
+	return func(limits *ASTLoaderLimits) {
+		limits.MaxResponseBodySnapshotSize = value
+	}
+}
+
+// ErrASTLimitExceeded indicates that an AST, or a stage loaded from an AST, exceeds
+// one of the [ASTLoaderLimits]. When a loaded stage exceeds a limit at runtime, the stage
+// returns an [*ErrException] wrapping this error.
+type ErrASTLimitExceeded struct {
+	// Limit is the name of the [ASTLoaderLimits] field.
+	Limit string
+
+	// Value is the value exceeding the limit.
+	Value int
+
+	// Max is the value of the limit.
+	Max int
+}
+
+// Error implements error.
+func (err *ErrASTLimitExceeded) Error() string {
+	return fmt.Sprintf("dsl: AST limit exceeded: %s is %d but the limit is %d", err.Limit, err.Value, err.Max)
+}
+
+// ErrNilASTNode indicates that the AST contains a nil (i.e., JSON null) node.
+var ErrNilASTNode = errors.New("dsl: nil AST node")
+
+// exceedsLimit returns whether value exceeds the given limit, where a zero
+// or negative limit means that there is no limit.
+func exceedsLimit(value, limit int) bool {
+	return limit > 0 && value > limit
+}
+
+// checkLimits walks the tree rooted at node and checks whether it exceeds the limits. We walk
+// the tree using an explicit stack and we stop as soon as we exceed a limit, so we never use
+// more resources than the limits allow, even when the tree is huge.
+//
+// Note: because rules call [ASTLoader.Load] recursively, we check each subtree again
+// when loading it. This is fine because the limits bound the amount of work.
+func (al *ASTLoader) checkLimits(root *LoadableASTNode) error {
+	type entry struct {
+		depth int
+		node  *LoadableASTNode
+	}
+	stack := []entry{{1, root}}
+	var count int
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current.node == nil {
+			return ErrNilASTNode
+		}
+		count++
+		if exceedsLimit(count, al.limits.MaxNodes) {
+			return &ErrASTLimitExceeded{"MaxNodes", count, al.limits.MaxNodes}
+		}
+		if exceedsLimit(current.depth, al.limits.MaxDepth) {
+			return &ErrASTLimitExceeded{"MaxDepth", current.depth, al.limits.MaxDepth}
+		}
+		if size := len(current.node.Children); exceedsLimit(size, al.limits.MaxChildren) {
+			return &ErrASTLimitExceeded{"MaxChildren", size, al.limits.MaxChildren}
+		}
+		// Note: the bind rule checks the size of the bindings
+		if size := len(current.node.Arguments); !isBindNode(current.node) &&
+			exceedsLimit(size, al.limits.MaxArgumentsSize) {
+			return &ErrASTLimitExceeded{"MaxArgumentsSize", size, al.limits.MaxArgumentsSize}
+		}
+		for _, child := range current.node.Children {
+			stack = append(stack, entry{current.depth + 1, child})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestASTLoaderLimits(t *testing.T) {
+	// load loads the given JSON AST using the given options
+	load := func(t *testing.T, rawAST string, options ...ASTLoaderOption) (RunnableASTNode, error) {
+		var loadable LoadableASTNode
+		if err := json.Unmarshal([]byte(rawAST), &loadable); err != nil {
+			t.Fatal(err)
+		}
+		return NewASTLoader(options...).Load(&loadable)
+	}
+
+	// requireLimitExceeded requires the error to be an ErrASTLimitExceeded for the given limit
+	requireLimitExceeded := func(t *testing.T, err error, limit string) {
+		var limitErr *ErrASTLimitExceeded
+		if !errors.As(err, &limitErr) {
+			t.Fatal("not an ErrASTLimitExceeded", err)
+		}
+		if limitErr.Limit != limit {
+			t.Fatal("expected", limit, "got", limitErr.Limit)
+		}
+	}
+
+	// nestedIdentities returns an AST containing the given number of nested with_timeout nodes
+	nestedIdentities := func(depth int) string {
+		head := strings.Repeat(`{"stage_name":"with_timeout","arguments":{"timeout":0},"children":[`, depth)
+		tail := strings.Repeat(`]}`, depth)
+		return head + `{"stage_name":"identity","arguments":null,"children":[]}` + tail
+	}
+
+	// parallelIdentities returns an AST running the given number of identities in parallel
+	parallelIdentities := func(count int) string {
+		var children []string
+		for idx := 0; idx < count; idx++ {
+			children = append(children, `{"stage_name":"identity","arguments":null,"children":[]}`)
+		}
+		return `{"stage_name":"run_stages_in_parallel","arguments":null,"children":[` +
+			strings.Join(children, ",") + `]}`
+	}
+
+	t.Run("we enforce the maximum depth", func(t *testing.T) {
+		if _, err := load(t, nestedIdentities(63)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := load(t, nestedIdentities(64))
+		requireLimitExceeded(t, err, "MaxDepth")
+	})
+
+	t.Run("we enforce the maximum number of nodes", func(t *testing.T) {
+		_, err := load(t, parallelIdentities(10), ASTLoaderOptionMaxNodes(10))
+		requireLimitExceeded(t, err, "MaxNodes")
+	})
+
+	t.Run("we enforce the maximum number of children", func(t *testing.T) {
+		_, err := load(t, parallelIdentities(257))
+		requireLimitExceeded(t, err, "MaxChildren")
+	})
+
+	t.Run("we enforce the maximum arguments size", func(t *testing.T) {
+		rawAST := `{"stage_name":"domain_name","arguments":{"domain":"` +
+			strings.Repeat("a", 1<<16) + `"},"children":[]}`
+		_, err := load(t, rawAST)
+		requireLimitExceeded(t, err, "MaxArgumentsSize")
+	})
+
+	t.Run("a zero limit means that we do not enforce the limit", func(t *testing.T) {
+		if _, err := load(t, nestedIdentities(128), ASTLoaderOptionMaxDepth(0)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("we enforce the maximum response body snapshot size", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "http_transaction",
+			"arguments": {"response_body_snapshot_size": 1073741824},
+			"children": []
+		}`)
+		requireLimitExceeded(t, err, "MaxResponseBodySnapshotSize")
+	})
+
+	t.Run("we enforce the maximum parallelism", func(t *testing.T) {
+		for _, stageName := range []string{
+			"run_stages_in_parallel",
+			"dns_lookup_parallel",
+			"measure_multiple_endpoints",
+			"new_endpoint_pipeline",
+			"collect_endpoint_results",
+			"for_each",
+		} {
+			t.Run(stageName, func(t *testing.T) {
+				rawAST := `{"stage_name":"` + stageName + `","arguments":{"parallelism":1000000},"children":[]}`
+				_, err := load(t, rawAST)
+				requireLimitExceeded(t, err, "MaxParallelism")
+			})
+		}
+	})
+
+	t.Run("we accept a parallelism within the limits", func(t *testing.T) {
+		rawAST := `{"stage_name":"run_stages_in_parallel","arguments":{"parallelism":64},"children":[]}`
+		if _, err := load(t, rawAST); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("we reject null nodes", func(t *testing.T) {
+		_, err := load(t, `{"stage_name":"run_stages_in_parallel","arguments":null,"children":[null]}`)
+		if !errors.Is(err, ErrNilASTNode) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we enforce the maximum number of endpoints", func(t *testing.T) {
+		runnable, err := load(t, `{
+			"stage_name": "compose",
+			"arguments": null,
+			"children": [
+				{"stage_name": "dns_lookup_static", "arguments": {"addresses": ["10.0.0.1", "10.0.0.2"]}, "children": []},
+				{"stage_name": "make_endpoints_for_port", "arguments": {"port": 443}, "children": []}
+			]
+		}`, ASTLoaderOptionMaxEndpoints(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtx := NewMinimalRuntime(log.Log)
+		results := runnable.Run(context.Background(), rtx, NewValue[any]("www.example.com"))
+		if !IsErrException(results.Error) {
+			t.Fatal("not an exception", results.Error)
+		}
+		requireLimitExceeded(t, results.Error, "MaxEndpoints")
+	})
+}
+
+func FuzzASTLoaderLoad(f *testing.F) {
+	seeds := []Stage[*Void, *Void]{
+		RunStagesInParallel(
+			Compose3(
+				DomainName("www.example.com"),
+				DNSLookupParallel(DNSLookupGetaddrinfo(), DNSLookupStatic("10.0.0.1")),
+				MeasureMultipleEndpoints(
+					Compose(
+						MakeEndpointsForPort(443),
+						NewEndpointPipeline(
+							Compose4(
+								WithTimeout(TCPConnect(), 0),
+								TLSHandshake(),
+								HTTPConnectionTLS(),
+								Compose(HTTPTransaction(), Discard[*HTTPResponse]()),
+							),
+						),
+					),
+				),
+			),
+		),
+		&Identity[*Void]{},
+	}
+	for _, seed := range seeds {
+		data, err := json.Marshal(seed.ASTNode())
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(`{"stage_name":"compose","arguments":null,"children":[null,null]}`))
+	f.Add([]byte(`{"stage_name":"if_filter_exists","arguments":null,"children":[]}`))
+	f.Add([]byte(`{"stage_name":"run_stages_in_parallel","arguments":{"parallelism":1000000},"children":[]}`))
+	f.Add([]byte(`{"stage_name":"for_each","arguments":{"parallelism":65},"children":[]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(data, &loadable); err != nil {
+			return
+		}
+		// we care about not crashing and not using too many resources
+		runnable, err := NewASTLoader().Load(&loadable)
+		if err != nil {
+			return
+		}
+
+		// make sure the loaded AST does not request too many goroutines
+		maxParallelism := DefaultASTLoaderLimits().MaxParallelism
+		stack := []*SerializableASTNode{runnable.ASTNode()}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if config, good := node.Arguments.(*parallelStageArguments); good && config.Parallelism > maxParallelism {
+				t.Fatal("loaded a parallel stage with parallelism", config.Parallelism)
+			}
+			stack = append(stack, node.Children...)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package dsl
 
 import (
+	"crypto/tls"
 	"io"
 	"net/http"
 	"time"
@@ -33,32 +34,57 @@ func NewMeasurexliteRuntime(
 	metrics Metrics,
 	progress ProgressMeter,
 	zeroTime time.Time,
+	options ...RuntimeOption,
 ) *MeasurexliteRuntime {
+	runtime := NewMinimalRuntime(logger, options...)
+	if len(runtime.sharedMetrics) > 0 {
+		metrics = NewTeeMetrics(metrics, runtime.sharedMetrics...)
+	}
 	return &MeasurexliteRuntime{
 		metrics:  metrics,
 		progress: progress,
-		runtime:  NewMinimalRuntime(logger),
+		runtime:  runtime,
 		zeroTime: zeroTime,
 	}
 }
 
 var _ Runtime = &MeasurexliteRuntime{}
 
+// Budget implements Runtime.
+func (r *MeasurexliteRuntime) Budget() Budget {
+	return r.runtime.Budget()
+}
+
 // Close implements Runtime.
 func (r *MeasurexliteRuntime) Close() error {
 	return r.runtime.Close()
 }
 
+// DestinationPolicy implements Runtime.
+func (r *MeasurexliteRuntime) DestinationPolicy() DestinationPolicy {
+	return r.runtime.DestinationPolicy()
+}
+
 // ProgressMeter implements Runtime.
 func (r *MeasurexliteRuntime) ProgressMeter() ProgressMeter {
 	return r.progress
 }
 
+// MaxParallelism implements Runtime.
+func (r *MeasurexliteRuntime) MaxParallelism() int {
+	return r.runtime.MaxParallelism()
+}
+
 // Metrics implements Runtime.
 func (r *MeasurexliteRuntime) Metrics() Metrics {
 	return r.metrics
 }
 
+// StageInterceptor implements Runtime.
+func (r *MeasurexliteRuntime) StageInterceptor() StageInterceptor {
+	return r.runtime.StageInterceptor()
+}
+
 // SaveObservations implements Runtime.
 func (r *MeasurexliteRuntime) SaveObservations(observations ...*Observations) {
 	r.runtime.SaveObservations(observations...)
@@ -254,10 +280,22 @@ func (t *measurexliteTrace) ExtractObservations() []*Observations {
 		TCPConnect:     t.trace.TCPConnects(),
 		TLSHandshakes:  t.trace.TLSHandshakes(),
 		QUICHandshakes: t.trace.QUICHandshakes(),
+		Annotations:    []*Annotation{},
 	}
 	return []*Observations{observations}
 }
 
+// OnDestinationDenied implements Trace.
+func (t *measurexliteTrace) OnDestinationDenied(network, endpoint string, config *tls.Config, err error) {
+	now := time.Now()
+	switch network {
+	case "udp":
+		t.trace.OnQUICHandshakeDone(now, endpoint, nil, config, err, now)
+	default:
+		t.trace.OnConnectDone(now, network, "", endpoint, err, now)
+	}
+}
+
 // Tags implements Trace.
 func (t *measurexliteTrace) Tags() []string {
 	return t.trace.Tags()
@@ -1,12 +1,21 @@
 package dsl
 
-import "sync"
+import (
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/pkg/measurexlite"
+)
 
 // Metrics counts events occurring in a measurement pipeline.
 type Metrics interface {
 	// Error increments the error counter for the given operation metric.
 	Error(name string)
 
+	// Observe records the outcome and the duration of a network operation. Network
+	// operations call this method after calling either Error or Success.
+	Observe(obs *MetricsObservation)
+
 	// Snapshot returns a snapshot of the metrics.
 	Snapshot() map[string]int64
 
@@ -14,6 +23,44 @@ type Metrics interface {
 	Success(name string)
 }
 
+// MetricsObservation describes the outcome of a network operation.
+type MetricsObservation struct {
+	// Name is the operation name (e.g., "tcp_connect").
+	Name string
+
+	// Endpoint is the endpoint address (e.g., "8.8.8.8:443"). For DNS lookups, it contains
+	// the resolver endpoint, which is empty when using getaddrinfo.
+	Endpoint string
+
+	// Tags contains the tags of the [Trace] used by the operation.
+	Tags []string
+
+	// Elapsed is the time it took to run the operation.
+	Elapsed time.Duration
+
+	// Failure is nil on success and otherwise contains the OONI failure
+	// string (e.g., "generic_timeout_error", "connection_reset").
+	Failure *string
+}
+
+// observeOperation updates the [Metrics] after running the operation with the given name,
+// endpoint and tags, which started at t0 and returned the given error.
+func observeOperation(rtx Runtime, name, endpoint string, tags []string, t0 time.Time, err error) {
+	metrics := rtx.Metrics()
+	if err != nil {
+		metrics.Error(name)
+	} else {
+		metrics.Success(name)
+	}
+	metrics.Observe(&MetricsObservation{
+		Name:     name,
+		Endpoint: endpoint,
+		Tags:     tags,
+		Elapsed:  time.Since(t0),
+		Failure:  measurexlite.NewFailure(err),
+	})
+}
+
 // NullMetrics implements [Metrics] but ignores events. The zero value of
 // this structure is ready to use.
 type NullMetrics struct{}
@@ -23,6 +70,11 @@ func (*NullMetrics) Error(name string) {
 	// nothing
 }
 
+// Observe implements Metrics.
+func (*NullMetrics) Observe(obs *MetricsObservation) {
+	// nothing
+}
+
 // Snapshot implements Metrics.
 func (*NullMetrics) Snapshot() map[string]int64 {
 	return make(map[string]int64)
@@ -36,8 +88,10 @@ func (*NullMetrics) Success(name string) {
 // defaultNullMetrics is the default [*NullMetrics] instance.
 var defaultNullMetrics = &NullMetrics{}
 
-// AccountingMetrics is a [Metrics] instance that accounts the events. The zero value
-// of this struct is not ready to use; construct with [NewAccountingMetrics].
+// AccountingMetrics is a [Metrics] instance that accounts the events. Because it
+// only counts successes and errors, it ignores the observations passed to Observe; use
+// [HistogramMetrics] to also account for durations, failures, endpoints, and tags. The
+// zero value of this struct is not ready to use; construct with [NewAccountingMetrics].
 type AccountingMetrics struct {
 	fail map[string]int64
 	m    sync.Mutex
@@ -60,6 +114,11 @@ func (am *AccountingMetrics) Error(name string) {
 	am.m.Unlock()
 }
 
+// Observe implements Metrics.
+func (am *AccountingMetrics) Observe(obs *MetricsObservation) {
+	// nothing
+}
+
 // Snapshot implements Metrics.
 func (am *AccountingMetrics) Snapshot() map[string]int64 {
 	out := make(map[string]int64)
@@ -80,3 +139,55 @@ func (am *AccountingMetrics) Success(name string) {
 	am.ok[name]++
 	am.m.Unlock()
 }
+
+// TeeMetrics is a [Metrics] forwarding each event to a primary [Metrics] and to zero
+// or more secondary [Metrics]. Snapshot and Series return the values provided by the
+// primary [Metrics]. Construct using [NewTeeMetrics].
+type TeeMetrics struct {
+	primary     Metrics
+	secondaries []Metrics
+}
+
+var _ Metrics = &TeeMetrics{}
+
+// NewTeeMetrics creates a new [*TeeMetrics] instance.
+func NewTeeMetrics(primary Metrics, secondaries ...Metrics) *TeeMetrics {
+	return &TeeMetrics{primary, secondaries}
+}
+
+// Error implements Metrics.
+func (tm *TeeMetrics) Error(name string) {
+	tm.primary.Error(name)
+	for _, m := range tm.secondaries {
+		m.Error(name)
+	}
+}
+
+// Observe implements Metrics.
+func (tm *TeeMetrics) Observe(obs *MetricsObservation) {
+	tm.primary.Observe(obs)
+	for _, m := range tm.secondaries {
+		m.Observe(obs)
+	}
+}
+
+// Series returns the [*MetricsSeries] of the primary [Metrics], if available, or an empty list.
+func (tm *TeeMetrics) Series() []*MetricsSeries {
+	if sm, good := tm.primary.(metricsWithSeries); good {
+		return sm.Series()
+	}
+	return []*MetricsSeries{}
+}
+
+// Snapshot implements Metrics.
+func (tm *TeeMetrics) Snapshot() map[string]int64 {
+	return tm.primary.Snapshot()
+}
+
+// Success implements Metrics.
+func (tm *TeeMetrics) Success(name string) {
+	tm.primary.Success(name)
+	for _, m := range tm.secondaries {
+		m.Success(name)
+	}
+}
@@ -0,0 +1,197 @@
+package dsl
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets contains the default upper bounds of the [LatencyHistogram] buckets.
+var DefaultLatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram is the distribution of the durations of an operation.
+type LatencyHistogram struct {
+	// BoundsMs contains the inclusive upper bounds of the buckets in milliseconds.
+	BoundsMs []float64 `json:"bounds_ms"`
+
+	// Counts contains the number of durations within each bucket. Because the
+	// last element counts the durations larger than the last bound, this slice
+	// contains one more element than BoundsMs.
+	Counts []int64 `json:"counts"`
+
+	// Count is the total number of durations.
+	Count int64 `json:"count"`
+
+	// SumMs is the sum of all the durations in milliseconds.
+	SumMs float64 `json:"sum_ms"`
+
+	// MinMs is the minimum duration in milliseconds.
+	MinMs float64 `json:"min_ms"`
+
+	// MaxMs is the maximum duration in milliseconds.
+	MaxMs float64 `json:"max_ms"`
+}
+
+// newLatencyHistogram creates a new [*LatencyHistogram] using the given bounds.
+func newLatencyHistogram(bounds []time.Duration) *LatencyHistogram {
+	hist := &LatencyHistogram{
+		BoundsMs: []float64{},
+		Counts:   make([]int64, len(bounds)+1),
+	}
+	for _, bound := range bounds {
+		hist.BoundsMs = append(hist.BoundsMs, durationToMs(bound))
+	}
+	return hist
+}
+
+// durationToMs converts a [time.Duration] to milliseconds.
+func durationToMs(value time.Duration) float64 {
+	return float64(value) / float64(time.Millisecond)
+}
+
+// add adds the given duration to the histogram.
+func (hist *LatencyHistogram) add(elapsed time.Duration) {
+	value := durationToMs(elapsed)
+	idx := sort.SearchFloat64s(hist.BoundsMs, value)
+	hist.Counts[idx]++
+	if hist.Count == 0 || value < hist.MinMs {
+		hist.MinMs = value
+	}
+	if hist.Count == 0 || value > hist.MaxMs {
+		hist.MaxMs = value
+	}
+	hist.Count++
+	hist.SumMs += value
+}
+
+// clone returns a deep copy of the histogram.
+func (hist *LatencyHistogram) clone() *LatencyHistogram {
+	out := *hist
+	out.BoundsMs = append([]float64{}, hist.BoundsMs...)
+	out.Counts = append([]int64{}, hist.Counts...)
+	return &out
+}
+
+// MetricsSeries contains the metrics of an operation with a given endpoint and tags.
+type MetricsSeries struct {
+	// Operation is the operation name (e.g., "tcp_connect").
+	Operation string `json:"operation"`
+
+	// Endpoint is the endpoint address (see [MetricsObservation]).
+	Endpoint string `json:"endpoint"`
+
+	// Tags contains the [Trace] tags.
+	Tags []string `json:"tags"`
+
+	// Successes is the number of successful operations.
+	Successes int64 `json:"successes"`
+
+	// Failures maps each OONI failure string to the number of failed operations.
+	Failures map[string]int64 `json:"failures"`
+
+	// Latency is the distribution of the durations of both successful and failed operations.
+	Latency *LatencyHistogram `json:"latency"`
+}
+
+// clone returns a deep copy of the series.
+func (ms *MetricsSeries) clone() *MetricsSeries {
+	out := *ms
+	out.Tags = append([]string{}, ms.Tags...)
+	out.Failures = make(map[string]int64)
+	for key, value := range ms.Failures {
+		out.Failures[key] = value
+	}
+	out.Latency = ms.Latency.clone()
+	return &out
+}
+
+// HistogramMetrics is a [Metrics] instance that, in addition to the counters of
+// [AccountingMetrics], groups the observations by operation, endpoint and tags, and
+// builds a [MetricsSeries] for each group, which includes failures by type and a
+// [LatencyHistogram]. The zero value of this struct is not ready to use; construct
+// with [NewHistogramMetrics].
+type HistogramMetrics struct {
+	*AccountingMetrics
+
+	// bounds contains the sorted histogram bounds.
+	bounds []time.Duration
+
+	// mu protects series.
+	mu sync.Mutex
+
+	// series maps a key derived from operation, endpoint and tags to the corresponding series.
+	series map[string]*MetricsSeries
+}
+
+var _ Metrics = &HistogramMetrics{}
+
+// NewHistogramMetrics creates a new [*HistogramMetrics] instance using the given histogram
+// bucket bounds or, when no bounds are given, the [DefaultLatencyBuckets].
+func NewHistogramMetrics(bounds ...time.Duration) *HistogramMetrics {
+	if len(bounds) <= 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	bounds = append([]time.Duration{}, bounds...)
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+	return &HistogramMetrics{
+		AccountingMetrics: NewAccountingMetrics(),
+		bounds:            bounds,
+		mu:                sync.Mutex{},
+		series:            map[string]*MetricsSeries{},
+	}
+}
+
+// Observe implements Metrics.
+func (hm *HistogramMetrics) Observe(obs *MetricsObservation) {
+	key := strings.Join(append([]string{obs.Name, obs.Endpoint}, obs.Tags...), "\x00")
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	series := hm.series[key]
+	if series == nil {
+		series = &MetricsSeries{
+			Operation: obs.Name,
+			Endpoint:  obs.Endpoint,
+			Tags:      append([]string{}, obs.Tags...),
+			Successes: 0,
+			Failures:  map[string]int64{},
+			Latency:   newLatencyHistogram(hm.bounds),
+		}
+		hm.series[key] = series
+	}
+	if obs.Failure != nil {
+		series.Failures[*obs.Failure]++
+	} else {
+		series.Successes++
+	}
+	series.Latency.add(obs.Elapsed)
+}
+
+// Series returns a copy of each [MetricsSeries] sorted by operation, endpoint and tags.
+func (hm *HistogramMetrics) Series() []*MetricsSeries {
+	hm.mu.Lock()
+	keys := make([]string, 0, len(hm.series))
+	for key := range hm.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*MetricsSeries, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, hm.series[key].clone())
+	}
+	hm.mu.Unlock()
+	return out
+}
@@ -0,0 +1,205 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistogramMetrics(t *testing.T) {
+	t.Run("we group observations and fill the histogram", func(t *testing.T) {
+		metrics := NewHistogramMetrics(100*time.Millisecond, 10*time.Millisecond)
+		timeout := "generic_timeout_error"
+		for _, obs := range []*MetricsObservation{{
+			Name:     "tcp_connect",
+			Endpoint: "8.8.8.8:443",
+			Tags:     []string{"a"},
+			Elapsed:  5 * time.Millisecond,
+		}, {
+			Name:     "tcp_connect",
+			Endpoint: "8.8.8.8:443",
+			Tags:     []string{"a"},
+			Elapsed:  50 * time.Millisecond,
+		}, {
+			Name:     "tcp_connect",
+			Endpoint: "8.8.8.8:443",
+			Tags:     []string{"a"},
+			Elapsed:  10 * time.Second,
+			Failure:  &timeout,
+		}, {
+			Name:     "tcp_connect",
+			Endpoint: "8.8.8.8:443",
+			Tags:     []string{"b"},
+			Elapsed:  10 * time.Millisecond,
+		}} {
+			metrics.Observe(obs)
+		}
+
+		expected := []*MetricsSeries{{
+			Operation: "tcp_connect",
+			Endpoint:  "8.8.8.8:443",
+			Tags:      []string{"a"},
+			Successes: 2,
+			Failures:  map[string]int64{"generic_timeout_error": 1},
+			Latency: &LatencyHistogram{
+				BoundsMs: []float64{10, 100},
+				Counts:   []int64{1, 1, 1},
+				Count:    3,
+				SumMs:    10055,
+				MinMs:    5,
+				MaxMs:    10000,
+			},
+		}, {
+			Operation: "tcp_connect",
+			Endpoint:  "8.8.8.8:443",
+			Tags:      []string{"b"},
+			Successes: 1,
+			Failures:  map[string]int64{},
+			Latency: &LatencyHistogram{
+				BoundsMs: []float64{10, 100},
+				Counts:   []int64{1, 0, 0},
+				Count:    1,
+				SumMs:    10,
+				MinMs:    10,
+				MaxMs:    10,
+			},
+		}}
+		series := metrics.Series()
+		if diff := cmp.Diff(expected, series); diff != "" {
+			t.Fatal(diff)
+		}
+
+		// make sure we return a copy
+		series[0].Latency.Counts[0] = 100
+		if metrics.Series()[0].Latency.Counts[0] != 1 {
+			t.Fatal("expected Series to return a copy")
+		}
+	})
+
+	t.Run("network operations update the metrics and we can save them", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+		pipeline := Compose(
+			RunStagesInParallel(
+				Compose4(
+					DomainName("www.example.com"),
+					DNSLookupStatic("127.0.0.1"),
+					MakeEndpointsForPort(port),
+					NewEndpointPipeline(Compose(TCPConnect(TCPConnectOptionTags("open")), Discard[*TCPConnection]())),
+				),
+				Compose4(
+					DomainName("www.example.com"),
+					DNSLookupStatic("127.0.0.1"),
+					MakeEndpointsForPort(9),
+					NewEndpointPipeline(Compose(TCPConnect(TCPConnectOptionTags("closed")), Discard[*TCPConnection]())),
+				),
+			),
+			SaveMetrics(""),
+		)
+		data, err := json.Marshal(pipeline.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&node)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metrics := NewHistogramMetrics()
+		rtx := NewMeasurexliteRuntime(log.Log, metrics, &NullProgressMeter{}, time.Now())
+		defer rtx.Close()
+		if output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{})); output.Error != nil {
+			t.Fatal(output.Error)
+		}
+
+		expectedCounters := map[string]int64{
+			"tcp_connect_error_count":   1,
+			"tcp_connect_success_count": 1,
+		}
+		if diff := cmp.Diff(expectedCounters, metrics.Snapshot()); diff != "" {
+			t.Fatal(diff)
+		}
+
+		observations := ReduceObservations(rtx.ExtractObservations()...)
+		if len(observations.Annotations) != 1 || observations.Annotations[0].Name != MetricsAnnotation {
+			t.Fatal("expected to find the annotation")
+		}
+		snap := observations.Annotations[0].Value.(*MetricsSnapshot)
+		if diff := cmp.Diff(expectedCounters, snap.Counters); diff != "" {
+			t.Fatal(diff)
+		}
+		if len(snap.Series) != 2 {
+			t.Fatal("expected two series, got", len(snap.Series))
+		}
+		closed, open := snap.Series[0], snap.Series[1]
+		if open.Endpoint == "127.0.0.1:9" {
+			closed, open = open, closed
+		}
+		if closed.Endpoint != "127.0.0.1:9" || closed.Failures["connection_refused"] != 1 || closed.Successes != 0 {
+			t.Fatalf("unexpected series %+v", closed)
+		}
+		if diff := cmp.Diff([]string{"open"}, open.Tags); diff != "" {
+			t.Fatal(diff)
+		}
+		if open.Successes != 1 || len(open.Failures) != 0 || open.Latency.Count != 1 {
+			t.Fatalf("unexpected series %+v", open)
+		}
+	})
+
+	t.Run("SaveMetrics works with other Metrics implementations", func(t *testing.T) {
+		rtx := NewMinimalRuntime(log.Log)
+		SaveMetrics("custom").Run(context.Background(), rtx, NewValue(&Void{}))
+		observations := ReduceObservations(rtx.ExtractObservations()...)
+		if len(observations.Annotations) != 1 || observations.Annotations[0].Name != "custom" {
+			t.Fatal("expected to find the annotation")
+		}
+		snap := observations.Annotations[0].Value.(*MetricsSnapshot)
+		if len(snap.Counters) != 0 || len(snap.Series) != 0 {
+			t.Fatal("expected an empty snapshot")
+		}
+	})
+	t.Run("we update the shared metrics", func(t *testing.T) {
+		shared := NewAccountingMetrics()
+		pipeline := Compose3(
+			DomainName("www.example.com"),
+			DNSLookupStatic("127.0.0.1"),
+			MakeEndpointsForPort(9),
+		)
+		for idx := 0; idx < 2; idx++ {
+			metrics := NewHistogramMetrics()
+			rtx := NewMeasurexliteRuntime(log.Log, metrics, &NullProgressMeter{}, time.Now(),
+				RuntimeOptionSharedMetrics(shared))
+			output := Compose3(
+				pipeline,
+				NewEndpointPipeline(Compose(TCPConnect(), Discard[*TCPConnection]())),
+				SaveMetrics(""),
+			).Run(context.Background(), rtx, NewValue(&Void{}))
+			rtx.Close()
+			if output.Error != nil {
+				t.Fatal(output.Error)
+			}
+			observations := ReduceObservations(rtx.ExtractObservations()...)
+			snap := observations.Annotations[0].Value.(*MetricsSnapshot)
+			if snap.Counters["tcp_connect_error_count"] != 1 || len(snap.Series) != 1 {
+				t.Fatalf("unexpected snapshot %+v", snap)
+			}
+		}
+		if diff := cmp.Diff(map[string]int64{"tcp_connect_error_count": 2}, shared.Snapshot()); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
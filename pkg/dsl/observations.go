@@ -21,6 +21,26 @@ type Observations struct {
 
 	// QUICHandshakes contains the QUIC handshakes results.
 	QUICHandshakes []*model.ArchivalTLSOrQUICHandshakeResult `json:"quic_handshakes"`
+
+	// Annotations contains the annotations saved by stages.
+	Annotations []*Annotation `json:"annotations"`
+}
+
+// Annotation is a named value saved by a stage (e.g., the random seed used by the
+// [SampleEndpoints] stage), which allows to interpret the other observations.
+type Annotation struct {
+	// Name is the annotation name.
+	Name string `json:"name"`
+
+	// Value is the annotation value.
+	Value any `json:"value"`
+}
+
+// SaveAnnotation saves an [Annotation] with the given name and value into the runtime.
+func SaveAnnotation(rtx Runtime, name string, value any) {
+	rtx.SaveObservations(&Observations{
+		Annotations: []*Annotation{{Name: name, Value: value}},
+	})
 }
 
 // NewObservations creates an empty set of [Observations].
@@ -32,6 +52,7 @@ func NewObservations() *Observations {
 		TCPConnect:     []*model.ArchivalTCPConnectResult{},
 		TLSHandshakes:  []*model.ArchivalTLSOrQUICHandshakeResult{},
 		QUICHandshakes: []*model.ArchivalTLSOrQUICHandshakeResult{},
+		Annotations:    []*Annotation{},
 	}
 }
 
@@ -45,6 +66,7 @@ func ReduceObservations(inputs ...*Observations) (output *Observations) {
 		output.Requests = append(output.Requests, input.Requests...)
 		output.TCPConnect = append(output.TCPConnect, input.TCPConnect...)
 		output.TLSHandshakes = append(output.TLSHandshakes, input.TLSHandshakes...)
+		output.Annotations = append(output.Annotations, input.Annotations...)
 	}
 	// TODO: we should also sort by T0 probably? or by transaction?
 	return
@@ -59,5 +81,6 @@ func (obs *Observations) AsMap() map[string]any {
 		"tcp_connect":     obs.TCPConnect,
 		"tls_handshakes":  obs.TLSHandshakes,
 		"quic_handshakes": obs.QUICHandshakes,
+		"annotations":     obs.Annotations,
 	}
 }
@@ -11,11 +11,12 @@ type operation[A, B any] interface {
 
 // wrapOperation adapts an [operation] to behave like a [Stage].
 func wrapOperation[A, B any](op operation[A, B]) Stage[A, B] {
-	return &wrapOperationStage[A, B]{op}
+	return &wrapOperationStage[A, B]{op: op}
 }
 
 type wrapOperationStage[A, B any] struct {
-	op operation[A, B]
+	node interceptedStageNode
+	op   operation[A, B]
 }
 
 // ASTNode implements Stage.
@@ -25,6 +26,11 @@ func (sx *wrapOperationStage[A, B]) ASTNode() *SerializableASTNode {
 
 // Run implements Stage.
 func (sx *wrapOperationStage[A, B]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[B] {
+	return interceptStage[A, B](ctx, rtx, sx, &sx.node, input, sx.run)
+}
+
+// run runs the operation.
+func (sx *wrapOperationStage[A, B]) run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[B] {
 	if input.Error != nil {
 		return NewError[B](input.Error)
 	}
@@ -2,12 +2,14 @@ package dsl
 
 import (
 	"context"
+	"encoding/json"
 	"sync"
 )
 
 // ParallelRun runs the given functions using the given number of workers and returns
 // a slice containing the result produced by each function. When the number of workers
-// is zero or negative, this function will use a single worker.
+// is zero or negative, this function will use a single worker. We never start more
+// goroutines than the number of functions to run.
 func ParallelRun[T any](ctx context.Context, parallelism int, workers ...Worker[T]) []T {
 	// create channel for distributing workers
 	inputs := make(chan Worker[T])
@@ -27,6 +29,9 @@ func ParallelRun[T any](ctx context.Context, parallelism int, workers ...Worker[
 	if parallelism < 1 {
 		parallelism = 1
 	}
+	if parallelism > len(workers) {
+		parallelism = len(workers)
+	}
 	waiter := &sync.WaitGroup{}
 	for idx := 0; idx < parallelism; idx++ {
 		waiter.Add(1)
@@ -52,14 +57,64 @@ func ParallelRun[T any](ctx context.Context, parallelism int, workers ...Worker[
 	return results
 }
 
+// defaultParallelism is the parallelism used by parallel stages when the
+// AST does not explicitly configure the parallelism.
+const defaultParallelism = 2
+
+// parallelStageArguments contains the arguments of parallel stages.
+type parallelStageArguments struct {
+	Parallelism int `json:"parallelism,omitempty"`
+}
+
+// newParallelStageArguments returns the arguments to serialize for a parallel stage. We
+// return nil when using the default parallelism, so the AST is what old probes expect.
+func newParallelStageArguments(parallelism int) any {
+	if parallelism <= 0 {
+		return nil
+	}
+	return &parallelStageArguments{parallelism}
+}
+
+// loadParallelStageArguments loads the arguments of a parallel stage and returns
+// an [*ErrASTLimitExceeded] if the parallelism exceeds the loader limits.
+func loadParallelStageArguments(loader *ASTLoader, node *LoadableASTNode) (*parallelStageArguments, error) {
+	var config parallelStageArguments
+	if err := json.Unmarshal(node.Arguments, &config); err != nil {
+		return nil, err
+	}
+	if limit := loader.Limits().MaxParallelism; exceedsLimit(config.Parallelism, limit) {
+		return nil, &ErrASTLimitExceeded{"MaxParallelism", config.Parallelism, limit}
+	}
+	return &config, nil
+}
+
+// effectiveParallelism returns the parallelism that a parallel stage should use given
+// the configured value, the stage default value and the runtime maximum parallelism.
+func effectiveParallelism(rtx Runtime, value, defaultValue int) int {
+	if value <= 0 {
+		value = defaultValue
+	}
+	if limit := rtx.MaxParallelism(); value > limit {
+		value = limit
+	}
+	return value
+}
+
 // RunStagesInParallel returns a stage that runs the given stages in parallel using
 // a pool of background goroutines.
 func RunStagesInParallel(stages ...Stage[*Void, *Void]) Stage[*Void, *Void] {
-	return &runStagesInParallelStage{stages}
+	return RunStagesInParallelWithParallelism(0, stages...)
+}
+
+// RunStagesInParallelWithParallelism is like [RunStagesInParallel] but allows to configure
+// the number of background goroutines. A zero or negative value means using the default.
+func RunStagesInParallelWithParallelism(parallelism int, stages ...Stage[*Void, *Void]) Stage[*Void, *Void] {
+	return &runStagesInParallelStage{parallelism, stages}
 }
 
 type runStagesInParallelStage struct {
-	stages []Stage[*Void, *Void]
+	parallelism int
+	stages      []Stage[*Void, *Void]
 }
 
 const runStagesInParallelStageName = "run_stages_in_parallel"
@@ -72,7 +127,7 @@ func (sx *runStagesInParallelStage) ASTNode() *SerializableASTNode {
 	}
 	return &SerializableASTNode{
 		StageName: runStagesInParallelStageName,
-		Arguments: nil,
+		Arguments: newParallelStageArguments(sx.parallelism),
 		Children:  nodes,
 	}
 }
@@ -81,16 +136,20 @@ type runStagesInParallelLoader struct{}
 
 // Load implements ASTLoaderRule.
 func (*runStagesInParallelLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
-	if err := loader.LoadEmptyArguments(node); err != nil {
+	config, err := loadParallelStageArguments(loader, node)
+	if err != nil {
 		return nil, err
 	}
 	runnables, err := loader.LoadChildren(node)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckChildrenTypes[*Void, *Void](node, runnables...); err != nil {
+		return nil, err
+	}
 	children := RunnableASTNodeListToStageList[*Void, *Void](runnables...)
-	stage := RunStagesInParallel(children...)
-	return &StageRunnableASTNode[*Void, *Void]{stage}, nil
+	stage := RunStagesInParallelWithParallelism(config.Parallelism, children...)
+	return &StageRunnableASTNode[*Void, *Void]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -98,6 +157,11 @@ func (*runStagesInParallelLoader) StageName() string {
 	return runStagesInParallelStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*runStagesInParallelLoader) StageArguments() any {
+	return &parallelStageArguments{}
+}
+
 // Run implements Stage.
 func (sx *runStagesInParallelStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
 	if input.Error != nil {
@@ -111,7 +175,7 @@ func (sx *runStagesInParallelStage) Run(ctx context.Context, rtx Runtime, input
 	}
 
 	// parallel run
-	const parallelism = 2
+	parallelism := effectiveParallelism(rtx, sx.parallelism, defaultParallelism)
 	results := ParallelRun(ctx, parallelism, workers...)
 
 	// route exceptions
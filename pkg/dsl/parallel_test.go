@@ -0,0 +1,241 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// parallelTestCountingStage is a stage that records the maximum number
+// of concurrent invocations of its Run method.
+type parallelTestCountingStage struct {
+	current int
+	maximum int
+	mu      sync.Mutex
+}
+
+// ASTNode implements Stage.
+func (sx *parallelTestCountingStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{StageName: "counting"}
+}
+
+// Run implements Stage.
+func (sx *parallelTestCountingStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
+	sx.mu.Lock()
+	sx.current++
+	if sx.current > sx.maximum {
+		sx.maximum = sx.current
+	}
+	sx.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	sx.mu.Lock()
+	sx.current--
+	sx.mu.Unlock()
+	return input
+}
+
+func TestRunStagesInParallel(t *testing.T) {
+	// runWithParallelism runs several counting stages and returns the maximum concurrency
+	runWithParallelism := func(parallelism int, options ...RuntimeOption) int {
+		counter := &parallelTestCountingStage{}
+		var stages []Stage[*Void, *Void]
+		for idx := 0; idx < 8; idx++ {
+			stages = append(stages, counter)
+		}
+		pipeline := RunStagesInParallelWithParallelism(parallelism, stages...)
+		rtx := NewMinimalRuntime(log.Log, options...)
+		_ = pipeline.Run(context.Background(), rtx, NewValue(&Void{}))
+		return counter.maximum
+	}
+
+	t.Run("we use the default parallelism when the parallelism is not set", func(t *testing.T) {
+		if got := runWithParallelism(0); got != defaultParallelism {
+			t.Fatal("expected", defaultParallelism, "got", got)
+		}
+	})
+
+	t.Run("we use the configured parallelism", func(t *testing.T) {
+		if got := runWithParallelism(4); got != 4 {
+			t.Fatal("expected 4, got", got)
+		}
+	})
+
+	t.Run("the runtime caps the configured parallelism", func(t *testing.T) {
+		if got := runWithParallelism(4, RuntimeOptionMaxParallelism(1)); got != 1 {
+			t.Fatal("expected 1, got", got)
+		}
+	})
+}
+
+// parallelTestGoroutinesInterceptor is a [StageInterceptor] recording the
+// maximum number of goroutines observed when starting each stage.
+type parallelTestGoroutinesInterceptor struct {
+	maximum int
+	mu      sync.Mutex
+}
+
+// BeforeStage implements StageInterceptor.
+func (pti *parallelTestGoroutinesInterceptor) BeforeStage(
+	ctx context.Context, node *SerializableASTNode, input Maybe[any]) (context.Context, error) {
+	time.Sleep(10 * time.Millisecond) // give the other goroutines time to start
+	pti.mu.Lock()
+	if count := runtime.NumGoroutine(); count > pti.maximum {
+		pti.maximum = count
+	}
+	pti.mu.Unlock()
+	return ctx, nil
+}
+
+// AfterStage implements StageInterceptor.
+func (pti *parallelTestGoroutinesInterceptor) AfterStage(
+	ctx context.Context, node *SerializableASTNode, input, output Maybe[any], elapsed time.Duration) error {
+	return nil
+}
+
+func TestParallelismIsBounded(t *testing.T) {
+	// loadHugeParallelism loads an AST requesting a huge parallelism for running three stages
+	loadHugeParallelism := func(t *testing.T) RunnableASTNode {
+		var stages []Stage[*Void, *Void]
+		for idx := 0; idx < 3; idx++ {
+			stages = append(stages, Compose3(
+				DomainName("www.example.com"),
+				DNSLookupStatic("127.0.0.1"),
+				Discard[*DNSLookupResult](),
+			))
+		}
+		data, err := json.Marshal(RunStagesInParallelWithParallelism(1<<20, stages...).ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		// disable the loader limit to make sure the runtime bounds the parallelism
+		runnable, err := NewASTLoader(ASTLoaderOptionMaxParallelism(0)).Load(&node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runnable
+	}
+
+	// countGoroutines runs the AST and returns the maximum number of additional goroutines
+	countGoroutines := func(t *testing.T, options ...RuntimeOption) int {
+		runnable := loadHugeParallelism(t)
+		interceptor := &parallelTestGoroutinesInterceptor{}
+		options = append(options, RuntimeOptionStageInterceptor(interceptor))
+		rtx := NewMinimalRuntime(log.Log, options...)
+		baseline := runtime.NumGoroutine()
+		if output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{})); output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		return interceptor.maximum - baseline
+	}
+
+	// we expect three workers plus the goroutines distributing inputs and collecting outputs
+	const maxGoroutines = 5
+
+	t.Run("the runtime has a default maximum parallelism", func(t *testing.T) {
+		if got := NewMinimalRuntime(log.Log).MaxParallelism(); got != DefaultMaxParallelism {
+			t.Fatal("expected", DefaultMaxParallelism, "got", got)
+		}
+		if got := NewMinimalRuntime(log.Log, RuntimeOptionMaxParallelism(0)).MaxParallelism(); got != DefaultMaxParallelism {
+			t.Fatal("expected", DefaultMaxParallelism, "got", got)
+		}
+	})
+
+	t.Run("we do not start a goroutine for each unit of requested parallelism", func(t *testing.T) {
+		if got := countGoroutines(t); got > maxGoroutines {
+			t.Fatal("expected at most", maxGoroutines, "goroutines, got", got)
+		}
+	})
+
+	t.Run("we do not start more goroutines than stages even with a huge runtime limit", func(t *testing.T) {
+		if got := countGoroutines(t, RuntimeOptionMaxParallelism(1<<30)); got > maxGoroutines {
+			t.Fatal("expected at most", maxGoroutines, "goroutines, got", got)
+		}
+	})
+}
+
+func TestParallelStagesSerialization(t *testing.T) {
+	// roundTrip serializes, loads, and serializes again the given stage
+	roundTrip := func(t *testing.T, stage Stage[*Void, *Void]) (string, string) {
+		expected, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(expected, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&loadable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(runnable.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(expected), string(got)
+	}
+
+	t.Run("the default parallelism serializes to null arguments", func(t *testing.T) {
+		expected, got := roundTrip(t, RunStagesInParallel(
+			Compose(
+				DomainName("www.example.com"),
+				Compose(
+					DNSLookupParallel(DNSLookupGetaddrinfo()),
+					MeasureMultipleEndpoints(
+						Compose(
+							MakeEndpointsForPort(443),
+							NewEndpointPipeline(Compose(TCPConnect(), Discard[*TCPConnection]())),
+						),
+					),
+				),
+			),
+		))
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Fatal(diff)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal([]byte(got), &node); err != nil {
+			t.Fatal(err)
+		}
+		if string(node.Arguments) != "null" {
+			t.Fatal("expected null arguments, got", string(node.Arguments))
+		}
+	})
+
+	t.Run("an explicit parallelism survives the round trip", func(t *testing.T) {
+		expected, got := roundTrip(t, RunStagesInParallelWithParallelism(
+			1,
+			Compose(
+				DomainName("www.example.com"),
+				Compose(
+					DNSLookupParallelWithParallelism(3, DNSLookupGetaddrinfo()),
+					MeasureMultipleEndpointsWithParallelism(
+						4,
+						Compose(
+							MakeEndpointsForPort(443),
+							NewEndpointPipelineWithParallelism(
+								8,
+								Compose(TCPConnect(), Discard[*TCPConnection]()),
+							),
+						),
+					),
+				),
+			),
+		))
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package dsl
+
+//
+// Destination policy
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ooni/probe-engine/pkg/netxlite"
+	"github.com/tailscale/hujson"
+)
+
+// DestinationPolicy decides which destinations the DSL may resolve and connect to. Because
+// the backend serves us ASTs, a [Runtime] uses this policy to make sure that an AST cannot
+// steer the probe into scanning the user's LAN or router. The [TCPConnect], [QUICHandshake],
+// [DNSLookupGetaddrinfo], [DNSLookupUDP], and [NewEndpoint] stages check the policy before
+// performing any network operation.
+//
+// When the policy denies a destination that the AST itself contains (i.e., the domain to
+// resolve, the DNS resolver endpoint, or the endpoint created by [NewEndpoint]), the stage
+// fails with an [*ErrException] wrapping an [*ErrDestinationDenied] error. Instead, when
+// the policy denies an endpoint that [TCPConnect] or [QUICHandshake] receive in input, which
+// is typically a resolved address, the operation fails like any other connect or handshake
+// failure (i.e., with an [*ErrTCPConnect] or an [*ErrQUICHandshake] error wrapping the
+// [*ErrDestinationDenied] error) using the [FailureDestinationDenied] failure string, and
+// we record the failure among the observations. We do this because censors commonly inject
+// private addresses (e.g., 10.10.34.35) into DNS responses and an exception would abort
+// the whole measurement rather than measuring the DNS tampering.
+type DestinationPolicy interface {
+	// CheckDomain returns an error if we should not resolve the given domain.
+	CheckDomain(domain string) error
+
+	// CheckEndpoint returns an error if we should not connect to the given
+	// endpoint, which consists of an IP address and a port.
+	CheckEndpoint(endpoint string) error
+}
+
+// ErrDestinationDenied indicates that the [DestinationPolicy] denies a destination.
+type ErrDestinationDenied struct {
+	// Destination is the denied domain or endpoint.
+	Destination string
+
+	// Reason explains why the policy denies the destination.
+	Reason string
+}
+
+// Error implements error.
+func (err *ErrDestinationDenied) Error() string {
+	return fmt.Sprintf("dsl: destination denied by policy: %s: %s", err.Destination, err.Reason)
+}
+
+// checkDestinationEndpoint checks whether the endpoint is valid and the policy
+// allows it and returns the [*ErrException] to return otherwise.
+func checkDestinationEndpoint(rtx Runtime, endpoint string) *ErrException {
+	if !ValidEndpoints(endpoint) {
+		return &ErrException{&ErrInvalidEndpoint{endpoint}}
+	}
+	if err := rtx.DestinationPolicy().CheckEndpoint(endpoint); err != nil {
+		return &ErrException{err}
+	}
+	return nil
+}
+
+// FailureDestinationDenied is the OONI failure string of the [TCPConnect] and [QUICHandshake]
+// operations that we did not perform because the [DestinationPolicy] denies the endpoint.
+const FailureDestinationDenied = "destination_denied_by_policy"
+
+// checkConnectEndpoint is like [checkDestinationEndpoint] but, when the policy denies the
+// endpoint, returns a nil exception and the error to use as the operation failure, which wraps
+// the [*ErrDestinationDenied] error using the given netxlite operation (e.g., "connect").
+func checkConnectEndpoint(rtx Runtime, endpoint, operation string) (*ErrException, error) {
+	if !ValidEndpoints(endpoint) {
+		return &ErrException{&ErrInvalidEndpoint{endpoint}}, nil
+	}
+	if err := rtx.DestinationPolicy().CheckEndpoint(endpoint); err != nil {
+		denied := &netxlite.ErrWrapper{
+			Failure:    FailureDestinationDenied,
+			Operation:  operation,
+			WrappedErr: err,
+		}
+		return nil, denied
+	}
+	return nil, nil
+}
+
+// checkDestinationDomain checks whether the policy allows resolving the
+// domain and returns the [*ErrException] to return otherwise.
+func checkDestinationDomain(rtx Runtime, domain string) *ErrException {
+	if err := rtx.DestinationPolicy().CheckDomain(domain); err != nil {
+		return &ErrException{err}
+	}
+	return nil
+}
+
+// NullDestinationPolicy is a [DestinationPolicy] allowing all destinations. The
+// zero value of this struct is ready to use.
+type NullDestinationPolicy struct{}
+
+var _ DestinationPolicy = &NullDestinationPolicy{}
+
+// CheckDomain implements DestinationPolicy.
+func (*NullDestinationPolicy) CheckDomain(domain string) error {
+	return nil
+}
+
+// CheckEndpoint implements DestinationPolicy.
+func (*NullDestinationPolicy) CheckEndpoint(endpoint string) error {
+	return nil
+}
+
+// defaultNullDestinationPolicy is the default [*NullDestinationPolicy] instance.
+var defaultNullDestinationPolicy = &NullDestinationPolicy{}
+
+// DestinationPolicyConfig is the serializable configuration of a [*DenyListDestinationPolicy].
+type DestinationPolicyConfig struct {
+	// DenyPrivate denies private addresses (e.g., 10.0.0.0/8, 192.168.0.0/16, fc00::/7).
+	DenyPrivate bool `json:"deny_private"`
+
+	// DenyLoopback denies loopback addresses (e.g., 127.0.0.0/8, ::1) and the unspecified
+	// addresses (i.e., 0.0.0.0 and ::), which typically also reach the local host.
+	DenyLoopback bool `json:"deny_loopback"`
+
+	// DenyLinkLocal denies link-local addresses (e.g., 169.254.0.0/16, fe80::/10).
+	DenyLinkLocal bool `json:"deny_link_local"`
+
+	// DenyMulticast denies multicast addresses (e.g., 224.0.0.0/4, ff00::/8).
+	DenyMulticast bool `json:"deny_multicast"`
+
+	// DenyCIDRs contains additional networks to deny (e.g., 100.64.0.0/10).
+	DenyCIDRs []string `json:"deny_cidrs"`
+
+	// DenyDomains contains domains to deny. Each entry also denies its subdomains.
+	DenyDomains []string `json:"deny_domains"`
+}
+
+// DefaultDestinationPolicyConfig returns the default [DestinationPolicyConfig], which
+// denies private, loopback, link-local, and multicast addresses as well as localhost.
+func DefaultDestinationPolicyConfig() *DestinationPolicyConfig {
+	return &DestinationPolicyConfig{
+		DenyPrivate:   true,
+		DenyLoopback:  true,
+		DenyLinkLocal: true,
+		DenyMulticast: true,
+		DenyCIDRs:     []string{},
+		DenyDomains:   []string{"localhost"},
+	}
+}
+
+// DenyListDestinationPolicy is a [DestinationPolicy] denying the destinations configured
+// using a [DestinationPolicyConfig]. The zero value of this struct is not ready to use;
+// construct using [NewDenyListDestinationPolicy] or [LoadDestinationPolicyFile].
+type DenyListDestinationPolicy struct {
+	config   *DestinationPolicyConfig
+	networks []*net.IPNet
+}
+
+var _ DestinationPolicy = &DenyListDestinationPolicy{}
+
+// NewDenyListDestinationPolicy creates a new [*DenyListDestinationPolicy] from the given
+// config. This function returns an error if the config contains an invalid CIDR.
+func NewDenyListDestinationPolicy(config *DestinationPolicyConfig) (*DenyListDestinationPolicy, error) {
+	policy := &DenyListDestinationPolicy{
+		config:   config,
+		networks: []*net.IPNet{},
+	}
+	for _, cidr := range config.DenyCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		policy.networks = append(policy.networks, network)
+	}
+	return policy, nil
+}
+
+// LoadDestinationPolicyFile loads a [*DenyListDestinationPolicy] from a file containing
+// a JSON serialized [DestinationPolicyConfig]. The file may contain comments.
+func LoadDestinationPolicyFile(filepath string) (*DenyListDestinationPolicy, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	data, err = hujson.Standardize(data) // remove comments
+	if err != nil {
+		return nil, err
+	}
+	var config DestinationPolicyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return NewDenyListDestinationPolicy(&config)
+}
+
+// normalizeDomain returns the lowercase domain without the trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// CheckDomain implements DestinationPolicy.
+func (p *DenyListDestinationPolicy) CheckDomain(domain string) error {
+	domain = normalizeDomain(domain)
+	for _, entry := range p.config.DenyDomains {
+		entry = normalizeDomain(entry)
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return &ErrDestinationDenied{domain, "denied domain"}
+		}
+	}
+	return nil
+}
+
+// CheckEndpoint implements DestinationPolicy.
+func (p *DenyListDestinationPolicy) CheckEndpoint(endpoint string) error {
+	address, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return &ErrDestinationDenied{endpoint, err.Error()}
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return &ErrDestinationDenied{endpoint, "not an IP address"}
+	}
+	switch {
+	case p.config.DenyPrivate && ip.IsPrivate():
+		return &ErrDestinationDenied{endpoint, "private address"}
+	case p.config.DenyLoopback && (ip.IsLoopback() || ip.IsUnspecified()):
+		return &ErrDestinationDenied{endpoint, "loopback address"}
+	case p.config.DenyLinkLocal && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()):
+		return &ErrDestinationDenied{endpoint, "link-local address"}
+	case p.config.DenyMulticast && ip.IsMulticast():
+		return &ErrDestinationDenied{endpoint, "multicast address"}
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return &ErrDestinationDenied{endpoint, "denied network " + network.String()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package dsl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDenyListDestinationPolicy(t *testing.T) {
+	policy, err := NewDenyListDestinationPolicy(&DestinationPolicyConfig{
+		DenyPrivate:   true,
+		DenyLoopback:  true,
+		DenyLinkLocal: true,
+		DenyMulticast: true,
+		DenyCIDRs:     []string{"100.64.0.0/10"},
+		DenyDomains:   []string{"localhost", "Router.LAN."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("CheckEndpoint", func(t *testing.T) {
+		expectations := map[string]bool{
+			"8.8.8.8:53":           true,
+			"[2001:4860::8888]:53": true,
+			"10.0.0.1:80":          false,
+			"192.168.1.1:80":       false,
+			"[fd00::1]:80":         false,
+			"127.0.0.1:80":         false,
+			"[::1]:80":             false,
+			"0.0.0.0:80":           false,
+			"169.254.169.254:80":   false,
+			"[fe80::1]:80":         false,
+			"224.0.0.251:5353":     false,
+			"100.64.0.1:80":        false,
+			"www.example.com:80":   false,
+		}
+		for endpoint, allowed := range expectations {
+			err := policy.CheckEndpoint(endpoint)
+			if allowed != (err == nil) {
+				t.Fatal("unexpected result for", endpoint, err)
+			}
+		}
+	})
+
+	t.Run("CheckDomain", func(t *testing.T) {
+		expectations := map[string]bool{
+			"www.example.com":       true,
+			"localhost":             false,
+			"LOCALHOST.":            false,
+			"router.lan":            false,
+			"admin.router.lan":      false,
+			"notrouter.lan":         true,
+			"localhost.example.com": true,
+		}
+		for domain, allowed := range expectations {
+			err := policy.CheckDomain(domain)
+			if allowed != (err == nil) {
+				t.Fatal("unexpected result for", domain, err)
+			}
+		}
+	})
+
+	t.Run("we reject invalid CIDRs", func(t *testing.T) {
+		config := DefaultDestinationPolicyConfig()
+		config.DenyCIDRs = []string{"10.0.0.0/99"}
+		if _, err := NewDenyListDestinationPolicy(config); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestLoadDestinationPolicyFile(t *testing.T) {
+	filepath := filepath.Join(t.TempDir(), "policy.jsonc")
+	data := []byte(`{
+		// we only deny a specific network
+		"deny_cidrs": ["192.0.2.0/24"]
+	}`)
+	if err := os.WriteFile(filepath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadDestinationPolicyFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.CheckEndpoint("192.0.2.1:443"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := policy.CheckEndpoint("127.0.0.1:443"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDestinationPolicyStages(t *testing.T) {
+	policy, err := NewDenyListDestinationPolicy(DefaultDestinationPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewAccountingMetrics()
+	rtx := NewMinimalRuntime(log.Log, RuntimeOptionDestinationPolicy(policy), RuntimeOptionSharedMetrics(metrics))
+	defer rtx.Close()
+
+	// requireDenied requires the error to be an exception wrapping ErrDestinationDenied
+	requireDenied := func(t *testing.T, err error) {
+		var denied *ErrDestinationDenied
+		if !IsErrException(err) || !errors.As(err, &denied) {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	// requireOperationDenied requires the error to be an operation failure, rather
+	// than an exception, wrapping ErrDestinationDenied
+	requireOperationDenied := func(t *testing.T, err error) {
+		var denied *ErrDestinationDenied
+		if IsErrException(err) || !errors.As(err, &denied) {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	t.Run("TCPConnect", func(t *testing.T) {
+		endpoint := &Endpoint{Address: "192.168.1.1:80", Domain: "router.lan"}
+		results := TCPConnect().Run(context.Background(), rtx, NewValue(endpoint))
+		requireOperationDenied(t, results.Error)
+		var connectErr *ErrTCPConnect
+		if !errors.As(results.Error, &connectErr) {
+			t.Fatal("unexpected error", results.Error)
+		}
+	})
+
+	t.Run("QUICHandshake", func(t *testing.T) {
+		endpoint := &Endpoint{Address: "127.0.0.1:443", Domain: "www.example.com"}
+		results := QUICHandshake().Run(context.Background(), rtx, NewValue(endpoint))
+		requireOperationDenied(t, results.Error)
+		var handshakeErr *ErrQUICHandshake
+		if !errors.As(results.Error, &handshakeErr) {
+			t.Fatal("unexpected error", results.Error)
+		}
+	})
+
+	t.Run("DNSLookupUDP", func(t *testing.T) {
+		results := DNSLookupUDP("192.168.1.1:53").Run(context.Background(), rtx, NewValue("www.example.com"))
+		requireDenied(t, results.Error)
+	})
+
+	t.Run("DNSLookupGetaddrinfo", func(t *testing.T) {
+		results := DNSLookupGetaddrinfo().Run(context.Background(), rtx, NewValue("localhost"))
+		requireDenied(t, results.Error)
+	})
+
+	t.Run("NewEndpoint", func(t *testing.T) {
+		results := NewEndpoint("[fe80::1]:80").Run(context.Background(), rtx, NewValue(&Void{}))
+		requireDenied(t, results.Error)
+	})
+
+	t.Run("denials reach the error counters", func(t *testing.T) {
+		expect := map[string]int64{
+			"dns_lookup_getaddrinfo_error_count": 1,
+			"dns_lookup_udp_error_count":         1,
+			"quic_handshake_error_count":         1,
+			"tcp_connect_error_count":            1,
+		}
+		if diff := cmp.Diff(expect, metrics.Snapshot()); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestDestinationPolicyResolvedAddresses(t *testing.T) {
+	policy, err := NewDenyListDestinationPolicy(DefaultDestinationPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtx := NewMeasurexliteRuntime(
+		log.Log,
+		&NullMetrics{},
+		&NullProgressMeter{},
+		time.Now(),
+		RuntimeOptionDestinationPolicy(policy),
+	)
+	defer rtx.Close()
+
+	// a censor injecting a private address must not abort the pipeline
+	pipeline := Compose4(
+		DomainName("www.example.com"),
+		DNSLookupStatic("10.0.0.1"),
+		MakeEndpointsForPort(80),
+		NewEndpointPipeline(Compose(TCPConnect(), Discard[*TCPConnection]())),
+	)
+	results := pipeline.Run(context.Background(), rtx, NewValue(&Void{}))
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+
+	// we should have recorded the denied connect as a failure
+	observations := ReduceObservations(rtx.ExtractObservations()...)
+	if len(observations.TCPConnect) != 1 {
+		t.Fatal("expected exactly one TCP connect result")
+	}
+	result := observations.TCPConnect[0]
+	if result.IP != "10.0.0.1" || result.Port != 80 {
+		t.Fatal("unexpected endpoint", result.IP, result.Port)
+	}
+	if result.Status.Failure == nil || *result.Status.Failure != FailureDestinationDenied {
+		t.Fatal("unexpected failure", result.Status.Failure)
+	}
+}
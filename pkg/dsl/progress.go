@@ -112,9 +112,12 @@ func (*wrapWithProgressLoader) Load(loader *ASTLoader, node *LoadableASTNode) (R
 	if len(runnables) != 1 {
 		return nil, ErrInvalidNumberOfChildren
 	}
+	if err := CheckChildrenTypes[*Void, *Void](node, runnables...); err != nil {
+		return nil, err
+	}
 	runnables0 := &RunnableASTNodeStage[*Void, *Void]{runnables[0]}
 	stage := &wrapWithProgressStage{config.Delta, runnables0}
-	return &StageRunnableASTNode[*Void, *Void]{stage}, nil
+	return &StageRunnableASTNode[*Void, *Void]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -122,6 +125,11 @@ func (*wrapWithProgressLoader) StageName() string {
 	return wrapWithProgressStageName
 }
 
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*wrapWithProgressLoader) StageArguments() any {
+	return &wrapWithProgressStageArguments{}
+}
+
 // Run implements Stage.
 func (sx *wrapWithProgressStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
 	output := sx.stage.Run(ctx, rtx, input)
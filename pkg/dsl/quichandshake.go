@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/pkg/measurexlite"
+	"github.com/ooni/probe-engine/pkg/netxlite"
 	"github.com/quic-go/quic-go"
 )
 
@@ -23,14 +24,27 @@ type quicHandshakeOperation struct {
 
 const quicHandshakeStageName = "quic_handshake"
 
+// quicHandshakeStageVersion is the most recent version of the quic_handshake stage. The second
+// version adds the timeout argument, which probes only supporting the first version would ignore.
+const quicHandshakeStageVersion = 2
+
+// quicHandshakeDefaultTimeout is the default QUIC handshake timeout.
+const quicHandshakeDefaultTimeout = 10 * time.Second
+
 // ASTNode implements operation.
 func (sx *quicHandshakeOperation) ASTNode() *SerializableASTNode {
 	var config quicHandshakeConfig
 	for _, option := range sx.options {
 		option(&config)
 	}
+	// Note: we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if config.Timeout > 0 {
+		version = quicHandshakeStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: quicHandshakeStageName,
+		StageName: VersionedStageName(quicHandshakeStageName, version),
 		Arguments: &config,
 		Children:  []*SerializableASTNode{},
 	}
@@ -48,7 +62,7 @@ func (*quicHandshakeLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runn
 		return nil, err
 	}
 	stage := QUICHandshake(config.options()...)
-	return &StageRunnableASTNode[*Endpoint, *QUICConnection]{stage}, nil
+	return &StageRunnableASTNode[*Endpoint, *QUICConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -56,6 +70,16 @@ func (*quicHandshakeLoader) StageName() string {
 	return quicHandshakeStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*quicHandshakeLoader) StageVersion() int {
+	return quicHandshakeStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*quicHandshakeLoader) StageArguments() any {
+	return &quicHandshakeConfig{}
+}
+
 // Run implements operation.
 func (sx *quicHandshakeOperation) Run(ctx context.Context, rtx Runtime, endpoint *Endpoint) (*QUICConnection, error) {
 	// initialize config
@@ -73,8 +97,51 @@ func (sx *quicHandshakeOperation) Run(ctx context.Context, rtx Runtime, endpoint
 	// obtain TLS config or return an exception
 	tlsConfig, err := config.TLSConfig()
 	if err != nil {
-		return nil, &ErrException{err}
+		except := &ErrException{err}
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, config.Tags, time.Now(), except)
+		return nil, except
+	}
+
+	// make sure the policy allows us to connect
+	except, denied := checkConnectEndpoint(rtx, endpoint.Address, netxlite.QUICHandshakeOperation)
+	if except != nil {
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, config.Tags, time.Now(), except)
+		return nil, except
+	}
+
+	// a denied endpoint is a handshake failure we record
+	if denied != nil {
+		trace := rtx.NewTrace(config.Tags...)
+		t0 := time.Now()
+		trace.OnDestinationDenied("udp", endpoint.Address, tlsConfig, denied)
+		rtx.SaveObservations(trace.ExtractObservations()...)
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, trace.Tags(), t0, denied)
+		return nil, &ErrQUICHandshake{denied}
+	}
+
+	// wait for the budget to allow us to open a new connection
+	timeout := durationOrDefault(config.Timeout, quicHandshakeDefaultTimeout)
+	tb := time.Now()
+	releaseConn, err := acquireConnectionBudget(ctx, rtx, timeout)
+	if err != nil {
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, config.Tags, tb, err)
+		return nil, &ErrQUICHandshake{err}
+	}
+
+	// release the connection budget unless we track the connection below
+	defer func() {
+		if releaseConn != nil {
+			releaseConn()
+		}
+	}()
+
+	// wait for the budget to allow us to start
+	release, err := rtx.Budget().Acquire(ctx)
+	if err != nil {
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, config.Tags, tb, err)
+		return nil, &ErrQUICHandshake{err}
 	}
+	defer release()
 
 	// create trace
 	trace := rtx.NewTrace(config.Tags...)
@@ -91,11 +158,11 @@ func (sx *quicHandshakeOperation) Run(ctx context.Context, rtx Runtime, endpoint
 
 	// setup
 	quicDialer := trace.NewQUICDialerWithoutResolver()
-	const timeout = 10 * time.Second
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// handshake
+	t0 := time.Now()
 	quicConn, err := quicDialer.DialContext(ctx, endpoint.Address, tlsConfig, &quic.Config{})
 
 	// stop the operation logger
@@ -106,15 +173,17 @@ func (sx *quicHandshakeOperation) Run(ctx context.Context, rtx Runtime, endpoint
 
 	// handle the error case
 	if err != nil {
-		rtx.Metrics().Error(quicHandshakeStageName)
+		observeOperation(rtx, quicHandshakeStageName, endpoint.Address, trace.Tags(), t0, err)
 		return nil, &ErrQUICHandshake{err}
 	}
 
-	// make sure we will close this conn
+	// make sure we will close this conn and release the connection budget
 	rtx.TrackQUICConn(quicConn)
+	rtx.TrackCloser(&budgetReleaseCloser{releaseConn})
+	releaseConn = nil
 
 	// prepare the return value
-	rtx.Metrics().Success(quicHandshakeStageName)
+	observeOperation(rtx, quicHandshakeStageName, endpoint.Address, trace.Tags(), t0, nil)
 	out := &QUICConnection{
 		Address:               endpoint.Address,
 		Conn:                  quicConn,
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"time"
 
 	"github.com/quic-go/quic-go"
 )
@@ -40,6 +41,7 @@ type quicHandshakeConfig struct {
 	SkipVerify bool     `json:"skip_verify,omitempty"`
 	SNI        string   `json:"sni,omitempty"`
 	Tags       []string `json:"tags,omitempty"`
+	Timeout    Duration `json:"timeout,omitempty"`
 	X509Certs  []string `json:"x509_certs,omitempty"`
 }
 
@@ -56,6 +58,9 @@ func (c *quicHandshakeConfig) options() (options []QUICHandshakeOption) {
 	if len(c.Tags) > 0 {
 		options = append(options, QUICHandshakeOptionTags(c.Tags...))
 	}
+	if c.Timeout > 0 {
+		options = append(options, QUICHandshakeOptionTimeout(time.Duration(c.Timeout)))
+	}
 	if len(c.X509Certs) > 0 {
 		options = append(options, QUICHandshakeOptionX509Certs(c.X509Certs...))
 	}
@@ -124,6 +129,14 @@ func QUICHandshakeOptionTags(tags ...string) QUICHandshakeOption {
 	}
 }
 
+// QUICHandshakeOptionTimeout allows to configure the handshake timeout. A zero or
+// negative value means that we should use the default timeout.
+func QUICHandshakeOptionTimeout(value time.Duration) QUICHandshakeOption {
+	return func(config *quicHandshakeConfig) {
+		config.Timeout = Duration(value)
+	}
+}
+
 // ErrQUICHandshake wraps errors occurred during a QUIC handshake operation.
 type ErrQUICHandshake struct {
 	Err error
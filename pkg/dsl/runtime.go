@@ -1,6 +1,7 @@
 package dsl
 
 import (
+	"crypto/tls"
 	"io"
 	"net/http"
 	"sync"
@@ -13,15 +14,26 @@ import (
 
 // Runtime is a runtime for running measurement pipelines.
 type Runtime interface {
+	// Budget returns the budget shared by all network operations.
+	Budget() Budget
+
 	// ExtractObservations removes and returns the observations saved so far.
 	ExtractObservations() []*Observations
 
 	// Close closes all the closers tracker by the runtime.
 	Close() error
 
+	// DestinationPolicy returns the policy deciding which destinations
+	// we may resolve and connect to.
+	DestinationPolicy() DestinationPolicy
+
 	// Logger returns the logger to use.
 	Logger() model.Logger
 
+	// MaxParallelism returns the maximum number of goroutines that a parallel
+	// stage may use regardless of the parallelism requested by the AST.
+	MaxParallelism() int
+
 	// Metrics returns the metrics to use.
 	Metrics() Metrics
 
@@ -34,6 +46,9 @@ type Runtime interface {
 	// SaveObservations saves the given observations into the runtime.
 	SaveObservations(observations ...*Observations)
 
+	// StageInterceptor returns the interceptor invoked around the execution of stages.
+	StageInterceptor() StageInterceptor
+
 	// TrackCloser register the closer to be closed by Close.
 	TrackCloser(io.Closer)
 
@@ -41,39 +56,155 @@ type Runtime interface {
 	TrackQUICConn(quic.EarlyConnection)
 }
 
+// RuntimeOption is an option for configuring a [Runtime].
+type RuntimeOption func(config *runtimeConfig)
+
+// runtimeConfig contains the [Runtime] configuration.
+type runtimeConfig struct {
+	// budget is the budget shared by network operations.
+	budget Budget
+
+	// interceptor is the stage interceptor.
+	interceptor StageInterceptor
+
+	// maxParallelism is the maximum parallelism.
+	maxParallelism int
+
+	// policy is the destination policy.
+	policy DestinationPolicy
+
+	// sharedMetrics contains the shared metrics.
+	sharedMetrics []Metrics
+}
+
+// DefaultMaxParallelism is the default maximum number of goroutines that each parallel
+// stage may use, which prevents ASTs served by the backend from requesting an arbitrarily
+// large parallelism (e.g., to exhaust the memory of low-end devices).
+const DefaultMaxParallelism = 16
+
+// newRuntimeConfig creates a new [runtimeConfig] using the given options.
+func newRuntimeConfig(options ...RuntimeOption) *runtimeConfig {
+	config := &runtimeConfig{
+		budget:         defaultNullBudget,
+		interceptor:    defaultNullStageInterceptor,
+		maxParallelism: DefaultMaxParallelism,
+		policy:         defaultNullDestinationPolicy,
+		sharedMetrics:  []Metrics{},
+	}
+	for _, option := range options {
+		option(config)
+	}
+	return config
+}
+
+// RuntimeOptionBudget configures the [Budget] shared by all network operations. You can share
+// the same [Budget] between several [Runtime] instances to enforce a global limit. By default, we
+// use a [NullBudget], which does not impose any limit.
+func RuntimeOptionBudget(value Budget) RuntimeOption {
+	return func(config *runtimeConfig) {
+		config.budget = value
+	}
+}
+
+// RuntimeOptionDestinationPolicy configures the [DestinationPolicy] deciding which destinations
+// we may resolve and connect to. By default, we use a [NullDestinationPolicy], which allows
+// all destinations. When running ASTs served by the backend, you should use a policy such as a
+// [*DenyListDestinationPolicy] created using the [DefaultDestinationPolicyConfig].
+func RuntimeOptionDestinationPolicy(value DestinationPolicy) RuntimeOption {
+	return func(config *runtimeConfig) {
+		config.policy = value
+	}
+}
+
+// RuntimeOptionMaxParallelism configures the maximum number of goroutines that each parallel
+// stage may use regardless of the parallelism requested by the AST. Use 1 for sequential
+// execution. A zero or negative value means using the [DefaultMaxParallelism].
+func RuntimeOptionMaxParallelism(value int) RuntimeOption {
+	return func(config *runtimeConfig) {
+		if value <= 0 {
+			value = DefaultMaxParallelism
+		}
+		config.maxParallelism = value
+	}
+}
+
+// RuntimeOptionSharedMetrics configures [Metrics] that we update along with the [Runtime]
+// metrics. You can share the same [Metrics] between several [Runtime] instances to account
+// the events of several measurements (e.g., to export counters for a long-running probe). You
+// can use this option several times to configure several [Metrics]. See also [TeeMetrics].
+func RuntimeOptionSharedMetrics(value Metrics) RuntimeOption {
+	return func(config *runtimeConfig) {
+		config.sharedMetrics = append(config.sharedMetrics, value)
+	}
+}
+
 // MinimalRuntime is a minimal [Runtime]. This [Runtime] mostly does not do anything
 // but incrementing the [Trace] index and tracking connections so that they're closed by
 // [MinimalRuntime.Close]. The zero value of this struct is not ready to use; construct
 // using the [NewMinimalRuntime] factory function.
 type MinimalRuntime struct {
+	// budget is the budget shared by network operations.
+	budget Budget
+
 	// closers contains the closers to close.
 	closers []io.Closer
 
 	// idGenerator generates atomic incremental IDs for traces.
 	idGenerator *atomic.Int64
 
+	// interceptor is the stage interceptor.
+	interceptor StageInterceptor
+
 	// logger is the logger to use.
 	logger model.Logger
 
+	// maxParallelism is the maximum parallelism.
+	maxParallelism int
+
+	// metrics contains the metrics.
+	metrics Metrics
+
 	// mu protects accesses to the closers field.
 	mu sync.Mutex
 
 	// observations contains the collected observations.
 	observations []*Observations
+
+	// policy is the destination policy.
+	policy DestinationPolicy
+
+	// sharedMetrics contains the shared metrics.
+	sharedMetrics []Metrics
 }
 
 // NewMinimalRuntime creates a minimal [Runtime] that increments
 // [Trace] indexes and tracks connections.
-func NewMinimalRuntime(logger model.Logger) *MinimalRuntime {
+func NewMinimalRuntime(logger model.Logger, options ...RuntimeOption) *MinimalRuntime {
+	config := newRuntimeConfig(options...)
+	var metrics Metrics = defaultNullMetrics
+	if len(config.sharedMetrics) > 0 {
+		metrics = NewTeeMetrics(defaultNullMetrics, config.sharedMetrics...)
+	}
 	return &MinimalRuntime{
-		closers:      []io.Closer{},
-		idGenerator:  &atomic.Int64{},
-		logger:       logger,
-		mu:           sync.Mutex{},
-		observations: []*Observations{},
+		budget:         config.budget,
+		closers:        []io.Closer{},
+		idGenerator:    &atomic.Int64{},
+		interceptor:    config.interceptor,
+		logger:         logger,
+		maxParallelism: config.maxParallelism,
+		metrics:        metrics,
+		mu:             sync.Mutex{},
+		observations:   []*Observations{},
+		policy:         config.policy,
+		sharedMetrics:  config.sharedMetrics,
 	}
 }
 
+// Budget implements Runtime.
+func (r *MinimalRuntime) Budget() Budget {
+	return r.budget
+}
+
 // Close implements Runtime.
 func (r *MinimalRuntime) Close() error {
 	defer r.mu.Unlock()
@@ -85,6 +216,11 @@ func (r *MinimalRuntime) Close() error {
 	return nil
 }
 
+// DestinationPolicy implements Runtime.
+func (r *MinimalRuntime) DestinationPolicy() DestinationPolicy {
+	return r.policy
+}
+
 // ExtractObservations implements Runtime.
 func (r *MinimalRuntime) ExtractObservations() []*Observations {
 	defer r.mu.Unlock()
@@ -99,9 +235,14 @@ func (r *MinimalRuntime) ProgressMeter() ProgressMeter {
 	return &NullProgressMeter{}
 }
 
+// MaxParallelism implements Runtime.
+func (r *MinimalRuntime) MaxParallelism() int {
+	return r.maxParallelism
+}
+
 // Metrics implements Runtime.
 func (r *MinimalRuntime) Metrics() Metrics {
-	return defaultNullMetrics
+	return r.metrics
 }
 
 // SaveObservations implements Runtime.
@@ -111,6 +252,11 @@ func (r *MinimalRuntime) SaveObservations(observations ...*Observations) {
 	r.mu.Unlock()
 }
 
+// StageInterceptor implements Runtime.
+func (r *MinimalRuntime) StageInterceptor() StageInterceptor {
+	return r.interceptor
+}
+
 // Logger implements Runtime.
 func (r *MinimalRuntime) Logger() model.Logger {
 	return r.logger
@@ -195,6 +341,11 @@ func (t *minimalTrace) Index() int64 {
 	return t.idx
 }
 
+// OnDestinationDenied implements Trace.
+func (t *minimalTrace) OnDestinationDenied(network, endpoint string, config *tls.Config, err error) {
+	// nothing
+}
+
 // NewDialerWithoutResolver implements Trace.
 func (t *minimalTrace) NewDialerWithoutResolver() model.Dialer {
 	return netxlite.NewDialerWithoutResolver(t.r.logger)
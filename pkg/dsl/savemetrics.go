@@ -0,0 +1,94 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// MetricsAnnotation is the default name of the [Annotation] saved by [SaveMetrics].
+const MetricsAnnotation = "metrics"
+
+// MetricsSnapshot is a snapshot of the [Metrics].
+type MetricsSnapshot struct {
+	// Counters contains the snapshot returned by [Metrics] Snapshot.
+	Counters map[string]int64 `json:"counters"`
+
+	// Series contains the series returned by [*HistogramMetrics] Series and is empty
+	// when the [Metrics] implementation does not provide a Series method.
+	Series []*MetricsSeries `json:"series"`
+}
+
+// metricsWithSeries is a [Metrics] providing [*MetricsSeries], such as [*HistogramMetrics].
+type metricsWithSeries interface {
+	Series() []*MetricsSeries
+}
+
+// NewMetricsSnapshot creates a [*MetricsSnapshot] from the given [Metrics].
+func NewMetricsSnapshot(metrics Metrics) *MetricsSnapshot {
+	snap := &MetricsSnapshot{
+		Counters: metrics.Snapshot(),
+		Series:   []*MetricsSeries{},
+	}
+	if sm, good := metrics.(metricsWithSeries); good {
+		snap.Series = sm.Series()
+	}
+	return snap
+}
+
+// SaveMetrics returns a stage that saves a [*MetricsSnapshot] of the [Runtime] metrics as an
+// [Annotation] with the given name or [MetricsAnnotation] if the name is empty. Because annotations
+// are part of the test keys, adding this stage at the end of a pipeline allows to include the
+// metrics into the measurement. We save the metrics regardless of whether the input is an
+// error and we return the input unmodified.
+func SaveMetrics(annotation string) Stage[*Void, *Void] {
+	return &saveMetricsStage{annotation}
+}
+
+type saveMetricsStage struct {
+	Annotation string `json:"annotation,omitempty"`
+}
+
+const saveMetricsStageName = "save_metrics"
+
+// ASTNode implements Stage.
+func (sx *saveMetricsStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: saveMetricsStageName,
+		Arguments: sx,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+type saveMetricsLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*saveMetricsLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var stage saveMetricsStage
+	if err := json.Unmarshal(node.Arguments, &stage); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	return &StageRunnableASTNode[*Void, *Void]{S: &stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*saveMetricsLoader) StageName() string {
+	return saveMetricsStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*saveMetricsLoader) StageArguments() any {
+	return &saveMetricsStage{}
+}
+
+// Run implements Stage.
+func (sx *saveMetricsStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
+	annotation := sx.Annotation
+	if annotation == "" {
+		annotation = MetricsAnnotation
+	}
+	SaveAnnotation(rtx, annotation, NewMetricsSnapshot(rtx.Metrics()))
+	return input
+}
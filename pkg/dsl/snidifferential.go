@@ -0,0 +1,228 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/ooni/probe-engine/pkg/measurexlite"
+)
+
+// SNIDifferentialOption is an option for [SNIDifferential].
+type SNIDifferentialOption func(config *sniDifferentialArguments)
+
+// SNIDifferentialOptionAnnotation configures the name of the [Annotation] containing the
+// [SNIDifferentialResult]. The default name is [SNIDifferentialAnnotation].
+func SNIDifferentialOptionAnnotation(value string) SNIDifferentialOption {
+	return func(config *sniDifferentialArguments) {
+		config.Annotation = value
+	}
+}
+
+// SNIDifferentialOptionTLSHandshake configures the options of both TLS handshakes. Note that
+// the control handshake always uses the control SNI and skips the certificate verification.
+func SNIDifferentialOptionTLSHandshake(options ...TLSHandshakeOption) SNIDifferentialOption {
+	return func(config *sniDifferentialArguments) {
+		for _, option := range options {
+			option(&config.TLSHandshake)
+		}
+	}
+}
+
+// SNIDifferentialClassification classifies the outcome of [SNIDifferential].
+type SNIDifferentialClassification string
+
+const (
+	// SNIDifferentialAccessible means that both handshakes succeeded.
+	SNIDifferentialAccessible = SNIDifferentialClassification("accessible")
+
+	// SNIDifferentialSNIBlocking means that the target handshake failed while
+	// the control handshake with the same endpoint succeeded.
+	SNIDifferentialSNIBlocking = SNIDifferentialClassification("sni_blocking")
+
+	// SNIDifferentialIPBlocking means that we could not connect to the endpoint.
+	SNIDifferentialIPBlocking = SNIDifferentialClassification("ip_blocking")
+
+	// SNIDifferentialInconclusive means that the results do not allow us to
+	// distinguish between the other cases (e.g., both handshakes failed).
+	SNIDifferentialInconclusive = SNIDifferentialClassification("inconclusive")
+)
+
+// SNIDifferentialAnnotation is the default name of the [Annotation]
+// containing the [SNIDifferentialResult].
+const SNIDifferentialAnnotation = "sni_differential"
+
+// SNIDifferentialResult is the result of [SNIDifferential].
+type SNIDifferentialResult struct {
+	// Address is the endpoint address.
+	Address string `json:"address"`
+
+	// Domain is the endpoint domain, which we use as the target SNI.
+	Domain string `json:"domain"`
+
+	// ControlSNI is the control SNI.
+	ControlSNI string `json:"control_sni"`
+
+	// TargetFailure is the failure of the target measurement or nil.
+	TargetFailure *string `json:"target_failure"`
+
+	// TargetFailedOperation is the operation that failed during the
+	// target measurement (e.g., "tls_handshake") or empty.
+	TargetFailedOperation string `json:"target_failed_operation"`
+
+	// ControlFailure is the failure of the control measurement or nil.
+	ControlFailure *string `json:"control_failure"`
+
+	// ControlFailedOperation is like TargetFailedOperation for the control measurement.
+	ControlFailedOperation string `json:"control_failed_operation"`
+
+	// Classification is the classification of the results.
+	Classification SNIDifferentialClassification `json:"classification"`
+}
+
+// SNIDifferential returns a stage that, for the given endpoint, connects and performs two
+// TLS handshakes in parallel: one with the endpoint domain as the SNI (the target) and one with
+// the given control SNI, which should be a domain that is not censored. Because the endpoint
+// may not serve the control domain, the control handshake skips the certificate verification.
+//
+// The stage classifies the outcome as described by the [SNIDifferentialClassification] values,
+// saves the [SNIDifferentialResult] as an [Annotation], and returns the result. The TCP
+// connect observations have the "sni_differential_target" or "sni_differential_control"
+// tags, so we know which measurement produced them. Note that we close the connections.
+func SNIDifferential(controlSNI string, options ...SNIDifferentialOption) Stage[*Endpoint, *SNIDifferentialResult] {
+	config := sniDifferentialArguments{ControlSNI: controlSNI}
+	for _, option := range options {
+		option(&config)
+	}
+	return &sniDifferentialStage{config}
+}
+
+type sniDifferentialArguments struct {
+	Annotation   string             `json:"annotation,omitempty"`
+	ControlSNI   string             `json:"control_sni"`
+	TLSHandshake tlsHandshakeConfig `json:"tls_handshake"`
+}
+
+type sniDifferentialStage struct {
+	config sniDifferentialArguments
+}
+
+const sniDifferentialStageName = "sni_differential"
+
+// ASTNode implements Stage.
+func (sx *sniDifferentialStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: sniDifferentialStageName,
+		Arguments: &sx.config,
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// ErrEmptyControlSNI indicates that the control SNI of [SNIDifferential] is empty.
+var ErrEmptyControlSNI = errors.New("dsl: sni_differential: empty control SNI")
+
+type sniDifferentialLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*sniDifferentialLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var config sniDifferentialArguments
+	if err := json.Unmarshal(node.Arguments, &config); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 0); err != nil {
+		return nil, err
+	}
+	if config.ControlSNI == "" {
+		return nil, ErrEmptyControlSNI
+	}
+	stage := &sniDifferentialStage{config}
+	return &StageRunnableASTNode[*Endpoint, *SNIDifferentialResult]{S: stage}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*sniDifferentialLoader) StageName() string {
+	return sniDifferentialStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*sniDifferentialLoader) StageArguments() any {
+	return &sniDifferentialArguments{}
+}
+
+// Run implements Stage.
+func (sx *sniDifferentialStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Endpoint]) Maybe[*SNIDifferentialResult] {
+	if input.Error != nil {
+		return NewError[*SNIDifferentialResult](input.Error)
+	}
+	if sx.config.ControlSNI == "" {
+		return NewError[*SNIDifferentialResult](&ErrException{ErrEmptyControlSNI})
+	}
+
+	// run the target and the control measurements in parallel
+	targetOptions := sx.config.TLSHandshake.options()
+	controlOptions := append(
+		sx.config.TLSHandshake.options(),
+		TLSHandshakeOptionSNI(sx.config.ControlSNI),
+		TLSHandshakeOptionSkipVerify(true),
+	)
+	pair := Zip(
+		Compose(TCPConnect(TCPConnectOptionTags("sni_differential_target")), TLSHandshake(targetOptions...)),
+		Compose(TCPConnect(TCPConnectOptionTags("sni_differential_control")), TLSHandshake(controlOptions...)),
+	).Run(ctx, rtx, input)
+	if pair.Error != nil {
+		return NewError[*SNIDifferentialResult](pair.Error)
+	}
+
+	// we don't need the connections anymore
+	for _, result := range []Maybe[*TLSConnection]{pair.Value.First, pair.Value.Second} {
+		if result.Error == nil {
+			result.Value.Conn.Close()
+		}
+	}
+
+	// create, save and return the result
+	output := &SNIDifferentialResult{
+		Address:                input.Value.Address,
+		Domain:                 input.Value.Domain,
+		ControlSNI:             sx.config.ControlSNI,
+		TargetFailure:          measurexlite.NewFailure(pair.Value.First.Error),
+		TargetFailedOperation:  sniDifferentialFailedOperation(pair.Value.First.Error),
+		ControlFailure:         measurexlite.NewFailure(pair.Value.Second.Error),
+		ControlFailedOperation: sniDifferentialFailedOperation(pair.Value.Second.Error),
+	}
+	output.Classification = classifySNIDifferential(output.TargetFailedOperation, output.ControlFailedOperation)
+	annotation := sx.config.Annotation
+	if annotation == "" {
+		annotation = SNIDifferentialAnnotation
+	}
+	SaveAnnotation(rtx, annotation, output)
+	return NewValue(output)
+}
+
+// sniDifferentialFailedOperation returns the name of the operation that failed.
+func sniDifferentialFailedOperation(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case IsErrTCPConnect(err):
+		return tcpConnectStageName
+	case IsErrTLSHandshake(err):
+		return tlsHandshakeStageName
+	default:
+		return "unknown"
+	}
+}
+
+// classifySNIDifferential classifies the result given the failed operations.
+func classifySNIDifferential(target, control string) SNIDifferentialClassification {
+	switch {
+	case target == "" && control == "":
+		return SNIDifferentialAccessible
+	case target == tlsHandshakeStageName && control == "":
+		return SNIDifferentialSNIBlocking
+	case target == tcpConnectStageName && control == tcpConnectStageName:
+		return SNIDifferentialIPBlocking
+	default:
+		return SNIDifferentialInconclusive
+	}
+}
@@ -0,0 +1,144 @@
+package dsl
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/pkg/testingx"
+)
+
+// sniDifferentialTestHandler is a [testingx.TLSHandler] resetting the connection
+// when the client uses the blocked SNI, which mimics SNI-based blocking.
+type sniDifferentialTestHandler struct {
+	blocked string
+	testingx.TLSHandler
+}
+
+// GetCertificate implements testingx.TLSHandler.
+func (thx *sniDifferentialTestHandler) GetCertificate(
+	ctx context.Context, tcpConn net.Conn, chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if chi.ServerName == thx.blocked {
+		return testingx.TLSHandlerReset().GetCertificate(ctx, tcpConn, chi)
+	}
+	return thx.TLSHandler.GetCertificate(ctx, tcpConn, chi)
+}
+
+func TestSNIDifferential(t *testing.T) {
+	srvr := testingx.MustNewTLSServer(&sniDifferentialTestHandler{
+		blocked:    "blocked.example.com",
+		TLSHandler: testingx.TLSHandlerHandshakeAndWriteText(testingx.MustNewTLSMITMProviderNetem(), nil),
+	})
+	defer srvr.Close()
+
+	// run runs the stage after a JSON round trip and returns the output and the observations
+	run := func(t *testing.T, stage Stage[*Endpoint, *SNIDifferentialResult], endpoint *Endpoint) (Maybe[*SNIDifferentialResult], *Observations) {
+		data, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtx := NewMeasurexliteRuntime(log.Log, &NullMetrics{}, &NullProgressMeter{}, time.Now())
+		defer rtx.Close()
+		output := (&RunnableASTNodeStage[*Endpoint, *SNIDifferentialResult]{runnable}).Run(
+			context.Background(), rtx, NewValue(endpoint))
+		return output, ReduceObservations(rtx.ExtractObservations()...)
+	}
+
+	// Note: we skip the verification of the target because the server uses a MITM certificate
+	skipVerify := SNIDifferentialOptionTLSHandshake(TLSHandshakeOptionSkipVerify(true))
+
+	type testcase struct {
+		name     string
+		stage    Stage[*Endpoint, *SNIDifferentialResult]
+		endpoint *Endpoint
+		expected SNIDifferentialClassification
+	}
+
+	for _, tc := range []testcase{{
+		name:     "with SNI-based blocking",
+		stage:    SNIDifferential("www.example.org", skipVerify),
+		endpoint: &Endpoint{Address: srvr.Endpoint(), Domain: "blocked.example.com"},
+		expected: SNIDifferentialSNIBlocking,
+	}, {
+		name:     "without blocking",
+		stage:    SNIDifferential("www.example.org", skipVerify),
+		endpoint: &Endpoint{Address: srvr.Endpoint(), Domain: "www.example.com"},
+		expected: SNIDifferentialAccessible,
+	}, {
+		name:     "with IP-based blocking",
+		stage:    SNIDifferential("www.example.org", skipVerify),
+		endpoint: &Endpoint{Address: "127.0.0.1:9", Domain: "www.example.com"},
+		expected: SNIDifferentialIPBlocking,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, observations := run(t, tc.stage, tc.endpoint)
+			if output.Error != nil {
+				t.Fatal(output.Error)
+			}
+			if output.Value.Classification != tc.expected {
+				t.Fatalf("unexpected result %+v", output.Value)
+			}
+			if len(observations.TCPConnect) != 2 {
+				t.Fatal("unexpected number of TCP connect observations", len(observations.TCPConnect))
+			}
+			if len(observations.Annotations) != 1 || observations.Annotations[0].Name != SNIDifferentialAnnotation {
+				t.Fatal("expected to find the annotation")
+			}
+		})
+	}
+
+	t.Run("we throw when the control SNI is empty", func(t *testing.T) {
+		rtx := NewMinimalRuntime(log.Log)
+		defer rtx.Close()
+		endpoint := &Endpoint{Address: srvr.Endpoint(), Domain: "www.example.com"}
+		output := SNIDifferential("").Run(context.Background(), rtx, NewValue(endpoint))
+		if !IsErrException(output.Error) || !errors.Is(output.Error, ErrEmptyControlSNI) {
+			t.Fatal("unexpected error", output.Error)
+		}
+	})
+
+	t.Run("we refuse to load an empty control SNI", func(t *testing.T) {
+		node := &LoadableASTNode{
+			StageName: sniDifferentialStageName,
+			Arguments: []byte(`{"control_sni":""}`),
+			Children:  []*LoadableASTNode{},
+		}
+		if _, err := NewASTLoader().Load(node); !errors.Is(err, ErrEmptyControlSNI) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
+
+func TestClassifySNIDifferential(t *testing.T) {
+	type testcase struct {
+		target   string
+		control  string
+		expected SNIDifferentialClassification
+	}
+
+	for _, tc := range []testcase{
+		{"", "", SNIDifferentialAccessible},
+		{"tls_handshake", "", SNIDifferentialSNIBlocking},
+		{"tcp_connect", "tcp_connect", SNIDifferentialIPBlocking},
+		{"tls_handshake", "tls_handshake", SNIDifferentialInconclusive},
+		{"tcp_connect", "", SNIDifferentialInconclusive},
+		{"", "tls_handshake", SNIDifferentialInconclusive},
+	} {
+		if got := classifySNIDifferential(tc.target, tc.control); got != tc.expected {
+			t.Fatalf("classifySNIDifferential(%q, %q): expected %s; got %s", tc.target, tc.control, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/pkg/measurexlite"
+)
+
+// Span describes the execution of a stage. The fields mimic the corresponding
+// OpenTelemetry concepts, such that we can export spans using OTLP.
+type Span struct {
+	// TraceID is the 16-byte ID shared by all the spans extracted together.
+	TraceID [16]byte
+
+	// SpanID is the 8-byte ID of this span.
+	SpanID [8]byte
+
+	// ParentSpanID is the ID of the parent span or all zeros for root spans.
+	ParentSpanID [8]byte
+
+	// Name is the stage name (e.g., "tcp_connect").
+	Name string
+
+	// Path contains the names of the intercepted stages from the root to this
+	// stage separated by "/" (e.g., "new_endpoint_pipeline/tcp_connect").
+	Path string
+
+	// TraceIndex is the index of the [Trace] used by the stage or zero when
+	// neither the input nor the output of the stage contain a [Trace].
+	TraceIndex int64
+
+	// Tags contains the tags of the [Trace] used by the stage.
+	Tags []string
+
+	// Start is when the stage started.
+	Start time.Time
+
+	// End is when the stage finished.
+	End time.Time
+
+	// ErrorClass is the OONI failure string (e.g., "connection_refused")
+	// computed from the stage error or nil on success.
+	ErrorClass *string
+}
+
+// SpanRecorder is a [StageInterceptor] creating a [Span] for each stage it
+// intercepts. The spans of stages running inside other stages are children of
+// the outer stage span. All the spans share the same trace ID until we call
+// [*SpanRecorder.ExtractSpans], which starts a new trace, so, for example, we
+// can use a trace for each nettest. Construct using [NewSpanRecorder].
+type SpanRecorder struct {
+	// mu protects spans and traceID.
+	mu sync.Mutex
+
+	// spans contains the finished spans.
+	spans []*Span
+
+	// traceID is the current trace ID.
+	traceID [16]byte
+}
+
+var _ StageInterceptor = &SpanRecorder{}
+
+// NewSpanRecorder creates a new [*SpanRecorder].
+func NewSpanRecorder() *SpanRecorder {
+	sr := &SpanRecorder{
+		mu:    sync.Mutex{},
+		spans: []*Span{},
+	}
+	spanRecorderRandomID(sr.traceID[:])
+	return sr
+}
+
+// spanRecorderKey is the context key for the span of the running stage.
+type spanRecorderKey struct{}
+
+// BeforeStage implements StageInterceptor.
+func (sr *SpanRecorder) BeforeStage(
+	ctx context.Context, node *SerializableASTNode, input Maybe[any]) (context.Context, error) {
+	span := &Span{
+		Name:       node.StageName,
+		Path:       node.StageName,
+		TraceIndex: 0,
+		Tags:       []string{},
+		Start:      time.Now(),
+	}
+	if parent, good := ctx.Value(spanRecorderKey{}).(*Span); good {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+		span.Path = parent.Path + "/" + node.StageName
+	} else {
+		sr.mu.Lock()
+		span.TraceID = sr.traceID
+		sr.mu.Unlock()
+	}
+	spanRecorderRandomID(span.SpanID[:])
+	return context.WithValue(ctx, spanRecorderKey{}, span), nil
+}
+
+// AfterStage implements StageInterceptor.
+func (sr *SpanRecorder) AfterStage(
+	ctx context.Context, node *SerializableASTNode, input, output Maybe[any], elapsed time.Duration) error {
+	span, good := ctx.Value(spanRecorderKey{}).(*Span)
+	if !good {
+		return nil // should not happen
+	}
+	span.End = span.Start.Add(elapsed)
+	span.ErrorClass = measurexlite.NewFailure(output.Error)
+
+	// prefer the output trace, which exists when we create a connection
+	for _, value := range []any{output.Value, input.Value} {
+		if trace := spanRecorderTraceOf(value); trace != nil {
+			span.TraceIndex = trace.Index()
+			span.Tags = trace.Tags()
+			break
+		}
+	}
+
+	sr.mu.Lock()
+	sr.spans = append(sr.spans, span)
+	sr.mu.Unlock()
+	return nil
+}
+
+// spanRecorderRandomID fills the given ID with random bytes.
+func spanRecorderRandomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		panic(err) // should not happen
+	}
+}
+
+// spanRecorderTraceOf returns the [Trace] used by the given value or nil.
+func spanRecorderTraceOf(value any) Trace {
+	switch conn := value.(type) {
+	case *TCPConnection:
+		if conn != nil {
+			return conn.Trace
+		}
+	case *TLSConnection:
+		if conn != nil {
+			return conn.Trace
+		}
+	case *QUICConnection:
+		if conn != nil {
+			return conn.Trace
+		}
+	case *HTTPConnection:
+		if conn != nil {
+			return conn.Trace
+		}
+	}
+	return nil
+}
+
+// ExtractSpans removes and returns the spans recorded so far and starts a new trace.
+func (sr *SpanRecorder) ExtractSpans() []*Span {
+	sr.mu.Lock()
+	spans := sr.spans
+	sr.spans = []*Span{}
+	spanRecorderRandomID(sr.traceID[:])
+	sr.mu.Unlock()
+	return spans
+}
+
+// WriteOTLPJSONFile appends the given OTLP JSON traces document, produced by [MarshalOTLPJSON],
+// to the given file as a single line, which is the format used by the file exporter of the
+// OpenTelemetry collector. Therefore, we can write the spans of several runs into the same file.
+func WriteOTLPJSONFile(filepath string, data []byte) error {
+	filep, err := os.OpenFile(filepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := filep.Write(append(data, '\n')); err != nil {
+		filep.Close()
+		return err
+	}
+	return filep.Close()
+}
+
+// ExportOTLPHTTP sends the given OTLP JSON traces document, produced by [MarshalOTLPJSON],
+// using OTLP/HTTP to the given URL, which typically is the traces endpoint of a local
+// OpenTelemetry collector (e.g., "http://127.0.0.1:4318/v1/traces").
+func ExportOTLPHTTP(ctx context.Context, URL string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("dsl: OTLP collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MarshalOTLPJSON serializes the given spans as an OTLP JSON traces document using
+// the given service name (e.g., "ooniprobe") as a resource attribute. We use the following span attributes:
+//
+// - "ooni.dsl.path" contains the [Span] Path;
+//
+// - "ooni.dsl.trace_index" contains the [Span] TraceIndex, if nonzero;
+//
+// - "ooni.dsl.tags" contains the [Span] Tags;
+//
+// - "error.type" contains the [Span] ErrorClass, if not nil.
+//
+// We also set the span status to error when the ErrorClass is not nil.
+func MarshalOTLPJSON(serviceName string, spans ...*Span) ([]byte, error) {
+	scope := &otlpScopeSpans{
+		Scope: otlpScope{Name: "github.com/ooni/2023-05-richer-input/pkg/dsl"},
+		Spans: []*otlpSpan{},
+	}
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, newOTLPSpan(span))
+	}
+	document := &otlpTracesData{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []*otlpKeyValue{otlpStringAttribute("service.name", serviceName)},
+			},
+			ScopeSpans: []*otlpScopeSpans{scope},
+		}},
+	}
+	return json.Marshal(document)
+}
+
+// newOTLPSpan converts a [*Span] to its OTLP representation.
+func newOTLPSpan(span *Span) *otlpSpan {
+	output := &otlpSpan{
+		TraceID:           hex.EncodeToString(span.TraceID[:]),
+		SpanID:            hex.EncodeToString(span.SpanID[:]),
+		ParentSpanID:      "",
+		Name:              span.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        []*otlpKeyValue{otlpStringAttribute("ooni.dsl.path", span.Path)},
+		Status:            otlpStatus{},
+	}
+	if span.ParentSpanID != [8]byte{} {
+		output.ParentSpanID = hex.EncodeToString(span.ParentSpanID[:])
+	}
+	if span.TraceIndex != 0 {
+		value := strconv.FormatInt(span.TraceIndex, 10)
+		output.Attributes = append(output.Attributes, &otlpKeyValue{
+			Key:   "ooni.dsl.trace_index",
+			Value: otlpAnyValue{IntValue: &value},
+		})
+	}
+	tags := &otlpArrayValue{Values: []*otlpAnyValue{}}
+	for _, tag := range span.Tags {
+		tag := tag
+		tags.Values = append(tags.Values, &otlpAnyValue{StringValue: &tag})
+	}
+	output.Attributes = append(output.Attributes, &otlpKeyValue{
+		Key:   "ooni.dsl.tags",
+		Value: otlpAnyValue{ArrayValue: tags},
+	})
+	if span.ErrorClass != nil {
+		output.Attributes = append(output.Attributes, otlpStringAttribute("error.type", *span.ErrorClass))
+		output.Status = otlpStatus{Code: otlpStatusCodeError, Message: *span.ErrorClass}
+	}
+	return output
+}
+
+// otlpStringAttribute creates an OTLP string attribute.
+func otlpStringAttribute(key, value string) *otlpKeyValue {
+	return &otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+const (
+	// otlpSpanKindInternal is the OTLP SPAN_KIND_INTERNAL value.
+	otlpSpanKindInternal = 1
+
+	// otlpStatusCodeError is the OTLP STATUS_CODE_ERROR value.
+	otlpStatusCodeError = 2
+)
+
+// otlpTracesData is the OTLP JSON TracesData message.
+type otlpTracesData struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpResourceSpans is the OTLP JSON ResourceSpans message.
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpResource is the OTLP JSON Resource message.
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+// otlpScopeSpans is the OTLP JSON ScopeSpans message.
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+// otlpScope is the OTLP JSON InstrumentationScope message.
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpSpan is the OTLP JSON Span message.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// otlpStatus is the OTLP JSON Status message.
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpKeyValue is the OTLP JSON KeyValue message.
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is the OTLP JSON AnyValue message. Note that OTLP JSON
+// encodes 64-bit integers as strings.
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+// otlpArrayValue is the OTLP JSON ArrayValue message.
+type otlpArrayValue struct {
+	Values []*otlpAnyValue `json:"values"`
+}
@@ -0,0 +1,271 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSpanRecorder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// connect connects to the listener and discards the connection
+	connect := Compose(TCPConnect(TCPConnectOptionTags("spans")), Discard[*TCPConnection]())
+
+	// runWith loads and runs a pipeline measuring the listener endpoints with the given stage
+	runWith := func(t *testing.T, recorder *SpanRecorder, endpoints Stage[[]*Endpoint, *Void]) {
+		pipeline := Compose4(
+			DomainName("www.example.com"),
+			DNSLookupStatic("127.0.0.1"),
+			MakeEndpointsForPort(port),
+			endpoints,
+		)
+		data, err := json.Marshal(pipeline.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node LoadableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtx := NewMeasurexliteRuntime(log.Log, &NullMetrics{}, &NullProgressMeter{}, time.Now(),
+			RuntimeOptionStageInterceptor(recorder))
+		defer rtx.Close()
+		output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+	}
+
+	// run loads and runs a pipeline connecting to the listener
+	run := func(t *testing.T, recorder *SpanRecorder) {
+		runWith(t, recorder, NewEndpointPipeline(connect))
+	}
+
+	t.Run("we record a span for each intercepted stage", func(t *testing.T) {
+		recorder := NewSpanRecorder()
+		run(t, recorder)
+		spans := recorder.ExtractSpans()
+
+		byPath, paths := map[string]*Span{}, []string{}
+		for _, span := range spans {
+			byPath[span.Path] = span
+			paths = append(paths, span.Path)
+		}
+		sort.Strings(paths)
+		expected := []string{
+			"dns_lookup_static",
+			"domain_name",
+			"make_endpoints_for_port",
+			"new_endpoint_pipeline",
+			"new_endpoint_pipeline/discard",
+			"new_endpoint_pipeline/tcp_connect",
+		}
+		if diff := cmp.Diff(expected, paths); diff != "" {
+			t.Fatal(diff)
+		}
+		for _, span := range spans {
+			if span.TraceID != spans[0].TraceID {
+				t.Fatal("expected all the spans to share the same trace ID")
+			}
+		}
+
+		parent, connect := byPath["new_endpoint_pipeline"], byPath["new_endpoint_pipeline/tcp_connect"]
+		if connect.ParentSpanID != parent.SpanID || parent.ParentSpanID != [8]byte{} {
+			t.Fatal("unexpected parent span IDs")
+		}
+		if connect.TraceIndex <= 0 {
+			t.Fatal("expected a positive trace index")
+		}
+		if diff := cmp.Diff([]string{"spans"}, connect.Tags); diff != "" {
+			t.Fatal(diff)
+		}
+		if connect.ErrorClass != nil {
+			t.Fatal("unexpected error class", *connect.ErrorClass)
+		}
+		if connect.End.Before(connect.Start) || parent.Start.After(connect.Start) || parent.End.Before(connect.End) {
+			t.Fatal("unexpected span times")
+		}
+
+		run(t, recorder)
+		if recorder.ExtractSpans()[0].TraceID == spans[0].TraceID {
+			t.Fatal("expected ExtractSpans to start a new trace")
+		}
+		if len(recorder.ExtractSpans()) != 0 {
+			t.Fatal("expected ExtractSpans to remove the spans")
+		}
+	})
+
+	t.Run("we record the spans of stages with a custom runnable node", func(t *testing.T) {
+		testcases := []struct {
+			name      string
+			endpoints Stage[[]*Endpoint, *Void]
+		}{{
+			name:      "race_endpoints",
+			endpoints: RaceEndpoints(connect),
+		}, {
+			name:      "for_each",
+			endpoints: ForEach(connect),
+		}}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				recorder := NewSpanRecorder()
+				runWith(t, recorder, tc.endpoints)
+				byPath := map[string]*Span{}
+				for _, span := range recorder.ExtractSpans() {
+					byPath[span.Path] = span
+				}
+				parent := byPath[tc.name]
+				connectSpan := byPath[tc.name+"/tcp_connect"]
+				discardSpan := byPath[tc.name+"/discard"]
+				if parent == nil || connectSpan == nil || discardSpan == nil {
+					t.Fatal("missing spans", byPath)
+				}
+				if parent.ParentSpanID != [8]byte{} {
+					t.Fatal("expected the parent to be a root span")
+				}
+				if connectSpan.ParentSpanID != parent.SpanID || discardSpan.ParentSpanID != parent.SpanID {
+					t.Fatal("unexpected parent span IDs")
+				}
+			})
+		}
+	})
+
+	t.Run("we record the error class", func(t *testing.T) {
+		recorder := NewSpanRecorder()
+		ctx, _ := recorder.BeforeStage(context.Background(), TCPConnect().ASTNode(), NewValue[any](&Endpoint{}))
+		output := NewError[any](&ErrTCPConnect{&net.OpError{Err: os.ErrDeadlineExceeded}})
+		if err := recorder.AfterStage(ctx, TCPConnect().ASTNode(), NewValue[any](&Endpoint{}), output, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		spans := recorder.ExtractSpans()
+		if len(spans) != 1 || spans[0].ErrorClass == nil {
+			t.Fatal("expected one span with an error class")
+		}
+		data, err := MarshalOTLPJSON("dsl-test", spans...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"status":{"code":2,"message":"`+*spans[0].ErrorClass+`"}`) {
+			t.Fatal("expected to see the error status", string(data))
+		}
+	})
+
+	t.Run("we can write OTLP JSON files", func(t *testing.T) {
+		recorder := NewSpanRecorder()
+		path := filepath.Join(t.TempDir(), "spans.jsonl")
+		for idx := 0; idx < 2; idx++ {
+			run(t, recorder)
+			if err := WriteOTLPJSONFile(path, spanRecorderMarshal(t, recorder)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 {
+			t.Fatal("expected two lines, got", len(lines))
+		}
+		for _, line := range lines {
+			spanRecorderCheckOTLPJSON(t, []byte(line))
+		}
+	})
+
+	t.Run("we can export to an OTLP HTTP collector", func(t *testing.T) {
+		bodych := make(chan []byte, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			bodych <- data
+		}))
+		defer collector.Close()
+
+		recorder := NewSpanRecorder()
+		run(t, recorder)
+		data := spanRecorderMarshal(t, recorder)
+		if err := ExportOTLPHTTP(context.Background(), collector.URL+"/v1/traces", data); err != nil {
+			t.Fatal(err)
+		}
+		spanRecorderCheckOTLPJSON(t, <-bodych)
+	})
+
+	t.Run("we fail when the collector fails", func(t *testing.T) {
+		collector := httptest.NewServer(http.NotFoundHandler())
+		defer collector.Close()
+
+		recorder := NewSpanRecorder()
+		run(t, recorder)
+		data := spanRecorderMarshal(t, recorder)
+		if err := ExportOTLPHTTP(context.Background(), collector.URL+"/v1/traces", data); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+// spanRecorderMarshal extracts the spans and serializes them as OTLP JSON.
+func spanRecorderMarshal(t *testing.T, recorder *SpanRecorder) []byte {
+	data, err := MarshalOTLPJSON("dsl-test", recorder.ExtractSpans()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// spanRecorderCheckOTLPJSON checks the OTLP JSON produced by running the TestSpanRecorder pipeline.
+func spanRecorderCheckOTLPJSON(t *testing.T, data []byte) {
+	var document otlpTracesData
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.ResourceSpans) != 1 || len(document.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatal("unexpected document structure", string(data))
+	}
+	attrs := document.ResourceSpans[0].Resource.Attributes
+	if len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "dsl-test" {
+		t.Fatal("unexpected resource attributes", string(data))
+	}
+	for _, span := range document.ResourceSpans[0].ScopeSpans[0].Spans {
+		if span.Name != "tcp_connect" {
+			continue
+		}
+		if len(span.TraceID) != 32 || len(span.SpanID) != 16 || len(span.ParentSpanID) != 16 {
+			t.Fatal("unexpected IDs", span)
+		}
+		keys := []string{}
+		for _, attr := range span.Attributes {
+			keys = append(keys, attr.Key)
+		}
+		if diff := cmp.Diff([]string{"ooni.dsl.path", "ooni.dsl.trace_index", "ooni.dsl.tags"}, keys); diff != "" {
+			t.Fatal(diff)
+		}
+		return
+	}
+	t.Fatal("cannot find the tcp_connect span", string(data))
+}
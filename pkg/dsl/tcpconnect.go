@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/pkg/measurexlite"
+	"github.com/ooni/probe-engine/pkg/netxlite"
 )
 
 // TCPConnectOption is an option for [TCPConnect].
@@ -19,6 +20,14 @@ func TCPConnectOptionTags(tags ...string) TCPConnectOption {
 	}
 }
 
+// TCPConnectOptionTimeout allows configuring the TCP connect timeout. A zero or
+// negative value means that we should use the default timeout.
+func TCPConnectOptionTimeout(timeout time.Duration) TCPConnectOption {
+	return func(operation *tcpConnectOperation) {
+		operation.Timeout = Duration(timeout)
+	}
+}
+
 // TCPConnect returns a stage that performs a TCP connect.
 //
 // This function returns an [ErrTCPConnect] if the error is a TCP connect error. Remember to
@@ -34,18 +43,33 @@ func TCPConnect(options ...TCPConnectOption) Stage[*Endpoint, *TCPConnection] {
 }
 
 type tcpConnectOperation struct {
-	Tags []string `json:"tags,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
 }
 
 const tcpConnectStageName = "tcp_connect"
 
+// tcpConnectStageVersion is the most recent version of the tcp_connect stage. The second
+// version adds the timeout argument, which probes only supporting the first version would ignore.
+const tcpConnectStageVersion = 2
+
+// tcpConnectDefaultTimeout is the default TCP connect timeout.
+const tcpConnectDefaultTimeout = 15 * time.Second
+
 // ASTNode implements operation.
 func (op *tcpConnectOperation) ASTNode() *SerializableASTNode {
 	// Note: we serialize the structure because this gives us forward compatibility (i.e., we
 	// may add a field to a future version without breaking the AST structure and old probes will
 	// be fine as long as the zero value of the new field is the default)
+	//
+	// Also, we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if op.Timeout > 0 {
+		version = tcpConnectStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: tcpConnectStageName,
+		StageName: VersionedStageName(tcpConnectStageName, version),
 		Arguments: op,
 		Children:  []*SerializableASTNode{},
 	}
@@ -63,7 +87,7 @@ func (*tcpConnectLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runnabl
 		return nil, err
 	}
 	stage := wrapOperation[*Endpoint, *TCPConnection](&op)
-	return &StageRunnableASTNode[*Endpoint, *TCPConnection]{stage}, nil
+	return &StageRunnableASTNode[*Endpoint, *TCPConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -71,8 +95,59 @@ func (*tcpConnectLoader) StageName() string {
 	return tcpConnectStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*tcpConnectLoader) StageVersion() int {
+	return tcpConnectStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*tcpConnectLoader) StageArguments() any {
+	return &tcpConnectOperation{}
+}
+
 // Run implements operation.
 func (op *tcpConnectOperation) Run(ctx context.Context, rtx Runtime, endpoint *Endpoint) (*TCPConnection, error) {
+	// make sure the policy allows us to connect
+	except, denied := checkConnectEndpoint(rtx, endpoint.Address, netxlite.ConnectOperation)
+	if except != nil {
+		observeOperation(rtx, tcpConnectStageName, endpoint.Address, op.Tags, time.Now(), except)
+		return nil, except
+	}
+
+	// a denied endpoint is a connect failure we record
+	if denied != nil {
+		trace := rtx.NewTrace(op.Tags...)
+		t0 := time.Now()
+		trace.OnDestinationDenied("tcp", endpoint.Address, nil, denied)
+		rtx.SaveObservations(trace.ExtractObservations()...)
+		observeOperation(rtx, tcpConnectStageName, endpoint.Address, trace.Tags(), t0, denied)
+		return nil, &ErrTCPConnect{denied}
+	}
+
+	// wait for the budget to allow us to open a new connection
+	timeout := durationOrDefault(op.Timeout, tcpConnectDefaultTimeout)
+	tb := time.Now()
+	releaseConn, err := acquireConnectionBudget(ctx, rtx, timeout)
+	if err != nil {
+		observeOperation(rtx, tcpConnectStageName, endpoint.Address, op.Tags, tb, err)
+		return nil, &ErrTCPConnect{err}
+	}
+
+	// release the connection budget unless we track the connection below
+	defer func() {
+		if releaseConn != nil {
+			releaseConn()
+		}
+	}()
+
+	// wait for the budget to allow us to start
+	release, err := rtx.Budget().Acquire(ctx)
+	if err != nil {
+		observeOperation(rtx, tcpConnectStageName, endpoint.Address, op.Tags, tb, err)
+		return nil, &ErrTCPConnect{err}
+	}
+	defer release()
+
 	// create trace
 	trace := rtx.NewTrace(op.Tags...)
 
@@ -85,7 +160,6 @@ func (op *tcpConnectOperation) Run(ctx context.Context, rtx Runtime, endpoint *E
 	)
 
 	// setup
-	const timeout = 15 * time.Second
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -93,6 +167,7 @@ func (op *tcpConnectOperation) Run(ctx context.Context, rtx Runtime, endpoint *E
 	dialer := trace.NewDialerWithoutResolver()
 
 	// connect
+	t0 := time.Now()
 	conn, err := dialer.DialContext(ctx, "tcp", endpoint.Address)
 
 	// stop the operation logger
@@ -103,15 +178,17 @@ func (op *tcpConnectOperation) Run(ctx context.Context, rtx Runtime, endpoint *E
 
 	// handle the error case
 	if err != nil {
-		rtx.Metrics().Error(tcpConnectStageName)
+		observeOperation(rtx, tcpConnectStageName, endpoint.Address, trace.Tags(), t0, err)
 		return nil, &ErrTCPConnect{err}
 	}
 
-	// make sure we close the conn when done
+	// make sure we close the conn and release the connection budget when done
 	rtx.TrackCloser(conn)
+	rtx.TrackCloser(&budgetReleaseCloser{releaseConn})
+	releaseConn = nil
 
 	// prepare the return value
-	rtx.Metrics().Success(tcpConnectStageName)
+	observeOperation(rtx, tcpConnectStageName, endpoint.Address, trace.Tags(), t0, nil)
 	out := &TCPConnection{
 		Address: endpoint.Address,
 		Conn:    conn,
@@ -0,0 +1,347 @@
+package dsl
+
+//
+// AST templates
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// astVariableKey is the key of the JSON object representing a template variable.
+const astVariableKey = "$var"
+
+// ASTVariable returns the value representing the template variable with the given name, which
+// serializes to `{"$var": "name"}`. You can use this value anywhere in the arguments of a
+// [SerializableASTNode] to write an AST template. See [BindASTTemplate] for more information.
+func ASTVariable(name string) any {
+	return map[string]string{astVariableKey: name}
+}
+
+// ErrUnboundASTVariable indicates that an AST contains a template variable for which
+// there is no binding. The [ASTLoader] returns this error when loading an AST
+// template without instantiating it first.
+type ErrUnboundASTVariable struct {
+	// Name is the name of the variable.
+	Name string
+}
+
+// Error implements error.
+func (err *ErrUnboundASTVariable) Error() string {
+	return fmt.Sprintf("dsl: unbound AST variable: %s", err.Name)
+}
+
+// BindASTTemplate instantiates an AST template by replacing each template variable (see
+// [ASTVariable]) in the arguments of the nodes with the value of the corresponding binding. We
+// return an [*ErrUnboundASTVariable] if a variable has no binding, while we ignore bindings
+// not referenced by the template. We do not modify the template and we do not replace the
+// variables inside the template of a nested [Bind] node, which has its own bindings.
+func BindASTTemplate(template *LoadableASTNode, bindings map[string]any) (*LoadableASTNode, error) {
+	if template == nil {
+		return nil, ErrNilASTNode
+	}
+	arguments, err := bindASTArguments(template.Arguments, bindings)
+	if err != nil {
+		return nil, err
+	}
+	children := []*LoadableASTNode{}
+	if name, _, _ := parseVersionedStageName(template.StageName); name == bindStageName {
+		children = template.Children
+	} else {
+		for _, child := range template.Children {
+			instance, err := BindASTTemplate(child, bindings)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, instance)
+		}
+	}
+	output := &LoadableASTNode{
+		StageName: template.StageName,
+		Arguments: arguments,
+		Children:  children,
+	}
+	return output, nil
+}
+
+// bindASTArguments replaces the template variables in the given JSON-serialized arguments.
+func bindASTArguments(arguments json.RawMessage, bindings map[string]any) (json.RawMessage, error) {
+	if !bytes.Contains(arguments, []byte(astVariableKey)) {
+		return arguments, nil // fast path: no variables
+	}
+	value, err := decodeASTArguments(arguments)
+	if err != nil {
+		return nil, err
+	}
+	value, err = bindASTValue(value, bindings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// decodeASTArguments decodes the arguments preserving the numbers.
+func decodeASTArguments(arguments json.RawMessage) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(arguments))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// bindASTValue recursively replaces the template variables in the given value.
+func bindASTValue(value any, bindings map[string]any) (any, error) {
+	if name, found := astVariableName(value); found {
+		binding, found := bindings[name]
+		if !found {
+			return nil, &ErrUnboundASTVariable{name}
+		}
+		return binding, nil
+	}
+	switch value := value.(type) {
+	case []any:
+		output := []any{}
+		for _, entry := range value {
+			entry, err := bindASTValue(entry, bindings)
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, entry)
+		}
+		return output, nil
+	case map[string]any:
+		output := map[string]any{}
+		for key, entry := range value {
+			entry, err := bindASTValue(entry, bindings)
+			if err != nil {
+				return nil, err
+			}
+			output[key] = entry
+		}
+		return output, nil
+	default:
+		return value, nil
+	}
+}
+
+// astVariableName returns the name of the variable if the value is a template variable.
+func astVariableName(value any) (string, bool) {
+	object, good := value.(map[string]any)
+	if !good || len(object) != 1 {
+		return "", false
+	}
+	name, good := object[astVariableKey].(string)
+	return name, good
+}
+
+// checkUnboundASTVariables returns an [*ErrUnboundASTVariable] if the arguments
+// of the given node contain a template variable.
+func checkUnboundASTVariables(node *LoadableASTNode) error {
+	_, err := bindASTArguments(node.Arguments, nil)
+	var varErr *ErrUnboundASTVariable
+	if errors.As(err, &varErr) {
+		return err
+	}
+	// Note: we let the rule report any other error when it parses the arguments
+	return nil
+}
+
+// LoadTemplate instantiates the given AST template using [BindASTTemplate] and then
+// loads the resulting AST using [ASTLoader.Load].
+func (al *ASTLoader) LoadTemplate(template *LoadableASTNode, bindings map[string]any) (RunnableASTNode, error) {
+	node, err := BindASTTemplate(template, bindings)
+	if err != nil {
+		return nil, err
+	}
+	return al.Load(node)
+}
+
+// Bind returns the [SerializableASTNode] of a stage that instantiates the given AST template
+// once for each of the given bindings (see [BindASTTemplate]) and runs the resulting stages in
+// parallel like [RunStagesInParallelWithParallelism] does. Therefore, the template must be a
+// stage that reads and returns [*Void]. A zero or negative parallelism means using the default.
+//
+// When loading, the [ASTLoaderLimits] apply to the template and to each instance rather than to
+// the whole expanded AST. Additionally, the MaxBindings and MaxBindNodes limits bound the number
+// of instances. With the [DefaultASTLoaderLimits], a [Bind] node may therefore contain up to
+// 4096 bindings for templates containing at most 15 nodes, while for larger templates the
+// maximum number of bindings is about 65536 divided by the number of template nodes (e.g.,
+// 2047 bindings for a template containing 32 nodes).
+//
+// The ASTNode method of the [RunnableASTNode] we load from a [Bind] node returns the original
+// node (i.e., the template and the bindings) rather than the expanded AST.
+//
+// This functionality allows the backend to serve a single measurement pipeline along with the
+// list of targets to measure (e.g., domain names and ports), rather than serving a copy of the
+// pipeline for each target. Because we cannot type check a template before instantiating it, there
+// is no corresponding [Stage] and you should use [ASTVariable] to write templates.
+func Bind(template *SerializableASTNode, parallelism int, bindings ...map[string]any) *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: bindStageName,
+		Arguments: &bindArguments{
+			Bindings:    bindings,
+			Parallelism: parallelism,
+		},
+		Children: []*SerializableASTNode{template},
+	}
+}
+
+const bindStageName = "bind"
+
+// bindArguments contains the arguments of the bind stage.
+type bindArguments struct {
+	// Bindings contains the bindings for each template instance.
+	Bindings []map[string]any `json:"bindings"`
+
+	// Parallelism is the number of background goroutines to use.
+	Parallelism int `json:"parallelism,omitempty"`
+}
+
+type bindLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*bindLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	// Note: we preserve the numbers in the bindings, which may be large integers
+	var config bindArguments
+	decoder := json.NewDecoder(bytes.NewReader(node.Arguments))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
+		return nil, err
+	}
+	if err := bindCheckLimits(loader.Limits(), node, &config); err != nil {
+		return nil, err
+	}
+
+	// Note: we load each instance separately, such that the limits apply to each
+	// instance, and we check that all the instances read and return *Void
+	expanded := &LoadableASTNode{
+		StageName: runStagesInParallelStageName,
+		Children:  []*LoadableASTNode{},
+	}
+	runnables := []RunnableASTNode{}
+	for idx, bindings := range config.Bindings {
+		instance, err := BindASTTemplate(node.Children[0], bindings)
+		if err != nil {
+			return nil, err
+		}
+		expanded.Children = append(expanded.Children, instance)
+		runnable, err := loader.Load(instance)
+		if err != nil {
+			var typeErr *ErrASTTypeCheck
+			if errors.As(err, &typeErr) {
+				typeErr.prependPath(astChildPathElem(idx))
+			}
+			return nil, err
+		}
+		runnables = append(runnables, runnable)
+	}
+	if err := CheckChildrenTypes[*Void, *Void](expanded, runnables...); err != nil {
+		return nil, err
+	}
+	children := RunnableASTNodeListToStageList[*Void, *Void](runnables...)
+	stage := RunStagesInParallelWithParallelism(config.Parallelism, children...)
+	runnable := &bindRunnableASTNode{
+		StageRunnableASTNode: &StageRunnableASTNode[*Void, *Void]{S: stage},
+		node:                 newSerializableASTNode(node),
+	}
+	return runnable, nil
+}
+
+// bindRunnableASTNode is the [RunnableASTNode] returned by the bind rule, which runs the
+// expanded stage but returns the original bind node (i.e., the template and the bindings)
+// as its AST node, such that serializing the loaded AST does not expand the template.
+type bindRunnableASTNode struct {
+	*StageRunnableASTNode[*Void, *Void]
+	node *SerializableASTNode
+}
+
+var _ TypedRunnableASTNode = &bindRunnableASTNode{}
+
+// ASTNode implements RunnableASTNode.
+func (n *bindRunnableASTNode) ASTNode() *SerializableASTNode {
+	return n.node
+}
+
+// isBindNode returns whether the given node is a bind node.
+func isBindNode(node *LoadableASTNode) bool {
+	name, _, _ := parseVersionedStageName(node.StageName)
+	return name == bindStageName
+}
+
+// bindCheckLimits checks whether the given bind node exceeds the limits.
+func bindCheckLimits(limits ASTLoaderLimits, node *LoadableASTNode, config *bindArguments) error {
+	count := len(config.Bindings)
+	if exceedsLimit(count, limits.MaxBindings) {
+		return &ErrASTLimitExceeded{"MaxBindings", count, limits.MaxBindings}
+	}
+	if limits.MaxArgumentsSize > 0 {
+		maxSize := limits.MaxArgumentsSize
+		if count > 1 {
+			maxSize *= count
+		}
+		if size := len(node.Arguments); size > maxSize {
+			return &ErrASTLimitExceeded{"MaxArgumentsSize", size, maxSize}
+		}
+	}
+	if limits.MaxBindNodes > 0 {
+		if nodes := bindExpandedNodes(node, limits.MaxBindNodes); nodes > limits.MaxBindNodes {
+			return &ErrASTLimitExceeded{"MaxBindNodes", nodes, limits.MaxBindNodes}
+		}
+	}
+	if exceedsLimit(config.Parallelism, limits.MaxParallelism) {
+		return &ErrASTLimitExceeded{"MaxParallelism", config.Parallelism, limits.MaxParallelism}
+	}
+	return nil
+}
+
+// bindExpandedNodes returns the number of nodes of the AST rooted at the given node once we
+// have expanded all the bind nodes. We stop counting as soon as we exceed the given limit, which
+// must be positive, therefore the returned value is only exact when it does not exceed the limit.
+func bindExpandedNodes(node *LoadableASTNode, limit int) int {
+	if node == nil {
+		return 0
+	}
+	if isBindNode(node) {
+		// Note: a bind node becomes a run_stages_in_parallel node containing an instance of
+		// the template for each binding and the bind rule reports invalid arguments
+		var config struct {
+			Bindings []json.RawMessage `json:"bindings"`
+		}
+		_ = json.Unmarshal(node.Arguments, &config)
+		count := 1
+		for _, child := range node.Children {
+			nodes := bindExpandedNodes(child, limit)
+			if nodes > 0 && len(config.Bindings) > (limit-count)/nodes {
+				return limit + 1 // avoid overflowing
+			}
+			count += nodes * len(config.Bindings)
+		}
+		return count
+	}
+	count := 1
+	for _, child := range node.Children {
+		if count > limit {
+			break
+		}
+		count += bindExpandedNodes(child, limit)
+	}
+	return count
+}
+
+// StageName implements ASTLoaderRule.
+func (*bindLoader) StageName() string {
+	return bindStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*bindLoader) StageArguments() any {
+	return &bindArguments{}
+}
@@ -0,0 +1,298 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// templateTestLoadable converts a [SerializableASTNode] to a [LoadableASTNode].
+func templateTestLoadable(t *testing.T, node *SerializableASTNode) *LoadableASTNode {
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loadable LoadableASTNode
+	if err := json.Unmarshal(data, &loadable); err != nil {
+		t.Fatal(err)
+	}
+	return &loadable
+}
+
+// templateTestDomainName returns a domain_name node using the given variable.
+func templateTestDomainName(name string) *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: domainNameStageName,
+		Arguments: map[string]any{"domain": ASTVariable(name)},
+		Children:  []*SerializableASTNode{},
+	}
+}
+
+// templateTestPipeline returns a template that reads and returns *Void.
+func templateTestPipeline() *SerializableASTNode {
+	return &SerializableASTNode{
+		StageName: composeStageName,
+		Arguments: nil,
+		Children: []*SerializableASTNode{
+			templateTestDomainName("domain"),
+			Discard[string]().ASTNode(),
+		},
+	}
+}
+
+func TestBindASTTemplate(t *testing.T) {
+	t.Run("we replace the variables", func(t *testing.T) {
+		template := templateTestLoadable(t, templateTestPipeline())
+		instance, err := BindASTTemplate(template, map[string]any{"domain": "www.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Compose(DomainName("www.example.com"), Discard[string]()).ASTNode()
+		if diff := cmp.Diff(textTestCanonicalSerializable(t, expected), textTestCanonicalLoadable(t, instance)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we do not modify the template", func(t *testing.T) {
+		template := templateTestLoadable(t, templateTestPipeline())
+		before := textTestCanonicalLoadable(t, template)
+		if _, err := BindASTTemplate(template, map[string]any{"domain": "www.example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(before, textTestCanonicalLoadable(t, template)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we fail when a variable is not bound", func(t *testing.T) {
+		template := templateTestLoadable(t, templateTestPipeline())
+		var varErr *ErrUnboundASTVariable
+		if _, err := BindASTTemplate(template, map[string]any{"port": 443}); !errors.As(err, &varErr) || varErr.Name != "domain" {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we do not replace the variables of a nested template", func(t *testing.T) {
+		template := templateTestLoadable(t, Bind(templateTestPipeline(), 0, map[string]any{
+			"domain": ASTVariable("outer"),
+		}))
+		instance, err := BindASTTemplate(template, map[string]any{"outer": "www.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Bind(templateTestPipeline(), 0, map[string]any{"domain": "www.example.com"})
+		if diff := cmp.Diff(textTestCanonicalSerializable(t, expected), textTestCanonicalLoadable(t, instance)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestASTLoaderLoadTemplate(t *testing.T) {
+	template := templateTestLoadable(t, templateTestDomainName("domain"))
+
+	t.Run("we cannot load a template without bindings", func(t *testing.T) {
+		var varErr *ErrUnboundASTVariable
+		if _, err := NewASTLoader().Load(template); !errors.As(err, &varErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we can load and run an instance", func(t *testing.T) {
+		runnable, err := NewASTLoader().LoadTemplate(template, map[string]any{"domain": "www.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := runnable.Run(context.Background(), NewMinimalRuntime(log.Log), NewValue[any](&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		if output.Value != "www.example.com" {
+			t.Fatal("unexpected value", output.Value)
+		}
+	})
+}
+
+func TestBind(t *testing.T) {
+	bindings := []map[string]any{
+		{"domain": "www.example.com"},
+		{"domain": "www.example.org"},
+	}
+
+	t.Run("we expand the template", func(t *testing.T) {
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 3, bindings...))
+		recorder := &interceptorTestRecorder{}
+		rtx := NewMinimalRuntime(log.Log, RuntimeOptionStageInterceptor(recorder))
+		runnable, err := NewASTLoader().Load(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := runnable.Run(context.Background(), rtx, NewValue[any](&Void{}))
+		if output.Error != nil {
+			t.Fatal(output.Error)
+		}
+		count := 0
+		for _, event := range recorder.events {
+			if event == "before "+domainNameStageName {
+				count++
+			}
+		}
+		if count != len(bindings) {
+			t.Fatal("expected one instance per binding, got", count)
+		}
+	})
+
+	t.Run("the loaded AST node is the original bind node", func(t *testing.T) {
+		original := Bind(templateTestPipeline(), 3, bindings...)
+		runnable, err := NewASTLoader().Load(templateTestLoadable(t, original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := textTestCanonicalSerializable(t, original)
+		if diff := cmp.Diff(expected, textTestCanonicalSerializable(t, runnable.ASTNode())); diff != "" {
+			t.Fatal(diff)
+		}
+
+		// make sure we can serialize and load the loaded AST node again
+		reloaded, err := NewASTLoader().Load(templateTestLoadable(t, runnable.ASTNode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, textTestCanonicalSerializable(t, reloaded.ASTNode())); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("we preserve large integers in the bindings", func(t *testing.T) {
+		node := &LoadableASTNode{
+			StageName: bindStageName,
+			Arguments: []byte(`{"bindings":[{"value":18446744073709551615}]}`),
+			Children: []*LoadableASTNode{{
+				StageName: "value_test",
+				Arguments: []byte(`{"value":{"$var":"value"}}`),
+			}},
+		}
+		loader := NewASTLoader()
+		var got json.RawMessage
+		loader.RegisterCustomLoaderRule(&templateTestCaptureLoader{&got})
+		if _, err := loader.Load(node); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != `{"value":18446744073709551615}` {
+			t.Fatal("unexpected arguments", string(got))
+		}
+	})
+
+	t.Run("we check the limits of the template", func(t *testing.T) {
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, bindings...))
+		loader := NewASTLoader(ASTLoaderOptionMaxNodes(3))
+		templateTestRequireLimitExceeded(t, loader, node, "MaxNodes")
+	})
+
+	t.Run("we check the types of the instances", func(t *testing.T) {
+		node := templateTestLoadable(t, Bind(templateTestDomainName("domain"), 0, bindings...))
+		var typeErr *ErrASTTypeCheck
+		if _, err := NewASTLoader().Load(node); !errors.As(err, &typeErr) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we require exactly one template", func(t *testing.T) {
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, bindings...))
+		node.Children = append(node.Children, node.Children[0])
+		if _, err := NewASTLoader().Load(node); !errors.Is(err, ErrInvalidNumberOfChildren) {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
+
+// templateTestRequireLimitExceeded requires loading the node to fail with the given limit.
+func templateTestRequireLimitExceeded(t *testing.T, loader *ASTLoader, node *LoadableASTNode, limit string) {
+	var limitErr *ErrASTLimitExceeded
+	if _, err := loader.Load(node); !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatal("expected", limit, "got", err)
+	}
+}
+
+// templateTestBindings returns the given number of bindings for the domain variable.
+func templateTestBindings(count int) (bindings []map[string]any) {
+	for idx := 0; idx < count; idx++ {
+		bindings = append(bindings, map[string]any{"domain": fmt.Sprintf("www%d.example.com", idx)})
+	}
+	return
+}
+
+func TestBindLimits(t *testing.T) {
+	t.Run("the limits apply to each instance rather than to the expanded AST", func(t *testing.T) {
+		// the expanded AST contains more than MaxChildren children and MaxNodes nodes
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, templateTestBindings(2000)...))
+		runnable, err := NewASTLoader().Load(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expanded := runnable.(*bindRunnableASTNode).S.ASTNode()
+		if count := len(expanded.Children); count != 2000 {
+			t.Fatal("expected 2000 instances, got", count)
+		}
+	})
+
+	t.Run("with the default limits we accept up to MaxBindings bindings", func(t *testing.T) {
+		limits := DefaultASTLoaderLimits()
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, templateTestBindings(limits.MaxBindings)...))
+		if _, err := NewASTLoader().Load(node); err != nil {
+			t.Fatal(err)
+		}
+		node = templateTestLoadable(t, Bind(templateTestPipeline(), 0, templateTestBindings(limits.MaxBindings+1)...))
+		templateTestRequireLimitExceeded(t, NewASTLoader(), node, "MaxBindings")
+	})
+
+	t.Run("we limit the number of nodes of the expanded AST", func(t *testing.T) {
+		// the expanded AST contains one node plus three nodes for each binding
+		loader := NewASTLoader(ASTLoaderOptionMaxBindNodes(31))
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, templateTestBindings(10)...))
+		if _, err := loader.Load(node); err != nil {
+			t.Fatal(err)
+		}
+		node = templateTestLoadable(t, Bind(templateTestPipeline(), 0, templateTestBindings(11)...))
+		templateTestRequireLimitExceeded(t, loader, node, "MaxBindNodes")
+	})
+
+	t.Run("we account for nested bind nodes", func(t *testing.T) {
+		inner := Bind(templateTestPipeline(), 0, templateTestBindings(100)...)
+		outer := Bind(inner, 0, templateTestBindings(1000)...)
+		templateTestRequireLimitExceeded(t, NewASTLoader(), templateTestLoadable(t, outer), "MaxBindNodes")
+	})
+
+	t.Run("we limit the average size of the bindings", func(t *testing.T) {
+		bindings := templateTestBindings(2)
+		bindings[0]["padding"] = strings.Repeat("x", 3<<16)
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 0, bindings...))
+		templateTestRequireLimitExceeded(t, NewASTLoader(), node, "MaxArgumentsSize")
+	})
+
+	t.Run("we limit the parallelism", func(t *testing.T) {
+		node := templateTestLoadable(t, Bind(templateTestPipeline(), 1<<20, templateTestBindings(2)...))
+		templateTestRequireLimitExceeded(t, NewASTLoader(), node, "MaxParallelism")
+	})
+}
+
+// templateTestCaptureLoader is a loader rule capturing the arguments of the node.
+type templateTestCaptureLoader struct {
+	arguments *json.RawMessage
+}
+
+// Load implements ASTLoaderRule.
+func (lx *templateTestCaptureLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	*lx.arguments = node.Arguments
+	return &StageRunnableASTNode[*Void, *Void]{S: &Identity[*Void]{}}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*templateTestCaptureLoader) StageName() string {
+	return "value_test"
+}
@@ -0,0 +1,619 @@
+package dsl
+
+//
+// Human-readable text syntax
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The text syntax is a compact, human-readable representation of an AST. For example, the
+// following text measures the HTTPS endpoints of a domain:
+//
+//	domain("www.example.com")
+//	| getaddrinfo()
+//	| endpoints(443)
+//	| each(
+//	    tcp_connect(timeout=10s)
+//	    | tls_handshake()
+//	    | http_connection_tls()
+//	    | http_transaction(include_response_body_snapshot=true)
+//	    | discard()
+//	  )
+//
+// The grammar is the following:
+//
+//	pipeline := primary [ "|" pipeline ]
+//	primary  := "(" pipeline ")" | call
+//	call     := name [ "@" integer ] "(" [ item { "," item } [ "," ] ] ")"
+//	item     := name "=" value | pipeline | value
+//	value    := string | number | duration | "true" | "false" | "null" | array | object
+//	array    := "[" [ value { "," value } [ "," ] ] "]"
+//	object   := "{" [ string ":" value { "," string ":" value } [ "," ] ] "}"
+//
+// where a pipeline "a | b" is equivalent to "compose(a, b)" and the "|" operator is right
+// associative, like [Compose3] and the other Compose functions. The name of a call is a stage
+// name (optionally followed by the stage version, see [VersionedStageName]) and each item is
+// either an argument (name "=" value) or a child node. Strings and numbers use the JSON syntax
+// and a duration (e.g., 10s or 250ms) is a [Duration], which we serialize as a string. We skip
+// comments, which start with "#" and extend to the end of the line.
+//
+// For convenience, the parser also accepts the following aliases, and, for aliases, a
+// value without a name is the value of the argument indicated below:
+//
+//	domain("x")      domain_name(domain="x")
+//	domains(["x"])   domain_names(domains=["x"])
+//	getaddrinfo()    dns_lookup_getaddrinfo()
+//	endpoints(443)   make_endpoints_for_port(port=443)
+//	each(p)          new_endpoint_pipeline(p)
+
+// textAlias is an alias of a stage name in the text syntax.
+type textAlias struct {
+	// stageName is the aliased stage name.
+	stageName string
+
+	// positional is the name of the argument set by a value without name, if any.
+	positional string
+}
+
+// textAliases contains the aliases accepted by [ParseASTText].
+var textAliases = map[string]textAlias{
+	"domain":      {stageName: domainNameStageName, positional: "domain"},
+	"domains":     {stageName: domainNamesStageName, positional: "domains"},
+	"each":        {stageName: newEndpointPipelineStageName},
+	"endpoints":   {stageName: makeEndpointsForPortStageName, positional: "port"},
+	"getaddrinfo": {stageName: dnsLookupGetaddrinfoStageName},
+}
+
+// ErrASTSyntax is the error returned by [ParseASTText] for invalid text.
+type ErrASTSyntax struct {
+	// Line is the line where we found the error (starting from 1).
+	Line int
+
+	// Column is the column where we found the error (starting from 1).
+	Column int
+
+	// Message describes the error.
+	Message string
+}
+
+// Error implements error.
+func (err *ErrASTSyntax) Error() string {
+	return fmt.Sprintf("dsl: syntax error at %d:%d: %s", err.Line, err.Column, err.Message)
+}
+
+// ParseASTText parses the text syntax of an AST and returns the corresponding [LoadableASTNode].
+func ParseASTText(text string) (*LoadableASTNode, error) {
+	parser := &textParser{input: text, offset: 0}
+	node, err := parser.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	if tok := parser.peek(); tok.kind != textTokenEOF {
+		return nil, parser.errorf(tok, "unexpected %s after the end of the pipeline", tok)
+	}
+	return node, nil
+}
+
+// textTokenKind is the kind of a [textToken].
+type textTokenKind int
+
+const (
+	textTokenEOF = textTokenKind(iota)
+	textTokenName
+	textTokenString
+	textTokenNumber
+	textTokenDuration
+	textTokenPunct
+	textTokenInvalid
+)
+
+// textToken is a token of the text syntax.
+type textToken struct {
+	kind   textTokenKind
+	value  string
+	offset int
+}
+
+// String implements fmt.Stringer.
+func (tok textToken) String() string {
+	if tok.kind == textTokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(tok.value)
+}
+
+// textParser is the parser of the text syntax.
+type textParser struct {
+	input  string
+	offset int
+}
+
+// errorf creates an [*ErrASTSyntax] at the position of the given token.
+func (p *textParser) errorf(tok textToken, format string, args ...any) error {
+	line, column := 1, 1
+	for _, r := range p.input[:tok.offset] {
+		if r == '\n' {
+			line, column = line+1, 1
+			continue
+		}
+		column++
+	}
+	return &ErrASTSyntax{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// skipSpaceAndComments skips whitespace and comments.
+func (p *textParser) skipSpaceAndComments() {
+	for p.offset < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.offset:])
+		switch {
+		case r == '#':
+			end := strings.IndexByte(p.input[p.offset:], '\n')
+			if end < 0 {
+				p.offset = len(p.input)
+				return
+			}
+			p.offset += end
+		case unicode.IsSpace(r):
+			p.offset += size
+		default:
+			return
+		}
+	}
+}
+
+// scan scans the next token starting at the current offset and returns the token
+// along with the offset immediately following the token.
+func (p *textParser) scan() (textToken, int) {
+	p.skipSpaceAndComments()
+	start := p.offset
+	if start >= len(p.input) {
+		return textToken{kind: textTokenEOF, offset: start}, start
+	}
+	ch := p.input[start]
+	switch {
+	case ch == '"':
+		end := start + 1
+		for end < len(p.input) && p.input[end] != '"' {
+			if p.input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.input) {
+			return textToken{kind: textTokenInvalid, value: p.input[start:], offset: start}, len(p.input)
+		}
+		return textToken{kind: textTokenString, value: p.input[start : end+1], offset: start}, end + 1
+
+	case ch == '-' || ch == '+' || ch == '.' || isTextDigit(ch):
+		end := start + 1
+		for end < len(p.input) && strings.IndexByte("0123456789.eE+-", p.input[end]) >= 0 {
+			end++
+		}
+		kind := textTokenNumber
+		for end < len(p.input) && isTextNameChar(p.input[end]) {
+			kind = textTokenDuration
+			end++
+		}
+		return textToken{kind: kind, value: p.input[start:end], offset: start}, end
+
+	case isTextNameChar(ch):
+		end := start + 1
+		for end < len(p.input) && isTextNameChar(p.input[end]) {
+			end++
+		}
+		return textToken{kind: textTokenName, value: p.input[start:end], offset: start}, end
+
+	case strings.IndexByte("()[]{},=|:@", ch) >= 0:
+		return textToken{kind: textTokenPunct, value: p.input[start : start+1], offset: start}, start + 1
+
+	default:
+		_, size := utf8.DecodeRuneInString(p.input[start:])
+		return textToken{kind: textTokenInvalid, value: p.input[start : start+size], offset: start}, start + size
+	}
+}
+
+// isTextDigit returns whether ch is a decimal digit.
+func isTextDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isTextNameChar returns whether ch may appear inside a name.
+func isTextNameChar(ch byte) bool {
+	return ch == '_' || isTextDigit(ch) || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// peek returns the next token without consuming it.
+func (p *textParser) peek() textToken {
+	saved := p.offset
+	tok, _ := p.scan()
+	p.offset = saved
+	return tok
+}
+
+// peekSecond returns the token following the next token without consuming them.
+func (p *textParser) peekSecond() textToken {
+	saved := p.offset
+	_, p.offset = p.scan()
+	tok, _ := p.scan()
+	p.offset = saved
+	return tok
+}
+
+// next consumes and returns the next token.
+func (p *textParser) next() textToken {
+	tok, end := p.scan()
+	p.offset = end
+	return tok
+}
+
+// isPunct returns whether tok is the given punctuation.
+func (tok textToken) isPunct(value string) bool {
+	return tok.kind == textTokenPunct && tok.value == value
+}
+
+// expect consumes the next token and fails unless it is the given punctuation.
+func (p *textParser) expect(value string) error {
+	if tok := p.next(); !tok.isPunct(value) {
+		return p.errorf(tok, "expected %q but found %s", value, tok)
+	}
+	return nil
+}
+
+// parsePipeline parses a pipeline.
+func (p *textParser) parsePipeline() (*LoadableASTNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peek().isPunct("|") {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	return &LoadableASTNode{
+		StageName: composeStageName,
+		Arguments: json.RawMessage(`{}`),
+		Children:  []*LoadableASTNode{left, right},
+	}, nil
+}
+
+// parsePrimary parses a parenthesized pipeline or a call.
+func (p *textParser) parsePrimary() (*LoadableASTNode, error) {
+	if p.peek().isPunct("(") {
+		p.next()
+		node, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return p.parseCall()
+}
+
+// parseCall parses a call.
+func (p *textParser) parseCall() (*LoadableASTNode, error) {
+	nameTok := p.next()
+	if nameTok.kind != textTokenName {
+		return nil, p.errorf(nameTok, "expected a stage name but found %s", nameTok)
+	}
+	stageName, positional := nameTok.value, ""
+	if alias, found := textAliases[stageName]; found {
+		stageName, positional = alias.stageName, alias.positional
+	}
+
+	if p.peek().isPunct("@") {
+		p.next()
+		versionTok := p.next()
+		version, err := strconv.Atoi(versionTok.value)
+		if versionTok.kind != textTokenNumber || err != nil || version < 1 {
+			return nil, p.errorf(versionTok, "expected a stage version but found %s", versionTok)
+		}
+		stageName = VersionedStageName(stageName, version)
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arguments := map[string]any{}
+	children := []*LoadableASTNode{}
+	for !p.peek().isPunct(")") {
+		tok := p.peek()
+		switch {
+		case tok.kind == textTokenName && p.peekSecond().isPunct("="):
+			p.next()
+			p.next()
+			if _, found := arguments[tok.value]; found {
+				return nil, p.errorf(tok, "duplicate argument %s", tok)
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			arguments[tok.value] = value
+
+		case tok.isPunct("(") || (tok.kind == textTokenName && !isTextLiteralName(tok.value)):
+			child, err := p.parsePipeline()
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+
+		default:
+			if positional == "" {
+				return nil, p.errorf(tok, "%s does not accept a value without name", nameTok)
+			}
+			if _, found := arguments[positional]; found {
+				return nil, p.errorf(tok, "duplicate argument %q", positional)
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			arguments[positional] = value
+		}
+		if !p.peek().isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	rawArguments, err := json.Marshal(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadableASTNode{
+		StageName: stageName,
+		Arguments: rawArguments,
+		Children:  children,
+	}, nil
+}
+
+// isTextLiteralName returns whether the given name is a literal value.
+func isTextLiteralName(name string) bool {
+	return name == "true" || name == "false" || name == "null"
+}
+
+// parseValue parses a value.
+func (p *textParser) parseValue() (any, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == textTokenString:
+		var value string
+		if err := json.Unmarshal([]byte(tok.value), &value); err != nil {
+			return nil, p.errorf(tok, "invalid string %s", tok)
+		}
+		return value, nil
+
+	case tok.kind == textTokenNumber:
+		if !json.Valid([]byte(tok.value)) {
+			return nil, p.errorf(tok, "invalid number %s", tok)
+		}
+		return json.Number(tok.value), nil
+
+	case tok.kind == textTokenDuration:
+		duration, err := time.ParseDuration(tok.value)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid duration %s", tok)
+		}
+		return duration.String(), nil
+
+	case tok.kind == textTokenName && tok.value == "true":
+		return true, nil
+
+	case tok.kind == textTokenName && tok.value == "false":
+		return false, nil
+
+	case tok.kind == textTokenName && tok.value == "null":
+		return nil, nil
+
+	case tok.isPunct("["):
+		values := []any{}
+		for !p.peek().isPunct("]") {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !p.peek().isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return values, nil
+
+	case tok.isPunct("{"):
+		object := map[string]any{}
+		for !p.peek().isPunct("}") {
+			key, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			skey, good := key.(string)
+			if !good {
+				return nil, p.errorf(tok, "object keys must be strings")
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			object[skey] = value
+			if !p.peek().isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect("}"); err != nil {
+			return nil, err
+		}
+		return object, nil
+
+	default:
+		return nil, p.errorf(tok, "expected a value but found %s", tok)
+	}
+}
+
+// FormatASTText returns the text syntax of the given [SerializableASTNode]. We format the
+// canonical form of the AST (see [CanonicalizeASTJSON]), therefore, parsing the text with
+// [ParseASTText] produces an AST with the same canonical form as the original AST.
+func FormatASTText(node *SerializableASTNode) (string, error) {
+	data, err := CanonicalASTJSON(node)
+	if err != nil {
+		return "", err
+	}
+	var canonical textFormatNode
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&canonical); err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	if err := canonical.format(&builder, ""); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// textFormatNode is the canonical AST node used by [FormatASTText].
+type textFormatNode struct {
+	StageName string            `json:"stage_name"`
+	Arguments any               `json:"arguments"`
+	Children  []*textFormatNode `json:"children"`
+}
+
+// isCompose returns whether the node is a composition of two nodes.
+func (n *textFormatNode) isCompose() bool {
+	return n.StageName == composeStageName && len(n.Children) == 2 && isDefaultJSONValue(n.Arguments)
+}
+
+// format writes the text syntax of the node using the given indentation.
+func (n *textFormatNode) format(builder *strings.Builder, indent string) error {
+	if n.isCompose() {
+		// Note: the "|" operator is right associative, so we need parentheses
+		// only when the left child is itself a composition
+		left, right := n.Children[0], n.Children[1]
+		if left.isCompose() {
+			builder.WriteString("(")
+			if err := left.format(builder, indent+" "); err != nil {
+				return err
+			}
+			builder.WriteString(")")
+		} else if err := left.format(builder, indent+"  "); err != nil {
+			return err
+		}
+		builder.WriteString("\n" + indent + "| ")
+		if right.isCompose() {
+			return right.format(builder, indent)
+		}
+		return right.format(builder, indent+"  ")
+	}
+
+	name, version, err := parseVersionedStageName(n.StageName)
+	if err != nil || !isTextName(name) || VersionedStageName(name, version) != n.StageName {
+		return fmt.Errorf("dsl: cannot format stage name %q", n.StageName)
+	}
+	arguments, good := n.Arguments.(map[string]any)
+	if !good {
+		return fmt.Errorf("dsl: %s: cannot format non-object arguments", n.StageName)
+	}
+	builder.WriteString(n.StageName + "(")
+	keys := []string{}
+	for key := range arguments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for idx, key := range keys {
+		if !isTextName(key) {
+			return fmt.Errorf("dsl: %s: cannot format argument %q", n.StageName, key)
+		}
+		value, err := formatTextValue(arguments[key])
+		if err != nil {
+			return err
+		}
+		if idx > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(key + "=" + value)
+	}
+	if len(n.Children) > 0 {
+		if len(keys) > 0 {
+			builder.WriteString(",")
+		}
+		for _, child := range n.Children {
+			builder.WriteString("\n" + indent + "  ")
+			if err := child.format(builder, indent+"  "); err != nil {
+				return err
+			}
+			builder.WriteString(",")
+		}
+		builder.WriteString("\n" + indent)
+	}
+	builder.WriteString(")")
+	return nil
+}
+
+// formatTextValue returns the text syntax of an argument value. We format a string containing
+// a duration as a duration literal (e.g., 10s) when parsing the literal produces the same string.
+func formatTextValue(value any) (string, error) {
+	if s, good := value.(string); good && isTextDurationLiteral(s) {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// isTextDurationLiteral returns whether the given string is a duration literal that the
+// parser maps back to the same string (e.g., 10s but not 10000ms, which becomes 10s).
+func isTextDurationLiteral(value string) bool {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration.String() != value {
+		return false
+	}
+	// Note: the parser only accepts ASCII literals, so we quote, e.g., 1.5µs
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isTextName returns whether the given string is a valid name in the text syntax.
+func isTextName(value string) bool {
+	if value == "" || isTextLiteralName(value) {
+		return false
+	}
+	for idx := 0; idx < len(value); idx++ {
+		if !isTextNameChar(value[idx]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tailscale/hujson"
+)
+
+// textTestCanonicalLoadable returns the canonical form of a [LoadableASTNode].
+func textTestCanonicalLoadable(t *testing.T, node *LoadableASTNode) string {
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := CanonicalizeASTJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(canonical)
+}
+
+// textTestCanonicalSerializable returns the canonical form of a [SerializableASTNode].
+func textTestCanonicalSerializable(t *testing.T, node *SerializableASTNode) string {
+	canonical, err := CanonicalASTJSON(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(canonical)
+}
+
+// textTestRoundTrip formats and parses the given AST and requires the result to be equivalent.
+func textTestRoundTrip(t *testing.T, node *SerializableASTNode) {
+	text, err := FormatASTText(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseASTText(text)
+	if err != nil {
+		t.Fatal(text, err)
+	}
+	if expected, got := textTestCanonicalSerializable(t, node), textTestCanonicalLoadable(t, parsed); expected != got {
+		t.Fatal(text, "\nexpected", expected, "\ngot", got)
+	}
+	if _, err := NewASTLoader().Load(parsed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseASTText(t *testing.T) {
+	t.Run("we parse the aliases and the pipeline operator", func(t *testing.T) {
+		text := `
+			# measure the HTTPS endpoints of www.example.com
+			domain("www.example.com")
+			| getaddrinfo()
+			| endpoints(443)
+			| each(tcp_connect@2(timeout=10s, tags=["tcp"]) | tls_handshake() | discard())
+		`
+		expected := Compose4(
+			DomainName("www.example.com"),
+			DNSLookupGetaddrinfo(),
+			MakeEndpointsForPort(443),
+			NewEndpointPipeline(
+				Compose3(
+					TCPConnect(TCPConnectOptionTimeout(10*time.Second), TCPConnectOptionTags("tcp")),
+					TLSHandshake(),
+					Discard[*TLSConnection](),
+				),
+			),
+		)
+		node, err := ParseASTText(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := textTestCanonicalLoadable(t, node), textTestCanonicalSerializable(t, expected.ASTNode()); got != want {
+			t.Fatal("expected", want, "\ngot", got)
+		}
+	})
+
+	t.Run("we parse parentheses, versions, and JSON values", func(t *testing.T) {
+		text := `(identity() | identity()) | capabilities_test@2(value="x", extra={"a": [1, 2.5, null, true]},)`
+		node, err := ParseASTText(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"arguments":{},"children":[` +
+			`{"arguments":{},"children":[` +
+			`{"arguments":{},"children":[],"stage_name":"identity"},` +
+			`{"arguments":{},"children":[],"stage_name":"identity"}],"stage_name":"compose"},` +
+			`{"arguments":{"extra":{"a":[1,2.5,null,true]},"value":"x"},"children":[],"stage_name":"capabilities_test@2"}` +
+			`],"stage_name":"compose"}`
+		if got := textTestCanonicalLoadable(t, node); got != expected {
+			t.Fatal("expected", expected, "\ngot", got)
+		}
+	})
+
+	t.Run("we report syntax errors with their position", func(t *testing.T) {
+		inputs := map[string][2]int{
+			``:                              {1, 1},
+			`tcp_connect(`:                  {1, 13},
+			"identity()\n| 17":              {2, 3},
+			`tcp_connect(timeout=1xs)`:      {1, 21},
+			`tcp_connect(10)`:               {1, 13},
+			`domain("a", "b")`:              {1, 13},
+			`tcp_connect(tags=1, tags=2)`:   {1, 21},
+			`tcp_connect(tags={1: 2})`:      {1, 18},
+			`tcp_connect() identity()`:      {1, 15},
+			`tls_handshake@0()`:             {1, 15},
+			`domain_name(domain="unclosed)`: {1, 20},
+		}
+		for input, position := range inputs {
+			_, err := ParseASTText(input)
+			var syntaxErr *ErrASTSyntax
+			if !errors.As(err, &syntaxErr) {
+				t.Fatal(input, err)
+			}
+			if syntaxErr.Line != position[0] || syntaxErr.Column != position[1] {
+				t.Fatal(input, syntaxErr)
+			}
+		}
+	})
+}
+
+func TestFormatASTText(t *testing.T) {
+	t.Run("we format pipelines and nested children", func(t *testing.T) {
+		stage := Compose3(
+			DomainName("www.example.com"),
+			DNSLookupParallel(
+				DNSLookupGetaddrinfo(),
+				DNSLookupUDP("8.8.8.8:53"),
+			),
+			MakeEndpointsForPort(443),
+		)
+		expected := `domain_name(domain="www.example.com")
+| dns_lookup_parallel(
+    dns_lookup_getaddrinfo(),
+    dns_lookup_udp(endpoint="8.8.8.8:53"),
+  )
+| make_endpoints_for_port(port=443)`
+		text, err := FormatASTText(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != expected {
+			t.Fatal("expected", expected, "\ngot", text)
+		}
+	})
+
+	t.Run("we format durations as duration literals", func(t *testing.T) {
+		stage := Compose(
+			WithTimeout(TCPConnect(TCPConnectOptionTimeout(1500*time.Millisecond)), time.Minute),
+			TLSHandshake(TLSHandshakeOptionTimeout(1500*time.Nanosecond)),
+		)
+		expected := `with_timeout(timeout=1m0s,
+    tcp_connect@2(timeout=1.5s),
+  )
+| tls_handshake@2(timeout="1.5µs")`
+		text, err := FormatASTText(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != expected {
+			t.Fatal("expected", expected, "\ngot", text)
+		}
+		textTestRoundTrip(t, stage.ASTNode())
+	})
+
+	t.Run("we reject ASTs that we cannot represent", func(t *testing.T) {
+		nodes := []*SerializableASTNode{
+			{StageName: "not a name"},
+			{StageName: "tcp_connect", Arguments: []int{1}},
+			{StageName: "tcp_connect", Arguments: map[string]any{"not a name": 1}},
+		}
+		for _, node := range nodes {
+			if _, err := FormatASTText(node); err == nil {
+				t.Fatal("expected an error for", node.StageName)
+			}
+		}
+	})
+}
+
+func TestASTTextRoundTrip(t *testing.T) {
+	t.Run("for ASTs generated using Go code", func(t *testing.T) {
+		stages := []Stage[*Void, *Void]{
+			Compose3(
+				DomainName("www.example.com"),
+				DNSLookupParallel(
+					DNSLookupGetaddrinfo(DNSLookupGetaddrinfoOptionTags("dns_getaddrinfo")),
+					DNSLookupUDP("8.8.8.8:53", DNSLookupUDPOptionTags("dns_udp")),
+				),
+				MeasureMultipleEndpoints(
+					Compose(
+						MakeEndpointsForPort(443),
+						NewEndpointPipeline(
+							Compose5(
+								TCPConnect(TCPConnectOptionTimeout(time.Second)),
+								TLSHandshake(TLSHandshakeOptionALPN("h2", "http/1.1")),
+								HTTPConnectionTLS(),
+								HTTPTransaction(HTTPTransactionOptionURLPath("/<&>")),
+								Discard[*HTTPResponse](),
+							),
+						),
+					),
+				),
+			),
+			Compose(
+				Compose(NewEndpoint("8.8.8.8:443"), TCPConnect()),
+				Discard[*TCPConnection](),
+			),
+		}
+		for _, stage := range stages {
+			textTestRoundTrip(t, stage.ASTNode())
+		}
+	})
+
+	t.Run("for the ASTs inside testdata", func(t *testing.T) {
+		data, err := os.ReadFile("../../testdata/minimaldsl.jsonc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = hujson.Standardize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node SerializableASTNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatal(err)
+		}
+		textTestRoundTrip(t, &node)
+
+		text, err := os.ReadFile("../../testdata/minimaldsl.dsl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseASTText(string(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := textTestCanonicalSerializable(t, &node), textTestCanonicalLoadable(t, parsed); expected != got {
+			t.Fatal("expected", expected, "\ngot", got)
+		}
+	})
+}
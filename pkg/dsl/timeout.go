@@ -0,0 +1,98 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// durationOrDefault returns the given value if positive and the default value otherwise.
+func durationOrDefault(value Duration, defaultValue time.Duration) time.Duration {
+	if value <= 0 {
+		return defaultValue
+	}
+	return time.Duration(value)
+}
+
+// WithTimeout returns a stage that runs the given stage bounding its total runtime with the
+// given timeout. Because we use a context with timeout, the timeout also bounds each operation
+// inside the stage, which still honors its own timeout when that is shorter. This allows the
+// backend to tune timeouts for a whole subtree depending on the country and the network. A
+// nettest running with a time budget can also wrap a loaded [RunnableASTNode] using this
+// function to pass its remaining budget to the DSL. A zero or negative timeout means that we
+// should not bound the runtime of the given stage.
+func WithTimeout[A, B any](stage Stage[A, B], timeout time.Duration) Stage[A, B] {
+	return &withTimeoutStage[A, B]{stage, timeout}
+}
+
+type withTimeoutStage[A, B any] struct {
+	stage   Stage[A, B]
+	timeout time.Duration
+}
+
+const withTimeoutStageName = "with_timeout"
+
+type withTimeoutStageArguments struct {
+	Timeout Duration `json:"timeout"`
+}
+
+// ASTNode implements Stage.
+func (sx *withTimeoutStage[A, B]) ASTNode() *SerializableASTNode {
+	// Note: we serialize the structure because this gives us forward compatibility (i.e., we
+	// may add a field to a future version without breaking the AST structure and old probes will
+	// be fine as long as the zero value of the new field is the default)
+	return &SerializableASTNode{
+		StageName: withTimeoutStageName,
+		Arguments: &withTimeoutStageArguments{Duration(sx.timeout)},
+		Children:  []*SerializableASTNode{sx.stage.ASTNode()},
+	}
+}
+
+type withTimeoutLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*withTimeoutLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	var config withTimeoutStageArguments
+	if err := json.Unmarshal(node.Arguments, &config); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 1); err != nil {
+		return nil, err
+	}
+	runnables, err := loader.LoadChildren(node)
+	if err != nil {
+		return nil, err
+	}
+	// Note: like compose, we use `any` here because we're not creating any Maybe[any]
+	// in the [withTimeoutStage.Run] method and the inner stage creates correctly-typed Maybes.
+	stage := WithTimeout[any, any](runnables[0], time.Duration(config.Timeout))
+	input, output := runnableASTNodeTypes(runnables[0])
+	inputPair, outputPair := runnableASTNodePairTypes(runnables[0])
+	return &typedRunnableASTNode{
+		RunnableASTNode: stage,
+		input:           input,
+		output:          output,
+		inputPair:       inputPair,
+		outputPair:      outputPair,
+	}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*withTimeoutLoader) StageName() string {
+	return withTimeoutStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*withTimeoutLoader) StageArguments() any {
+	return &withTimeoutStageArguments{}
+}
+
+// Run implements Stage.
+func (sx *withTimeoutStage[A, B]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[B] {
+	if sx.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sx.timeout)
+		defer cancel()
+	}
+	return sx.stage.Run(ctx, rtx, input)
+}
@@ -0,0 +1,79 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// timeoutTestBlockingStage is a stage that blocks until the context is done.
+type timeoutTestBlockingStage struct{}
+
+// ASTNode implements Stage.
+func (*timeoutTestBlockingStage) ASTNode() *SerializableASTNode {
+	return &SerializableASTNode{StageName: "blocking"}
+}
+
+// Run implements Stage.
+func (*timeoutTestBlockingStage) Run(ctx context.Context, rtx Runtime, input Maybe[*Void]) Maybe[*Void] {
+	<-ctx.Done()
+	return NewError[*Void](ctx.Err())
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Run("we bound the runtime of the wrapped stage", func(t *testing.T) {
+		pipeline := WithTimeout[*Void, *Void](&timeoutTestBlockingStage{}, 10*time.Millisecond)
+		rtx := NewMinimalRuntime(log.Log)
+		results := pipeline.Run(context.Background(), rtx, NewValue(&Void{}))
+		if !errors.Is(results.Error, context.DeadlineExceeded) {
+			t.Fatal("unexpected error", results.Error)
+		}
+	})
+
+	t.Run("we bound the runtime of operations", func(t *testing.T) {
+		pipeline := WithTimeout(TCPConnect(), time.Nanosecond)
+		endpoint := NewValue(&Endpoint{
+			Address: "10.0.0.1:80",
+			Domain:  "www.example.com",
+		})
+		rtx := NewMinimalRuntime(log.Log)
+		results := pipeline.Run(context.Background(), rtx, endpoint)
+		if !IsErrTCPConnect(results.Error) {
+			t.Fatal("not an ErrTCPConnect", results.Error)
+		}
+	})
+
+	t.Run("we can serialize and load the stage and the operations timeouts", func(t *testing.T) {
+		pipeline := WithTimeout(
+			Compose(
+				TCPConnect(TCPConnectOptionTimeout(time.Second)),
+				TLSHandshake(TLSHandshakeOptionTimeout(2*time.Second)),
+			),
+			5*time.Second,
+		)
+		expected, err := json.Marshal(pipeline.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadable LoadableASTNode
+		if err := json.Unmarshal(expected, &loadable); err != nil {
+			t.Fatal(err)
+		}
+		runnable, err := NewASTLoader().Load(&loadable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(runnable.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(expected), string(got)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
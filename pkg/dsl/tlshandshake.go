@@ -23,13 +23,26 @@ type tlsHandshakeOperation struct {
 
 const tlsHandshakeStageName = "tls_handshake"
 
+// tlsHandshakeStageVersion is the most recent version of the tls_handshake stage. The second
+// version adds the timeout argument, which probes only supporting the first version would ignore.
+const tlsHandshakeStageVersion = 2
+
+// tlsHandshakeDefaultTimeout is the default TLS handshake timeout.
+const tlsHandshakeDefaultTimeout = 10 * time.Second
+
 func (op *tlsHandshakeOperation) ASTNode() *SerializableASTNode {
 	var config tlsHandshakeConfig
 	for _, option := range op.options {
 		option(&config)
 	}
+	// Note: we only use the second version when we need it, such that probes only
+	// supporting the first version can load ASTs that do not configure the timeout
+	version := 1
+	if config.Timeout > 0 {
+		version = tlsHandshakeStageVersion
+	}
 	return &SerializableASTNode{
-		StageName: tlsHandshakeStageName,
+		StageName: VersionedStageName(tlsHandshakeStageName, version),
 		Arguments: &config,
 		Children:  []*SerializableASTNode{},
 	}
@@ -47,7 +60,7 @@ func (*tlsHandshakeLoader) Load(loader *ASTLoader, node *LoadableASTNode) (Runna
 		return nil, err
 	}
 	stage := TLSHandshake(config.options()...)
-	return &StageRunnableASTNode[*TCPConnection, *TLSConnection]{stage}, nil
+	return &StageRunnableASTNode[*TCPConnection, *TLSConnection]{S: stage}, nil
 }
 
 // StageName implements ASTLoaderRule.
@@ -55,6 +68,16 @@ func (*tlsHandshakeLoader) StageName() string {
 	return tlsHandshakeStageName
 }
 
+// StageVersion implements ASTLoaderRuleWithVersion.
+func (*tlsHandshakeLoader) StageVersion() int {
+	return tlsHandshakeStageVersion
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*tlsHandshakeLoader) StageArguments() any {
+	return &tlsHandshakeConfig{}
+}
+
 func (op *tlsHandshakeOperation) Run(ctx context.Context, rtx Runtime, tcpConn *TCPConnection) (*TLSConnection, error) {
 	// initialize config
 	config := &tlsHandshakeConfig{
@@ -68,7 +91,9 @@ func (op *tlsHandshakeOperation) Run(ctx context.Context, rtx Runtime, tcpConn *
 	// obtain TLS config or return an exception
 	tlsConfig, err := config.TLSConfig()
 	if err != nil {
-		return nil, &ErrException{err}
+		except := &ErrException{err}
+		observeOperation(rtx, tlsHandshakeStageName, tcpConn.Address, tcpConn.Trace.Tags(), time.Now(), except)
+		return nil, except
 	}
 
 	// start the operation logger
@@ -83,11 +108,12 @@ func (op *tlsHandshakeOperation) Run(ctx context.Context, rtx Runtime, tcpConn *
 
 	// setup
 	handshaker := tcpConn.Trace.NewTLSHandshakerStdlib()
-	const timeout = 10 * time.Second
+	timeout := durationOrDefault(config.Timeout, tlsHandshakeDefaultTimeout)
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// handshake
+	t0 := time.Now()
 	conn, state, err := handshaker.Handshake(ctx, tcpConn.Conn, tlsConfig)
 
 	// stop the operation logger
@@ -98,7 +124,7 @@ func (op *tlsHandshakeOperation) Run(ctx context.Context, rtx Runtime, tcpConn *
 
 	// handle the error case
 	if err != nil {
-		rtx.Metrics().Error(tlsHandshakeStageName)
+		observeOperation(rtx, tlsHandshakeStageName, tcpConn.Address, tcpConn.Trace.Tags(), t0, err)
 		return nil, &ErrTLSHandshake{err}
 	}
 
@@ -106,7 +132,7 @@ func (op *tlsHandshakeOperation) Run(ctx context.Context, rtx Runtime, tcpConn *
 	rtx.TrackCloser(conn)
 
 	// prepare the return value
-	rtx.Metrics().Success(tlsHandshakeStageName)
+	observeOperation(rtx, tlsHandshakeStageName, tcpConn.Address, tcpConn.Trace.Tags(), t0, nil)
 	out := &TLSConnection{
 		Address:               tcpConn.Address,
 		Conn:                  conn.(netxlite.TLSConn), // guaranteed to work
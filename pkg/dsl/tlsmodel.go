@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"time"
 
 	"github.com/ooni/probe-engine/pkg/netxlite"
 )
@@ -36,6 +37,7 @@ type tlsHandshakeConfig struct {
 	ALPN       []string `json:"alpn,omitempty"`
 	SkipVerify bool     `json:"skip_verify,omitempty"`
 	SNI        string   `json:"sni,omitempty"`
+	Timeout    Duration `json:"timeout,omitempty"`
 	X509Certs  []string `json:"x509_certs,omitempty"`
 }
 
@@ -49,6 +51,9 @@ func (c *tlsHandshakeConfig) options() (options []TLSHandshakeOption) {
 	if c.SNI != "" {
 		options = append(options, TLSHandshakeOptionSNI(c.SNI))
 	}
+	if c.Timeout > 0 {
+		options = append(options, TLSHandshakeOptionTimeout(time.Duration(c.Timeout)))
+	}
 	if len(c.X509Certs) > 0 {
 		options = append(options, TLSHandshakeOptionX509Certs(c.X509Certs...))
 	}
@@ -107,6 +112,14 @@ func TLSHandshakeOptionSNI(value string) TLSHandshakeOption {
 	}
 }
 
+// TLSHandshakeOptionTimeout allows to configure the handshake timeout. A zero or
+// negative value means that we should use the default timeout.
+func TLSHandshakeOptionTimeout(value time.Duration) TLSHandshakeOption {
+	return func(config *tlsHandshakeConfig) {
+		config.Timeout = Duration(value)
+	}
+}
+
 // ErrTLSHandshake wraps errors occurred during a TLS handshake operation.
 type ErrTLSHandshake struct {
 	Err error
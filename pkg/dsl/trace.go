@@ -1,6 +1,7 @@
 package dsl
 
 import (
+	"crypto/tls"
 	"net/http"
 
 	"github.com/ooni/probe-engine/pkg/model"
@@ -47,6 +48,11 @@ type Trace interface {
 	// Index is the unique index of this trace.
 	Index() int64
 
+	// OnDestinationDenied records the failure of an operation that we did not perform because
+	// the [DestinationPolicy] denies the given endpoint. The network is "tcp" for a TCP connect
+	// and "udp" for a QUIC handshake, which also uses the given TLS config.
+	OnDestinationDenied(network, endpoint string, config *tls.Config, err error)
+
 	// NewDialerWithoutResolver creates a dialer not attached to any resolver.
 	NewDialerWithoutResolver() model.Dialer
 
@@ -0,0 +1,229 @@
+package dsl
+
+//
+// Load-time type checking
+//
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TypedRunnableASTNode is a [RunnableASTNode] that declares its input and output types, which
+// allows the [ASTLoader] to type check an AST before running it. An [ASTLoaderRule] declares
+// the types of the stage it loads by returning a [TypedRunnableASTNode]. The simplest way to
+// do that is to return a [*StageRunnableASTNode], whose type parameters are the types.
+type TypedRunnableASTNode interface {
+	RunnableASTNode
+
+	// InputType returns the input type. The `any` type means that the node accepts any input.
+	InputType() reflect.Type
+
+	// OutputType returns the output type. The `any` type means that the output type is not
+	// known at load time. A nil type means that the node is a filter returning the same type
+	// it receives in input (e.g., the [Identity] stage).
+	OutputType() reflect.Type
+}
+
+// ErrASTTypeCheck indicates that an AST is not well typed.
+type ErrASTTypeCheck struct {
+	// Path is the path from the root to the ill-typed node, where each element is either
+	// a stage name or the index of a child in the form `children[N]`.
+	Path []string
+
+	// Expected is the type expected by the node.
+	Expected reflect.Type
+
+	// Got is the type the node would receive.
+	Got reflect.Type
+}
+
+// Error implements error.
+func (err *ErrASTTypeCheck) Error() string {
+	return fmt.Sprintf(
+		"dsl: type error at %s: expected %s; got %s",
+		strings.Join(err.Path, "/"),
+		err.Expected,
+		err.Got,
+	)
+}
+
+// prependPath prepends the given elements to the path.
+func (err *ErrASTTypeCheck) prependPath(elems ...string) {
+	err.Path = append(elems, err.Path...)
+}
+
+// newErrASTTypeCheck creates a new [*ErrASTTypeCheck] for the child with the given index.
+func newErrASTTypeCheck(node *LoadableASTNode, index int, expected, got reflect.Type) *ErrASTTypeCheck {
+	return &ErrASTTypeCheck{
+		Path:     []string{astChildPathElem(index), node.Children[index].StageName},
+		Expected: expected,
+		Got:      got,
+	}
+}
+
+// astChildPathElem returns the path element for the child with the given index.
+func astChildPathElem(index int) string {
+	return fmt.Sprintf("children[%d]", index)
+}
+
+// anyType is the [reflect.Type] of `any`.
+var anyType = typeOf[any]()
+
+// typeOf returns the [reflect.Type] of T.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// runnableASTNodeTypes returns the input and output types of a [RunnableASTNode]. We return
+// `any` for both types when the node is not a [TypedRunnableASTNode], such that we defer the
+// type checking to runtime, and a nil output type when the node is a filter.
+func runnableASTNodeTypes(node RunnableASTNode) (input, output reflect.Type) {
+	typed, good := node.(TypedRunnableASTNode)
+	if !good {
+		return anyType, anyType
+	}
+	return typed.InputType(), typed.OutputType()
+}
+
+// typesAreCompatible returns whether we can pass a value of type got to a node expecting the
+// expected type. When either type is `any`, we cannot know until runtime, so we return true.
+func typesAreCompatible(got, expected reflect.Type) bool {
+	return got == anyType || expected == anyType || got.AssignableTo(expected)
+}
+
+// CheckChildrenTypes is a convenience function for implementing an [ASTLoaderRule] that
+// loads children using [ASTLoader.LoadChildren] and runs them as [Stage] with input type
+// A and output type B. This function returns an [*ErrASTTypeCheck] if a child's types are
+// not compatible with A and B. Note that a filter child is compatible when A is compatible
+// with B because a filter returns the same type it receives in input.
+func CheckChildrenTypes[A, B any](node *LoadableASTNode, runnables ...RunnableASTNode) error {
+	expectedInput, expectedOutput := typeOf[A](), typeOf[B]()
+	for idx, runnable := range runnables {
+		input, output := runnableASTNodeTypes(runnable)
+		if !typesAreCompatible(expectedInput, input) {
+			return newErrASTTypeCheck(node, idx, input, expectedInput)
+		}
+		if output == nil {
+			output = expectedInput
+		}
+		if !typesAreCompatible(output, expectedOutput) {
+			return newErrASTTypeCheck(node, idx, expectedOutput, output)
+		}
+	}
+	return nil
+}
+
+// astPairTypes contains the types of the elements of a *Pair[any, any]. Because there is
+// type erasure when we load a [Zip] stage, which returns a *Pair[any, any], we track the
+// types of the pair elements separately, such that we can type check the stages receiving
+// the pair (e.g., [CompareAddressSets]) at load time rather than at runtime.
+type astPairTypes struct {
+	first  reflect.Type
+	second reflect.Type
+}
+
+// pairTypedRunnableASTNode is a [TypedRunnableASTNode] that also declares the types of the
+// elements of the *Pair[any, any] it receives in input or returns in output.
+type pairTypedRunnableASTNode interface {
+	TypedRunnableASTNode
+
+	// pairTypes returns the types of the elements of the input and output pairs, where
+	// nil means that the type is not a pair or that we do not know the elements types.
+	pairTypes() (input, output *astPairTypes)
+}
+
+// runnableASTNodePairTypes returns the types of the elements of the input and output pairs
+// of a [RunnableASTNode] or nil when the node is not a [pairTypedRunnableASTNode].
+func runnableASTNodePairTypes(node RunnableASTNode) (input, output *astPairTypes) {
+	typed, good := node.(pairTypedRunnableASTNode)
+	if !good {
+		return nil, nil
+	}
+	return typed.pairTypes()
+}
+
+// checkPairTypes returns an [*ErrASTTypeCheck] when the child with the given index expects
+// pair elements types that are not compatible with the pair elements types it would receive.
+func checkPairTypes(node *LoadableASTNode, index int, got, expected *astPairTypes) error {
+	if got == nil || expected == nil {
+		return nil // we cannot know until runtime
+	}
+	if !typesAreCompatible(got.first, expected.first) {
+		return newErrASTTypeCheck(node, index, expected.first, got.first)
+	}
+	if !typesAreCompatible(got.second, expected.second) {
+		return newErrASTTypeCheck(node, index, expected.second, got.second)
+	}
+	return nil
+}
+
+// typedRunnableASTNode is a [RunnableASTNode] with types inferred at load time.
+type typedRunnableASTNode struct {
+	RunnableASTNode
+	input  reflect.Type
+	output reflect.Type
+
+	// inputPair and outputPair are OPTIONAL and contain the
+	// types of the elements of the input and output pairs.
+	inputPair  *astPairTypes
+	outputPair *astPairTypes
+}
+
+var _ pairTypedRunnableASTNode = &typedRunnableASTNode{}
+
+// InputType implements TypedRunnableASTNode.
+func (n *typedRunnableASTNode) InputType() reflect.Type {
+	return n.input
+}
+
+// OutputType implements TypedRunnableASTNode.
+func (n *typedRunnableASTNode) OutputType() reflect.Type {
+	return n.output
+}
+
+// pairTypes implements pairTypedRunnableASTNode.
+func (n *typedRunnableASTNode) pairTypes() (input, output *astPairTypes) {
+	return n.inputPair, n.outputPair
+}
+
+// inferComposeTypes checks whether we can compose the two children of the given compose node
+// and returns the types of the resulting composed node, whose RunnableASTNode field is nil.
+func inferComposeTypes(node *LoadableASTNode, s1, s2 RunnableASTNode) (*typedRunnableASTNode, error) {
+	input1, output1 := runnableASTNodeTypes(s1)
+	input2, output2 := runnableASTNodeTypes(s2)
+	inputPair1, outputPair1 := runnableASTNodePairTypes(s1)
+	inputPair2, outputPair2 := runnableASTNodePairTypes(s2)
+
+	// a filter returns the same type it receives in input
+	filter1 := output1 == nil
+	if filter1 {
+		output1 = input1
+		outputPair1 = nil // we cannot know until runtime
+	}
+	if !typesAreCompatible(output1, input2) {
+		return nil, newErrASTTypeCheck(node, 1, input2, output1)
+	}
+	if err := checkPairTypes(node, 1, outputPair1, inputPair2); err != nil {
+		return nil, err
+	}
+	typed := &typedRunnableASTNode{}
+
+	// when the first stage is a generic filter, the second stage constrains the input
+	typed.input, typed.inputPair = input1, inputPair1
+	if filter1 && input1 == anyType {
+		typed.input, typed.inputPair = input2, inputPair2
+	}
+
+	// the composition is a filter only when both stages are filters
+	switch {
+	case output2 != nil:
+		typed.output, typed.outputPair = output2, outputPair2
+	case !filter1:
+		typed.output, typed.outputPair = output1, outputPair1
+	default:
+		typed.output = nil
+	}
+	return typed, nil
+}
@@ -0,0 +1,146 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestASTLoaderTypeCheck(t *testing.T) {
+	// load loads the given JSON AST
+	load := func(t *testing.T, rawAST string) (RunnableASTNode, error) {
+		var loadable LoadableASTNode
+		if err := json.Unmarshal([]byte(rawAST), &loadable); err != nil {
+			t.Fatal(err)
+		}
+		return NewASTLoader().Load(&loadable)
+	}
+
+	// requireTypeError requires the error to be an ErrASTTypeCheck with the given path
+	requireTypeError := func(t *testing.T, err error, expectedPath []string) {
+		var typeErr *ErrASTTypeCheck
+		if !errors.As(err, &typeErr) {
+			t.Fatal("not an ErrASTTypeCheck", err)
+		}
+		if diff := cmp.Diff(expectedPath, typeErr.Path); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+
+	t.Run("we accept a well-typed AST", func(t *testing.T) {
+		stage := RunStagesInParallel(
+			Compose3(
+				DomainName("www.example.com"),
+				DNSLookupParallel(DNSLookupGetaddrinfo(), DNSLookupUDP("8.8.8.8:53")),
+				MeasureMultipleEndpoints(
+					Compose(
+						MakeEndpointsForPort(443),
+						NewEndpointPipeline(
+							Compose4(
+								WithTimeout(TCPConnect(), 0),
+								IfFilterExists[*TCPConnection](&Identity[*TCPConnection]{}),
+								TLSHandshake(),
+								Discard[*TLSConnection](),
+							),
+						),
+					),
+				),
+			),
+		)
+		data, err := json.Marshal(stage.ASTNode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := load(t, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("we reject composing incompatible stages", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "compose",
+			"arguments": null,
+			"children": [
+				{"stage_name": "dns_lookup_getaddrinfo", "arguments": {}, "children": []},
+				{"stage_name": "tcp_connect", "arguments": {}, "children": []}
+			]
+		}`)
+		requireTypeError(t, err, []string{"compose", "children[1]", "tcp_connect"})
+	})
+
+	t.Run("we infer types through identities and filters", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "compose",
+			"arguments": null,
+			"children": [
+				{"stage_name": "domain_name", "arguments": {"domain": "www.example.com"}, "children": []},
+				{"stage_name": "compose", "arguments": null, "children": [
+					{"stage_name": "identity", "arguments": null, "children": []},
+					{"stage_name": "if_filter_exists", "arguments": null, "children": [
+						{"stage_name": "nonexistent_filter", "arguments": null, "children": []}
+					]}
+				]}
+			]
+		}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = load(t, `{
+			"stage_name": "compose",
+			"arguments": null,
+			"children": [
+				{"stage_name": "compose", "arguments": null, "children": [
+					{"stage_name": "domain_name", "arguments": {"domain": "www.example.com"}, "children": []},
+					{"stage_name": "identity", "arguments": null, "children": []}
+				]},
+				{"stage_name": "tls_handshake", "arguments": {}, "children": []}
+			]
+		}`)
+		requireTypeError(t, err, []string{"compose", "children[1]", "tls_handshake"})
+	})
+
+	t.Run("we report the path of an ill-typed node inside parallel stages", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "run_stages_in_parallel",
+			"arguments": null,
+			"children": [
+				{"stage_name": "identity", "arguments": null, "children": []},
+				{"stage_name": "compose", "arguments": null, "children": [
+					{"stage_name": "domain_name", "arguments": {"domain": "www.example.com"}, "children": []},
+					{"stage_name": "dns_lookup_parallel", "arguments": null, "children": [
+						{"stage_name": "tcp_connect", "arguments": {}, "children": []}
+					]}
+				]}
+			]
+		}`)
+		requireTypeError(t, err, []string{
+			"run_stages_in_parallel", "children[1]", "compose",
+			"children[1]", "dns_lookup_parallel", "children[0]", "tcp_connect",
+		})
+	})
+
+	t.Run("we reject parallel stages not returning the expected type", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "new_endpoint_pipeline",
+			"arguments": null,
+			"children": [
+				{"stage_name": "tcp_connect", "arguments": {}, "children": []}
+			]
+		}`)
+		requireTypeError(t, err, []string{"new_endpoint_pipeline", "children[0]", "tcp_connect"})
+	})
+
+	t.Run("we do not replace an ill-typed filter with the identity", func(t *testing.T) {
+		_, err := load(t, `{
+			"stage_name": "if_filter_exists",
+			"arguments": null,
+			"children": [
+				{"stage_name": "tcp_connect", "arguments": {}, "children": []}
+			]
+		}`)
+		requireTypeError(t, err, []string{"if_filter_exists", "children[0]", "tcp_connect"})
+	})
+}
@@ -0,0 +1,158 @@
+package dsl
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// Pair contains the results of the two stages joined by [Zip].
+type Pair[A, B any] struct {
+	// First is the result of the first stage.
+	First Maybe[A]
+
+	// Second is the result of the second stage.
+	Second Maybe[B]
+}
+
+// Zip returns a stage that runs the two given stages in parallel with the same input and
+// returns a [Pair] containing both results, such that the following stages can compare
+// them (e.g., using [CompareAddressSets]). Unlike [DNSLookupParallel], we do not merge the
+// results, so we know which stage returned what. The returned stage fails only when its
+// input is an error or when one of the two stages returns an exception; otherwise, the
+// [Pair] contains the result of each stage, including any error it may have returned.
+//
+// When we load this stage from an AST, there is type erasure and the stage returns
+// a *Pair[any, any], where each value has the type returned by the child stage.
+func Zip[A, B, C any](first Stage[A, B], second Stage[A, C]) Stage[A, *Pair[B, C]] {
+	return &zipStage[A, B, C]{first, second}
+}
+
+type zipStage[A, B, C any] struct {
+	first  Stage[A, B]
+	second Stage[A, C]
+}
+
+const zipStageName = "zip"
+
+// ASTNode implements Stage.
+func (sx *zipStage[A, B, C]) ASTNode() *SerializableASTNode {
+	// There is type erasure when we AST-serialize
+	return &SerializableASTNode{
+		StageName: zipStageName,
+		Arguments: nil,
+		Children:  []*SerializableASTNode{sx.first.ASTNode(), sx.second.ASTNode()},
+	}
+}
+
+type zipLoader struct{}
+
+// Load implements ASTLoaderRule.
+func (*zipLoader) Load(loader *ASTLoader, node *LoadableASTNode) (RunnableASTNode, error) {
+	if err := loader.LoadEmptyArguments(node); err != nil {
+		return nil, err
+	}
+	if err := loader.RequireExactlyNumChildren(node, 2); err != nil {
+		return nil, err
+	}
+	runnables, err := loader.LoadChildren(node)
+	if err != nil {
+		return nil, err
+	}
+	runtimex.Assert(len(runnables) == 2, "expected exactly two children nodes")
+
+	// Because there is type erasure, we create a Zip[any, any, any] and we declare the input
+	// type using the input types of the children, such that we can type check the AST. We
+	// also declare the types of the pair elements using the output types of the children.
+	input, output := runnableASTNodeTypes(runnables[0])
+	input2, output2 := runnableASTNodeTypes(runnables[1])
+	if !typesAreCompatible(input, input2) {
+		return nil, newErrASTTypeCheck(node, 1, input2, input)
+	}
+	if input == anyType {
+		input = input2
+	}
+	stage := Zip[any, any, any](
+		&erasedRunnableASTNodeStage[any]{runnables[0]},
+		&erasedRunnableASTNodeStage[any]{runnables[1]},
+	)
+	// a filter returns the same type it receives in input
+	if output == nil {
+		output = input
+	}
+	if output2 == nil {
+		output2 = input2
+	}
+	return &typedRunnableASTNode{
+		RunnableASTNode: &StageRunnableASTNode[any, *Pair[any, any]]{S: stage},
+		input:           input,
+		output:          typeOf[*Pair[any, any]](),
+		inputPair:       nil,
+		outputPair:      &astPairTypes{output, output2},
+	}, nil
+}
+
+// StageName implements ASTLoaderRule.
+func (*zipLoader) StageName() string {
+	return zipStageName
+}
+
+// StageArguments implements ASTLoaderRuleWithArguments.
+func (*zipLoader) StageArguments() any {
+	return nil
+}
+
+// Run implements Stage.
+func (sx *zipStage[A, B, C]) Run(ctx context.Context, rtx Runtime, input Maybe[A]) Maybe[*Pair[B, C]] {
+	if input.Error != nil {
+		return NewError[*Pair[B, C]](input.Error)
+	}
+
+	// run the two stages in parallel unless the runtime does not allow us to do that
+	pair := &Pair[B, C]{}
+	wg := &sync.WaitGroup{}
+	if rtx.MaxParallelism() == 1 {
+		pair.First = sx.first.Run(ctx, rtx, input)
+	} else {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pair.First = sx.first.Run(ctx, rtx, input)
+		}()
+	}
+	pair.Second = sx.second.Run(ctx, rtx, input)
+	wg.Wait()
+
+	// route exceptions
+	if err := Try(pair.First); err != nil {
+		return NewError[*Pair[B, C]](err)
+	}
+	if err := Try(pair.Second); err != nil {
+		return NewError[*Pair[B, C]](err)
+	}
+
+	return NewValue(pair)
+}
+
+// asSpecificPair converts a *Pair[any, any] to a *Pair[A, B].
+func asSpecificPair[A, B any](pair *Pair[any, any]) (*Pair[A, B], *ErrException) {
+	first, except := asSpecificPairElement[A](pair.First)
+	if except != nil {
+		return nil, except
+	}
+	second, except := asSpecificPairElement[B](pair.Second)
+	if except != nil {
+		return nil, except
+	}
+	return &Pair[A, B]{first, second}, nil
+}
+
+// asSpecificPairElement is like [AsSpecificMaybe] but ignores the value of errors, since
+// the stage producing the error may not have been able to create a correctly typed value.
+func asSpecificPairElement[T any](v Maybe[any]) (Maybe[T], *ErrException) {
+	if v.Error != nil {
+		return NewError[T](v.Error), nil
+	}
+	return AsSpecificMaybe[T](v)
+}
@@ -73,6 +73,11 @@ func (nl *dnsConsistencyCheckLoader) StageName() string {
 	return dnsConsistencyCheckFilterName
 }
 
+// StageArguments implements dsl.ASTLoaderRuleWithArguments.
+func (nl *dnsConsistencyCheckLoader) StageArguments() any {
+	return &dnsConsistencyCheckArguments{}
+}
+
 // Run implements dsl.Stage.
 func (fx *dnsConsistencyCheckFilter) Run(ctx context.Context,
 	rtx dsl.Runtime, input dsl.Maybe[*dsl.DNSLookupResult]) dsl.Maybe[*dsl.DNSLookupResult] {
@@ -73,6 +73,11 @@ func (nl *tcpReachabilityCheckLoader) StageName() string {
 	return tcpReachabilityFilterCheckName
 }
 
+// StageArguments implements dsl.ASTLoaderRuleWithArguments.
+func (nl *tcpReachabilityCheckLoader) StageArguments() any {
+	return &tcpReachabilityCheckArguments{}
+}
+
 // Run implements dsl.Stage.
 func (fx *tcpReachabilityCheckFilter) Run(ctx context.Context, rtx dsl.Runtime,
 	input dsl.Maybe[*dsl.TCPConnection]) dsl.Maybe[*dsl.TCPConnection] {
@@ -8,15 +8,19 @@ import (
 	"github.com/ooni/probe-engine/pkg/model"
 )
 
-// NewMeasurer returns a new [Measurer] instance.
-func NewMeasurer(rawOptions json.RawMessage) *Measurer {
-	return &Measurer{rawOptions}
+// NewMeasurer returns a new [Measurer] instance. The options allow to configure
+// the DSL runtime, for example to share a [dsl.Budget] across nettests.
+func NewMeasurer(rawOptions json.RawMessage, options ...dsl.RuntimeOption) *Measurer {
+	return &Measurer{rawOptions, options}
 }
 
 // Measurer is the fbmessenger measurer.
 type Measurer struct {
 	// RawOptions contains the raw options for this experiment.
 	RawOptions json.RawMessage
+
+	// RuntimeOptions contains the options for the DSL runtime.
+	RuntimeOptions []dsl.RuntimeOption
 }
 
 var _ model.ExperimentMeasurer = &Measurer{}
@@ -36,10 +40,17 @@ func (m *Measurer) ExperimentVersion() string {
 // Run implements model.ExperimentMeasurer
 func (m *Measurer) Run(ctx context.Context, args *model.ExperimentArgs) error {
 	// parse the targets
-	var astRoot dsl.LoadableASTNode
-	if err := json.Unmarshal(m.RawOptions, &astRoot); err != nil {
+	astDoc, err := dsl.UnmarshalASTDocument(m.RawOptions)
+	if err != nil {
+		return err
+	}
+
+	// record the hash of the AST as received, because the loaded AST may differ
+	astHash, err := dsl.ASTDocumentContentHash(astDoc)
+	if err != nil {
 		return err
 	}
+	args.Measurement.AddAnnotation(dsl.ASTContentHashAnnotation, astHash)
 
 	// create an AST loader
 	loader := dsl.NewASTLoader()
@@ -52,7 +63,7 @@ func (m *Measurer) Run(ctx context.Context, args *model.ExperimentArgs) error {
 	loader.RegisterCustomLoaderRule(&tcpReachabilityCheckLoader{tk})
 
 	// load and make the AST runnable
-	pipeline, err := loader.Load(&astRoot)
+	pipeline, err := loader.LoadDocument(astDoc)
 	if err != nil {
 		return err
 	}
@@ -60,8 +71,8 @@ func (m *Measurer) Run(ctx context.Context, args *model.ExperimentArgs) error {
 	// create the DSL runtime
 	meter := dsl.NewProgressMeterExperimentCallbacks(args.Callbacks)
 	rtx := dsl.NewMeasurexliteRuntime(
-		args.Session.Logger(), &dsl.NullMetrics{}, meter,
-		args.Measurement.MeasurementStartTimeSaved)
+		args.Session.Logger(), dsl.NewHistogramMetrics(), meter,
+		args.Measurement.MeasurementStartTimeSaved, m.RuntimeOptions...)
 	defer rtx.Close()
 
 	// evaluate the pipeline and handle exceptions
@@ -12,15 +12,65 @@ import (
 
 // InterpreterSettings abstracts OONI Probe settings for the interpreter.
 type InterpreterSettings interface {
+	// DestinationPolicyFile returns the path of the file containing the policy deciding
+	// which destinations DSL-based nettests may connect to or an empty string, in which
+	// case DSL-based nettests use the default policy denying LAN destinations.
+	DestinationPolicyFile() string
+
 	// IsNettestEnabled returns true if a nettest is enabled.
 	IsNettestEnabled(name string) bool
 
 	// IsSuiteEnabled returns true if a suite is enabled.
 	IsSuiteEnabled(name string) bool
 
+	// MaxInFlightOperations returns zero or the maximum number of dials, handshakes,
+	// and lookups that DSL-based nettests may run concurrently. This setting does
+	// not limit the number of connections that remain open after a dial or handshake,
+	// which is what MaxOpenConnections does.
+	MaxInFlightOperations() int
+
+	// MaxOpenConnections returns zero or the maximum number of TCP and QUIC connections
+	// that DSL-based nettests may have open at the same time, including the ones being
+	// established. We release a connection slot when the measurement closes it.
+	MaxOpenConnections() int
+
+	// MaxOperationsPerSecond returns zero or the maximum number of network
+	// operations that DSL-based nettests may start each second.
+	MaxOperationsPerSecond() float64
+
+	// MaxParallelism returns zero or the maximum number of goroutines that each parallel
+	// stage of DSL-based nettests may use, where zero means using the DSL default.
+	MaxParallelism() int
+
 	// MaxRuntime returns the maximum runtime for nettests that take
 	// multiple targets such as Web Connectivity.
 	MaxRuntime() time.Duration
+
+	// OTLPEndpoint returns the URL of the OTLP/HTTP traces endpoint (e.g.,
+	// "http://127.0.0.1:4318/v1/traces") to which we export the spans of
+	// DSL-based nettests or an empty string.
+	OTLPEndpoint() string
+
+	// OTLPSpansFile returns the path of the file to which we append the spans
+	// of DSL-based nettests in OTLP JSON format or an empty string.
+	OTLPSpansFile() string
+}
+
+// InterpreterMetrics collects metrics about the measurements performed by the interpreter.
+type InterpreterMetrics interface {
+	// MeasurementFailed is called when we cannot create, run, or save a
+	// measurement of the experiment with the given name.
+	MeasurementFailed(experimentName string)
+
+	// MeasurementRun is called after running a measurement of the experiment with
+	// the given name, regardless of whether the measurement succeeded.
+	MeasurementRun(experimentName string)
+
+	// MeasurementSaved is called after saving a measurement of the experiment with the given name.
+	MeasurementSaved(experimentName string)
+
+	// NettestRun is called after running the nettest with the given name.
+	NettestRun(nettestName string)
 }
 
 // InterpreterSaver is the interpreter view of the interface
@@ -77,8 +127,18 @@ type InterpreterView interface {
 	UpdateSuiteName(name string)
 }
 
+// InterpreterScriptVersion is the most recent [InterpreterScript] version we support.
+const InterpreterScriptVersion = 1
+
+// ErrUnsupportedScriptVersion indicates that we do not support the script version.
+var ErrUnsupportedScriptVersion = errors.New("unsupported script version")
+
 // InterpreterScript is the script for the interpreter.
 type InterpreterScript struct {
+	// Version is the script version. The zero value means the first version, which
+	// allows us to interpret scripts generated before we introduced versions.
+	Version int `json:"version,omitempty"`
+
 	// Config contains global configuration for the interpreter.
 	Config InterpreterConfig `json:"config"`
 
@@ -86,6 +146,37 @@ type InterpreterScript struct {
 	Commands []InterpreterCommand `json:"commands"`
 }
 
+// SignedInterpreterScript is an [InterpreterScript] signed using ed25519. Because the embedded
+// DSL ASTs are part of the commands arguments, the signature also covers the ASTs.
+type SignedInterpreterScript struct {
+	// Payload is the JSON or CBOR serialization of the [InterpreterScriptPayload]. We
+	// sign the exact payload bytes, which we encode as base64 such that
+	// reformatting the document does not invalidate the signatures.
+	Payload []byte `json:"payload"`
+
+	// Signatures contains the payload signatures. A payload may contain more
+	// than one signature to allow us to rotate the signing keys.
+	Signatures []InterpreterScriptSignature `json:"signatures"`
+}
+
+// InterpreterScriptPayload is the signed content of a [SignedInterpreterScript].
+type InterpreterScriptPayload struct {
+	// Expires is the time after which the probe MUST NOT run the script.
+	Expires time.Time `json:"expires"`
+
+	// Script is the script to run.
+	Script InterpreterScript `json:"script"`
+}
+
+// InterpreterScriptSignature is a signature of a [SignedInterpreterScript] payload.
+type InterpreterScriptSignature struct {
+	// KeyID identifies the key that produced the signature.
+	KeyID string `json:"key_id"`
+
+	// Signature is the ed25519 signature.
+	Signature []byte `json:"signature"`
+}
+
 // InterpreterConfig contains configuration for running the interpreter.
 type InterpreterConfig struct {
 	// TestHelpers contains test helpers information.
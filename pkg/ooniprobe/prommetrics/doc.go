@@ -0,0 +1,4 @@
+// Package prommetrics exposes ooniprobe metrics using Prometheus. The key data structure
+// is the [Exporter], which implements [modelx.InterpreterMetrics] and serves the metrics
+// using the Prometheus text format at the /metrics endpoint.
+package prommetrics
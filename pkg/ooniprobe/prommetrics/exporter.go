@@ -0,0 +1,230 @@
+package prommetrics
+
+//
+// Prometheus exporter
+//
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultAddress is the default address where we serve metrics. We bind to
+// localhost by default to avoid exposing metrics to the whole network.
+const DefaultAddress = "127.0.0.1:9464"
+
+// Exporter collects ooniprobe metrics and exports them using Prometheus. The zero
+// value of this struct is invalid; construct using [NewExporter].
+type Exporter struct {
+	// lastRun contains the last time we ran each nettest.
+	lastRun *prometheus.GaugeVec
+
+	// measurementsFailed counts the failed measurements by experiment name.
+	measurementsFailed *prometheus.CounterVec
+
+	// measurementsRun counts the measurements run by experiment name.
+	measurementsRun *prometheus.CounterVec
+
+	// measurementsSaved counts the saved measurements by experiment name.
+	measurementsSaved *prometheus.CounterVec
+
+	// registry is the registry containing all the metrics.
+	registry *prometheus.Registry
+
+	// scriptFetches counts the attempts at fetching the script by result.
+	scriptFetches *prometheus.CounterVec
+
+	// scriptLastFetch contains the last time we attempted to fetch the script.
+	scriptLastFetch prometheus.Gauge
+
+	// scriptLastFetchSuccess is 1 if the last fetch succeeded and 0 otherwise.
+	scriptLastFetchSuccess prometheus.Gauge
+}
+
+var _ modelx.InterpreterMetrics = &Exporter{}
+
+// NewExporter creates a new [*Exporter].
+func NewExporter() *Exporter {
+	exp := &Exporter{
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ooniprobe_nettest_last_run_timestamp_seconds",
+			Help: "Unix time of when we last ran each nettest.",
+		}, []string{"nettest"}),
+		measurementsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ooniprobe_measurements_failed_total",
+			Help: "Number of measurements we could not create, run, or save.",
+		}, []string{"experiment"}),
+		measurementsRun: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ooniprobe_measurements_run_total",
+			Help: "Number of measurements we ran.",
+		}, []string{"experiment"}),
+		measurementsSaved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ooniprobe_measurements_saved_total",
+			Help: "Number of measurements we saved.",
+		}, []string{"experiment"}),
+		registry: prometheus.NewRegistry(),
+		scriptFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ooniprobe_script_fetches_total",
+			Help: "Number of attempts at fetching the script by result.",
+		}, []string{"result"}),
+		scriptLastFetch: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ooniprobe_script_last_fetch_timestamp_seconds",
+			Help: "Unix time of the last attempt at fetching the script.",
+		}),
+		scriptLastFetchSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ooniprobe_script_last_fetch_success",
+			Help: "Whether the last attempt at fetching the script succeeded.",
+		}),
+	}
+	exp.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		exp.lastRun,
+		exp.measurementsFailed,
+		exp.measurementsRun,
+		exp.measurementsSaved,
+		exp.scriptFetches,
+		exp.scriptLastFetch,
+		exp.scriptLastFetchSuccess,
+	)
+	return exp
+}
+
+// RegisterDSLMetrics registers the counters of the network operations performed by DSL-based
+// nettests, which we export as the ooniprobe_dsl_operations_total metric. You typically obtain
+// them by calling the DSLMetrics method of the runner Interpreter. This method returns an
+// error if you attempt to register DSL metrics more than once.
+func (exp *Exporter) RegisterDSLMetrics(metrics *dsl.AccountingMetrics) error {
+	return exp.registry.Register(newDSLOperationsCollector(metrics))
+}
+
+// MeasurementFailed implements modelx.InterpreterMetrics.
+func (exp *Exporter) MeasurementFailed(experimentName string) {
+	exp.measurementsFailed.WithLabelValues(experimentName).Inc()
+}
+
+// MeasurementRun implements modelx.InterpreterMetrics.
+func (exp *Exporter) MeasurementRun(experimentName string) {
+	exp.measurementsRun.WithLabelValues(experimentName).Inc()
+}
+
+// MeasurementSaved implements modelx.InterpreterMetrics.
+func (exp *Exporter) MeasurementSaved(experimentName string) {
+	exp.measurementsSaved.WithLabelValues(experimentName).Inc()
+}
+
+// NettestRun implements modelx.InterpreterMetrics.
+func (exp *Exporter) NettestRun(nettestName string) {
+	exp.lastRun.WithLabelValues(nettestName).SetToCurrentTime()
+}
+
+// ScriptFetched records the result of an attempt at fetching the script, where
+// a nil error indicates success.
+func (exp *Exporter) ScriptFetched(err error) {
+	exp.scriptLastFetch.SetToCurrentTime()
+	if err != nil {
+		exp.scriptFetches.WithLabelValues("failure").Inc()
+		exp.scriptLastFetchSuccess.Set(0)
+		return
+	}
+	exp.scriptFetches.WithLabelValues("success").Inc()
+	exp.scriptLastFetchSuccess.Set(1)
+}
+
+// Handler returns the [http.Handler] serving the metrics.
+func (exp *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(exp.registry, promhttp.HandlerOpts{})
+}
+
+// Server serves the metrics of an [Exporter]. Construct using [*Exporter.Listen].
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts serving the metrics at the /metrics endpoint of the given TCP address
+// in a background goroutine. Use [DefaultAddress] unless you have a good reason to expose
+// the metrics to other hosts. Call the [*Server] Close method when done.
+func (exp *Exporter) Listen(address string) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp.Handler())
+	srv := &Server{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go srv.server.Serve(listener)
+	return srv, nil
+}
+
+// Addr returns the address where we're listening.
+func (srv *Server) Addr() net.Addr {
+	return srv.listener.Addr()
+}
+
+// Close stops serving metrics.
+func (srv *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// dslOperationsCollector is a [prometheus.Collector] exporting the counters
+// of a [*dsl.AccountingMetrics] as the ooniprobe_dsl_operations_total metric.
+type dslOperationsCollector struct {
+	desc    *prometheus.Desc
+	metrics *dsl.AccountingMetrics
+}
+
+// newDSLOperationsCollector creates a new [*dslOperationsCollector].
+func newDSLOperationsCollector(metrics *dsl.AccountingMetrics) *dslOperationsCollector {
+	return &dslOperationsCollector{
+		desc: prometheus.NewDesc(
+			"ooniprobe_dsl_operations_total",
+			"Number of network operations performed by DSL-based nettests by result.",
+			[]string{"operation", "result"},
+			nil,
+		),
+		metrics: metrics,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *dslOperationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *dslOperationsCollector) Collect(ch chan<- prometheus.Metric) {
+	for key, value := range c.metrics.Snapshot() {
+		// the snapshot keys are like "tcp_connect_success_count" or "tcp_connect_error_count"
+		for _, result := range []string{"success", "error"} {
+			suffix := "_" + result + "_count"
+			if strings.HasSuffix(key, suffix) {
+				operation := strings.TrimSuffix(key, suffix)
+				ch <- prometheus.MustNewConstMetric(
+					c.desc, prometheus.CounterValue, float64(value), operation, result)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package prommetrics
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+)
+
+func TestExporter(t *testing.T) {
+	// collect some metrics
+	exporter := NewExporter()
+	dslMetrics := dsl.NewAccountingMetrics()
+	dslMetrics.Success("tcp_connect")
+	dslMetrics.Success("tcp_connect")
+	dslMetrics.Error("tls_handshake")
+	if err := exporter.RegisterDSLMetrics(dslMetrics); err != nil {
+		t.Fatal(err)
+	}
+	exporter.ScriptFetched(errors.New("mocked error"))
+	exporter.ScriptFetched(nil)
+	exporter.MeasurementRun("facebook_messenger")
+	exporter.MeasurementSaved("facebook_messenger")
+	exporter.MeasurementRun("riseupvpn")
+	exporter.MeasurementFailed("riseupvpn")
+	exporter.NettestRun("facebook_messenger")
+
+	// scrape the metrics using a local server
+	srv, err := exporter.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	resp, err := http.Get("http://" + srv.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("unexpected status code", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	for _, expected := range []string{
+		`ooniprobe_dsl_operations_total{operation="tcp_connect",result="success"} 2`,
+		`ooniprobe_dsl_operations_total{operation="tls_handshake",result="error"} 1`,
+		`ooniprobe_measurements_failed_total{experiment="riseupvpn"} 1`,
+		`ooniprobe_measurements_run_total{experiment="facebook_messenger"} 1`,
+		`ooniprobe_measurements_run_total{experiment="riseupvpn"} 1`,
+		`ooniprobe_measurements_saved_total{experiment="facebook_messenger"} 1`,
+		`ooniprobe_nettest_last_run_timestamp_seconds{nettest="facebook_messenger"} `,
+		`ooniprobe_script_fetches_total{result="failure"} 1`,
+		`ooniprobe_script_fetches_total{result="success"} 1`,
+		`ooniprobe_script_last_fetch_success 1`,
+		`ooniprobe_script_last_fetch_timestamp_seconds `,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("cannot find %q in:\n%s", expected, body)
+		}
+	}
+
+	// make sure we cannot register the DSL metrics twice
+	if err := exporter.RegisterDSLMetrics(dslMetrics); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDefaultAddressIsLoopback(t *testing.T) {
+	host, _, err := net.SplitHostPort(DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		t.Fatal("expected a loopback address, got", host)
+	}
+}
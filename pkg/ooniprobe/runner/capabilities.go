@@ -0,0 +1,40 @@
+package runner
+
+//
+// Probe capabilities
+//
+
+import (
+	"sort"
+
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+)
+
+// Capabilities describes what this probe is able to interpret. The probe reports its
+// capabilities to the backend, such that the backend can serve scripts and ASTs that
+// the probe understands (e.g., using versioned stage names with fallbacks).
+type Capabilities struct {
+	// Nettests contains the names of the nettests we can run.
+	Nettests []string `json:"nettests"`
+
+	// ScriptVersion is the most recent script version we can interpret.
+	ScriptVersion int `json:"script_version"`
+
+	// Stages contains the DSL stages we can load.
+	Stages []dsl.StageCapability `json:"stages"`
+}
+
+// NewCapabilities returns the [*Capabilities] of this probe.
+func NewCapabilities() *Capabilities {
+	nettests := []string{}
+	for name := range nettestRegistry {
+		nettests = append(nettests, name)
+	}
+	sort.Strings(nettests)
+	return &Capabilities{
+		Nettests:      nettests,
+		ScriptVersion: modelx.InterpreterScriptVersion,
+		Stages:        dsl.NewASTLoader().Capabilities(),
+	}
+}
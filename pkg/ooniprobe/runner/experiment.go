@@ -42,6 +42,7 @@ func runExperiment(
 
 	// it is an hard error if we cannot create a measurement
 	if err != nil {
+		ix.metrics.MeasurementFailed(exp.ExperimentName())
 		return err
 	}
 
@@ -53,6 +54,7 @@ func runExperiment(
 
 	// it is an hard error if we cannot create a new session
 	if err != nil {
+		ix.metrics.MeasurementFailed(exp.ExperimentName())
 		return err
 	}
 
@@ -64,7 +66,10 @@ func runExperiment(
 	}
 
 	// measure
-	if err := exp.Run(ctx, args); err != nil {
+	err = exp.Run(ctx, args)
+	ix.metrics.MeasurementRun(exp.ExperimentName())
+	if err != nil {
+		ix.metrics.MeasurementFailed(exp.ExperimentName())
 		ix.logger.Warnf(
 			"run %s with %s: %s",
 			exp.ExperimentName(),
@@ -88,6 +93,7 @@ func runExperiment(
 	// scrub the IP addresses
 	meas, err = scrubMeasurement(meas, ix.location)
 	if err != nil {
+		ix.metrics.MeasurementFailed(exp.ExperimentName())
 		ix.logger.Warnf(
 			"run %s with %s: %s",
 			exp.ExperimentName(),
@@ -101,6 +107,7 @@ func runExperiment(
 
 	// save the measurement
 	if err := ix.saver.SaveMeasurement(ctx, meas); err != nil {
+		ix.metrics.MeasurementFailed(exp.ExperimentName())
 		ix.logger.Warnf(
 			"run %s with %s: %s",
 			exp.ExperimentName(),
@@ -109,6 +116,7 @@ func runExperiment(
 		)
 		return err
 	}
+	ix.metrics.MeasurementSaved(exp.ExperimentName())
 
 	return nil
 }
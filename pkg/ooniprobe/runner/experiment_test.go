@@ -0,0 +1,199 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/ooni/probe-engine/pkg/model"
+	"github.com/ooni/probe-engine/pkg/optional"
+)
+
+// experimentTestMetrics is a [modelx.InterpreterMetrics] counting the events.
+type experimentTestMetrics struct {
+	failed map[string]int
+	run    map[string]int
+	saved  map[string]int
+}
+
+var _ modelx.InterpreterMetrics = &experimentTestMetrics{}
+
+// MeasurementFailed implements modelx.InterpreterMetrics.
+func (m *experimentTestMetrics) MeasurementFailed(experimentName string) {
+	m.failed[experimentName]++
+}
+
+// MeasurementRun implements modelx.InterpreterMetrics.
+func (m *experimentTestMetrics) MeasurementRun(experimentName string) {
+	m.run[experimentName]++
+}
+
+// MeasurementSaved implements modelx.InterpreterMetrics.
+func (m *experimentTestMetrics) MeasurementSaved(experimentName string) {
+	m.saved[experimentName]++
+}
+
+// NettestRun implements modelx.InterpreterMetrics.
+func (m *experimentTestMetrics) NettestRun(nettestName string) {
+	// nothing
+}
+
+// experimentTestSaver is a [modelx.InterpreterSaver] returning the given error.
+type experimentTestSaver struct {
+	err error
+}
+
+var _ modelx.InterpreterSaver = &experimentTestSaver{}
+
+// SaveMeasurement implements modelx.InterpreterSaver.
+func (s *experimentTestSaver) SaveMeasurement(ctx context.Context, meas *model.Measurement) error {
+	return s.err
+}
+
+// experimentTestLocation is a [modelx.InterpreterLocation] with a static IPv4 location.
+type experimentTestLocation struct {
+	v4 optional.Value[*modelx.Location]
+}
+
+var _ modelx.InterpreterLocation = &experimentTestLocation{}
+
+// IPv4 implements modelx.InterpreterLocation.
+func (l *experimentTestLocation) IPv4() optional.Value[*modelx.Location] {
+	return l.v4
+}
+
+// IPv6 implements modelx.InterpreterLocation.
+func (l *experimentTestLocation) IPv6() optional.Value[*modelx.Location] {
+	return optional.None[*modelx.Location]()
+}
+
+// Refresh implements modelx.InterpreterLocation.
+func (l *experimentTestLocation) Refresh() error {
+	return nil
+}
+
+// experimentTestMeasurer is a [model.ExperimentMeasurer] returning the given error.
+type experimentTestMeasurer struct {
+	err error
+}
+
+var _ model.ExperimentMeasurer = &experimentTestMeasurer{}
+
+// ExperimentName implements model.ExperimentMeasurer.
+func (m *experimentTestMeasurer) ExperimentName() string {
+	return "dsl_test"
+}
+
+// ExperimentVersion implements model.ExperimentMeasurer.
+func (m *experimentTestMeasurer) ExperimentVersion() string {
+	return "0.1.0"
+}
+
+// GetSummaryKeys implements model.ExperimentMeasurer.
+func (m *experimentTestMeasurer) GetSummaryKeys(*model.Measurement) (interface{}, error) {
+	return nil, nil
+}
+
+// Run implements model.ExperimentMeasurer.
+func (m *experimentTestMeasurer) Run(ctx context.Context, args *model.ExperimentArgs) error {
+	return m.err
+}
+
+func TestRunExperimentMetrics(t *testing.T) {
+	location := optional.Some(&modelx.Location{
+		ProbeIP:  "130.192.91.211",
+		ProbeASN: 137,
+		ProbeCC:  "IT",
+	})
+
+	type testcase struct {
+		// name is the name of the test case.
+		name string
+
+		// location is the IPv4 location.
+		location optional.Value[*modelx.Location]
+
+		// runErr is the error returned by the experiment.
+		runErr error
+
+		// saveErr is the error returned by the saver.
+		saveErr error
+
+		// expectRun, expectSaved, and expectFailed are the expected counters.
+		expectRun, expectSaved, expectFailed int
+	}
+
+	testcases := []testcase{{
+		name:         "when we run and save the measurement",
+		location:     location,
+		runErr:       nil,
+		saveErr:      nil,
+		expectRun:    1,
+		expectSaved:  1,
+		expectFailed: 0,
+	}, {
+		name:         "when we cannot create the measurement",
+		location:     optional.None[*modelx.Location](),
+		runErr:       nil,
+		saveErr:      nil,
+		expectRun:    0,
+		expectSaved:  0,
+		expectFailed: 1,
+	}, {
+		name:         "when the experiment fails",
+		location:     location,
+		runErr:       errors.New("mocked error"),
+		saveErr:      nil,
+		expectRun:    1,
+		expectSaved:  0,
+		expectFailed: 1,
+	}, {
+		name:         "when we cannot save the measurement",
+		location:     location,
+		runErr:       nil,
+		saveErr:      errors.New("mocked error"),
+		expectRun:    1,
+		expectSaved:  0,
+		expectFailed: 1,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics := &experimentTestMetrics{
+				failed: map[string]int{},
+				run:    map[string]int{},
+				saved:  map[string]int{},
+			}
+			ix := &Interpreter{
+				location:        &experimentTestLocation{tc.location},
+				logger:          model.DiscardLogger,
+				metrics:         metrics,
+				saver:           &experimentTestSaver{tc.saveErr},
+				softwareName:    "miniooni",
+				softwareVersion: "0.1.0-dev",
+			}
+			err := runExperiment(
+				context.Background(),
+				map[string]string{},
+				model.NewPrinterCallbacks(model.DiscardLogger),
+				&experimentTestMeasurer{tc.runErr},
+				"",
+				ix,
+				"",
+				time.Now(),
+				map[string][]model.OOAPIService{},
+			)
+			if (err != nil) != (tc.expectFailed > 0) {
+				t.Fatal("unexpected error", err)
+			}
+			got := []int{metrics.run["dsl_test"], metrics.saved["dsl_test"], metrics.failed["dsl_test"]}
+			expected := []int{tc.expectRun, tc.expectSaved, tc.expectFailed}
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -50,7 +50,7 @@ func (nt *fbmessengerNettest) Run(ctx context.Context) error {
 	// create a new experiment instance
 	var exp model.ExperimentMeasurer
 	if nt.args.ExperimentalFlags["dsl"] {
-		exp = fbmessengernew.NewMeasurer(nt.args.Targets)
+		exp = fbmessengernew.NewMeasurer(nt.args.Targets, nt.ix.dslRuntimeOptions()...)
 	} else {
 		exp = fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
 	}
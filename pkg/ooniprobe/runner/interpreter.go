@@ -7,7 +7,10 @@ package runner
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"time"
 
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
 	"github.com/ooni/2023-05-richer-input/pkg/modelx"
 	"github.com/ooni/probe-engine/pkg/model"
 )
@@ -15,12 +18,27 @@ import (
 // Interpreter contains the interpreter. The zero value is
 // invalid; construct using [NewInterpreter].
 type Interpreter struct {
+	// dslBudget is the budget shared by all DSL-based nettests.
+	dslBudget dsl.Budget
+
+	// dslMetrics accounts the network operations of all DSL-based nettests.
+	dslMetrics *dsl.AccountingMetrics
+
+	// dslPolicy is the destination policy for all DSL-based nettests.
+	dslPolicy dsl.DestinationPolicy
+
+	// dslSpans is nil or records the spans of DSL-based nettests.
+	dslSpans *dsl.SpanRecorder
+
 	// location contains the probe location.
 	location modelx.InterpreterLocation
 
 	// logger is the [model.Logger] to use.
 	logger model.Logger
 
+	// metrics collects metrics about measurements.
+	metrics modelx.InterpreterMetrics
+
 	// saver is used to save measurements results.
 	saver modelx.InterpreterSaver
 
@@ -37,64 +55,179 @@ type Interpreter struct {
 	view modelx.InterpreterView
 }
 
-// NewInterpreter creates a new [Interpreter] instance.
+// NewInterpreter creates a new [Interpreter] instance. This function returns an error
+// if we cannot load the destination policy file configured by the settings.
 func NewInterpreter(
 	location modelx.InterpreterLocation,
 	logger model.Logger,
+	metrics modelx.InterpreterMetrics,
 	saver modelx.InterpreterSaver,
 	settings modelx.InterpreterSettings,
 	softwareName string,
 	softwareVersion string,
 	view modelx.InterpreterView,
-) *Interpreter {
-	return &Interpreter{
+) (*Interpreter, error) {
+	policy, err := newDestinationPolicy(settings.DestinationPolicyFile())
+	if err != nil {
+		return nil, err
+	}
+	ix := &Interpreter{
+		dslBudget: dsl.NewLimitedBudget(
+			settings.MaxInFlightOperations(),
+			settings.MaxOpenConnections(),
+			settings.MaxOperationsPerSecond(),
+		),
+		dslMetrics:      dsl.NewAccountingMetrics(),
+		dslPolicy:       policy,
 		location:        location,
 		logger:          logger,
+		metrics:         metrics,
 		saver:           saver,
 		settings:        settings,
 		softwareName:    softwareName,
 		softwareVersion: softwareVersion,
 		view:            view,
 	}
+	if settings.OTLPEndpoint() != "" || settings.OTLPSpansFile() != "" {
+		ix.dslSpans = dsl.NewSpanRecorder()
+	}
+	return ix, nil
+}
+
+// newDestinationPolicy loads the destination policy from the given file or returns
+// the default destination policy if the file name is empty.
+func newDestinationPolicy(filepath string) (dsl.DestinationPolicy, error) {
+	if filepath == "" {
+		return dsl.NewDenyListDestinationPolicy(dsl.DefaultDestinationPolicyConfig())
+	}
+	return dsl.LoadDestinationPolicyFile(filepath)
+}
+
+// dslRuntimeOptions returns the [dsl.RuntimeOption] for DSL-based nettests.
+func (ix *Interpreter) dslRuntimeOptions() []dsl.RuntimeOption {
+	options := []dsl.RuntimeOption{
+		dsl.RuntimeOptionBudget(ix.dslBudget),
+		dsl.RuntimeOptionDestinationPolicy(ix.dslPolicy),
+		dsl.RuntimeOptionMaxParallelism(ix.settings.MaxParallelism()),
+		dsl.RuntimeOptionSharedMetrics(ix.dslMetrics),
+	}
+	if ix.dslSpans != nil {
+		options = append(options, dsl.RuntimeOptionStageInterceptor(ix.dslSpans))
+	}
+	return options
+}
+
+// DSLMetrics returns the [*dsl.AccountingMetrics] counting the network
+// operations performed by all the DSL-based nettests.
+func (ix *Interpreter) DSLMetrics() *dsl.AccountingMetrics {
+	return ix.dslMetrics
+}
+
+// dslSpansExportTimeout is the maximum time we wait for the OTLP collector.
+const dslSpansExportTimeout = 10 * time.Second
+
+// exportDSLSpans exports the spans of DSL-based nettests, if enabled. We only
+// warn on failure because spans are a debugging aid and should not prevent
+// running the remaining nettests. We do not use the nettest context, which may
+// already be canceled (e.g., after a timeout), because we want to export the
+// spans of interrupted nettests, which are the most interesting ones.
+func (ix *Interpreter) exportDSLSpans() {
+	if ix.dslSpans == nil {
+		return
+	}
+	spans := ix.dslSpans.ExtractSpans()
+	if len(spans) <= 0 {
+		return
+	}
+	data, err := dsl.MarshalOTLPJSON(ix.softwareName, spans...)
+	if err != nil {
+		ix.logger.Warnf("interpreter: cannot serialize spans: %s", err.Error())
+		return
+	}
+	if filepath := ix.settings.OTLPSpansFile(); filepath != "" {
+		if err := dsl.WriteOTLPJSONFile(filepath, data); err != nil {
+			ix.logger.Warnf("interpreter: cannot write spans: %s", err.Error())
+		}
+	}
+	if URL := ix.settings.OTLPEndpoint(); URL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), dslSpansExportTimeout)
+		defer cancel()
+		if err := dsl.ExportOTLPHTTP(ctx, URL, data); err != nil {
+			ix.logger.Warnf("interpreter: cannot export spans: %s", err.Error())
+		}
+	}
 }
 
 // Run runs the given script.
 func (ix *Interpreter) Run(ctx context.Context, script *modelx.InterpreterScript) error {
-	// TODO(bassosimone): reject scripts with an unknown version number
+	// reject scripts more recent than the ones we support
+	if script.Version > modelx.InterpreterScriptVersion {
+		return fmt.Errorf("%w: %d", modelx.ErrUnsupportedScriptVersion, script.Version)
+	}
 
 	// execute each command
 	for _, command := range script.Commands {
 		ix.logger.Debugf("interpreter: interpreting: %s", command.RunCommand)
 
-		switch command.RunCommand {
-		case "ui/set_suite":
-			if err := ix.doUISetSuite(ctx, command.WithArguments); err != nil {
-				return err
-			}
-
-		case "ui/set_progress_bar_range":
-			if err := ix.doUISetProgressBarRange(ctx, command.WithArguments); err != nil {
-				return err
-			}
-
-		case "ui/set_progress_bar_value":
-			if err := ix.doUISetProgressBarValue(ctx, command.WithArguments); err != nil {
-				return err
-			}
-
-		case "nettest/run":
-			if err := ix.doNettestRun(ctx, &script.Config, command.WithArguments); err != nil {
-				return err
-			}
-
-		default:
+		info, found := interpreterCommands[command.RunCommand]
+		if !found {
 			ix.logger.Infof("interpreter: ignoring unknown command: %s", command.RunCommand)
+			continue
+		}
+		if err := info.run(ix, ctx, &script.Config, command.WithArguments); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// interpreterCommand describes a command that the [Interpreter] supports.
+type interpreterCommand struct {
+	// arguments is a pointer to the zero value of the command arguments, which we use
+	// to generate the JSON schema of the command arguments.
+	arguments any
+
+	// run runs the command with the given raw arguments.
+	run func(ix *Interpreter, ctx context.Context,
+		config *modelx.InterpreterConfig, rawMsg json.RawMessage) error
+}
+
+// interpreterCommands maps the name of each command to its description.
+var interpreterCommands = map[string]interpreterCommand{
+	"ui/set_suite": {
+		arguments: &modelx.InterpreterUISetSuiteArguments{},
+		run: func(ix *Interpreter, ctx context.Context,
+			config *modelx.InterpreterConfig, rawMsg json.RawMessage) error {
+			return ix.doUISetSuite(ctx, rawMsg)
+		},
+	},
+
+	"ui/set_progress_bar_range": {
+		arguments: &modelx.InterpreterUISetProgressBarRangeArguments{},
+		run: func(ix *Interpreter, ctx context.Context,
+			config *modelx.InterpreterConfig, rawMsg json.RawMessage) error {
+			return ix.doUISetProgressBarRange(ctx, rawMsg)
+		},
+	},
+
+	"ui/set_progress_bar_value": {
+		arguments: &modelx.InterpreterUISetProgressBarValueArguments{},
+		run: func(ix *Interpreter, ctx context.Context,
+			config *modelx.InterpreterConfig, rawMsg json.RawMessage) error {
+			return ix.doUISetProgressBarValue(ctx, rawMsg)
+		},
+	},
+
+	"nettest/run": {
+		arguments: &modelx.InterpreterNettestRunArguments{},
+		run: func(ix *Interpreter, ctx context.Context,
+			config *modelx.InterpreterConfig, rawMsg json.RawMessage) error {
+			return ix.doNettestRun(ctx, config, rawMsg)
+		},
+	},
+}
+
 // doUISetSuite is the method implementing the the ui/set_suite command.
 func (ix *Interpreter) doUISetSuite(ctx context.Context, rawMsg json.RawMessage) error {
 	// parse the raw JSON message
@@ -190,6 +323,12 @@ func (ix *Interpreter) doNettestRun(ctx context.Context,
 		ix.view.UpdateProgressBarValueWithinRange(1.0)
 	}()
 
+	// export the spans of each nettest run as a separate trace
+	defer ix.exportDSLSpans()
+
+	// remember when we last ran this nettest
+	defer ix.metrics.NettestRun(value.NettestName)
+
 	// let the nettest runner finish the job
 	return nettest.Run(ctx)
 }
@@ -0,0 +1,70 @@
+package runner
+
+//
+// JSON schemas
+//
+
+import (
+	"sort"
+
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+)
+
+// ScriptJSONSchema returns the JSON schema document describing the [modelx.InterpreterScript]
+// that this probe is able to interpret, including the arguments of each command. Because we
+// generate the schema from the Go types and from the commands that the [Interpreter] supports,
+// the schema is always in sync with the code.
+//
+// Note that the schema does not describe the targets of the nettest/run command, which are
+// nettest specific. For DSL-based nettests, the targets are ASTs, which [ASTJSONSchema] describes.
+func ScriptJSONSchema() map[string]any {
+	g := dsl.NewJSONSchemaGenerator()
+	schema := g.ForValue(&modelx.InterpreterScript{})
+	properties := schema["properties"].(map[string]any)
+
+	// we cannot interpret scripts more recent than the ones we support
+	properties["version"].(map[string]any)["maximum"] = modelx.InterpreterScriptVersion
+
+	names := []string{}
+	for name := range interpreterCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	commands := []any{}
+	for _, name := range names {
+		commands = append(commands, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"run_command":    map[string]any{"const": name},
+				"with_arguments": g.ForValue(interpreterCommands[name].arguments),
+			},
+			"required": []string{"run_command"},
+		})
+	}
+
+	// the interpreter ignores unknown commands, so the schema must accept them
+	commands = append(commands, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"run_command": map[string]any{
+				"type": "string",
+				"not":  map[string]any{"enum": names},
+			},
+		},
+		"required": []string{"run_command"},
+	})
+
+	properties["commands"] = map[string]any{
+		"type":  []string{"array", "null"},
+		"items": map[string]any{"oneOf": commands},
+	}
+	return g.Document(schema)
+}
+
+// ASTJSONSchema returns the JSON schema document describing the ASTs that the default
+// [dsl.ASTLoader] is able to load. See [dsl.ASTLoader.JSONSchema] for more information.
+func ASTJSONSchema() map[string]any {
+	return dsl.NewASTLoader().JSONSchema()
+}
@@ -50,7 +50,7 @@ func (nt *riseupvpnNettest) Run(ctx context.Context) error {
 	// create a new experiment instance
 	var exp model.ExperimentMeasurer
 	if nt.args.ExperimentalFlags["dsl"] {
-		exp = riseupvpnnew.NewMeasurer(nt.args.Targets)
+		exp = riseupvpnnew.NewMeasurer(nt.args.Targets, nt.ix.dslRuntimeOptions()...)
 	} else {
 		exp = riseupvpn.NewExperimentMeasurer(riseupvpn.Config{})
 	}
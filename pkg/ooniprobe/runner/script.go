@@ -0,0 +1,107 @@
+package runner
+
+//
+// Script encoding
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// scriptCBORSelfDescribePrefix is the self-described CBOR tag (see RFC 8949 Sect. 3.4.6)
+// that we prepend to CBOR signed scripts, like [dsl.JSONToCBOR] does.
+var scriptCBORSelfDescribePrefix = []byte{0xd9, 0xd9, 0xf7}
+
+var (
+	// scriptCBOREncMode is the CBOR encoding mode for signed scripts.
+	scriptCBOREncMode cbor.EncMode
+
+	// scriptCBORDecMode is the CBOR decoding mode for signed scripts.
+	scriptCBORDecMode cbor.DecMode
+)
+
+func init() {
+	scriptCBOREncMode = runtimex.Try1(cbor.CoreDetEncOptions().EncMode())
+	decOptions := cbor.DecOptions{
+		DupMapKey: cbor.DupMapKeyEnforcedAPF,
+	}
+	scriptCBORDecMode = runtimex.Try1(decOptions.DecMode())
+}
+
+// errNotSignedScriptCBOR indicates that a CBOR document is not a signed script
+// encoded using [MarshalSignedInterpreterScriptCBOR].
+var errNotSignedScriptCBOR = errors.New("not a CBOR signed script")
+
+// MarshalInterpreterScriptCBOR returns the CBOR encoding of the given script, which is
+// more compact than JSON and includes the embedded ASTs. See [dsl.JSONToCBOR]. Use
+// [SignInterpreterScriptCBOR] and [MarshalSignedInterpreterScriptCBOR] for signed scripts.
+func MarshalInterpreterScriptCBOR(script *modelx.InterpreterScript) ([]byte, error) {
+	data, err := json.Marshal(script)
+	if err != nil {
+		return nil, err
+	}
+	return dsl.JSONToCBOR(data)
+}
+
+// MarshalSignedInterpreterScriptCBOR returns the CBOR encoding of the given signed script, where
+// the payload and the signatures are CBOR byte strings. Because the JSON encoding of a signed
+// script embeds the payload as a base64 string, this encoding is more compact, especially when
+// the payload is itself CBOR (see [SignInterpreterScriptCBOR]).
+//
+// Note that, unlike the documents produced by [dsl.JSONToCBOR], this document does not belong
+// to the JSON data model, because JSON does not have byte strings. Use [ScriptJSON] to obtain
+// the equivalent JSON encoding, where the payload and the signatures are base64 strings.
+func MarshalSignedInterpreterScriptCBOR(signed *modelx.SignedInterpreterScript) ([]byte, error) {
+	encoded, err := scriptCBOREncMode.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, scriptCBORSelfDescribePrefix...), encoded...), nil
+}
+
+// unmarshalSignedInterpreterScriptCBOR decodes a signed script produced by
+// [MarshalSignedInterpreterScriptCBOR] or returns [errNotSignedScriptCBOR].
+func unmarshalSignedInterpreterScriptCBOR(data []byte) (*modelx.SignedInterpreterScript, error) {
+	var signed modelx.SignedInterpreterScript
+	data = bytes.TrimPrefix(data, scriptCBORSelfDescribePrefix)
+	if err := scriptCBORDecMode.Unmarshal(data, &signed); err != nil || len(signed.Payload) <= 0 {
+		// Note: this happens, e.g., for unsigned scripts and for signed scripts transcoded
+		// from JSON using dsl.JSONToCBOR, where the payload is a base64 string
+		return nil, errNotSignedScriptCBOR
+	}
+	return &signed, nil
+}
+
+// ScriptJSON returns the JSON encoding of a script (or signed script) that is either
+// encoded using JSON or using CBOR, which we detect automatically. We support CBOR
+// documents produced by [MarshalInterpreterScriptCBOR], [MarshalSignedInterpreterScriptCBOR],
+// and by transcoding the JSON encoding of a script (or signed script) to CBOR.
+func ScriptJSON(data []byte) ([]byte, error) {
+	if !dsl.IsCBOR(data) {
+		return data, nil
+	}
+	if signed, err := unmarshalSignedInterpreterScriptCBOR(data); err == nil {
+		return json.Marshal(signed)
+	}
+	return dsl.CBORToJSON(data)
+}
+
+// UnmarshalInterpreterScript decodes a script encoded using either JSON or CBOR.
+func UnmarshalInterpreterScript(data []byte) (*modelx.InterpreterScript, error) {
+	data, err := ScriptJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var script modelx.InterpreterScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
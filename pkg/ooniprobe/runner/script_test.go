@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/tailscale/hujson"
+)
+
+// scriptTestNormalize returns a generic representation of the script where the
+// commands arguments are decoded values rather than raw JSON bytes.
+func scriptTestNormalize(t *testing.T, script *modelx.InterpreterScript) any {
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestSignedScriptCBOR(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := ScriptSigner{KeyID: "test", PrivateKey: privateKey}
+	verifier := NewScriptVerifier(ScriptSigningKey{KeyID: "test", PublicKey: publicKey})
+	expires := time.Now().Add(time.Hour)
+
+	// verify decodes the given signed script using ScriptJSON and verifies it
+	verify := func(t *testing.T, data []byte) *modelx.InterpreterScript {
+		data, err := ScriptJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var signed modelx.SignedInterpreterScript
+		if err := json.Unmarshal(data, &signed); err != nil {
+			t.Fatal(err)
+		}
+		script, err := verifier.Verify(&signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return script
+	}
+
+	for _, filename := range []string{"full.jsonc", "throttling.jsonc", "fbmessenger.jsonc"} {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile("../../../testdata/" + filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = hujson.Minimize(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var script modelx.InterpreterScript
+			if err := json.Unmarshal(data, &script); err != nil {
+				t.Fatal(err)
+			}
+
+			signedJSON, err := SignInterpreterScript(&script, expires, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonData, err := json.Marshal(signedJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signedCBOR, err := SignInterpreterScriptCBOR(&script, expires, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cborData, err := MarshalSignedInterpreterScriptCBOR(signedCBOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !dsl.IsCBOR(cborData) {
+				t.Fatal("expected to detect CBOR")
+			}
+
+			t.Logf("signed script size: JSON %d bytes, CBOR %d bytes (%.1f%%)",
+				len(jsonData), len(cborData), 100*float64(len(cborData))/float64(len(jsonData)))
+			if len(cborData) >= len(data) {
+				t.Fatal("expected the CBOR signed script to be smaller than the unsigned JSON script")
+			}
+
+			expected := scriptTestNormalize(t, &script)
+			for _, encoded := range [][]byte{jsonData, cborData} {
+				if diff := cmp.Diff(expected, scriptTestNormalize(t, verify(t, encoded))); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+		})
+	}
+
+	t.Run("we detect a tampered CBOR payload", func(t *testing.T) {
+		script := &modelx.InterpreterScript{Commands: []modelx.InterpreterCommand{}}
+		signed, err := SignInterpreterScriptCBOR(script, expires, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed.Payload[len(signed.Payload)-1] ^= 0x01
+		if _, err := verifier.Verify(signed); err != ErrScriptBadSignature {
+			t.Fatal("unexpected error", err)
+		}
+	})
+
+	t.Run("we still decode signed scripts transcoded from JSON", func(t *testing.T) {
+		script := &modelx.InterpreterScript{Commands: []modelx.InterpreterCommand{}}
+		signed, err := SignInterpreterScript(script, expires, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cborData, err := dsl.JSONToCBOR(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(scriptTestNormalize(t, script), scriptTestNormalize(t, verify(t, cborData))); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package runner
+
+//
+// Script signatures
+//
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+	"github.com/ooni/probe-engine/pkg/runtimex"
+)
+
+// ErrScriptUnsigned indicates that a script does not contain any signature.
+var ErrScriptUnsigned = errors.New("script is not signed")
+
+// ErrScriptBadSignature indicates that a script does not contain any valid signature
+// produced by a key we trust at the current time.
+var ErrScriptBadSignature = errors.New("script does not contain any valid signature")
+
+// ErrScriptExpired indicates that a script has expired.
+var ErrScriptExpired = errors.New("script has expired")
+
+// scriptSignaturePrefix is the domain separation prefix we prepend to the payload before
+// signing, such that we cannot confuse script signatures with other ed25519 signatures.
+const scriptSignaturePrefix = "ooni-interpreter-script-signature-v1\x00"
+
+// ScriptSigningKey is a public key that we trust for signing scripts.
+type ScriptSigningKey struct {
+	// KeyID identifies the key.
+	KeyID string
+
+	// PublicKey is the ed25519 public key.
+	PublicKey ed25519.PublicKey
+
+	// NotBefore is the time since when we trust the key. The zero
+	// value means that we have always trusted the key.
+	NotBefore time.Time
+
+	// NotAfter is the time since when we stop trusting the key. The zero
+	// value means that we trust the key indefinitely.
+	NotAfter time.Time
+}
+
+// isTrustedAt returns whether we trust the key at the given time.
+func (k *ScriptSigningKey) isTrustedAt(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && !t.Before(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// pinnedScriptSigningKeys contains the keys that this binary trusts for signing scripts. To
+// rotate keys, we add the new key, we sign scripts with both the old and the new key, and we
+// set the NotAfter field of the old key once all the probes in the wild know the new key.
+//
+// The staging-2023 key is a staging key for testing the signing pipeline end to end: its
+// private key is public (see testdata/staging-script-signing.key), so anyone can sign scripts
+// with it. We must replace it with the production keys once the backend signs scripts.
+var pinnedScriptSigningKeys = []ScriptSigningKey{{
+	KeyID:     "staging-2023",
+	PublicKey: runtimex.Try1(base64.StdEncoding.DecodeString("PGgVrY4gOqmgMU8X4wWeYdGuIJMo9x89GUoM7KjOBxo=")),
+	NotBefore: time.Time{},
+	NotAfter:  time.Time{},
+}}
+
+// ScriptVerifier verifies [modelx.SignedInterpreterScript] documents. The zero value is
+// invalid; use [NewScriptVerifier] or [NewPinnedScriptVerifier] to construct.
+type ScriptVerifier struct {
+	// keys contains the trusted keys.
+	keys []ScriptSigningKey
+
+	// timeNow allows to mock the current time in tests.
+	timeNow func() time.Time
+}
+
+// NewScriptVerifier creates a new [*ScriptVerifier] trusting the given keys.
+func NewScriptVerifier(keys ...ScriptSigningKey) *ScriptVerifier {
+	return &ScriptVerifier{
+		keys:    keys,
+		timeNow: time.Now,
+	}
+}
+
+// NewPinnedScriptVerifier creates a new [*ScriptVerifier] trusting the keys pinned in this binary
+// as well as the given extra keys. The extra keys are meant for developers and tests that need
+// to run scripts signed using keys generated locally (e.g., using the keygenx subcommand).
+func NewPinnedScriptVerifier(extraKeys ...ScriptSigningKey) *ScriptVerifier {
+	keys := append([]ScriptSigningKey{}, pinnedScriptSigningKeys...)
+	return NewScriptVerifier(append(keys, extraKeys...)...)
+}
+
+// Verify verifies the signatures and the expiry of the given signed script and returns
+// the script on success. We accept the script when at least one signature has been
+// produced by a key that we trust at the current time.
+func (sv *ScriptVerifier) Verify(signed *modelx.SignedInterpreterScript) (*modelx.InterpreterScript, error) {
+	payload, err := sv.VerifySignatures(signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := sv.CheckExpiry(payload); err != nil {
+		return nil, err
+	}
+	return &payload.Script, nil
+}
+
+// VerifySignatures is like [ScriptVerifier.Verify] but does not check whether the script
+// has expired. Use [ScriptVerifier.CheckExpiry] to check the expiry.
+func (sv *ScriptVerifier) VerifySignatures(
+	signed *modelx.SignedInterpreterScript) (*modelx.InterpreterScriptPayload, error) {
+	if len(signed.Signatures) <= 0 {
+		return nil, ErrScriptUnsigned
+	}
+	if !sv.hasValidSignature(signed) {
+		return nil, ErrScriptBadSignature
+	}
+	// Note: the payload is either JSON or CBOR (see SignInterpreterScriptCBOR)
+	data, err := scriptPayloadJSON(signed.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var payload modelx.InterpreterScriptPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// scriptPayloadJSON returns the JSON encoding of a payload encoded using either JSON or CBOR.
+func scriptPayloadJSON(payload []byte) ([]byte, error) {
+	if dsl.IsCBOR(payload) {
+		return dsl.CBORToJSON(payload)
+	}
+	return payload, nil
+}
+
+// hasValidSignature returns whether the signed script contains at least a valid signature.
+func (sv *ScriptVerifier) hasValidSignature(signed *modelx.SignedInterpreterScript) bool {
+	now := sv.timeNow()
+	message := scriptSignatureMessage(signed.Payload)
+	for _, signature := range signed.Signatures {
+		for _, key := range sv.keys {
+			if key.KeyID != signature.KeyID || !key.isTrustedAt(now) {
+				continue
+			}
+			if len(key.PublicKey) != ed25519.PublicKeySize {
+				continue
+			}
+			if ed25519.Verify(key.PublicKey, message, signature.Signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckExpiry returns [ErrScriptExpired] if the given payload has expired. We consider
+// expired a payload that lacks an expiry time, such that scripts cannot live forever.
+func (sv *ScriptVerifier) CheckExpiry(payload *modelx.InterpreterScriptPayload) error {
+	if payload.Expires.IsZero() || !sv.timeNow().Before(payload.Expires) {
+		return fmt.Errorf("%w: expires: %s", ErrScriptExpired, payload.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ScriptSigner is a private key used for signing scripts.
+type ScriptSigner struct {
+	// KeyID identifies the key.
+	KeyID string
+
+	// PrivateKey is the ed25519 private key.
+	PrivateKey ed25519.PrivateKey
+}
+
+// SignInterpreterScript signs the given script, which expires at the given time, using all
+// the given signers. Using more than one signer is useful when rotating the keys.
+func SignInterpreterScript(
+	script *modelx.InterpreterScript,
+	expires time.Time,
+	signers ...ScriptSigner,
+) (*modelx.SignedInterpreterScript, error) {
+	payload, err := json.Marshal(&modelx.InterpreterScriptPayload{
+		Expires: expires.UTC(),
+		Script:  *script,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signInterpreterScriptPayload(payload, signers...)
+}
+
+// SignInterpreterScriptCBOR is like [SignInterpreterScript] but the payload, hence the signed
+// bytes, is the canonical CBOR encoding of the JSON payload (see [dsl.JSONToCBOR]). Use this
+// function along with [MarshalSignedInterpreterScriptCBOR] to produce compact signed scripts.
+func SignInterpreterScriptCBOR(
+	script *modelx.InterpreterScript,
+	expires time.Time,
+	signers ...ScriptSigner,
+) (*modelx.SignedInterpreterScript, error) {
+	data, err := json.Marshal(&modelx.InterpreterScriptPayload{
+		Expires: expires.UTC(),
+		Script:  *script,
+	})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := dsl.JSONToCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	return signInterpreterScriptPayload(payload, signers...)
+}
+
+// signInterpreterScriptPayload signs the given payload using all the given signers.
+func signInterpreterScriptPayload(
+	payload []byte, signers ...ScriptSigner) (*modelx.SignedInterpreterScript, error) {
+	signed := &modelx.SignedInterpreterScript{
+		Payload:    payload,
+		Signatures: []modelx.InterpreterScriptSignature{},
+	}
+	message := scriptSignatureMessage(payload)
+	for _, signer := range signers {
+		if len(signer.PrivateKey) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid private key for %s", signer.KeyID)
+		}
+		signed.Signatures = append(signed.Signatures, modelx.InterpreterScriptSignature{
+			KeyID:     signer.KeyID,
+			Signature: ed25519.Sign(signer.PrivateKey, message),
+		})
+	}
+	return signed, nil
+}
+
+// scriptSignatureMessage returns the message we sign for the given payload.
+func scriptSignatureMessage(payload []byte) []byte {
+	return append([]byte(scriptSignaturePrefix), payload...)
+}
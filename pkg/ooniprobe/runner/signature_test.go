@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/2023-05-richer-input/pkg/modelx"
+)
+
+func TestScriptVerifier(t *testing.T) {
+	// newKey generates a new signing key and the corresponding trusted key
+	newKey := func(t *testing.T, keyID string) (ScriptSigner, ScriptSigningKey) {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ScriptSigner{keyID, privateKey}, ScriptSigningKey{KeyID: keyID, PublicKey: publicKey}
+	}
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldSigner, oldKey := newKey(t, "old")
+	newSigner, newTrustedKey := newKey(t, "new")
+	unknownSigner, _ := newKey(t, "unknown")
+
+	// the old key has been rotated out before the current time
+	rotatedOutKey := oldKey
+	rotatedOutKey.NotAfter = now.Add(-time.Hour)
+
+	script := &modelx.InterpreterScript{
+		Config: modelx.InterpreterConfig{},
+		Commands: []modelx.InterpreterCommand{{
+			RunCommand:    "ui/set_suite",
+			WithArguments: json.RawMessage(`{"suite_name":"websites"}`),
+		}},
+	}
+
+	type testcase struct {
+		// name is the name of the test case.
+		name string
+
+		// keys contains the keys we trust.
+		keys []ScriptSigningKey
+
+		// expires is the script expiry time.
+		expires time.Time
+
+		// signers contains the keys signing the script.
+		signers []ScriptSigner
+
+		// tamper optionally modifies the signed script.
+		tamper func(signed *modelx.SignedInterpreterScript)
+
+		// expectErr is the expected error.
+		expectErr error
+	}
+
+	testcases := []testcase{{
+		name:      "with a valid signature",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{oldSigner},
+		tamper:    nil,
+		expectErr: nil,
+	}, {
+		name:    "with a tampered payload",
+		keys:    []ScriptSigningKey{oldKey},
+		expires: now.Add(time.Hour),
+		signers: []ScriptSigner{oldSigner},
+		tamper: func(signed *modelx.SignedInterpreterScript) {
+			signed.Payload[len(signed.Payload)-2] ^= 0x01
+		},
+		expectErr: ErrScriptBadSignature,
+	}, {
+		name:      "with a signature produced by an unknown key",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{unknownSigner},
+		tamper:    nil,
+		expectErr: ErrScriptBadSignature,
+	}, {
+		name:      "with a signature claiming to be produced by a trusted key",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{{KeyID: "old", PrivateKey: unknownSigner.PrivateKey}},
+		tamper:    nil,
+		expectErr: ErrScriptBadSignature,
+	}, {
+		name:      "with an expired script",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   now.Add(-time.Second),
+		signers:   []ScriptSigner{oldSigner},
+		tamper:    nil,
+		expectErr: ErrScriptExpired,
+	}, {
+		name:      "with a script without expiry time",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   time.Time{},
+		signers:   []ScriptSigner{oldSigner},
+		tamper:    nil,
+		expectErr: ErrScriptExpired,
+	}, {
+		name:      "with a signature produced by a rotated-out key",
+		keys:      []ScriptSigningKey{rotatedOutKey, newTrustedKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{oldSigner},
+		tamper:    nil,
+		expectErr: ErrScriptBadSignature,
+	}, {
+		name:      "with signatures produced by both the rotated-out key and the new key",
+		keys:      []ScriptSigningKey{rotatedOutKey, newTrustedKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{oldSigner, newSigner},
+		tamper:    nil,
+		expectErr: nil,
+	}, {
+		name:      "without signatures",
+		keys:      []ScriptSigningKey{oldKey},
+		expires:   now.Add(time.Hour),
+		signers:   []ScriptSigner{},
+		tamper:    nil,
+		expectErr: ErrScriptUnsigned,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			signed, err := SignInterpreterScript(script, tc.expires, tc.signers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				tc.tamper(signed)
+			}
+
+			// make sure the signed script survives a JSON round trip
+			data, err := json.Marshal(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded modelx.SignedInterpreterScript
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+
+			verifier := NewScriptVerifier(tc.keys...)
+			verifier.timeNow = func() time.Time {
+				return now
+			}
+			got, err := verifier.Verify(&decoded)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatal("expected", tc.expectErr, "got", err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(script, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	t.Run("the pinned verifier trusts the extra keys", func(t *testing.T) {
+		signed, err := SignInterpreterScript(script, time.Now().Add(time.Hour), newSigner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewPinnedScriptVerifier().Verify(signed); !errors.Is(err, ErrScriptBadSignature) {
+			t.Fatal("unexpected error", err)
+		}
+		if _, err := NewPinnedScriptVerifier(newTrustedKey).Verify(signed); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("the pinned verifier trusts the staging key", func(t *testing.T) {
+		data, err := os.ReadFile("../../../testdata/staging-script-signing.key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key struct {
+			KeyID      string `json:"key_id"`
+			PrivateKey []byte `json:"private_key"`
+		}
+		if err := json.Unmarshal(data, &key); err != nil {
+			t.Fatal(err)
+		}
+		signer := ScriptSigner{KeyID: key.KeyID, PrivateKey: key.PrivateKey}
+		signed, err := SignInterpreterScript(script, time.Now().Add(time.Hour), signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewPinnedScriptVerifier().Verify(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(script, got); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
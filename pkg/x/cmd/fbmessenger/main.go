@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/ooni/2023-05-richer-input/pkg/dsl"
 	"github.com/ooni/2023-05-richer-input/pkg/experiment/fbmessenger"
 	"github.com/ooni/probe-engine/pkg/runtimex"
 )
@@ -14,8 +15,11 @@ func main() {
 	// obtain the measurement pipeline
 	pipeline := fbmessenger.DSLToplevelFunc(fbmessenger.NewTestKeys())
 
-	// serialize its AST to JSON
-	data := runtimex.Try1(json.Marshal(pipeline.ASTNode()))
+	// move the repeated subtrees into the definitions table
+	doc := runtimex.Try1(dsl.NewSerializableASTDocument(pipeline.ASTNode()))
+
+	// serialize the AST document to JSON
+	data := runtimex.Try1(json.Marshal(doc))
 
 	// write the JSON to the standard output
 	fmt.Printf("%s\n", string(data))
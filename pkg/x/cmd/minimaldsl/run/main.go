@@ -1,11 +1,17 @@
-// This command runs a minimal measurement DSL.
+// This command runs a minimal measurement DSL written either using JSON or, when
+// the file name ends with ".dsl", using the text syntax.
+//
+// Use the -otlp-spans-file and -otlp-endpoint flags to export the spans of the
+// executed stages in OTLP JSON format to a file or to an OTLP/HTTP collector.
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/apex/log"
@@ -15,21 +21,51 @@ import (
 )
 
 func main() {
-	rawAST := runtimex.Try1(os.ReadFile(os.Args[1]))
-	rawAST = runtimex.Try1(hujson.Standardize(rawAST)) // remove comments
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP traces URL to export spans to")
+	otlpSpansFile := flag.String("otlp-spans-file", "", "path of the file where to append OTLP JSON spans")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] FILE\n", os.Args[0])
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
 
-	var loadableNode dsl.LoadableASTNode
-	runtimex.Try0(json.Unmarshal(rawAST, &loadableNode))
+	rawAST := runtimex.Try1(os.ReadFile(filename))
+
+	var loadableNode *dsl.LoadableASTNode
+	if strings.HasSuffix(filename, ".dsl") {
+		loadableNode = runtimex.Try1(dsl.ParseASTText(string(rawAST)))
+	} else {
+		rawAST = runtimex.Try1(hujson.Standardize(rawAST)) // remove comments
+		loadableDoc := runtimex.Try1(dsl.UnmarshalASTDocument(rawAST))
+		loadableNode = runtimex.Try1(dsl.ExpandASTDocument(loadableDoc))
+	}
 
 	loader := dsl.NewASTLoader()
-	runnableNode := runtimex.Try1(loader.Load(&loadableNode))
+	runnableNode := runtimex.Try1(loader.Load(loadableNode))
 
-	metrics := &dsl.NullMetrics{}
+	metrics := dsl.NewHistogramMetrics()
 	progress := &dsl.NullProgressMeter{}
-	rtx := dsl.NewMeasurexliteRuntime(log.Log, metrics, progress, time.Now())
+	spans := dsl.NewSpanRecorder()
+	exportSpans := *otlpSpansFile != "" || *otlpEndpoint != ""
+	options := []dsl.RuntimeOption{}
+	if exportSpans {
+		options = append(options, dsl.RuntimeOptionStageInterceptor(spans))
+	}
+	rtx := dsl.NewMeasurexliteRuntime(log.Log, metrics, progress, time.Now(), options...)
 
 	input := dsl.NewValue(&dsl.Void{}).AsGeneric()
 	runtimex.Try0(dsl.Try(runnableNode.Run(context.Background(), rtx, input)))
 
+	if exportSpans {
+		data := runtimex.Try1(dsl.MarshalOTLPJSON("minimaldsl", spans.ExtractSpans()...))
+		if *otlpSpansFile != "" {
+			runtimex.Try0(dsl.WriteOTLPJSONFile(*otlpSpansFile, data))
+		}
+		if *otlpEndpoint != "" {
+			runtimex.Try0(dsl.ExportOTLPHTTP(context.Background(), *otlpEndpoint, data))
+		}
+	}
+
 	fmt.Printf("%s\n", string(runtimex.Try1(json.Marshal(dsl.ReduceObservations(rtx.ExtractObservations()...)))))
 }
@@ -97,16 +97,16 @@ func main() {
 	// generate the DSL
 	DSL := mustGenerateDSL(eipServices, rootCA)
 
-	// serialize to JSON
-	rawDSL := runtimex.Try1(json.Marshal(DSL.ASTNode()))
+	// move the repeated subtrees into the definitions table and serialize to JSON
+	doc := runtimex.Try1(dsl.NewSerializableASTDocument(DSL.ASTNode()))
+	rawDSL := runtimex.Try1(json.Marshal(doc))
 
 	// load the DSL
-	var loadable dsl.LoadableASTNode
-	runtimex.Try0(json.Unmarshal(rawDSL, &loadable))
+	loadable := runtimex.Try1(dsl.UnmarshalASTDocument(rawDSL))
 
 	// make the DSL runnable
 	loader := dsl.NewASTLoader()
-	runnable := runtimex.Try1(loader.Load(&loadable))
+	runnable := runtimex.Try1(loader.LoadDocument(loadable))
 
 	// make sure we can run the DSL
 	log.Info("- checking whether we can run the generated DSL")